@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/docker/docker/client"
+	"github.com/jaredfolkins/letemcook/worker"
+	"github.com/joho/godotenv"
+)
+
+const (
+	DEFAULT_SERVER_URL  = "ws://127.0.0.1:5362/worker/connect"
+	DEFAULT_DOCKER_HOST = "unix:///var/run/docker.sock"
+)
+
+func env(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func main() {
+	_ = godotenv.Load()
+
+	hostname, _ := os.Hostname()
+	server := flag.String("server", env("LEMC_WORKER_SERVER", DEFAULT_SERVER_URL), "websocket URL of the LEMC server's worker endpoint")
+	token := flag.String("token", os.Getenv("LEMC_WORKER_TOKEN"), "shared token, must match LEMC_WORKER_TOKEN of the server")
+	name := flag.String("name", env("LEMC_WORKER_NAME", hostname), "name shown on the server")
+	labels := flag.String("labels", os.Getenv("LEMC_WORKER_LABELS"), "comma separated labels steps can target with runs_on, e.g. dmz,gpu-less")
+	dockerHost := flag.String("docker-host", env("LEMC_DOCKER_HOST", DEFAULT_DOCKER_HOST), "Docker host steps run on")
+	flag.Parse()
+
+	if *token == "" {
+		fmt.Println("Usage: lemc-worker -token <LEMC_WORKER_TOKEN> [-server url] [-name name] [-labels a,b]")
+		os.Exit(1)
+	}
+
+	own, err := worker.ParseLabels(*labels)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cli, err := client.NewClientWithOpts(client.WithHost(*dockerHost), client.WithAPIVersionNegotiation())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	agent := &worker.Agent{
+		URL:    *server,
+		Token:  *token,
+		Name:   *name,
+		Labels: append(worker.DefaultLabels(), own...),
+		Docker: cli,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := agent.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
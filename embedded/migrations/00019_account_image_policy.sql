@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE account_settings ADD COLUMN image_allowlist TEXT NOT NULL DEFAULT '';
+ALTER TABLE account_settings ADD COLUMN require_digest BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE account_settings DROP COLUMN require_digest;
+ALTER TABLE account_settings DROP COLUMN image_allowlist;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE image_state (
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    image TEXT PRIMARY KEY,
+    local_id TEXT NOT NULL DEFAULT '',
+    remote_digest TEXT NOT NULL DEFAULT '',
+    drifted BOOLEAN NOT NULL DEFAULT FALSE,
+    checked TIMESTAMP,
+    last_run_id TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE image_pulls (
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    image TEXT NOT NULL,
+    source TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    previous_id TEXT NOT NULL DEFAULT '',
+    new_id TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_image_pulls_created ON image_pulls(created);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_image_pulls_created;
+DROP TABLE IF EXISTS image_pulls;
+DROP TABLE IF EXISTS image_state;
+-- +goose StatementEnd
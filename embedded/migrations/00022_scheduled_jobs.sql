@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE scheduled_jobs (
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    queue TEXT NOT NULL,
+    job_key TEXT NOT NULL,
+    job_group TEXT NOT NULL DEFAULT '',
+    next_run_time INTEGER NOT NULL,
+    recipe_name TEXT NOT NULL DEFAULT '',
+    username TEXT NOT NULL DEFAULT '',
+    account_id INTEGER NOT NULL DEFAULT 0,
+    payload TEXT NOT NULL,
+    UNIQUE (queue, job_key)
+);
+
+CREATE INDEX idx_scheduled_jobs_next_run ON scheduled_jobs(queue, next_run_time, id);
+CREATE INDEX idx_scheduled_jobs_account ON scheduled_jobs(account_id, next_run_time);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_scheduled_jobs_account;
+DROP INDEX IF EXISTS idx_scheduled_jobs_next_run;
+DROP TABLE IF EXISTS scheduled_jobs;
+-- +goose StatementEnd
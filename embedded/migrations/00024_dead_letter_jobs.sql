@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE dead_letter_jobs (
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    queue TEXT NOT NULL,
+    job_key TEXT NOT NULL DEFAULT '',
+    recipe_name TEXT NOT NULL DEFAULT '',
+    username TEXT NOT NULL DEFAULT '',
+    account_id INTEGER NOT NULL DEFAULT 0,
+    reason TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    payload TEXT NOT NULL
+);
+
+CREATE INDEX idx_dead_letter_jobs_created ON dead_letter_jobs(created, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_dead_letter_jobs_created;
+DROP TABLE IF EXISTS dead_letter_jobs;
+-- +goose StatementEnd
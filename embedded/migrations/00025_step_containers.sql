@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE step_containers (
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    container_id TEXT PRIMARY KEY,
+    queue TEXT NOT NULL,
+    job_key TEXT NOT NULL DEFAULT '',
+    recipe_name TEXT NOT NULL DEFAULT '',
+    step_id TEXT NOT NULL DEFAULT '',
+    username TEXT NOT NULL DEFAULT '',
+    account_id INTEGER NOT NULL DEFAULT 0,
+    logged_at INTEGER NOT NULL DEFAULT 0,
+    payload TEXT NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS step_containers;
+-- +goose StatementEnd
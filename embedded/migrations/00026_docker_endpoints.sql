@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE docker_endpoints (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    host TEXT NOT NULL,
+    tls_ca TEXT NOT NULL DEFAULT '',
+    tls_cert TEXT NOT NULL DEFAULT '',
+    tls_key TEXT NOT NULL DEFAULT '',
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE account_settings ADD COLUMN docker_endpoint TEXT NOT NULL DEFAULT '';
+ALTER TABLE step_containers ADD COLUMN endpoint TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE step_containers DROP COLUMN endpoint;
+ALTER TABLE account_settings DROP COLUMN docker_endpoint;
+DROP TABLE IF EXISTS docker_endpoints;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE image_usage (
+    image_id TEXT PRIMARY KEY,
+    last_used TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO image_usage (image_id, last_used)
+    SELECT last_run_id, MAX(last_run) FROM image_state
+    WHERE last_run_id != '' AND last_run IS NOT NULL
+    GROUP BY last_run_id;
+
+INSERT OR IGNORE INTO image_usage (image_id, last_used)
+    SELECT local_id, MAX(updated) FROM image_state
+    WHERE local_id != ''
+    GROUP BY local_id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS image_usage;
+-- +goose StatementEnd
@@ -104,10 +104,22 @@ func partialAccountSettingsHandler(c LemcContext) (models.AccountSettingsView, t
 	baseView := NewBaseViewWithSquidAndAccountName(c, user.Account.Squid, user.Account.Name)
 	baseView.ActiveNav = "account"
 	baseView.ActiveSubNav = paths.AccountSettings
+	endpoints, err := models.DockerEndpoints()
+	if err != nil {
+		return models.AccountSettingsView{}, nil, err
+	}
+	endpointNames := []string{}
+	for _, e := range endpoints {
+		if e.Name != models.DefaultDockerEndpoint {
+			endpointNames = append(endpointNames, e.Name)
+		}
+	}
+
 	viewData := models.AccountSettingsView{
 		BaseView:        baseView,
 		Settings:        settings,
 		AvailableThemes: availableThemes, // Pass the list of themes
+		DockerEndpoints: endpointNames,
 	}
 
 	settingsComponent := pages.AccountSettings(viewData)
@@ -139,6 +151,13 @@ func PostAccountSettingsHandler(c LemcContext) error {
 	currentSettings.Theme = c.FormValue("theme")
 	currentSettings.Registration = c.FormValue("registration") == "on" // Checkbox value
 	currentSettings.Heckle = c.FormValue("heckle") == "on"             // Checkbox value
+	currentSettings.ImageAllowlist = strings.TrimSpace(c.FormValue("image_allowlist"))
+	currentSettings.RequireDigest = c.FormValue("require_digest") == "on"
+	currentSettings.DockerEndpoint = c.FormValue("docker_endpoint")
+	if _, err := models.DockerEndpointByName(currentSettings.DockerEndpoint); err != nil {
+		c.AddErrorFlash("settings-update", err.Error())
+		return c.Redirect(http.StatusSeeOther, paths.AccountSettings)
+	}
 
 	err = models.UpsertAccountSettings(db.Db(), currentSettings) // Use .DB
 	if err != nil {
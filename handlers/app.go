@@ -350,6 +350,11 @@ func PostAppCreate(c LemcContext) error {
 		return err
 	}
 
+	if err := reconcileAppSchedules(app, c.UserContext().ActingAs.ID); err != nil {
+		log.Printf("Error registering schedules of app %s: %v", app.UUID, err)
+		c.AddErrorFlash("app-create-schedules", "app created but some schedules were not registered: "+err.Error())
+	}
+
 	userID := c.UserContext().ActingAs.ID
 	accountID := c.UserContext().ActingAs.Account.ID
 	cbs, err := models.Apps(userID, accountID, 1, 10)
@@ -453,6 +458,11 @@ func AppRefreshHandler(c LemcContext) error {
 
 	log.Printf("Successfully refreshed app %s for account %d from cookbook %d", appUUID, accountID, app.CookbookID)
 
+	if err := reconcileAppSchedules(app, userID); err != nil {
+		log.Printf("Error reconciling schedules of app %s: %v", appUUID, err)
+		c.AddErrorFlash("app-refresh-schedules", "app refreshed but some schedules were not registered: "+err.Error())
+	}
+
 	apps, err := models.Apps(userID, accountID, 1, DefaultappLimit)
 	if err != nil {
 		log.Printf("Error fetching apps after refresh: %v", err)
@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/jaredfolkins/letemcook/models"
 	"github.com/jaredfolkins/letemcook/util"
 	"github.com/jaredfolkins/letemcook/views/pages"
 	"github.com/jaredfolkins/letemcook/views/partials"
+	"github.com/jaredfolkins/letemcook/yeschef"
 	"github.com/labstack/gommon/log"
 	"gopkg.in/yaml.v3"
 )
@@ -45,6 +47,7 @@ func GetCookbookYamlConfigHandler(c LemcContext) error {
 
 	yaml_default_no_storage.Cookbook.Pages = yaml_default.Cookbook.Pages
 	yaml_default_no_storage.Cookbook.Environment = yaml_default.Cookbook.Environment
+	yaml_default_no_storage.Cookbook.DockerEndpoint = yaml_default.Cookbook.DockerEndpoint
 
 	v := models.CoreView{
 		Cookbook:             cb,
@@ -95,11 +98,13 @@ func processFormData(formData string, action string) (*models.YamlDefaultNoStora
 	return &yamlNoStorage, nil
 }
 
-// Helper function to save YAML data back to cookbook
-func saveYamlToCookbook(cb *models.Cookbook, yamlDefault *models.YamlDefault, viewType string) (bool, error) {
+// Helper function to save YAML data back to cookbook. Steps whose images
+// violate the account's image policy are returned so the editor can be warned;
+// they are enforced when a job is submitted.
+func saveYamlToCookbook(cb *models.Cookbook, yamlDefault *models.YamlDefault, viewType string) (bool, []models.ImagePolicyViolation, error) {
 	prettyYAML, err := yaml.Marshal(yamlDefault)
 	if err != nil {
-		return false, fmt.Errorf("yaml marshal failed: %w", err)
+		return false, nil, fmt.Errorf("yaml marshal failed: %w", err)
 	}
 
 	var isAdmin bool
@@ -107,19 +112,65 @@ func saveYamlToCookbook(cb *models.Cookbook, yamlDefault *models.YamlDefault, vi
 	case SCOPE_YAML_TYPE_INDIVIDUAL:
 		cb.YamlIndividual, err = prettyPrintYAML(string(prettyYAML))
 		if err != nil {
-			return false, fmt.Errorf("yaml pretty print failed: %w", err)
+			return false, nil, fmt.Errorf("yaml pretty print failed: %w", err)
 		}
 	case SCOPE_YAML_TYPE_SHARED:
 		cb.YamlShared, err = prettyPrintYAML(string(prettyYAML))
 		if err != nil {
-			return false, fmt.Errorf("yaml pretty print failed: %w", err)
+			return false, nil, fmt.Errorf("yaml pretty print failed: %w", err)
 		}
 		isAdmin = true
 	default:
-		return false, fmt.Errorf("view_type not found")
+		return false, nil, fmt.Errorf("view_type not found")
 	}
 
-	return isAdmin, nil
+	policy, err := models.ImagePolicyByAccountID(cb.AccountID)
+	if err != nil {
+		return false, nil, fmt.Errorf("image policy lookup failed: %w", err)
+	}
+
+	return isAdmin, policy.CheckYaml(yamlDefault), nil
+}
+
+// validateSchedules checks the schedule block, step misfire and pull
+// policies, step bounds and worker labels of every recipe so a broken schedule is caught
+// when the cookbook is saved rather than when an app registers it.
+func validateSchedules(pages []models.Page) error {
+	for _, p := range pages {
+		for _, r := range p.Recipes {
+			for _, st := range r.Steps {
+				if !models.ValidMisfirePolicy(st.Misfire) {
+					return fmt.Errorf("recipe %q step %d: misfire %q is not valid (must be 'fire_once', 'fire_all' or 'skip')", r.Name, st.Step, st.Misfire)
+				}
+				if !models.ValidPullPolicy(st.PullPolicy) {
+					return fmt.Errorf("recipe %q step %d: pull_policy %q is not valid (must be 'always', 'if-not-present' or 'scheduled')", r.Name, st.Step, st.PullPolicy)
+				}
+				if err := yeschef.ValidateStepBounds(st); err != nil {
+					return fmt.Errorf("recipe %q step %d: %w", r.Name, st.Step, err)
+				}
+				if err := yeschef.ValidateRunsOn(st); err != nil {
+					return fmt.Errorf("recipe %q step %d: %w", r.Name, st.Step, err)
+				}
+			}
+			if r.Schedule == nil {
+				continue
+			}
+			if err := yeschef.ValidateRecipeSchedule(*r.Schedule); err != nil {
+				return fmt.Errorf("recipe %q: %w", r.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateDockerEndpoint checks that the Docker endpoint a cookbook picked is
+// configured. Endpoints are global, any account may pick any of them.
+func validateDockerEndpoint(name string) error {
+	if name == "" {
+		return nil
+	}
+	_, err := models.DockerEndpointByName(name)
+	return err
 }
 
 // Helper function to process pages and cache generation
@@ -209,15 +260,25 @@ func PostCookbookYamlConfigHandler(c LemcContext) error {
 		return c.NoContent(http.StatusConflict)
 	}
 
+	if err := validateSchedules(yamlNoStorage.Cookbook.Pages); err != nil {
+		c.AddErrorFlash("yaml", "invalid schedule: "+err.Error())
+		return c.NoContent(http.StatusConflict)
+	}
+	if err := validateDockerEndpoint(yamlNoStorage.Cookbook.DockerEndpoint); err != nil {
+		c.AddErrorFlash("yaml", err.Error())
+		return c.NoContent(http.StatusConflict)
+	}
+
 	// Update YAML data
 	yamlDefault.Cookbook.Pages = yamlNoStorage.Cookbook.Pages
 	yamlDefault.Cookbook.Environment = yamlNoStorage.Cookbook.Environment
+	yamlDefault.Cookbook.DockerEndpoint = yamlNoStorage.Cookbook.DockerEndpoint
 
 	// Reorder pages sequentially if needed
 	yamlDefault.Cookbook.Pages = models.ReorderPagesSequentially(yamlDefault.Cookbook.Pages)
 
 	// Save YAML back to cookbook
-	isAdmin, err := saveYamlToCookbook(cb, yamlDefault, viewType)
+	isAdmin, violations, err := saveYamlToCookbook(cb, yamlDefault, viewType)
 	if err != nil {
 		log.Printf("saveYamlToCookbook failed: %v", err)
 		c.AddErrorFlash("yaml", "yaml failed to save")
@@ -245,7 +306,15 @@ func PostCookbookYamlConfigHandler(c LemcContext) error {
 		YamlDefault: *yamlDefault,
 	}
 
-	c.AddSuccessFlash("yaml", "yaml saved")
+	if len(violations) > 0 {
+		var msgs []string
+		for _, v := range violations {
+			msgs = append(msgs, v.String())
+		}
+		c.AddErrorFlash("yaml", "yaml saved with image policy violations: "+strings.Join(msgs, "; "))
+	} else {
+		c.AddSuccessFlash("yaml", "yaml saved")
+	}
 	re := partials.Cookbook(v)
 	return HTML(c, re)
 }
@@ -176,6 +176,13 @@ func PostYamlUpload(c LemcContext) error {
 		return c.JSON(http.StatusInternalServerError, "Invalid view_type")
 	}
 
+	if err := validateSchedules(yaml_default.Cookbook.Pages); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid schedule: "+err.Error())
+	}
+	if err := validateDockerEndpoint(yaml_default.Cookbook.DockerEndpoint); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
 	for k, v := range yaml_default.Cookbook.Storage.Wikis {
 		// Replace any image links containing the old UUID with the new UUID
 		// First, decode the base64 wiki content
@@ -173,56 +173,21 @@ func NewJobStatus(jr *yeschef.JobRecipe) *JobStatus {
 		log.Printf("WARNING: yeschef.XoxoX.NowQueue is nil")
 	}
 
-	// Check IN jobs
-	inKey := yeschef.LemcJobKey(jr, yeschef.IN_QUEUE)
-	log.Printf("Checking IN jobs with key: %s", inKey)
-
-	if yeschef.XoxoX.RunningMan.IsRunning(inKey) {
-		log.Printf("IN job is currently running: %s", inKey)
+	// Check IN and EVERY jobs, each step of a recipe is queued on its own
+	running, queued := yeschef.StepJobStatus(jr, yeschef.IN_QUEUE)
+	log.Printf("IN jobs of %s: running=%v queued=%v", yeschef.LemcJobKey(jr, yeschef.IN_QUEUE), running, queued)
+	if running {
 		js.InRunning = 1
-	} else if yeschef.XoxoX.InQueue != nil {
-		// Check if there's a scheduled IN job
-		jobKey := quartz.NewJobKey(inKey)
-		if job, err := yeschef.XoxoX.InQueue.Get(jobKey); err == nil && job != nil {
-			// Check if the job trigger is expired
-			trigger := job.Trigger()
-			if runOnceTrigger, ok := trigger.(*quartz.RunOnceTrigger); ok && runOnceTrigger.Expired {
-				log.Printf("IN job found but trigger is expired: %s", inKey)
-			} else {
-				log.Printf("IN job is scheduled: %s", inKey)
-				js.InQueued = 1
-			}
-		} else {
-			log.Printf("No scheduled IN job found: %s (err: %v)", inKey, err)
-		}
-	} else {
-		log.Printf("WARNING: yeschef.XoxoX.InQueue is nil")
+	} else if queued {
+		js.InQueued = 1
 	}
 
-	// Check EVERY jobs
-	everyKey := yeschef.LemcJobKey(jr, yeschef.EVERY_QUEUE)
-	log.Printf("Checking EVERY jobs with key: %s", everyKey)
-
-	if yeschef.XoxoX.RunningMan.IsRunning(everyKey) {
-		log.Printf("EVERY job is currently running: %s", everyKey)
+	running, queued = yeschef.StepJobStatus(jr, yeschef.EVERY_QUEUE)
+	log.Printf("EVERY jobs of %s: running=%v queued=%v", yeschef.LemcJobKey(jr, yeschef.EVERY_QUEUE), running, queued)
+	if running {
 		js.EveryRunning = 1
-	} else if yeschef.XoxoX.EveryQueue != nil {
-		// Check if there's a scheduled EVERY job
-		jobKey := quartz.NewJobKey(everyKey)
-		if job, err := yeschef.XoxoX.EveryQueue.Get(jobKey); err == nil && job != nil {
-			// EVERY jobs use SimpleTrigger which doesn't expire, but check for RunOnceTrigger just in case
-			trigger := job.Trigger()
-			if runOnceTrigger, ok := trigger.(*quartz.RunOnceTrigger); ok && runOnceTrigger.Expired {
-				log.Printf("EVERY job found but trigger is expired: %s", everyKey)
-			} else {
-				log.Printf("EVERY job is scheduled: %s", everyKey)
-				js.EveryQueued = 1
-			}
-		} else {
-			log.Printf("No scheduled EVERY job found: %s (err: %v)", everyKey, err)
-		}
-	} else {
-		log.Printf("WARNING: yeschef.XoxoX.EveryQueue is nil")
+	} else if queued {
+		js.EveryQueued = 1
 	}
 
 	log.Printf("Final JobStatus: NOW Running=%d Queued=%d, IN Running=%d Queued=%d, EVERY Running=%d Queued=%d",
@@ -300,15 +265,7 @@ func PutCookbookJob(c LemcContext) error {
 
 	yaml_default.UUID = cb.UUID
 
-	for _, private := range yaml_default.Cookbook.Environment.Private {
-		log.Debug(private)
-		env = append(env, private)
-	}
-
-	for _, public := range yaml_default.Cookbook.Environment.Public {
-		log.Debug(public)
-		env = append(env, public)
-	}
+	env = yeschef.CookbookEnv(yaml_default)
 
 	for _, p := range yaml_default.Cookbook.Pages {
 		if p.PageID == pagei {
@@ -348,12 +305,23 @@ func PutCookbookJob(c LemcContext) error {
 		UUID:             cb.UUID,
 		PageID:           pageid,
 		CookbookID:       fmt.Sprintf("%d", cb.ID),
+		AccountID:        cb.AccountID,
 		UserID:           fmt.Sprintf("%d", originatingUserID),
 		Username:         username,
 		Env:              env,
 		Scope:            scope,
 		Recipe:           final_recipe,
 		RecipientUserIDs: recipientUserIDs,
+		DockerEndpoint:   yaml_default.Cookbook.DockerEndpoint,
+	}
+
+	if err := yeschef.CheckJobImagePolicy(job, cb.AccountID); err != nil {
+		if userErr := yeschef.GetUserVisibleError(err); userErr != nil {
+			c.AddErrorFlash("error", userErr.Message)
+		} else {
+			c.AddErrorFlash("error", "failed to check image policy: "+err.Error())
+		}
+		return c.NoContent(http.StatusConflict)
 	}
 
 	if missing, err := yeschef.CheckJobImages(job); err == nil && len(missing) > 0 {
@@ -380,7 +348,19 @@ func PutCookbookJob(c LemcContext) error {
 	return HTML(c, partials.OpenMonitorModal(cb.UUID, pageid, msg))
 }
 
-func PutAppJob(c LemcContext) error {
+// appJob is a job built from an app run request along with the app and
+// cookbook YAML it was built from.
+type appJob struct {
+	App  *models.App
+	Yaml models.YamlDefault
+	Job  *yeschef.JobRecipe
+}
+
+// buildAppJob resolves the app, recipe, scope and environment of an app run
+// request. It is shared by PutAppJob and PostAppJobPlan so a plan always
+// reflects what a run would do. On failure the returned status and error
+// message are meant to be sent back to the client.
+func buildAppJob(c LemcContext) (*appJob, int, error) {
 	var env []string
 	var username, pageid string
 	var yaml_default models.YamlDefault
@@ -395,8 +375,7 @@ func PutAppJob(c LemcContext) error {
 
 	formValues, err := c.FormParams()
 	if err != nil {
-		c.AddErrorFlash("error", err.Error())
-		return c.NoContent(http.StatusConflict)
+		return nil, http.StatusConflict, errors.New(err.Error())
 	}
 
 	// Look up App first
@@ -404,11 +383,9 @@ func PutAppJob(c LemcContext) error {
 	if err != nil {
 		log.Printf("Error fetching app by UUID %s for account %d: %v", uuid, c.UserContext().ActingAs.Account.ID, err)
 		if errors.Is(err, sql.ErrNoRows) {
-			c.AddErrorFlash("error", "app not found or permission denied")
-			return c.NoContent(http.StatusNotFound)
+			return nil, http.StatusNotFound, errors.New("app not found or permission denied")
 		}
-		c.AddErrorFlash("error", "Error retrieving app")
-		return c.NoContent(http.StatusInternalServerError)
+		return nil, http.StatusInternalServerError, errors.New("Error retrieving app")
 	}
 
 	// Get associated Cookbook
@@ -417,11 +394,9 @@ func PutAppJob(c LemcContext) error {
 	if err != nil {
 		log.Printf("Error fetching cookbook by ID %d for account %d (from app %s): %v", app.CookbookID, c.UserContext().ActingAs.Account.ID, uuid, err)
 		if errors.Is(err, sql.ErrNoRows) {
-			c.AddErrorFlash("error", "Associated cookbook not found")
-			return c.NoContent(http.StatusNotFound)
+			return nil, http.StatusNotFound, errors.New("Associated cookbook not found")
 		}
-		c.AddErrorFlash("error", "Error retrieving associated cookbook")
-		return c.NoContent(http.StatusInternalServerError)
+		return nil, http.StatusInternalServerError, errors.New("Error retrieving associated cookbook")
 	}
 
 	// Populate Cookbook struct with App details
@@ -437,8 +412,7 @@ func PutAppJob(c LemcContext) error {
 	originatingUserID := c.UserContext().ActingAs.ID
 	pagei, err := strconv.Atoi(page)
 	if err != nil {
-		c.AddErrorFlash("error", "error parsing page")
-		return c.NoContent(http.StatusConflict)
+		return nil, http.StatusConflict, errors.New("error parsing page")
 	}
 
 	switch view_type {
@@ -446,8 +420,7 @@ func PutAppJob(c LemcContext) error {
 		scope = SCOPE_YAML_TYPE_INDIVIDUAL
 		err = yaml.Unmarshal([]byte(CookbookPretendingToBeApp.YamlIndividual), &yaml_default)
 		if err != nil {
-			c.AddErrorFlash("error", "error parsing yaml")
-			return c.NoContent(http.StatusConflict)
+			return nil, http.StatusConflict, errors.New("error parsing yaml")
 		}
 		recipientUserIDs = []int64{originatingUserID}
 		isShared = false
@@ -457,34 +430,23 @@ func PutAppJob(c LemcContext) error {
 		scope = SCOPE_YAML_TYPE_SHARED
 		err = yaml.Unmarshal([]byte(CookbookPretendingToBeApp.YamlShared), &yaml_default)
 		if err != nil {
-			c.AddErrorFlash("error", "error parsing yaml")
-			return c.NoContent(http.StatusConflict)
+			return nil, http.StatusConflict, errors.New("error parsing yaml")
 		}
 		isShared = true
 		ids, err := models.GetUserIDsForSharedApp(CookbookPretendingToBeApp.UUID)
 		if err != nil {
 			log.Errorf("Error getting user IDs for shared app %s: %v", CookbookPretendingToBeApp.UUID, err)
-			c.AddErrorFlash("error", "Failed to determine recipients for shared job.")
-			return c.NoContent(http.StatusInternalServerError)
+			return nil, http.StatusInternalServerError, errors.New("Failed to determine recipients for shared job.")
 		}
 		recipientUserIDs = ids
 		http_file_download = fmt.Sprintf(paths.LockerDownloadPattern, CookbookPretendingToBeApp.UUID, pagei, SCOPE_YAML_TYPE_SHARED)
 	default:
-		c.AddErrorFlash("error", "view_type not found")
-		return c.NoContent(http.StatusConflict)
+		return nil, http.StatusConflict, errors.New("view_type not found")
 	}
 
 	yaml_default.UUID = CookbookPretendingToBeApp.UUID
 
-	for _, private := range yaml_default.Cookbook.Environment.Private {
-		log.Debug(private)
-		env = append(env, private)
-	}
-
-	for _, public := range yaml_default.Cookbook.Environment.Public {
-		log.Debug(public)
-		env = append(env, public)
-	}
+	env = yeschef.CookbookEnv(yaml_default)
 
 	for _, p := range yaml_default.Cookbook.Pages {
 		if p.PageID == pagei {
@@ -493,8 +455,7 @@ func PutAppJob(c LemcContext) error {
 					for key, values := range formValues {
 						// Process all form fields without requiring a prefix
 						if validateFormName(key) != nil {
-							c.AddErrorFlash("error", "error parsing form field names, invalid characters in: "+key)
-							return c.NoContent(http.StatusConflict)
+							return nil, http.StatusConflict, errors.New("error parsing form field names, invalid characters in: " + key)
 						}
 						uppercasedFieldName := strings.ToUpper(key)
 						for _, value := range values {
@@ -503,13 +464,7 @@ func PutAppJob(c LemcContext) error {
 						}
 					}
 
-					env = append(env, "LEMC_SCOPE="+scope)
-					env = append(env, "LEMC_USER_ID="+fmt.Sprintf("%d", originatingUserID))
-					env = append(env, "LEMC_USERNAME="+username)
-					env = append(env, "LEMC_UUID="+CookbookPretendingToBeApp.UUID)
-					env = append(env, "LEMC_RECIPE_NAME="+util.AlphaNumHyphen(r.Name))
-					env = append(env, "LEMC_PAGE_ID="+fmt.Sprintf("%d", p.PageID))
-					env = append(env, fmt.Sprintf("LEMC_HTTP_DOWNLOAD_BASE_URL=%s", http_file_download))
+					env = append(env, yeschef.LemcEnv(scope, originatingUserID, username, CookbookPretendingToBeApp.UUID, r.Name, p.PageID, http_file_download)...)
 					pageid = strconv.Itoa(p.PageID)
 					final_recipe = r
 					final_recipe.IsShared = isShared
@@ -523,12 +478,34 @@ func PutAppJob(c LemcContext) error {
 		UUID:             CookbookPretendingToBeApp.UUID,
 		PageID:           pageid,
 		AppID:            fmt.Sprintf("%d", app.ID),
+		AccountID:        app.AccountID,
 		UserID:           fmt.Sprintf("%d", originatingUserID),
 		Username:         username,
 		Env:              env,
 		Scope:            scope,
 		Recipe:           final_recipe,
 		RecipientUserIDs: recipientUserIDs,
+		DockerEndpoint:   yaml_default.Cookbook.DockerEndpoint,
+	}
+
+	return &appJob{App: app, Yaml: yaml_default, Job: job}, http.StatusOK, nil
+}
+
+func PutAppJob(c LemcContext) error {
+	aj, status, err := buildAppJob(c)
+	if err != nil {
+		c.AddErrorFlash("error", err.Error())
+		return c.NoContent(status)
+	}
+	app, job := aj.App, aj.Job
+
+	if err := yeschef.CheckJobImagePolicy(job, app.AccountID); err != nil {
+		if userErr := yeschef.GetUserVisibleError(err); userErr != nil {
+			c.AddErrorFlash("error", userErr.Message)
+		} else {
+			c.AddErrorFlash("error", "failed to check image policy: "+err.Error())
+		}
+		return c.NoContent(http.StatusConflict)
 	}
 
 	if missing, err := yeschef.CheckJobImages(job); err == nil && len(missing) > 0 {
@@ -552,5 +529,141 @@ func PutAppJob(c LemcContext) error {
 	}
 
 	c.AddSuccessFlash("success", msg)
-	return HTML(c, partials.OpenMonitorModal(CookbookPretendingToBeApp.UUID, pageid, msg))
+	return HTML(c, partials.OpenMonitorModal(job.UUID, job.PageID, msg))
+}
+
+// PostAppJobPlan renders what PutAppJob would do for the same request without
+// starting any containers.
+func PostAppJobPlan(c LemcContext) error {
+	aj, status, err := buildAppJob(c)
+	if err != nil {
+		c.AddErrorFlash("error", err.Error())
+		return c.NoContent(status)
+	}
+
+	plan, err := yeschef.PlanJob(aj.Job, aj.Yaml.Cookbook.Environment.Private)
+	if err != nil {
+		if userErr := yeschef.GetUserVisibleError(err); userErr != nil {
+			c.AddErrorFlash("error", userErr.Message)
+			return c.NoContent(http.StatusBadRequest)
+		}
+		log.Errorf("Error planning job: %v", err)
+		c.AddErrorFlash("error", "Error planning job")
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return HTML(c, partials.JobPlan(plan))
+}
+
+// reconcileAppSchedules registers the schedule blocks declared in the app's
+// YAML with the scheduler and drops the ones that are no longer declared.
+func reconcileAppSchedules(app *models.App, savedBy int64) error {
+	specs, err := appScheduleSpecs(app, savedBy)
+	return errors.Join(err, yeschef.ReconcileAppSchedules(app.UUID, specs))
+}
+
+// appScheduleSpecs resolves every recipe schedule in the app's shared and
+// individual YAML. Recipes that fail to resolve are left out and reported in
+// the returned error. savedBy is the user who saved the app.
+func appScheduleSpecs(app *models.App, savedBy int64) ([]yeschef.RecipeScheduleSpec, error) {
+	docs := []struct{ scope, yaml string }{
+		{SCOPE_YAML_TYPE_SHARED, app.YAMLShared},
+		{SCOPE_YAML_TYPE_INDIVIDUAL, app.YAMLIndividual},
+	}
+
+	var specs []yeschef.RecipeScheduleSpec
+	var errs []error
+	for _, doc := range docs {
+		if strings.TrimSpace(doc.yaml) == "" {
+			continue
+		}
+		var yd models.YamlDefault
+		if err := yaml.Unmarshal([]byte(doc.yaml), &yd); err != nil {
+			errs = append(errs, fmt.Errorf("%s yaml: %w", doc.scope, err))
+			continue
+		}
+		yd.UUID = app.UUID
+
+		for _, p := range yd.Cookbook.Pages {
+			for _, r := range p.Recipes {
+				if r.Schedule == nil {
+					continue
+				}
+				job, err := scheduledAppJob(app, savedBy, yd, p.PageID, r, doc.scope)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("recipe %q: %w", r.Name, err))
+					continue
+				}
+				specs = append(specs, yeschef.RecipeScheduleSpec{Job: job, Schedule: *r.Schedule})
+			}
+		}
+	}
+	return specs, errors.Join(errs...)
+}
+
+// scheduledAppJob builds the job a recipe schedule runs. It mirrors
+// buildAppJob without form input: the recipe runs as the schedule's run_as
+// user, or the app owner, in the schedule's scope, or the scope of the YAML
+// it is declared in. That user must be allowed to run the recipes of the
+// scope, and only an account administrator may have a schedule run as
+// someone else than themselves.
+func scheduledAppJob(app *models.App, savedBy int64, yd models.YamlDefault, pageID int, r models.Recipe, yamlScope string) (*yeschef.JobRecipe, error) {
+	schedule := *r.Schedule
+	if err := yeschef.ValidateRecipeSchedule(schedule); err != nil {
+		return nil, err
+	}
+
+	scope := schedule.Scope
+	if scope == "" {
+		scope = yamlScope
+	}
+
+	userID := app.OwnerID
+	if schedule.RunAs != "" {
+		runAs, err := models.ByUsernameAndAccountID(schedule.RunAs, app.AccountID)
+		if err != nil {
+			return nil, fmt.Errorf("run_as user %q not found in account: %w", schedule.RunAs, err)
+		}
+		if runAs.ID != savedBy {
+			admin, err := models.HasAccountPermission(savedBy, app.AccountID, models.CanAdministerAccount)
+			if err != nil {
+				return nil, err
+			}
+			if !admin {
+				return nil, fmt.Errorf("run_as user %q: only an account administrator may schedule runs as another user", schedule.RunAs)
+			}
+		}
+		userID = runAs.ID
+	}
+	user, err := yeschef.ScopeUser(userID, app.AccountID, app.ID, scope)
+	if err != nil {
+		return nil, fmt.Errorf("schedule user: %w", err)
+	}
+
+	recipients := []int64{user.ID}
+	if scope == SCOPE_YAML_TYPE_SHARED {
+		if recipients, err = models.GetUserIDsForSharedApp(app.UUID); err != nil {
+			return nil, fmt.Errorf("shared recipients: %w", err)
+		}
+	}
+
+	env := yeschef.CookbookEnv(yd)
+	env = append(env, yeschef.LemcEnv(scope, user.ID, user.Username, app.UUID, r.Name, pageID, fmt.Sprintf(paths.LockerDownloadPattern, app.UUID, pageID, scope))...)
+
+	recipe := r
+	recipe.IsShared = scope == SCOPE_YAML_TYPE_SHARED
+	return &yeschef.JobRecipe{
+		JobType:          yeschef.JOB_TYPE_APP,
+		UUID:             app.UUID,
+		PageID:           strconv.Itoa(pageID),
+		AppID:            fmt.Sprintf("%d", app.ID),
+		AccountID:        app.AccountID,
+		UserID:           fmt.Sprintf("%d", user.ID),
+		Username:         user.Username,
+		Env:              env,
+		Scope:            scope,
+		Recipe:           recipe,
+		RecipientUserIDs: recipients,
+		DockerEndpoint:   yd.Cookbook.DockerEndpoint,
+	}, nil
 }
@@ -1,269 +1,32 @@
 package handlers
 
 import (
-	"encoding/json"
+	"database/sql"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/jaredfolkins/letemcook/models"
 	"github.com/jaredfolkins/letemcook/paths"
-	"github.com/jaredfolkins/letemcook/util"
 	"github.com/jaredfolkins/letemcook/views/pages"
+	"github.com/jaredfolkins/letemcook/yeschef"
 	"github.com/labstack/echo/v4"
+	"github.com/reugn/go-quartz/quartz"
 )
 
-type persistedJobInfo struct {
-	ID          string     `json:"id"`
-	RecipeName  string     `json:"recipe_name"`
-	Username    string     `json:"username"`
-	AccountID   int64      `json:"account_id"` // Important for filtering later
-	JobType     string     `json:"job_type"`   // e.g., "NOW", "IN", "EVERY"
-	Status      string     `json:"status"`     // e.g., "Scheduled", "Running", "Completed"
-	CreatedAt   time.Time  `json:"created_at"`
-	ScheduledAt *time.Time `json:"scheduled_at,omitempty"` // Pointer, might be null
-}
-
-// YesChef job structures - imported from yeschef package structures
-type yeschefStepJob struct {
-	Job         *yeschefStepJobData `json:"job"`
-	JobKey      string              `json:"job_key"`
-	Description string              `json:"description"`
-	Group       string              `json:"group"`
-	Trigger     string              `json:"trigger"`
-	NextRunTime int64               `json:"next_run_time"`
-}
-
-type yeschefStepJobData struct {
-	Step      *yeschefStep      `json:"Step"`
-	RecipeJob *yeschefRecipeJob `json:"RecipeJob"`
-}
-
-type yeschefStep struct {
-	Step    int    `json:"Step"`
-	Name    string `json:"Name"`
-	Image   string `json:"Image"`
-	Do      string `json:"Do"`
-	Timeout string `json:"Timeout"`
-}
-
-type yeschefRecipeJob struct {
-	JobType    string         `json:"JobType"`
-	UUID       string         `json:"UUID"`
-	CookbookID string         `json:"CookbookID"`
-	AppID      string         `json:"AppID"`
-	UserID     string         `json:"UserID"`
-	Username   string         `json:"Username"`
-	PageID     string         `json:"PageID"`
-	StepID     string         `json:"StepID"`
-	Scope      string         `json:"Scope"`
-	Recipe     *yeschefRecipe `json:"Recipe"`
-}
-
-type yeschefRecipe struct {
-	Name        string `json:"Name"`
-	Description string `json:"Description"`
-}
-
-type yeschefRecipeJobFile struct {
-	Job         *yeschefRecipeJob `json:"job"`
-	JobKey      string            `json:"job_key"`
-	Description string            `json:"description"`
-	Group       string            `json:"group"`
-	Trigger     string            `json:"trigger"`
-	NextRunTime int64             `json:"next_run_time"`
-}
-
-func extractJobInfoFromYeschef(fileData []byte, filename string, dirPath string) (*persistedJobInfo, error) {
-	// Determine job type from directory path
-	jobType := "UNKNOWN"
-	if strings.Contains(dirPath, "/now/") || strings.HasSuffix(dirPath, "/now") {
-		jobType = "NOW"
-	} else if strings.Contains(dirPath, "/in/") || strings.HasSuffix(dirPath, "/in") {
-		jobType = "IN"
-	} else if strings.Contains(dirPath, "/every/") || strings.HasSuffix(dirPath, "/every") {
-		jobType = "EVERY"
-	}
-
-	// Try to parse as step job first
-	var stepJob yeschefStepJob
-	if err := json.Unmarshal(fileData, &stepJob); err == nil && stepJob.Job != nil && stepJob.Job.RecipeJob != nil {
-		userID, _ := strconv.ParseInt(stepJob.Job.RecipeJob.UserID, 10, 64)
-
-		recipeName := "Unknown Recipe"
-		if stepJob.Job.RecipeJob.Recipe != nil {
-			recipeName = stepJob.Job.RecipeJob.Recipe.Name
-		} else if stepJob.Job.Step != nil {
-			recipeName = stepJob.Job.Step.Name
-		}
-
-		// Extract timestamp from filename for ID and creation time
-		var timestamp int64
-		var err error
-		if strings.HasSuffix(filename, ".json") {
-			timestamp, err = strconv.ParseInt(strings.TrimSuffix(filename, ".json"), 10, 64)
-		} else {
-			timestamp, err = strconv.ParseInt(filename, 10, 64)
-		}
-		if err != nil {
-			timestamp = time.Now().UnixNano()
-		}
-
-		createdAt := time.Unix(0, timestamp)
-		scheduledAt := time.Unix(0, stepJob.NextRunTime)
-
-		return &persistedJobInfo{
-			ID:          filename,
-			RecipeName:  recipeName,
-			Username:    stepJob.Job.RecipeJob.Username,
-			AccountID:   userID,
-			JobType:     jobType,
-			Status:      "Scheduled",
-			CreatedAt:   createdAt,
-			ScheduledAt: &scheduledAt,
-		}, nil
-	}
-
-	// Try to parse as recipe job
-	var recipeJob yeschefRecipeJobFile
-	if err := json.Unmarshal(fileData, &recipeJob); err == nil && recipeJob.Job != nil {
-		userID, _ := strconv.ParseInt(recipeJob.Job.UserID, 10, 64)
-
-		recipeName := "Unknown Recipe"
-		if recipeJob.Job.Recipe != nil {
-			recipeName = recipeJob.Job.Recipe.Name
-		}
-
-		// Extract timestamp from filename for ID and creation time
-		var timestamp int64
-		var err error
-		if strings.HasSuffix(filename, ".json") {
-			timestamp, err = strconv.ParseInt(strings.TrimSuffix(filename, ".json"), 10, 64)
-		} else {
-			timestamp, err = strconv.ParseInt(filename, 10, 64)
-		}
-		if err != nil {
-			timestamp = time.Now().UnixNano()
-		}
-
-		createdAt := time.Unix(0, timestamp)
-		scheduledAt := time.Unix(0, recipeJob.NextRunTime)
-
-		return &persistedJobInfo{
-			ID:          filename,
-			RecipeName:  recipeName,
-			Username:    recipeJob.Job.Username,
-			AccountID:   userID,
-			JobType:     jobType,
-			Status:      "Scheduled",
-			CreatedAt:   createdAt,
-			ScheduledAt: &scheduledAt,
-		}, nil
-	}
-
-	return nil, fmt.Errorf("unable to parse as yeschef job format")
-}
-
-func getJobs(page, limit int, c LemcContext) ([]models.JobInfo, int, error) { // Removed userID param, using context 'c' instead
+func getJobs(page, limit int, c LemcContext) ([]models.JobInfo, int, error) {
 	userCtx := c.UserContext()
 	if userCtx == nil || userCtx.LoggedInAs == nil {
 		return nil, 0, fmt.Errorf("user context not available")
 	}
-	expectedAccountID := userCtx.ActingAs.Account.ID
-
-	jobDataDir := util.QueuesPath()
-	loadedJobs := []persistedJobInfo{}
-
-	err := filepath.WalkDir(jobDataDir, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			// Skip paths we can't stat/read but continue walking
-			log.Printf("Error accessing path '%s': %v", path, err)
-			return nil
-		}
-
-		if d.IsDir() {
-			return nil
-		}
-
-		// Accept both .json files and files without extensions (for backward compatibility)
-		filename := d.Name()
-		if !strings.HasSuffix(filename, ".json") {
-			// Check if it's a timestamp-like filename (all digits)
-			if _, err := strconv.ParseInt(filename, 10, 64); err != nil {
-				return nil // Skip non-numeric filenames without .json extension
-			}
-		}
-
-		fileData, readErr := os.ReadFile(path)
-		if readErr != nil {
-			log.Printf("Error reading job file '%s': %v", path, readErr)
-			return nil
-		}
-
-		// Try to parse as old persistedJobInfo format first
-		var jobData persistedJobInfo
-		if unmarshalErr := json.Unmarshal(fileData, &jobData); unmarshalErr == nil {
-			// Check if the parsed data is actually valid (not just empty struct)
-			if jobData.ID != "" && jobData.AccountID != 0 {
-				loadedJobs = append(loadedJobs, jobData)
-				return nil
-			}
-		}
-
-		// Try to parse as yeschef format
-		if jobInfo, err := extractJobInfoFromYeschef(fileData, filename, path); err == nil {
-			loadedJobs = append(loadedJobs, *jobInfo)
-		} else {
-			log.Printf("Error parsing job file '%s' as either format: %v", path, err)
-		}
-
-		return nil
-	})
+	jobs, total, err := models.ScheduledJobInfos(userCtx.ActingAs.Account.ID, page, limit)
 	if err != nil {
-		if os.IsNotExist(err) {
-			log.Printf("Job data directory not found: %s. Returning empty job list.", jobDataDir)
-			return []models.JobInfo{}, 0, nil
-		}
-		log.Printf("Error walking job directory '%s': %v", jobDataDir, err)
-		return nil, 0, fmt.Errorf("failed to read job data directory: %w", err)
-	}
-
-	filteredJobs := []models.JobInfo{}
-	for _, jobData := range loadedJobs { // Iterate over persistedJobInfo
-		shouldInclude := (jobData.AccountID == expectedAccountID)
-
-		if shouldInclude {
-			jobInfo := models.JobInfo{
-				ID:         jobData.ID,
-				RecipeName: jobData.RecipeName,
-				Username:   jobData.Username,
-				Type:       jobData.JobType,
-				Status:     jobData.Status,
-				CreatedAt:  jobData.CreatedAt,
-			}
-			if jobData.ScheduledAt != nil {
-				jobInfo.ScheduledAt = *jobData.ScheduledAt
-			}
-			filteredJobs = append(filteredJobs, jobInfo) // Append the mapped struct
-		}
+		return nil, 0, err
 	}
-
-	totalJobs := len(filteredJobs)
-	start := (page - 1) * limit
-	end := start + limit
-	if start >= totalJobs {
-		return []models.JobInfo{}, totalJobs, nil // Page out of bounds
-	}
-	if end > totalJobs {
-		end = totalJobs
-	}
-
-	return filteredJobs[start:end], totalJobs, nil
+	yeschef.DescribeScheduledJobs(jobs)
+	return jobs, total, nil
 }
 
 func GetJobs(c LemcContext) error { // Changed context type to LemcContext
@@ -315,60 +78,194 @@ func GetJobs(c LemcContext) error { // Changed context type to LemcContext
 }
 
 func getAllJobs(page, limit int) ([]models.JobInfo, int, error) {
-	jobDataDir := util.QueuesPath()
-	loadedJobs := []persistedJobInfo{}
-	err := filepath.WalkDir(jobDataDir, func(path string, d os.DirEntry, err error) error {
+	jobs, total, err := models.ScheduledJobInfos(0, page, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	yeschef.DescribeScheduledJobs(jobs)
+	return jobs, total, nil
+}
+
+// jobActionForm identifies a queued job. Interval is only read by the
+// interval action.
+type jobActionForm struct {
+	Queue    string `form:"queue" json:"queue"`
+	Key      string `form:"key" json:"key"`
+	Interval string `form:"interval" json:"interval"`
+}
+
+var errUnknownJobAction = errors.New("unknown job action")
+
+// applyJobAction runs action against the queued job named by f. A non-zero
+// accountID restricts the action to jobs owned by that account.
+func applyJobAction(accountID int64, action string, f jobActionForm) error {
+	queue := strings.ToLower(f.Queue)
+	if accountID != 0 {
+		job, err := models.ScheduledJobByKey(queue, f.Key)
 		if err != nil {
-			log.Printf("Error accessing path '%s': %v", path, err)
-			return nil
-		}
-		if d.IsDir() {
-			return nil
+			return err
 		}
-		if filepath.Ext(d.Name()) != ".json" {
-			return nil
+		if job.AccountID != accountID {
+			return sql.ErrNoRows
 		}
-		fileData, rErr := os.ReadFile(path)
-		if rErr != nil {
-			log.Printf("Error reading job file '%s': %v", path, rErr)
-			return nil
-		}
-		var jobData persistedJobInfo
-		if uErr := json.Unmarshal(fileData, &jobData); uErr != nil {
-			log.Printf("Error unmarshalling job file '%s': %v", path, uErr)
-			return nil
+	}
+
+	switch action {
+	case "pause":
+		return yeschef.PauseScheduledJob(queue, f.Key)
+	case "resume":
+		return yeschef.ResumeScheduledJob(queue, f.Key)
+	case "run":
+		return yeschef.RunScheduledJobNow(queue, f.Key)
+	case "interval":
+		return yeschef.SetScheduledJobInterval(queue, f.Key, f.Interval)
+	case "delete":
+		return yeschef.DeleteScheduledJob(queue, f.Key)
+	}
+	return fmt.Errorf("%w: %q", errUnknownJobAction, action)
+}
+
+// jobActionStatus maps an applyJobAction error to an HTTP status code.
+func jobActionStatus(err error) int {
+	switch {
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, quartz.ErrJobNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errUnknownJobAction), errors.Is(err, yeschef.ErrUnmanagedQueue), errors.Is(err, yeschef.ErrInvalidInterval),
+		errors.Is(err, yeschef.ErrInvalidDeadLetter):
+		return http.StatusBadRequest
+	}
+	return http.StatusConflict
+}
+
+// PostJobActionHandler pauses, resumes, runs, reschedules or deletes one of
+// the acting account's in or every jobs and re-renders the jobs page.
+func PostJobActionHandler(c LemcContext) error {
+	var f jobActionForm
+	if err := c.Bind(&f); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if err := applyJobAction(c.UserContext().ActingAs.Account.ID, c.Param("action"), f); err != nil {
+		c.AddErrorFlash("jobs", "job "+c.Param("action")+" failed: "+err.Error())
+		return c.NoContent(jobActionStatus(err))
+	}
+	return GetJobs(c)
+}
+
+// PostSystemJobActionHandler is PostJobActionHandler for any account's jobs.
+func PostSystemJobActionHandler(c LemcContext) error {
+	var f jobActionForm
+	if err := c.Bind(&f); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if err := applyJobAction(0, c.Param("action"), f); err != nil {
+		c.AddErrorFlash("jobs", "job "+c.Param("action")+" failed: "+err.Error())
+		return c.NoContent(jobActionStatus(err))
+	}
+	return GetSystemJobsHandler(c)
+}
+
+// deadJobActionForm identifies a dead letter job. Payload is only read by
+// the edit action.
+type deadJobActionForm struct {
+	ID      int64  `form:"id" json:"id"`
+	Payload string `form:"payload" json:"payload"`
+}
+
+// applyDeadJobAction retries, edits and retries, or purges dead letter jobs.
+func applyDeadJobAction(action string, f deadJobActionForm) error {
+	switch action {
+	case "retry":
+		return yeschef.RetryDeadLetterJob(f.ID, "")
+	case "edit":
+		if strings.TrimSpace(f.Payload) == "" {
+			return fmt.Errorf("%w: payload is empty", yeschef.ErrInvalidDeadLetter)
 		}
-		loadedJobs = append(loadedJobs, jobData)
-		return nil
-	})
+		return yeschef.RetryDeadLetterJob(f.ID, f.Payload)
+	case "purge":
+		return models.DeleteDeadLetterJob(f.ID)
+	case "purge_all":
+		_, err := models.PurgeDeadLetterJobs()
+		return err
+	}
+	return fmt.Errorf("%w: %q", errUnknownJobAction, action)
+}
+
+// PostSystemDeadJobActionHandler acts on the dead letter jobs listed on the
+// system jobs page and re-renders it.
+func PostSystemDeadJobActionHandler(c LemcContext) error {
+	var f deadJobActionForm
+	if err := c.Bind(&f); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if err := applyDeadJobAction(c.Param("action"), f); err != nil {
+		c.AddErrorFlash("jobs", "dead job "+c.Param("action")+" failed: "+err.Error())
+		return c.NoContent(jobActionStatus(err))
+	}
+	return GetSystemJobsHandler(c)
+}
+
+// drainForm turns drain mode on or off. Message is shown to users whose runs
+// are refused.
+type drainForm struct {
+	Drain   bool   `form:"drain" json:"drain"`
+	Message string `form:"message" json:"message"`
+}
+
+// PostSystemDrainHandler toggles drain mode from the system jobs page.
+func PostSystemDrainHandler(c LemcContext) error {
+	var f drainForm
+	if err := c.Bind(&f); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	yeschef.SetDrain(f.Drain, f.Message)
+	return GetSystemJobsHandler(c)
+}
+
+// GetApiJobsHandler lists the acting account's queued jobs as JSON.
+func GetApiJobsHandler(c LemcContext) error {
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit < 1 {
+		limit = 10
+	}
+
+	jobs, total, err := getJobs(page, limit, c)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []models.JobInfo{}, 0, nil
-		}
-		return nil, 0, fmt.Errorf("failed to read job data directory: %w", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
-	jobs := make([]models.JobInfo, len(loadedJobs))
-	for i, jd := range loadedJobs {
-		jobs[i] = models.JobInfo{
-			ID:         jd.ID,
-			RecipeName: jd.RecipeName,
-			Username:   jd.Username,
-			Type:       jd.JobType,
-			Status:     jd.Status,
-			CreatedAt:  jd.CreatedAt,
-		}
-		if jd.ScheduledAt != nil {
-			jobs[i].ScheduledAt = *jd.ScheduledAt
-		}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"jobs":  jobs,
+		"page":  page,
+		"limit": limit,
+		"total": total,
+	})
+}
+
+// PostApiJobActionHandler is PostJobActionHandler for JSON clients. It
+// responds with the job as it is queued after the action, or with only its
+// key once it has been deleted.
+func PostApiJobActionHandler(c LemcContext) error {
+	var f jobActionForm
+	if err := c.Bind(&f); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
-	total := len(jobs)
-	start := (page - 1) * limit
-	end := start + limit
-	if start >= total {
-		return []models.JobInfo{}, total, nil
+	action := c.Param("action")
+	if err := applyJobAction(c.UserContext().ActingAs.Account.ID, action, f); err != nil {
+		return c.JSON(jobActionStatus(err), map[string]string{"error": err.Error()})
 	}
-	if end > total {
-		end = total
+	if action == "delete" {
+		return c.JSON(http.StatusOK, map[string]string{"queue": strings.ToLower(f.Queue), "key": f.Key, "status": "deleted"})
+	}
+
+	job, err := models.ScheduledJobInfoByKey(strings.ToLower(f.Queue), f.Key)
+	if err != nil {
+		// A run once job may already have fired and left its queue.
+		return c.JSON(http.StatusOK, map[string]string{"queue": strings.ToLower(f.Queue), "key": f.Key, "status": "done"})
 	}
-	return jobs[start:end], total, nil
+	jobs := []models.JobInfo{*job}
+	yeschef.DescribeScheduledJobs(jobs)
+	return c.JSON(http.StatusOK, jobs[0])
 }
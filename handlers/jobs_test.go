@@ -1,40 +1,32 @@
 package handlers
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/jaredfolkins/letemcook/db"
 	"github.com/jaredfolkins/letemcook/middleware"
 	"github.com/jaredfolkins/letemcook/models"
-	"github.com/jaredfolkins/letemcook/util"
+	"github.com/jaredfolkins/letemcook/yeschef"
 	"github.com/labstack/echo/v4"
 )
 
-// Helper functions
-
-// testCleanup removes all test data to ensure test isolation
-func testCleanup(t *testing.T) {
+// setupJobsTest empties every queue and clears it again when the test ends.
+func setupJobsTest(t *testing.T) {
 	t.Helper()
-	queuesPath := util.QueuesPath()
-	if err := os.RemoveAll(queuesPath); err != nil {
-		t.Logf("Warning: failed to clean up test directory: %v", err)
+	clear := func() {
+		for _, q := range []string{"now", "in", "every"} {
+			if err := models.ClearScheduledJobs(q); err != nil {
+				t.Fatalf("clear %s: %v", q, err)
+			}
+		}
 	}
-}
-
-// setupTestEnv sets up the test environment and returns a cleanup function
-func setupTestEnv(t *testing.T) func() {
-	t.Helper()
-	os.Setenv("LEMC_ENV", "test")
-	os.Setenv("LEMC_DATA", util.TestDataRoot())
-	testCleanup(t) // Clean up any existing data
-	return func() { testCleanup(t) }
+	clear()
+	t.Cleanup(clear)
 }
 
 // testContextWithAccount creates a test context with a user belonging to given account ID
@@ -49,569 +41,309 @@ func testContextWithAccount(t *testing.T, accountID int64) LemcContext {
 	return middleware.SetUserContext(c, uc)
 }
 
-// writeJob writes a legacy format job to a file
-func writeJob(t *testing.T, dir, name string, info persistedJobInfo) {
+// queueJob inserts a scheduled job row the way the yeschef queues do.
+func queueJob(t *testing.T, queue, key, recipe, username string, accountID int64, next time.Time) {
 	t.Helper()
-	data, err := json.Marshal(info)
-	if err != nil {
-		t.Fatalf("failed to marshal job: %v", err)
-	}
-	if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
-		t.Fatalf("failed to write job file: %v", err)
+	j := &models.ScheduledJob{
+		Queue:       queue,
+		JobKey:      key,
+		NextRunTime: next.UnixNano(),
+		RecipeName:  recipe,
+		Username:    username,
+		AccountID:   accountID,
+		Payload:     "{}",
+	}
+	if err := models.InsertScheduledJob(j, false); err != nil {
+		t.Fatalf("queue job %s: %v", key, err)
 	}
 }
 
-// writeYeschefStepJob writes a yeschef format step job to a file
-func writeYeschefStepJob(t *testing.T, dir, filename string, jobType, recipeName, username string, userID int64, nextRunTime int64) {
-	t.Helper()
+func TestGetJobsTypes(t *testing.T) {
+	setupJobsTest(t)
 
-	userIDStr := fmt.Sprintf("%d", userID)
-
-	yeschefJob := map[string]interface{}{
-		"job": map[string]interface{}{
-			"Step": map[string]interface{}{
-				"Step":    1,
-				"Name":    "test step",
-				"Image":   "test-image:latest",
-				"Do":      getStepDo(jobType),
-				"Timeout": "10.minutes",
-			},
-			"RecipeJob": map[string]interface{}{
-				"JobType":    "cookbook",
-				"UUID":       "test-uuid-123",
-				"CookbookID": "1",
-				"AppID":      "",
-				"UserID":     userIDStr,
-				"Username":   username,
-				"PageID":     "1",
-				"StepID":     "",
-				"Scope":      "individual",
-				"Recipe": map[string]interface{}{
-					"Name":        recipeName,
-					"Description": "A test recipe for " + jobType + " job type",
-				},
-			},
-		},
-		"job_key":       "[cookbook][individual][userid:" + userIDStr + "][page:1][uuid:test-uuid-123][queue:" + strings.ToLower(jobType) + "]",
-		"description":   "StepJob: " + getStepDo(jobType),
-		"group":         "[userid:" + userIDStr + "][page:1][uuid:test-uuid-123][group]",
-		"trigger":       getTrigger(jobType),
-		"next_run_time": nextRunTime,
-	}
-
-	jobData, err := json.Marshal(yeschefJob)
-	if err != nil {
-		t.Fatalf("failed to marshal yeschef job: %v", err)
-	}
+	now := time.Now()
+	queueJob(t, "every", "every-job", "Every Job", "user1", 1, now.Add(30*time.Second))
+	queueJob(t, "now", "now-job", "Now Job", "user1", 1, now)
+	queueJob(t, "in", "in-job", "In Job", "user1", 1, now.Add(5*time.Minute))
 
-	if err := os.WriteFile(filepath.Join(dir, filename), jobData, 0o644); err != nil {
-		t.Fatalf("failed to write yeschef job file: %v", err)
-	}
-}
-
-// writeYeschefRecipeJob writes a yeschef format recipe job to a file (used for NOW jobs)
-func writeYeschefRecipeJob(t *testing.T, dir, filename string, recipeName, username string, userID int64, nextRunTime int64) {
-	t.Helper()
-
-	userIDStr := fmt.Sprintf("%d", userID)
-
-	yeschefJob := map[string]interface{}{
-		"job": map[string]interface{}{
-			"JobType":    "cookbook",
-			"UUID":       "test-uuid-456",
-			"CookbookID": "1",
-			"AppID":      "",
-			"UserID":     userIDStr,
-			"Username":   username,
-			"PageID":     "1",
-			"StepID":     "",
-			"Scope":      "individual",
-			"Recipe": map[string]interface{}{
-				"Name":        recipeName,
-				"Description": "A test recipe for NOW job type",
-				"Steps": []map[string]interface{}{
-					{
-						"Step":    1,
-						"Name":    "test step",
-						"Image":   "test-image:latest",
-						"Do":      "now",
-						"Timeout": "10.minutes",
-					},
-				},
-			},
-		},
-		"job_key":       "[cookbook][individual][userid:" + userIDStr + "][page:1][uuid:test-uuid-456][queue:now]",
-		"description":   "[cookbook][individual][userid:" + userIDStr + "][page:1][uuid:test-uuid-456][queue:cookbook]",
-		"group":         "[userid:" + userIDStr + "][page:1][uuid:test-uuid-456][group]",
-		"trigger":       "RunOnceTrigger::100ms::expired",
-		"next_run_time": nextRunTime,
-	}
-
-	jobData, err := json.Marshal(yeschefJob)
+	jobs, total, err := getJobs(1, 10, testContextWithAccount(t, 1))
 	if err != nil {
-		t.Fatalf("failed to marshal yeschef recipe job: %v", err)
+		t.Fatalf("getJobs returned error: %v", err)
 	}
-
-	if err := os.WriteFile(filepath.Join(dir, filename), jobData, 0o644); err != nil {
-		t.Fatalf("failed to write yeschef recipe job file: %v", err)
+	if total != 3 || len(jobs) != 3 {
+		t.Fatalf("expected 3 jobs, got total=%d len=%d", total, len(jobs))
 	}
-}
 
-// Helper functions for job type specific data
-func getStepDo(jobType string) string {
-	switch jobType {
-	case "NOW":
-		return "now"
-	case "IN":
-		return "in.5.minutes"
-	case "EVERY":
-		return "every.30.seconds"
-	default:
-		return "now"
+	want := []struct{ name, typ string }{{"Now Job", "NOW"}, {"Every Job", "EVERY"}, {"In Job", "IN"}}
+	for i, w := range want {
+		if jobs[i].RecipeName != w.name || jobs[i].Type != w.typ || jobs[i].Status != "Scheduled" {
+			t.Errorf("job %d = %+v, want %s %s", i, jobs[i], w.name, w.typ)
+		}
 	}
-}
-
-func getTrigger(jobType string) string {
-	switch jobType {
-	case "NOW":
-		return "RunOnceTrigger::100ms::expired"
-	case "IN":
-		return "RunOnceTrigger::5m0s::expired"
-	case "EVERY":
-		return "SimpleTrigger::30s"
-	default:
-		return "RunOnceTrigger::100ms::expired"
+	if !jobs[2].ScheduledAt.Equal(time.Unix(0, now.Add(5*time.Minute).UnixNano())) {
+		t.Errorf("unexpected scheduled time: %v", jobs[2].ScheduledAt)
 	}
 }
 
-// Test for legacy format jobs (should still work)
-func TestGetJobsLegacyFormat(t *testing.T) {
-	cleanup := setupTestEnv(t)
-	defer cleanup()
-
-	queuesPath := util.QueuesPath()
-	nowDir := filepath.Join(queuesPath, "now")
-	inDir := filepath.Join(queuesPath, "in")
-	everyDir := filepath.Join(queuesPath, "every")
-
-	os.MkdirAll(nowDir, 0o755)
-	os.MkdirAll(inDir, 0o755)
-	os.MkdirAll(everyDir, 0o755)
-
-	// Create legacy format jobs
-	j1 := persistedJobInfo{ID: "legacy-now", RecipeName: "legacy NOW recipe", Username: "user1", AccountID: 1, JobType: "NOW", Status: "Running", CreatedAt: time.Now()}
-	j2 := persistedJobInfo{ID: "legacy-in", RecipeName: "legacy IN recipe", Username: "user1", AccountID: 1, JobType: "IN", Status: "Scheduled", CreatedAt: time.Now()}
-	j3 := persistedJobInfo{ID: "legacy-every", RecipeName: "legacy EVERY recipe", Username: "user2", AccountID: 2, JobType: "EVERY", Status: "Scheduled", CreatedAt: time.Now()}
+func TestGetJobsAccountFiltering(t *testing.T) {
+	setupJobsTest(t)
 
-	writeJob(t, nowDir, "j1.json", j1)
-	writeJob(t, inDir, "j2.json", j2)
-	writeJob(t, everyDir, "j3.json", j3)
+	now := time.Now()
+	queueJob(t, "now", "account1-now", "Account 1 NOW", "user1", 1, now)
+	queueJob(t, "in", "account1-in", "Account 1 IN", "user1", 1, now.Add(5*time.Minute))
+	queueJob(t, "every", "account1-every", "Account 1 EVERY", "user1", 1, now.Add(30*time.Second))
+	queueJob(t, "now", "account2-now", "Account 2 NOW", "user2", 2, now)
+	queueJob(t, "in", "account2-in", "Account 2 IN", "user2", 2, now.Add(5*time.Minute))
 
-	ctx := testContextWithAccount(t, 1)
-	jobs, total, err := getJobs(1, 10, ctx)
+	_, total1, err := getJobs(1, 10, testContextWithAccount(t, 1))
 	if err != nil {
-		t.Fatalf("getJobs returned error: %v", err)
+		t.Fatalf("getJobs returned error for account 1: %v", err)
 	}
-
-	if total != 2 || len(jobs) != 2 {
-		t.Fatalf("expected 2 jobs for account 1, got total=%d len=%d", total, len(jobs))
+	if total1 != 3 {
+		t.Fatalf("expected 3 jobs for account 1, got %d", total1)
 	}
 
-	// Verify job types are correct
-	jobTypes := make(map[string]bool)
-	for _, job := range jobs {
-		jobTypes[job.Type] = true
+	_, total2, err := getJobs(1, 10, testContextWithAccount(t, 2))
+	if err != nil {
+		t.Fatalf("getJobs returned error for account 2: %v", err)
+	}
+	if total2 != 2 {
+		t.Fatalf("expected 2 jobs for account 2, got %d", total2)
 	}
 
-	if !jobTypes["NOW"] || !jobTypes["IN"] {
-		t.Error("expected to find both NOW and IN job types for account 1")
+	_, all, err := getAllJobs(1, 10)
+	if err != nil {
+		t.Fatalf("getAllJobs returned error: %v", err)
+	}
+	if all != 5 {
+		t.Fatalf("expected 5 jobs across accounts, got %d", all)
 	}
 }
 
-// Test for yeschef format NOW jobs
-func TestGetJobsYeschefNOWFormat(t *testing.T) {
-	cleanup := setupTestEnv(t)
-	defer cleanup()
-
-	queuesPath := util.QueuesPath()
-	nowDir := filepath.Join(queuesPath, "now")
-	os.MkdirAll(nowDir, 0o755)
+func TestGetJobsPagination(t *testing.T) {
+	setupJobsTest(t)
 
-	timestamp := time.Now().UnixNano()
-	writeYeschefRecipeJob(t, nowDir, "1748466187227539001.json", "yeschef NOW recipe", "test-user", 1, timestamp)
+	now := time.Now()
+	for i := 0; i < 25; i++ {
+		queueJob(t, "in", "job-"+string(rune('a'+i)), "Job "+string(rune('A'+i)), "user1", 1, now.Add(time.Duration(i)*time.Minute))
+	}
 
-	ctx := testContextWithAccount(t, 1)
-	jobs, total, err := getJobs(1, 10, ctx)
+	jobs, total, err := getJobs(3, 10, testContextWithAccount(t, 1))
 	if err != nil {
 		t.Fatalf("getJobs returned error: %v", err)
 	}
-
-	if total != 1 || len(jobs) != 1 {
-		t.Fatalf("expected 1 job for account 1, got total=%d len=%d", total, len(jobs))
-	}
-
-	job := jobs[0]
-	if job.RecipeName != "yeschef NOW recipe" {
-		t.Errorf("expected recipe name 'yeschef NOW recipe', got '%s'", job.RecipeName)
-	}
-	if job.Type != "NOW" {
-		t.Errorf("expected job type 'NOW', got '%s'", job.Type)
+	if total != 25 || len(jobs) != 5 {
+		t.Fatalf("expected 5 of 25 jobs on page 3, got total=%d len=%d", total, len(jobs))
 	}
-	if job.Username != "test-user" {
-		t.Errorf("expected username 'test-user', got '%s'", job.Username)
-	}
-	if job.Status != "Scheduled" {
-		t.Errorf("expected status 'Scheduled', got '%s'", job.Status)
+	if jobs[0].RecipeName != "Job U" {
+		t.Fatalf("expected page 3 to start at Job U, got %s", jobs[0].RecipeName)
 	}
 
-	t.Logf("✅ Successfully parsed yeschef format NOW job: %s (Type: %s, Status: %s)",
-		job.RecipeName, job.Type, job.Status)
+	jobs, _, err = getJobs(4, 10, testContextWithAccount(t, 1))
+	if err != nil || len(jobs) != 0 {
+		t.Fatalf("expected empty page, got len=%d err=%v", len(jobs), err)
+	}
 }
 
-// Test for yeschef format IN jobs
-func TestGetJobsYeschefINFormat(t *testing.T) {
-	cleanup := setupTestEnv(t)
-	defer cleanup()
+func TestGetJobsHandlerMixedTypes(t *testing.T) {
+	setupJobsTest(t)
 
-	queuesPath := util.QueuesPath()
-	inDir := filepath.Join(queuesPath, "in")
-	os.MkdirAll(inDir, 0o755)
+	now := time.Now()
+	queueJob(t, "now", "now-job", "YesChef NOW Job", "user1", 1, now)
+	queueJob(t, "in", "in-job", "YesChef IN Job", "user1", 1, now)
+	queueJob(t, "every", "every-job", "YesChef EVERY Job", "user1", 1, now)
 
-	timestamp := time.Now().UnixNano() + 5*60*1000000000 // 5 minutes from now
-	writeYeschefStepJob(t, inDir, "1748466187227539002.json", "IN", "yeschef IN recipe", "test-user", 1, timestamp)
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/lemc/account/jobs?page=1&limit=10", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	user := &models.User{Account: &models.Account{ID: 1}}
+	uc := &models.UserContext{ActingAs: user, LoggedInAs: user}
+	ctx := middleware.SetUserContext(c, uc)
 
-	ctx := testContextWithAccount(t, 1)
-	jobs, total, err := getJobs(1, 10, ctx)
-	if err != nil {
-		t.Fatalf("getJobs returned error: %v", err)
+	if err := GetJobs(ctx); err != nil {
+		t.Fatalf("GetJobs returned error: %v", err)
 	}
-
-	if total != 1 || len(jobs) != 1 {
-		t.Fatalf("expected 1 job for account 1, got total=%d len=%d", total, len(jobs))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
 	}
 
-	job := jobs[0]
-	if job.RecipeName != "yeschef IN recipe" {
-		t.Errorf("expected recipe name 'yeschef IN recipe', got '%s'", job.RecipeName)
-	}
-	if job.Type != "IN" {
-		t.Errorf("expected job type 'IN', got '%s'", job.Type)
-	}
-	if job.Username != "test-user" {
-		t.Errorf("expected username 'test-user', got '%s'", job.Username)
+	body := rec.Body.String()
+	if strings.Contains(body, "No jobs found") {
+		t.Fatalf("unexpected 'No jobs found' in response")
 	}
-	if job.Status != "Scheduled" {
-		t.Errorf("expected status 'Scheduled', got '%s'", job.Status)
+	for _, jobName := range []string{"YesChef NOW Job", "YesChef IN Job", "YesChef EVERY Job"} {
+		if !strings.Contains(body, jobName) {
+			t.Errorf("response missing expected job name: %s", jobName)
+		}
 	}
-
-	t.Logf("✅ Successfully parsed yeschef format IN job: %s (Type: %s, Status: %s)",
-		job.RecipeName, job.Type, job.Status)
 }
 
-// Test for yeschef format EVERY jobs
-func TestGetJobsYeschefEVERYFormat(t *testing.T) {
-	cleanup := setupTestEnv(t)
-	defer cleanup()
-
-	queuesPath := util.QueuesPath()
-	everyDir := filepath.Join(queuesPath, "every")
-	os.MkdirAll(everyDir, 0o755)
+func TestGetJobsPausedAndLastResult(t *testing.T) {
+	setupJobsTest(t)
 
-	timestamp := time.Now().UnixNano() + 30*1000000000 // 30 seconds from now
-	writeYeschefStepJob(t, everyDir, "1748466187227539003.json", "EVERY", "yeschef EVERY recipe", "test-user", 1, timestamp)
+	now := time.Now()
+	queueJob(t, "every", "paused-job", "Paused Job", "user1", 1, time.Unix(0, models.ScheduledJobPaused))
+	queueJob(t, "in", "ran-job", "Ran Job", "user1", 1, now.Add(time.Minute))
+	result := &models.ScheduledJobResult{Queue: "in", JobKey: "ran-job", StartedAt: now, FinishedAt: now, Error: "boom"}
+	if err := models.UpsertScheduledJobResult(result); err != nil {
+		t.Fatalf("upsert result: %v", err)
+	}
+	t.Cleanup(func() { models.DeleteScheduledJobResult("in", "ran-job") })
 
-	ctx := testContextWithAccount(t, 1)
-	jobs, total, err := getJobs(1, 10, ctx)
+	jobs, _, err := getJobs(1, 10, testContextWithAccount(t, 1))
 	if err != nil {
 		t.Fatalf("getJobs returned error: %v", err)
 	}
-
-	if total != 1 || len(jobs) != 1 {
-		t.Fatalf("expected 1 job for account 1, got total=%d len=%d", total, len(jobs))
+	if len(jobs) != 2 {
+		t.Fatalf("expected 2 jobs, got %d", len(jobs))
 	}
-
-	job := jobs[0]
-	if job.RecipeName != "yeschef EVERY recipe" {
-		t.Errorf("expected recipe name 'yeschef EVERY recipe', got '%s'", job.RecipeName)
+	if jobs[0].RecipeName != "Ran Job" || jobs[0].LastResult == nil || jobs[0].LastResult.Succeeded() {
+		t.Errorf("expected failed last result on Ran Job, got %+v", jobs[0])
 	}
-	if job.Type != "EVERY" {
-		t.Errorf("expected job type 'EVERY', got '%s'", job.Type)
+	if jobs[1].RecipeName != "Paused Job" || !jobs[1].Paused || jobs[1].Status != "Paused" {
+		t.Errorf("expected Paused Job to be paused, got %+v", jobs[1])
 	}
-	if job.Username != "test-user" {
-		t.Errorf("expected username 'test-user', got '%s'", job.Username)
-	}
-	if job.Status != "Scheduled" {
-		t.Errorf("expected status 'Scheduled', got '%s'", job.Status)
-	}
-
-	t.Logf("✅ Successfully parsed yeschef format EVERY job: %s (Type: %s, Status: %s)",
-		job.RecipeName, job.Type, job.Status)
 }
 
-// Test mixing legacy and yeschef formats
-func TestGetJobsMixedFormats(t *testing.T) {
-	cleanup := setupTestEnv(t)
-	defer cleanup()
+func TestApplyJobActionOtherAccount(t *testing.T) {
+	setupJobsTest(t)
 
-	queuesPath := util.QueuesPath()
-	nowDir := filepath.Join(queuesPath, "now")
-	inDir := filepath.Join(queuesPath, "in")
-	everyDir := filepath.Join(queuesPath, "every")
+	queueJob(t, "in", "account2-in", "Account 2 IN", "user2", 2, time.Now().Add(time.Minute))
 
-	os.MkdirAll(nowDir, 0o755)
-	os.MkdirAll(inDir, 0o755)
-	os.MkdirAll(everyDir, 0o755)
+	err := applyJobAction(1, "pause", jobActionForm{Queue: "in", Key: "account2-in"})
+	if code := jobActionStatus(err); code != http.StatusNotFound {
+		t.Fatalf("expected 404 pausing another account's job, got %d (%v)", code, err)
+	}
+}
 
-	// Create one legacy job and one yeschef job for each type
-	legacyNow := persistedJobInfo{ID: "legacy-now", RecipeName: "legacy NOW", Username: "user1", AccountID: 1, JobType: "NOW", Status: "Running", CreatedAt: time.Now()}
-	writeJob(t, nowDir, "legacy-now.json", legacyNow)
+func TestApplyJobActionUnknown(t *testing.T) {
+	setupJobsTest(t)
 
-	timestamp := time.Now().UnixNano()
-	writeYeschefRecipeJob(t, nowDir, "yeschef-now.json", "yeschef NOW", "user1", 1, timestamp)
-	writeYeschefStepJob(t, inDir, "yeschef-in.json", "IN", "yeschef IN", "user1", 1, timestamp+5*60*1000000000)
-	writeYeschefStepJob(t, everyDir, "yeschef-every.json", "EVERY", "yeschef EVERY", "user1", 1, timestamp+30*1000000000)
+	queueJob(t, "in", "in-job", "In Job", "user1", 1, time.Now().Add(time.Minute))
 
-	ctx := testContextWithAccount(t, 1)
-	jobs, total, err := getJobs(1, 10, ctx)
-	if err != nil {
-		t.Fatalf("getJobs returned error: %v", err)
+	err := applyJobAction(1, "explode", jobActionForm{Queue: "in", Key: "in-job"})
+	if code := jobActionStatus(err); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown action, got %d (%v)", code, err)
 	}
+}
 
-	if total != 4 || len(jobs) != 4 {
-		t.Fatalf("expected 4 jobs for account 1, got total=%d len=%d", total, len(jobs))
+func TestApplyDeadJobAction(t *testing.T) {
+	if _, err := models.PurgeDeadLetterJobs(); err != nil {
+		t.Fatalf("purge: %v", err)
 	}
+	t.Cleanup(func() { models.PurgeDeadLetterJobs() })
 
-	// Verify we have all job types
-	jobTypes := make(map[string]int)
-	for _, job := range jobs {
-		jobTypes[job.Type]++
+	dead := &models.DeadLetterJob{Queue: "in", JobKey: "dead-in", Reason: models.DeadLetterInvalid, Error: "bad", Payload: "{"}
+	if err := models.InsertDeadLetterJob(dead); err != nil {
+		t.Fatalf("insert: %v", err)
 	}
 
-	if jobTypes["NOW"] != 2 {
-		t.Errorf("expected 2 NOW jobs, got %d", jobTypes["NOW"])
+	if code := jobActionStatus(applyDeadJobAction("edit", deadJobActionForm{ID: dead.ID})); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 editing with an empty payload, got %d", code)
 	}
-	if jobTypes["IN"] != 1 {
-		t.Errorf("expected 1 IN job, got %d", jobTypes["IN"])
+	if code := jobActionStatus(applyDeadJobAction("explode", deadJobActionForm{ID: dead.ID})); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown action, got %d", code)
 	}
-	if jobTypes["EVERY"] != 1 {
-		t.Errorf("expected 1 EVERY job, got %d", jobTypes["EVERY"])
+	if err := applyDeadJobAction("purge", deadJobActionForm{ID: dead.ID}); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if code := jobActionStatus(applyDeadJobAction("retry", deadJobActionForm{ID: dead.ID})); code != http.StatusNotFound {
+		t.Fatalf("expected 404 retrying a purged job, got %d", code)
 	}
-
-	t.Logf("✅ Successfully parsed mixed format jobs: NOW=%d, IN=%d, EVERY=%d",
-		jobTypes["NOW"], jobTypes["IN"], jobTypes["EVERY"])
 }
 
-// Test account filtering across different job types
-func TestGetJobsAccountFiltering(t *testing.T) {
-	cleanup := setupTestEnv(t)
-	defer cleanup()
-
-	queuesPath := util.QueuesPath()
-	nowDir := filepath.Join(queuesPath, "now")
-	inDir := filepath.Join(queuesPath, "in")
-	everyDir := filepath.Join(queuesPath, "every")
-
-	os.MkdirAll(nowDir, 0o755)
-	os.MkdirAll(inDir, 0o755)
-	os.MkdirAll(everyDir, 0o755)
-
-	timestamp := time.Now().UnixNano()
-
-	// Create jobs for different accounts
-	writeYeschefRecipeJob(t, nowDir, "account1-now.json", "Account 1 NOW", "user1", 1, timestamp)
-	writeYeschefStepJob(t, inDir, "account1-in.json", "IN", "Account 1 IN", "user1", 1, timestamp+5*60*1000000000)
-	writeYeschefStepJob(t, everyDir, "account1-every.json", "EVERY", "Account 1 EVERY", "user1", 1, timestamp+30*1000000000)
-
-	writeYeschefRecipeJob(t, nowDir, "account2-now.json", "Account 2 NOW", "user2", 2, timestamp)
-	writeYeschefStepJob(t, inDir, "account2-in.json", "IN", "Account 2 IN", "user2", 2, timestamp+5*60*1000000000)
-
-	// Test account 1
-	ctx1 := testContextWithAccount(t, 1)
-	jobs1, total1, err := getJobs(1, 10, ctx1)
+func TestScheduledAppJobRunAs(t *testing.T) {
+	tx, err := db.Db().Beginx()
 	if err != nil {
-		t.Fatalf("getJobs returned error for account 1: %v", err)
+		t.Fatal(err)
 	}
-
-	if total1 != 3 || len(jobs1) != 3 {
-		t.Fatalf("expected 3 jobs for account 1, got total=%d len=%d", total1, len(jobs1))
-	}
-
-	// Test account 2
-	ctx2 := testContextWithAccount(t, 2)
-	jobs2, total2, err := getJobs(1, 10, ctx2)
+	defer tx.Rollback()
+	acc, err := models.AccountCreate("Schedules "+t.Name(), tx)
 	if err != nil {
-		t.Fatalf("getJobs returned error for account 2: %v", err)
-	}
-
-	if total2 != 2 || len(jobs2) != 2 {
-		t.Fatalf("expected 2 jobs for account 2, got total=%d len=%d", total2, len(jobs2))
-	}
-
-	t.Logf("✅ Account filtering works correctly: Account 1=%d jobs, Account 2=%d jobs", total1, total2)
-}
-
-// Test recursive directory scanning
-func TestGetJobsRecursiveScanning(t *testing.T) {
-	cleanup := setupTestEnv(t)
-	defer cleanup()
-
-	queuesPath := util.QueuesPath()
-	nowDir := filepath.Join(queuesPath, "now")
-	nestedDir := filepath.Join(nowDir, "nested", "deep")
-	os.MkdirAll(nestedDir, 0o755)
-
-	// Create jobs at different directory levels
-	j1 := persistedJobInfo{ID: "root-job", RecipeName: "root job", Username: "user1", AccountID: 1, JobType: "NOW", Status: "Running", CreatedAt: time.Now()}
-	writeJob(t, nowDir, "root.json", j1)
-
-	timestamp := time.Now().UnixNano()
-	writeYeschefRecipeJob(t, nestedDir, "nested.json", "nested job", "user1", 1, timestamp)
-
-	ctx := testContextWithAccount(t, 1)
-	jobs, total, err := getJobs(1, 10, ctx)
-	if err != nil {
-		t.Fatalf("getJobs returned error: %v", err)
+		t.Fatal(err)
+	}
+	newUser := func(name string) *models.User {
+		u := models.NewUser()
+		u.Username = fmt.Sprintf("%s-%d", name, acc.ID)
+		u.Email = u.Username + "@example.com"
+		u.Hash = "x"
+		if u.ID, err = models.CreateUserWithAccountID(u, acc.ID, tx); err != nil {
+			t.Fatal(err)
+		}
+		return u
 	}
-
-	if total != 2 || len(jobs) != 2 {
-		t.Fatalf("expected 2 jobs for account 1, got total=%d len=%d", total, len(jobs))
+	owner, bob := newUser("owner"), newUser("bob")
+	if _, err := tx.Exec("UPDATE permissions_accounts SET can_administer = true WHERE user_id = ? AND account_id = ?", owner.ID, acc.ID); err != nil {
+		t.Fatal(err)
 	}
-
-	t.Logf("✅ Recursive directory scanning works correctly: found %d jobs", total)
-}
-
-// Test GetJobs handler with mixed job types
-func TestGetJobsHandlerMixedTypes(t *testing.T) {
-	cleanup := setupTestEnv(t)
-	defer cleanup()
-
-	queuesPath := util.QueuesPath()
-	nowDir := filepath.Join(queuesPath, "now")
-	inDir := filepath.Join(queuesPath, "in")
-	everyDir := filepath.Join(queuesPath, "every")
-
-	os.MkdirAll(nowDir, 0o755)
-	os.MkdirAll(inDir, 0o755)
-	os.MkdirAll(everyDir, 0o755)
-
-	// Create mixed format jobs
-	legacyJob := persistedJobInfo{ID: "legacy", RecipeName: "Legacy Job", Username: "user1", AccountID: 1, JobType: "NOW", Status: "Running", CreatedAt: time.Now()}
-	writeJob(t, nowDir, "legacy.json", legacyJob)
-
-	timestamp := time.Now().UnixNano()
-	writeYeschefStepJob(t, inDir, "yeschef-in.json", "IN", "YesChef IN Job", "user1", 1, timestamp)
-	writeYeschefStepJob(t, everyDir, "yeschef-every.json", "EVERY", "YesChef EVERY Job", "user1", 1, timestamp)
-
-	e := echo.New()
-	req := httptest.NewRequest(http.MethodGet, "/lemc/account/jobs?page=1&limit=10", nil)
-	rec := httptest.NewRecorder()
-	c := e.NewContext(req, rec)
-	user := &models.User{Account: &models.Account{ID: 1}}
-	uc := &models.UserContext{ActingAs: user, LoggedInAs: user}
-	ctx := middleware.SetUserContext(c, uc)
-
-	if err := GetJobs(ctx); err != nil {
-		t.Fatalf("GetJobs returned error: %v", err)
+	cb := &models.Cookbook{AccountID: acc.ID, OwnerID: owner.ID, Name: "CB"}
+	if err := cb.Create(tx); err != nil {
+		t.Fatal(err)
 	}
-
-	if rec.Code != http.StatusOK {
-		t.Fatalf("expected status 200, got %d", rec.Code)
+	app := &models.App{AccountID: acc.ID, OwnerID: owner.ID, CookbookID: cb.ID, Name: "App"}
+	if err := app.Create(tx); err != nil {
+		t.Fatal(err)
 	}
-
-	body := rec.Body.String()
-	if strings.Contains(body, "No jobs found") {
-		t.Fatalf("unexpected 'No jobs found' in response")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
 	}
 
-	// Check that all job names are present
-	expectedJobs := []string{"Legacy Job", "YesChef IN Job", "YesChef EVERY Job"}
-	for _, jobName := range expectedJobs {
-		if !strings.Contains(body, jobName) {
-			t.Errorf("response missing expected job name: %s", jobName)
+	schedule := func(savedBy int64, runAs string) (*yeschef.JobRecipe, error) {
+		r := models.Recipe{
+			Name:     "nightly",
+			Schedule: &models.RecipeSchedule{Every: "10.minutes", RunAs: runAs},
+			Steps:    []models.Step{{Step: 1, Image: "docker.io/test", Do: "now", Timeout: "1.minutes"}},
 		}
+		return scheduledAppJob(app, savedBy, models.YamlDefault{}, 1, r, SCOPE_YAML_TYPE_SHARED)
 	}
 
-	t.Logf("✅ Handler correctly renders mixed job types")
-}
-
-// Demonstration test showing the fix for the original issue
-func TestYeschefJobParsingFix(t *testing.T) {
-	// This test demonstrates the fix for the issue where IN jobs (yeschef format)
-	// were not showing up in the jobs list because they were being parsed as
-	// empty persistedJobInfo structs instead of being processed as yeschef format.
-
-	cleanup := setupTestEnv(t)
-	defer cleanup()
-
-	queuesPath := util.QueuesPath()
-	inDir := filepath.Join(queuesPath, "in")
-	os.MkdirAll(inDir, 0o755)
-
-	// Create a yeschef format job file (this is the actual format used by the yeschef system)
-	yeschefJobData := `{
-		"job": {
-			"Step": {
-				"Step": 1,
-				"Name": "terraform destroy",
-				"Image": "us-east5-docker.pkg.dev/holidayhack2025/hhc25/lemc-gcp-terraform-debian:latest",
-				"Do": "in.5.minutes",
-				"Timeout": "10.minutes"
-			},
-			"RecipeJob": {
-				"JobType": "cookbook",
-				"UUID": "0196fe96-c31a-7678-b28d-8cad0afee525",
-				"CookbookID": "17",
-				"UserID": "1",
-				"Username": "alpha-owner",
-				"PageID": "1",
-				"Scope": "individual",
-				"Recipe": {
-					"Name": "delayed terraform destroy",
-					"Description": "This runs terraform destroy to teardown all assets"
-				}
-			}
-		},
-		"job_key": "[cookbook][individual][userid:1][page:1][uuid:0196fe96-c31a-7678-b28d-8cad0afee525][queue:in]",
-		"description": "StepJob: in.5.minutes",
-		"group": "[userid:1][page:1][uuid:0196fe96-c31a-7678-b28d-8cad0afee525][group]",
-		"trigger": "RunOnceTrigger::5m0s::expired",
-		"next_run_time": 1748466187227539000
-	}`
-
-	jobFile := filepath.Join(inDir, "1748466187227539000.json")
-	if err := os.WriteFile(jobFile, []byte(yeschefJobData), 0o644); err != nil {
-		t.Fatalf("failed to write yeschef job file: %v", err)
+	if _, err := schedule(owner.ID, bob.Username); err == nil {
+		t.Error("scheduled a run as a user without the app permission")
 	}
 
-	ctx := testContextWithAccount(t, 1)
-	jobs, total, err := getJobs(1, 10, ctx)
+	tx, err = db.Db().Beginx()
 	if err != nil {
-		t.Fatalf("getJobs returned error: %v", err)
+		t.Fatal(err)
 	}
-
-	// Before the fix: total would be 0 because the yeschef job would be parsed
-	// as an empty persistedJobInfo struct and filtered out
-	// After the fix: total should be 1 because the yeschef job is properly parsed
-	if total != 1 || len(jobs) != 1 {
-		t.Fatalf("expected 1 job for account 1, got total=%d len=%d", total, len(jobs))
+	perm := &models.PermApp{UserID: bob.ID, AccountID: acc.ID, CookbookID: cb.ID, AppID: app.ID, CanShared: true}
+	if err := perm.UpsertappPermissions(tx); err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
 	}
 
-	job := jobs[0]
-
-	// Verify the job was parsed correctly from yeschef format
-	if job.RecipeName != "delayed terraform destroy" {
-		t.Errorf("expected recipe name 'delayed terraform destroy', got '%s'", job.RecipeName)
+	job, err := schedule(owner.ID, bob.Username)
+	if err != nil {
+		t.Fatalf("account administrator scheduling as bob: %v", err)
 	}
-	if job.Type != "IN" {
-		t.Errorf("expected job type 'IN', got '%s'", job.Type)
+	if job.Username != bob.Username {
+		t.Errorf("job runs as %s, want %s", job.Username, bob.Username)
 	}
-	if job.Username != "alpha-owner" {
-		t.Errorf("expected username 'alpha-owner', got '%s'", job.Username)
+	if _, err := schedule(bob.ID, owner.Username); err == nil {
+		t.Error("a user who does not administer the account scheduled a run as someone else")
 	}
-	if job.Status != "Scheduled" {
-		t.Errorf("expected status 'Scheduled', got '%s'", job.Status)
+	if _, err := schedule(bob.ID, bob.Username); err != nil {
+		t.Errorf("bob scheduling as themselves: %v", err)
 	}
+}
 
-	t.Logf("✅ Successfully parsed yeschef format IN job: %s (Type: %s, Status: %s)",
-		job.RecipeName, job.Type, job.Status)
+func TestValidateSchedulesPullPolicy(t *testing.T) {
+	pages := func(policy string) []models.Page {
+		return []models.Page{{Recipes: []models.Recipe{{
+			Name:  "pull",
+			Steps: []models.Step{{Step: 1, Image: "alpine", Do: "now", PullPolicy: policy}},
+		}}}}
+	}
+	for _, policy := range []string{"", models.PullPolicyAlways, models.PullPolicyIfNotPresent, models.PullPolicyScheduled} {
+		if err := validateSchedules(pages(policy)); err != nil {
+			t.Fatalf("pull_policy %q: %v", policy, err)
+		}
+	}
+	err := validateSchedules(pages("allways"))
+	if err == nil || !strings.Contains(err.Error(), `pull_policy "allways"`) {
+		t.Fatalf("expected a typo in pull_policy to be refused, got %v", err)
+	}
 }
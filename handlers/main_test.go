@@ -5,15 +5,36 @@ import (
 	"path/filepath"
 	"testing"
 
-	"github.com/jaredfolkins/letemcook/util"
+	"github.com/jaredfolkins/letemcook/db"
+	"github.com/jaredfolkins/letemcook/embedded"
+	"github.com/pressly/goose/v3"
 )
 
 func TestMain(m *testing.M) {
-	dataRoot := util.TestDataRoot()
+	// Each test binary gets its own data root so the database does not
+	// collide with other packages migrating theirs in parallel.
+	dataRoot, err := os.MkdirTemp("", "lemc-handlers-")
+	if err != nil {
+		panic(err)
+	}
 	os.Setenv("LEMC_ENV", "test")
 	os.Setenv("LEMC_DATA", dataRoot)
 	envDir := filepath.Join(dataRoot, "test")
 	_ = os.MkdirAll(envDir, 0o755)
+
+	migrationsFS, err := embedded.GetMigrationsFS()
+	if err != nil {
+		panic(err)
+	}
+	goose.SetBaseFS(migrationsFS)
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		panic(err)
+	}
+	if err := goose.Up(db.Db().DB, "."); err != nil {
+		panic(err)
+	}
+
 	code := m.Run()
+	os.RemoveAll(dataRoot)
 	os.Exit(code)
 }
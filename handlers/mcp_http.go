@@ -6,29 +6,79 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/jaredfolkins/letemcook/models"
 	"github.com/jaredfolkins/letemcook/yeschef"
 )
 
-// McpSSE establishes an SSE stream for MCP messages.
-func McpSSE(c LemcContext) error {
+// Headers of the MCP Streamable HTTP transport.
+const (
+	mcpSessionHeader = "Mcp-Session-Id"
+	mcpVersionHeader = "MCP-Protocol-Version"
+)
+
+// mcpSessionQuery names the client of a legacy transport POST, as announced
+// in the endpoint event of its stream.
+const mcpSessionQuery = "sessionId"
+
+// mcpApp authenticates the API key of an MCP request against the app in the
+// path. It returns the status to answer with when the request is refused.
+func mcpApp(c LemcContext) (*models.App, *models.PermApp, string, int) {
 	uuid := c.Param("uuid")
 	apiKey := c.Request().Header.Get("X-API-Key")
 	if apiKey == "" {
-		return c.NoContent(http.StatusUnauthorized)
+		return nil, nil, "", http.StatusUnauthorized
 	}
 
 	app, perm, err := models.AppByUUIDAndUserAPIKey(uuid, apiKey)
 	if err != nil {
 		log.Printf("AppByUUIDAndUserAPIKey: %v", err)
-		return c.NoContent(http.StatusUnauthorized)
+		return nil, nil, "", http.StatusUnauthorized
 	}
 	if perm.ID == 0 {
-		return c.NoContent(http.StatusUnauthorized)
+		return nil, nil, "", http.StatusUnauthorized
 	}
 	if !app.IsMcpEnabled {
-		return c.NoContent(http.StatusForbidden)
+		return nil, nil, "", http.StatusForbidden
+	}
+	return app, perm, apiKey, 0
+}
+
+// mcpStreamable reports whether a POST uses the Streamable HTTP transport,
+// whose clients accept both JSON and event stream responses. Other POSTs
+// are the legacy transport answered on the SSE stream.
+func mcpStreamable(r *http.Request) bool {
+	if r.Header.Get(mcpSessionHeader) != "" {
+		return true
+	}
+	if r.URL.Query().Get(mcpSessionQuery) != "" {
+		return false
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && strings.Contains(accept, "text/event-stream")
+}
+
+// McpSSE establishes an SSE stream for MCP messages. With an Mcp-Session-Id
+// it is the stream of a Streamable HTTP session, which outlives it.
+// Without, it is the legacy transport: the client is registered for as long
+// as the stream is open, the first event is the endpoint to POST to and the
+// responses to its POSTs come on it. Either way the stream carries the
+// output of the client's own runs, or of every run of the app with
+// ?observe=all.
+func McpSSE(c LemcContext) error {
+	app, perm, apiKey, status := mcpApp(c)
+	if status != 0 {
+		return c.NoContent(status)
+	}
+
+	server := yeschef.XoxoX.CreateMcpAppInstance(app.ID)
+	var client *yeschef.McpClient
+	if sid := c.Request().Header.Get(mcpSessionHeader); sid != "" {
+		client = server.Session(sid)
+		if client == nil || client.ApiKey != apiKey {
+			return c.NoContent(http.StatusNotFound)
+		}
 	}
 
 	w := c.Response()
@@ -40,22 +90,22 @@ func McpSSE(c LemcContext) error {
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.WriteHeader(http.StatusOK)
-
-	server := yeschef.XoxoX.CreateMcpAppInstance(app.ID)
-	client := &yeschef.McpClient{
-		Server:    server,
-		Send:      make(chan []byte, 64),
-		UserID:    perm.UserID,
-		AccountID: perm.AccountID,
-		ApiKey:    apiKey,
-	}
-	server.Provision <- client
+	flusher.Flush()
 
 	ctx := c.Request().Context()
-	go func() {
-		<-ctx.Done()
-		server.Deprovision <- client
-	}()
+	if client == nil {
+		client = server.NewClient(perm.UserID, perm.AccountID, apiKey)
+		server.Provision <- client
+		go func() {
+			<-ctx.Done()
+			server.Deprovision <- client
+		}()
+		fmt.Fprintf(w, "event: endpoint\ndata: %s?%s=%s\n\n", c.Request().URL.Path, mcpSessionQuery, client.SessionID)
+		flusher.Flush()
+	}
+	if c.QueryParam("observe") == "all" {
+		server.Observe(client, true)
+	}
 
 	for {
 		select {
@@ -71,41 +121,97 @@ func McpSSE(c LemcContext) error {
 	}
 }
 
-// McpPost handles MCP JSON-RPC requests sent via POST.
+// McpPost handles MCP JSON-RPC requests sent via POST. Streamable HTTP
+// requests are answered in the response, an initialize without a session
+// starts one. Legacy requests are answered on the SSE stream named by their
+// sessionId, or on the first stream of the API key when it is missing.
 func McpPost(c LemcContext) error {
-	uuid := c.Param("uuid")
-	apiKey := c.Request().Header.Get("X-API-Key")
-	if apiKey == "" {
-		return c.NoContent(http.StatusUnauthorized)
+	app, perm, apiKey, status := mcpApp(c)
+	if status != 0 {
+		return c.NoContent(status)
 	}
 
-	app, perm, err := models.AppByUUIDAndUserAPIKey(uuid, apiKey)
+	server := yeschef.XoxoX.CreateMcpAppInstance(app.ID)
+	req := c.Request()
+	body, err := io.ReadAll(req.Body)
 	if err != nil {
-		log.Printf("AppByUUIDAndUserAPIKey: %v", err)
-		return c.NoContent(http.StatusUnauthorized)
+		return err
 	}
-	if perm.ID == 0 {
-		return c.NoContent(http.StatusUnauthorized)
+
+	if !mcpStreamable(req) {
+		var client *yeschef.McpClient
+		if sid := c.QueryParam(mcpSessionQuery); sid != "" {
+			client = server.StreamClient(apiKey, sid)
+		} else {
+			client = server.FindClient(apiKey)
+		}
+		if client == nil {
+			return c.NoContent(http.StatusGone)
+		}
+		var msg yeschef.McpMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return c.NoContent(http.StatusBadRequest)
+		}
+		server.Enqueue(client, &msg)
+		return c.NoContent(http.StatusAccepted)
 	}
-	if !app.IsMcpEnabled {
-		return c.NoContent(http.StatusForbidden)
+
+	var msg yeschef.McpMessage
+	if err := json.Unmarshal(body, &msg); err != nil || msg.JSONRPC != "2.0" {
+		return c.JSONBlob(http.StatusBadRequest, yeschef.McpParseError())
+	}
+	if v := req.Header.Get(mcpVersionHeader); v != "" && !yeschef.McpSupportsVersion(v) {
+		return c.JSONBlob(http.StatusBadRequest, yeschef.McpInvalidRequest(msg.ID, fmt.Sprintf("unsupported protocol version %s", v)))
 	}
 
-	server := yeschef.XoxoX.CreateMcpAppInstance(app.ID)
-	client := server.FindClient(apiKey)
-	if client == nil {
-		return c.NoContent(http.StatusGone)
+	var client *yeschef.McpClient
+	sid := req.Header.Get(mcpSessionHeader)
+	switch {
+	case sid != "":
+		client = server.Session(sid)
+		if client == nil || client.ApiKey != apiKey {
+			return c.NoContent(http.StatusNotFound)
+		}
+	case msg.Method == "initialize":
+		client = server.NewSession(perm.UserID, perm.AccountID, apiKey)
+	default:
+		return c.JSONBlob(http.StatusBadRequest, yeschef.McpInvalidRequest(msg.ID, "missing "+mcpSessionHeader))
+	}
+
+	if msg.Method == "" || len(msg.ID) == 0 {
+		// Notifications and responses get no answer.
+		server.Enqueue(client, &msg)
+		return c.NoContent(http.StatusAccepted)
 	}
 
-	body, err := io.ReadAll(c.Request().Body)
+	resp, err := server.Call(req.Context(), client, &msg)
 	if err != nil {
-		return err
+		return nil
 	}
-	var msg yeschef.McpMessage
-	if err := json.Unmarshal(body, &msg); err != nil {
-		return c.NoContent(http.StatusBadRequest)
+	if sid == "" {
+		var r struct {
+			Error json.RawMessage `json:"error"`
+		}
+		if json.Unmarshal(resp, &r) == nil && len(r.Error) > 0 {
+			server.EndSession(client.SessionID)
+		} else {
+			c.Response().Header().Set(mcpSessionHeader, client.SessionID)
+		}
 	}
+	return c.JSONBlob(http.StatusOK, resp)
+}
 
-	server.Enqueue(client, &msg)
-	return c.NoContent(http.StatusAccepted)
+// McpDelete ends a Streamable HTTP session.
+func McpDelete(c LemcContext) error {
+	app, _, apiKey, status := mcpApp(c)
+	if status != 0 {
+		return c.NoContent(status)
+	}
+	server := yeschef.XoxoX.CreateMcpAppInstance(app.ID)
+	sid := c.Request().Header.Get(mcpSessionHeader)
+	if client := server.Session(sid); sid == "" || client == nil || client.ApiKey != apiKey {
+		return c.NoContent(http.StatusNotFound)
+	}
+	server.EndSession(sid)
+	return c.NoContent(http.StatusNoContent)
 }
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMcpStreamable(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		want    bool
+	}{
+		{"legacy", "/mcp/app/u", nil, false},
+		{"session header", "/mcp/app/u", map[string]string{mcpSessionHeader: "s"}, true},
+		{"accepts both", "/mcp/app/u", map[string]string{"Accept": "application/json, text/event-stream"}, true},
+		{"accepts json only", "/mcp/app/u", map[string]string{"Accept": "application/json"}, false},
+		{"legacy endpoint", "/mcp/app/u?sessionId=s", map[string]string{"Accept": "application/json, text/event-stream"}, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.target, nil)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		if got := mcpStreamable(req); got != tt.want {
+			t.Errorf("%s: mcpStreamable = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -21,8 +21,11 @@ func Routes(e *echo.Echo) {
 	e.POST("/setup", Ctx(PostSetupHandler))
 	e.GET("/", Ctx(redirLoginHandler))
 	e.GET("/ws", Ctx(Ws))
+	e.GET("/sse", Ctx(Sse))
 	e.GET("/mcp/app/:uuid", Ctx(McpSSE))
 	e.POST("/mcp/app/:uuid", Ctx(McpPost))
+	e.DELETE("/mcp/app/:uuid", Ctx(McpDelete))
+	e.GET("/worker/connect", Ctx(WorkerConnect))
 	e.GET("/navtop", Ctx(GetNavtop))
 	e.GET("/heckle", Ctx(GetHeckle))
 
@@ -59,10 +62,16 @@ func Routes(e *echo.Echo) {
 	account.GET("/settings", middleware.ApplyMiddlewares(Ctx(GetAccountSettingsHandler), middleware.CheckPermission(models.CanAdministerAccount))) // Basic logged-in check is enough for now
 	account.POST("/settings", middleware.ApplyMiddlewares(Ctx(PostAccountSettingsHandler), middleware.CheckPermission(models.CanAdministerAccount)))
 	account.GET("/jobs", middleware.ApplyMiddlewares(Ctx(GetJobs), middleware.CheckPermission(models.CanAdministerAccount))) // TODO: i need more permissions here
+	account.POST("/jobs/:action", middleware.ApplyMiddlewares(Ctx(PostJobActionHandler), middleware.CheckPermission(models.CanAdministerAccount)))
+
+	api := lemc.Group("/api")
+	api.GET("/jobs", middleware.ApplyMiddlewares(Ctx(GetApiJobsHandler), middleware.CheckPermission(models.CanAdministerAccount)))
+	api.POST("/jobs/:action", middleware.ApplyMiddlewares(Ctx(PostApiJobActionHandler), middleware.CheckPermission(models.CanAdministerAccount)))
 
 	app := lemc.Group("/app")
-	app.GET("/job/status/uuid/:uuid/page/:page/scope/:scope", middleware.ApplyMiddlewares(Ctx(GetAppJobStatus))) // TODO: i need more permissions here
-	app.PUT("/job/:view_type/uuid/:uuid/page/:page/recipe/:recipe", middleware.ApplyMiddlewares(Ctx(PutAppJob))) // TODO: i need more permissions here
+	app.GET("/job/status/uuid/:uuid/page/:page/scope/:scope", middleware.ApplyMiddlewares(Ctx(GetAppJobStatus)))            // TODO: i need more permissions here
+	app.PUT("/job/:view_type/uuid/:uuid/page/:page/recipe/:recipe", middleware.ApplyMiddlewares(Ctx(PutAppJob)))            // TODO: i need more permissions here
+	app.POST("/job/plan/:view_type/uuid/:uuid/page/:page/recipe/:recipe", middleware.ApplyMiddlewares(Ctx(PostAppJobPlan))) // TODO: i need more permissions here
 
 	app.GET("/index/individual/:uuid", middleware.ApplyMiddlewares(Ctx(GetAppIndexIndividualHandler), middleware.CheckPermission(models.CanIndividualApp, models.CanAdministerAccount)))
 	app.GET("/index/shared/:uuid", middleware.ApplyMiddlewares(Ctx(GetAppIndexSharedHandler), middleware.CheckPermission(models.CanSharedApp, models.CanAdministerAccount)))
@@ -111,10 +120,16 @@ func Routes(e *echo.Echo) {
 
 	system := lemc.Group("/system")
 	system.GET("/settings", middleware.ApplyMiddlewares(Ctx(GetSystemSettingsHandler), middleware.CheckPermission(models.CanAdministerSystem)))
+	system.POST("/settings/endpoints", middleware.ApplyMiddlewares(Ctx(PostSystemEndpointHandler), middleware.CheckPermission(models.CanAdministerSystem)))
+	system.POST("/settings/endpoints/delete", middleware.ApplyMiddlewares(Ctx(PostSystemEndpointDeleteHandler), middleware.CheckPermission(models.CanAdministerSystem)))
 	system.GET("/accounts", middleware.ApplyMiddlewares(Ctx(GetSystemAccountsHandler), middleware.CheckPermission(models.CanAdministerSystem)))
 	system.GET("/images", middleware.ApplyMiddlewares(Ctx(GetSystemImagesHandler), middleware.CheckPermission(models.CanAdministerSystem)))
 	system.POST("/images/pull", middleware.ApplyMiddlewares(Ctx(PostSystemImagePullHandler), middleware.CheckPermission(models.CanAdministerSystem)))
+	system.POST("/images/prune", middleware.ApplyMiddlewares(Ctx(PostSystemImagePruneHandler), middleware.CheckPermission(models.CanAdministerSystem)))
 	system.GET("/jobs", middleware.ApplyMiddlewares(Ctx(GetSystemJobsHandler), middleware.CheckPermission(models.CanAdministerSystem)))
+	system.POST("/jobs/drain", middleware.ApplyMiddlewares(Ctx(PostSystemDrainHandler), middleware.CheckPermission(models.CanAdministerSystem)))
+	system.POST("/jobs/:action", middleware.ApplyMiddlewares(Ctx(PostSystemJobActionHandler), middleware.CheckPermission(models.CanAdministerSystem)))
+	system.POST("/jobs/dead/:action", middleware.ApplyMiddlewares(Ctx(PostSystemDeadJobActionHandler), middleware.CheckPermission(models.CanAdministerSystem)))
 
 	e.Use(middleware.After)
 }
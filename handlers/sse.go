@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/yeschef"
+)
+
+// Sse streams the messages of the user's CmdServer as Server-Sent Events,
+// for browsers behind proxies that do not let the websocket of Ws through.
+// Each message carries its sequence number as the event id, so a browser
+// that reconnects resumes with Last-Event-ID. Topics are passed like for Ws
+// and changed by reconnecting.
+func Sse(c LemcContext) error {
+	userCtx := c.UserContext()
+
+	if userCtx == nil || userCtx.ActingAs == nil || userCtx.ActingAs.Account == nil {
+		log.Println("User is not authenticated")
+		return c.NoContent(http.StatusUnauthorized)
+	}
+
+	userID := userCtx.ActingAs.ID
+
+	w := c.Response()
+	flusher, ok := w.Writer.(http.Flusher)
+	if !ok {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	var since map[string]uint64
+	if c.QueryParams().Has("since") {
+		since = yeschef.ParseSince(c.QueryParam("since"))
+	}
+	if id := c.Request().Header.Get("Last-Event-ID"); id != "" {
+		since = yeschef.ParseSince(id)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Keeps nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	xerver := yeschef.XoxoX.CreateInstance(userID)
+	xlient := &yeschef.Client{
+		Xend:   make(chan []byte, yeschef.CLIENT_BUFFER_SIZE),
+		Xerver: xerver,
+		Since:  since,
+		Topics: yeschef.ParseTopics(c.QueryParam("topics")),
+	}
+	xerver.Provision <- xlient
+
+	ctx := c.Request().Context()
+	defer func() {
+		xerver.Deprovision <- xlient
+	}()
+
+	ticker := time.NewTicker(yeschef.PING_PERIOD)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-xlient.Xend:
+			if !ok {
+				// Closed for falling behind, the browser reconnects and resumes.
+				return nil
+			}
+			since = writeSseEvent(w, msg, since)
+			flusher.Flush()
+		case <-ticker.C:
+			// A comment keeps proxies from closing an idle stream.
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// writeSseEvent writes a message as an event. Its id is the last sequence
+// number of each run seen, so the browser resumes every run with
+// Last-Event-ID. It returns the runs seen after the message.
+func writeSseEvent(w http.ResponseWriter, msg []byte, seen map[string]uint64) map[string]uint64 {
+	var r yeschef.Response
+	if json.Unmarshal(msg, &r) == nil {
+		switch {
+		case r.Cmd == yeschef.LEMC_REPLAY:
+			seen = r.Runs
+		case r.RunID != "" && r.Seq > 0:
+			if seen == nil {
+				seen = make(map[string]uint64)
+			}
+			seen[r.RunID] = r.Seq
+		}
+		if id := yeschef.FormatSince(seen); id != "" && (r.Cmd == yeschef.LEMC_REPLAY || r.Seq > 0) {
+			fmt.Fprintf(w, "id: %s\n", id)
+		}
+	}
+	fmt.Fprintf(w, "data: %s\n\n", msg)
+	return seen
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteSseEvent(t *testing.T) {
+	tests := []struct {
+		msg, want string
+	}{
+		{`{"Cmd":"lemc.replay;","Runs":{"r1":3}}`, "id: r1:3\ndata: {\"Cmd\":\"lemc.replay;\",\"Runs\":{\"r1\":3}}\n\n"},
+		{`{"Cmd":"lemc.html.append;","Msg":"hi","RunID":"r2","Seq":7}`, "id: r1:3,r2:7\ndata: {\"Cmd\":\"lemc.html.append;\",\"Msg\":\"hi\",\"RunID\":\"r2\",\"Seq\":7}\n\n"},
+		{`{"Cmd":"lemc.html.append;","Msg":"hi","RunID":"r1","Seq":4}`, "id: r1:4,r2:7\ndata: {\"Cmd\":\"lemc.html.append;\",\"Msg\":\"hi\",\"RunID\":\"r1\",\"Seq\":4}\n\n"},
+		{`visible`, "data: visible\n\n"},
+	}
+	var seen map[string]uint64
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		seen = writeSseEvent(rec, []byte(tt.msg), seen)
+		if got := rec.Body.String(); got != tt.want {
+			t.Errorf("writeSseEvent(%s) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
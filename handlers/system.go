@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jaredfolkins/letemcook/models"
 	"github.com/jaredfolkins/letemcook/paths"
@@ -23,15 +25,24 @@ func GetSystemSettingsHandler(c LemcContext) error {
 	v.BaseView.ActiveSubNav = paths.SystemSettings
 
 	settings := map[string]string{
-		"LEMC_ENV":         os.Getenv("LEMC_ENV"),
-		"LEMC_FQDN":        os.Getenv("LEMC_FQDN"),
-		"LEMC_DATA":        os.Getenv("LEMC_DATA"),
-		"LEMC_PORT_DEV":    os.Getenv("LEMC_PORT_DEV"),
-		"LEMC_PORT_TEST":   os.Getenv("LEMC_PORT_TEST"),
-		"LEMC_PORT_PROD":   os.Getenv("LEMC_PORT_PROD"),
-		"LEMC_DOCKER_HOST": os.Getenv("LEMC_DOCKER_HOST"),
-	}
-	sv := models.SystemSettingsView{BaseView: v.BaseView, Settings: settings}
+		"LEMC_ENV":                  os.Getenv("LEMC_ENV"),
+		"LEMC_FQDN":                 os.Getenv("LEMC_FQDN"),
+		"LEMC_DATA":                 os.Getenv("LEMC_DATA"),
+		"LEMC_PORT_DEV":             os.Getenv("LEMC_PORT_DEV"),
+		"LEMC_PORT_TEST":            os.Getenv("LEMC_PORT_TEST"),
+		"LEMC_PORT_PROD":            os.Getenv("LEMC_PORT_PROD"),
+		"LEMC_DOCKER_HOST":          os.Getenv("LEMC_DOCKER_HOST"),
+		"LEMC_IMAGE_CHECK_INTERVAL": os.Getenv("LEMC_IMAGE_CHECK_INTERVAL"),
+		"LEMC_IMAGE_GC_INTERVAL":    os.Getenv("LEMC_IMAGE_GC_INTERVAL"),
+		"LEMC_IMAGE_GC_RETENTION":   os.Getenv("LEMC_IMAGE_GC_RETENTION"),
+		"LEMC_MISFIRE_THRESHOLD":    os.Getenv("LEMC_MISFIRE_THRESHOLD"),
+		"LEMC_SHUTDOWN_TIMEOUT":     os.Getenv("LEMC_SHUTDOWN_TIMEOUT"),
+	}
+	endpoints, err := yeschef.DockerEndpointStatuses()
+	if err != nil {
+		return err
+	}
+	sv := models.SystemSettingsView{BaseView: v.BaseView, Settings: settings, Endpoints: endpoints}
 	cmp := pages.SystemSettings(sv)
 	if strings.ToLower(c.QueryParam("partial")) == "true" {
 		return HTML(c, cmp)
@@ -39,6 +50,34 @@ func GetSystemSettingsHandler(c LemcContext) error {
 	return HTML(c, pages.SystemSettingsIndex(sv, cmp))
 }
 
+// PostSystemEndpointHandler adds a Docker endpoint or updates the one with
+// the same name.
+func PostSystemEndpointHandler(c LemcContext) error {
+	e := &models.DockerEndpoint{
+		Name:    strings.TrimSpace(c.FormValue("name")),
+		Host:    strings.TrimSpace(c.FormValue("host")),
+		TLSCA:   strings.TrimSpace(c.FormValue("tls_ca")),
+		TLSCert: strings.TrimSpace(c.FormValue("tls_cert")),
+		TLSKey:  strings.TrimSpace(c.FormValue("tls_key")),
+	}
+	if err := models.SaveDockerEndpoint(e); err != nil {
+		c.AddErrorFlash("endpoints", "saving endpoint failed: "+err.Error())
+		return c.NoContent(http.StatusConflict)
+	}
+	c.AddSuccessFlash("endpoints", "endpoint "+e.Name+" saved")
+	return GetSystemSettingsHandler(c)
+}
+
+// PostSystemEndpointDeleteHandler removes a Docker endpoint.
+func PostSystemEndpointDeleteHandler(c LemcContext) error {
+	if err := models.DeleteDockerEndpoint(c.FormValue("name")); err != nil {
+		c.AddErrorFlash("endpoints", "deleting endpoint failed: "+err.Error())
+		return c.NoContent(http.StatusConflict)
+	}
+	c.AddSuccessFlash("endpoints", "endpoint deleted")
+	return GetSystemSettingsHandler(c)
+}
+
 func GetSystemAccountsHandler(c LemcContext) error {
 	v := getSystemView(c)
 	v.BaseView.ActiveSubNav = paths.SystemAccounts
@@ -74,11 +113,10 @@ func GetSystemImagesHandler(c LemcContext) error {
 	v := getSystemView(c)
 	v.BaseView.ActiveSubNav = paths.SystemImages
 
-	imgs, err := models.CollectImageInfos()
+	sv, err := newSystemImagesView(v.BaseView)
 	if err != nil {
 		return err
 	}
-	sv := models.SystemImagesView{BaseView: v.BaseView, Images: imgs}
 	cmp := pages.SystemImages(sv)
 	if strings.ToLower(c.QueryParam("partial")) == "true" {
 		return HTML(c, cmp)
@@ -86,6 +124,34 @@ func GetSystemImagesHandler(c LemcContext) error {
 	return HTML(c, pages.SystemImagesIndex(sv, cmp))
 }
 
+const systemImagePullsLimit = 25
+
+func newSystemImagesView(base models.BaseView) (models.SystemImagesView, error) {
+	imgs, err := models.CollectImageInfos()
+	if err != nil {
+		return models.SystemImagesView{}, err
+	}
+	pulls, err := models.RecentImagePulls(systemImagePullsLimit)
+	if err != nil {
+		return models.SystemImagesView{}, err
+	}
+	sv := models.SystemImagesView{BaseView: base, Images: imgs, Pulls: pulls}
+
+	unused, err := yeschef.CollectUnusedImages()
+	if err != nil {
+		log.Printf("CollectUnusedImages: %v", err)
+	}
+	sv.Unused = unused
+	for _, img := range unused {
+		sv.Reclaimable += img.Size
+	}
+	return sv, nil
+}
+
+// deadJobsLimit is how many of the newest dead letter jobs the system jobs
+// page lists.
+const deadJobsLimit = 50
+
 func GetSystemJobsHandler(c LemcContext) error {
 	v := getSystemView(c)
 	v.BaseView.ActiveSubNav = paths.SystemJobs
@@ -105,7 +171,14 @@ func GetSystemJobsHandler(c LemcContext) error {
 		return err
 	}
 	totalPages := (total + limit - 1) / limit
-	sv := models.SystemJobsView{BaseView: v.BaseView, Jobs: jobs, CurrentPage: page, TotalPages: totalPages, Limit: limit}
+	deadJobs, deadTotal, err := models.DeadLetterJobs(1, deadJobsLimit)
+	if err != nil {
+		return err
+	}
+
+	sv := models.SystemJobsView{BaseView: v.BaseView, Jobs: jobs, CurrentPage: page, TotalPages: totalPages, Limit: limit,
+		Recovery: yeschef.LastRecoveryReport(), DeadJobs: deadJobs, DeadTotal: deadTotal, Drain: yeschef.DrainStatus(),
+		Workers: yeschef.Workers()}
 	cmp := pages.SystemJobs(sv)
 	if strings.ToLower(c.QueryParam("partial")) == "true" {
 		return HTML(c, cmp)
@@ -119,17 +192,40 @@ func PostSystemImagePullHandler(c LemcContext) error {
 		return c.NoContent(http.StatusBadRequest)
 	}
 
-	if err := yeschef.PullImage(yeschef.ImageSpec{Name: img}); err != nil {
+	if err := yeschef.PullImage(yeschef.ImageSpec{Name: img}, c.UserContext().ActingAs.Username); err != nil {
 		return err
 	}
 
 	v := getSystemView(c)
 	v.BaseView.ActiveSubNav = paths.SystemImages
-	imgs, err := models.CollectImageInfos()
+	sv, err := newSystemImagesView(v.BaseView)
+	if err != nil {
+		return err
+	}
+	cmp := pages.SystemImages(sv)
+	return HTML(c, cmp)
+}
+
+func PostSystemImagePruneHandler(c LemcContext) error {
+	dryRun := c.FormValue("dry_run") == "on"
+	retention := yeschef.DefaultImageGCRetention
+	if days, err := strconv.Atoi(c.FormValue("retention_days")); err == nil && days >= 0 {
+		retention = time.Duration(days) * 24 * time.Hour
+	}
+
+	report, err := yeschef.PruneUnusedImages(dryRun, retention)
+	if err != nil {
+		c.AddErrorFlash("images", "image prune failed: "+err.Error())
+		return c.NoContent(http.StatusConflict)
+	}
+
+	v := getSystemView(c)
+	v.BaseView.ActiveSubNav = paths.SystemImages
+	sv, err := newSystemImagesView(v.BaseView)
 	if err != nil {
 		return err
 	}
-	sv := models.SystemImagesView{BaseView: v.BaseView, Images: imgs}
+	sv.GCReport = report
 	cmp := pages.SystemImages(sv)
 	return HTML(c, cmp)
 }
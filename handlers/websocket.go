@@ -41,10 +41,19 @@ func Ws(c LemcContext) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
+	// A reconnecting browser passes the last sequence number it saw of each
+	// run and is sent what it missed on the topics it subscribes to.
+	var since map[string]uint64
+	if c.QueryParams().Has("since") {
+		since = yeschef.ParseSince(c.QueryParam("since"))
+	}
+
 	xlient := &yeschef.Client{
-		Xend:   make(chan []byte, 1024),
+		Xend:   make(chan []byte, yeschef.CLIENT_BUFFER_SIZE),
 		Xerver: xerver,
 		Xonn:   xonn,
+		Since:  since,
+		Topics: yeschef.ParseTopics(c.QueryParam("topics")),
 	}
 
 	// Register the client with the server
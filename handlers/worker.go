@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/jaredfolkins/letemcook/yeschef"
+)
+
+// WorkerConnect upgrades the connection of a lemc-worker that presents the
+// worker token and serves it until it disconnects.
+func WorkerConnect(c LemcContext) error {
+	if yeschef.WorkerToken() == "" {
+		return c.NoContent(http.StatusNotFound)
+	}
+	if !yeschef.WorkerAuthorized(c.Request().Header.Get("Authorization")) {
+		return c.NoContent(http.StatusUnauthorized)
+	}
+
+	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		log.Printf("Failed to upgrade worker websocket: %v", err)
+		return err
+	}
+	if err := yeschef.ServeWorker(conn, c.RealIP()); err != nil {
+		log.Printf("worker connection from %s closed: %v", c.RealIP(), err)
+	}
+	return nil
+}
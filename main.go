@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/jaredfolkins/letemcook/db"
@@ -44,6 +49,8 @@ const (
 	DATA_FOLDER        = "data"
 
 	ENV_FILE = ".env"
+
+	HTTP_SHUTDOWN_TIMEOUT = 5 * time.Second
 )
 
 func portFromEnv() string {
@@ -180,5 +187,28 @@ func main() {
 	yeschef.Start()
 
 	port := portFromEnv()
-	e.Logger.Fatal(e.Start(":" + port))
+	go func() {
+		if err := e.Start(":" + port); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	// On SIGTERM or interrupt, let running steps finish and persist what is
+	// left before the HTTP server goes, so their logs keep streaming.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	stop()
+
+	timeout := yeschef.ShutdownTimeout()
+	log.Printf("shutting down, waiting up to %s for running jobs", timeout)
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), timeout)
+	defer cancelDrain()
+	yeschef.Shutdown(drainCtx)
+
+	httpCtx, cancelHTTP := context.WithTimeout(context.Background(), HTTP_SHUTDOWN_TIMEOUT)
+	defer cancelHTTP()
+	if err := e.Shutdown(httpCtx); err != nil {
+		log.Printf("http shutdown: %v", err)
+	}
 }
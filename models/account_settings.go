@@ -16,12 +16,18 @@ type AccountSettings struct {
 	Theme        string
 	Registration bool
 	Heckle       bool
-	Created      time.Time
-	Updated      time.Time
+	// ImageAllowlist holds newline separated repository globs. Empty allows any image.
+	ImageAllowlist string
+	RequireDigest  bool
+	// DockerEndpoint is the Docker endpoint steps of the account run on,
+	// empty for the default one.
+	DockerEndpoint string
+	Created        time.Time
+	Updated        time.Time
 }
 
 func GetAccountSettingsByAccountID(db *sql.DB, accountID int64) (*AccountSettings, error) {
-	query := `SELECT id, account_id, theme, registration, heckle, created, updated
+	query := `SELECT id, account_id, theme, registration, heckle, image_allowlist, require_digest, docker_endpoint, created, updated
               FROM account_settings WHERE account_id = ?`
 	row := db.QueryRow(query, accountID)
 
@@ -33,6 +39,9 @@ func GetAccountSettingsByAccountID(db *sql.DB, accountID int64) (*AccountSetting
 		&settings.Theme,
 		&settings.Registration,
 		&settings.Heckle,
+		&settings.ImageAllowlist,
+		&settings.RequireDigest,
+		&settings.DockerEndpoint,
 		&settings.Created,
 		&settings.Updated,
 	)
@@ -50,15 +59,18 @@ func GetAccountSettingsByAccountID(db *sql.DB, accountID int64) (*AccountSetting
 func UpsertAccountSettings(db *sqlx.DB, settings *AccountSettings) error {
 
 	query := `
-        INSERT INTO account_settings (account_id, theme, registration, heckle)
-        VALUES (?, ?, ?, ?)
+        INSERT INTO account_settings (account_id, theme, registration, heckle, image_allowlist, require_digest, docker_endpoint)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
         ON CONFLICT(account_id) DO UPDATE SET
         theme = excluded.theme,
         registration = excluded.registration,
         heckle = excluded.heckle,
+        image_allowlist = excluded.image_allowlist,
+        require_digest = excluded.require_digest,
+        docker_endpoint = excluded.docker_endpoint,
         updated = CURRENT_TIMESTAMP;`
 
-	_, err := db.Exec(query, settings.AccountID, settings.Theme, settings.Registration, settings.Heckle)
+	_, err := db.Exec(query, settings.AccountID, settings.Theme, settings.Registration, settings.Heckle, settings.ImageAllowlist, settings.RequireDigest, settings.DockerEndpoint)
 	if err != nil {
 		log.Printf("Failed to upsert account settings for account %d: %v", settings.AccountID, err)
 		return err
@@ -85,6 +97,17 @@ func AccountSettingsByAccountID(accountID int64) (bool, error) {
 	return registration, nil
 }
 
+// AccountDockerEndpoint returns the Docker endpoint the account picked, empty
+// for the default one or when the account has no settings.
+func AccountDockerEndpoint(accountID int64) (string, error) {
+	var name string
+	err := db.Db().Get(&name, `SELECT docker_endpoint FROM account_settings WHERE account_id = ?`, accountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return name, err
+}
+
 // Save inserts or updates the account settings in the database.
 func (s *AccountSettings) Save() error {
 	return UpsertAccountSettings(db.Db(), s)
@@ -4,4 +4,5 @@ type AccountSettingsView struct {
 	BaseView
 	Settings        *AccountSettings
 	AvailableThemes []string
+	DockerEndpoints []string // names of the configured Docker endpoints
 }
@@ -156,6 +156,7 @@ func (c *App) Create(tx *sqlx.Tx) error {
 	if err != nil {
 		return err
 	}
+	c.ID = id
 
 	query = `
         INSERT INTO permissions_apps
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/db"
+)
+
+// Dead letter reasons record why a job left its queue without running.
+const (
+	DeadLetterInvalid     = "invalid"     // the payload could not be decoded or validated
+	DeadLetterFailed      = "failed"      // the job ran and returned an error
+	DeadLetterInterrupted = "interrupted" // the server went down while the job ran and its container is gone
+	DeadLetterUnrestored  = "unrestored"  // the job could not be put back in its queue at startup
+)
+
+// DeadLetterJob is a scheduled job that was taken out of its queue because it
+// was broken or failed. Payload is the job as it was queued so it can be
+// retried, edited first if need be.
+type DeadLetterJob struct {
+	Created    time.Time `db:"created" json:"created"`
+	ID         int64     `db:"id" json:"id"`
+	Queue      string    `db:"queue" json:"queue"`
+	JobKey     string    `db:"job_key" json:"job_key"`
+	RecipeName string    `db:"recipe_name" json:"recipe_name"`
+	Username   string    `db:"username" json:"username"`
+	AccountID  int64     `db:"account_id" json:"account_id"`
+	Reason     string    `db:"reason" json:"reason"`
+	Error      string    `db:"error" json:"error"`
+	Payload    string    `db:"payload" json:"payload"`
+}
+
+const deadLetterJobColumns = `created, id, queue, job_key, recipe_name, username, account_id, reason, error, payload`
+
+// InsertDeadLetterJob stores a dead job and sets its ID.
+func InsertDeadLetterJob(j *DeadLetterJob) error {
+	query := `
+        INSERT INTO dead_letter_jobs (queue, job_key, recipe_name, username, account_id, reason, error, payload)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := db.Db().Exec(query, j.Queue, j.JobKey, j.RecipeName, j.Username, j.AccountID, j.Reason, j.Error, j.Payload)
+	if err != nil {
+		return err
+	}
+	j.ID, err = res.LastInsertId()
+	return err
+}
+
+// DeadLetterJobs returns a page of dead jobs, newest first, and the total
+// number of dead jobs.
+func DeadLetterJobs(page, limit int) ([]DeadLetterJob, int, error) {
+	var total int
+	if err := db.Db().Get(&total, `SELECT COUNT(*) FROM dead_letter_jobs`); err != nil {
+		return nil, 0, err
+	}
+
+	jobs := []DeadLetterJob{}
+	query := `SELECT ` + deadLetterJobColumns + ` FROM dead_letter_jobs ORDER BY created DESC, id DESC LIMIT ? OFFSET ?`
+	if err := db.Db().Select(&jobs, query, limit, (page-1)*limit); err != nil {
+		return nil, 0, err
+	}
+	return jobs, total, nil
+}
+
+// DeadLetterJobByID returns the dead job with the given ID.
+func DeadLetterJobByID(id int64) (*DeadLetterJob, error) {
+	var j DeadLetterJob
+	query := `SELECT ` + deadLetterJobColumns + ` FROM dead_letter_jobs WHERE id = ?`
+	if err := db.Db().Get(&j, query, id); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// UpdateDeadLetterJob records a new error and payload for a dead job, for
+// example after a retry failed again.
+func UpdateDeadLetterJob(id int64, errMsg, payload string) error {
+	res, err := db.Db().Exec(`UPDATE dead_letter_jobs SET error = ?, payload = ? WHERE id = ?`, errMsg, payload, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteDeadLetterJob removes a dead job. sql.ErrNoRows is returned when it
+// does not exist.
+func DeleteDeadLetterJob(id int64) error {
+	res, err := db.Db().Exec(`DELETE FROM dead_letter_jobs WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PurgeDeadLetterJobs removes every dead job and returns how many there were.
+func PurgeDeadLetterJobs() (int64, error) {
+	res, err := db.Db().Exec(`DELETE FROM dead_letter_jobs`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestDeadLetterJobs(t *testing.T) {
+	if _, err := PurgeDeadLetterJobs(); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	defer PurgeDeadLetterJobs()
+
+	first := &DeadLetterJob{Queue: "in", JobKey: "first", Reason: DeadLetterInvalid, Error: "bad", Payload: "{"}
+	second := &DeadLetterJob{Queue: "now", JobKey: "second", Reason: DeadLetterFailed, Error: "boom", Payload: "{}"}
+	for _, d := range []*DeadLetterJob{first, second} {
+		if err := InsertDeadLetterJob(d); err != nil {
+			t.Fatalf("insert %s: %v", d.JobKey, err)
+		}
+	}
+
+	jobs, total, err := DeadLetterJobs(1, 1)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if total != 2 || len(jobs) != 1 || jobs[0].JobKey != "second" {
+		t.Fatalf("expected the newest of 2 dead jobs, got %+v total=%d", jobs, total)
+	}
+
+	if err := UpdateDeadLetterJob(first.ID, "still bad", "{}"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	got, err := DeadLetterJobByID(first.ID)
+	if err != nil || got.Error != "still bad" || got.Payload != "{}" {
+		t.Fatalf("unexpected dead job %+v err=%v", got, err)
+	}
+
+	if err := DeleteDeadLetterJob(first.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := DeleteDeadLetterJob(first.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows deleting twice, got %v", err)
+	}
+	if n, err := PurgeDeadLetterJobs(); err != nil || n != 1 {
+		t.Fatalf("expected to purge 1 job, got %d err=%v", n, err)
+	}
+}
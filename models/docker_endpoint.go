@@ -0,0 +1,247 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/jaredfolkins/letemcook/db"
+)
+
+// DefaultDockerEndpoint is the name of the endpoint LEMC_DOCKER_HOST points
+// at. It is used when a cookbook and its account pick none.
+const DefaultDockerEndpoint = "default"
+
+var dockerEndpointNameRgx = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// DockerEndpoint is a named Docker host steps can run on. Host is a unix://,
+// tcp:// or ssh:// URL. A tcp endpoint with TLSCA, TLSCert and TLSKey, paths
+// to PEM files on the server, is reached over TLS with a client certificate.
+// An ssh endpoint runs `docker system dial-stdio` on the remote host through
+// the ssh client of the server.
+type DockerEndpoint struct {
+	ID      int64     `db:"id"`
+	Name    string    `db:"name"`
+	Host    string    `db:"host"`
+	TLSCA   string    `db:"tls_ca"`
+	TLSCert string    `db:"tls_cert"`
+	TLSKey  string    `db:"tls_key"`
+	Created time.Time `db:"created"`
+	Updated time.Time `db:"updated"`
+}
+
+// DockerEndpointStatus is the result of a health check of an endpoint.
+type DockerEndpointStatus struct {
+	DockerEndpoint
+	Healthy bool
+	Version string // Docker version of a healthy endpoint
+	Error   string
+}
+
+// Validate checks the name, host and TLS files of the endpoint.
+func (e *DockerEndpoint) Validate() error {
+	if !dockerEndpointNameRgx.MatchString(e.Name) {
+		return fmt.Errorf("endpoint name %q must be lower case letters, digits, '_' or '-'", e.Name)
+	}
+	if e.Name == DefaultDockerEndpoint {
+		return fmt.Errorf("endpoint name %q is reserved for LEMC_DOCKER_HOST", e.Name)
+	}
+	u, err := url.Parse(e.Host)
+	if err != nil {
+		return fmt.Errorf("endpoint host: %w", err)
+	}
+	switch u.Scheme {
+	case "unix", "tcp", "ssh":
+	default:
+		return fmt.Errorf("endpoint host %q must start with unix://, tcp:// or ssh://", e.Host)
+	}
+	tls := e.TLSCA != "" || e.TLSCert != "" || e.TLSKey != ""
+	if tls && u.Scheme != "tcp" {
+		return fmt.Errorf("TLS client certificates need a tcp:// host")
+	}
+	if tls && (e.TLSCA == "" || e.TLSCert == "" || e.TLSKey == "") {
+		return fmt.Errorf("TLS needs the CA, certificate and key files")
+	}
+	return nil
+}
+
+// TLS reports whether the endpoint is reached with a client certificate.
+func (e *DockerEndpoint) TLS() bool {
+	return e.TLSCert != ""
+}
+
+// Client returns a Docker client for the endpoint.
+func (e *DockerEndpoint) Client() (*client.Client, error) {
+	opts := []client.Opt{client.WithAPIVersionNegotiation()}
+	u, err := url.Parse(e.Host)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case u.Scheme == "ssh":
+		opts = append(opts, client.WithHost("http://docker.example.com"), client.WithDialContext(sshDialer(u)))
+	case e.TLS():
+		opts = append(opts, client.WithHost(e.Host), client.WithTLSClientConfig(e.TLSCA, e.TLSCert, e.TLSKey))
+	default:
+		opts = append(opts, client.WithHost(e.Host))
+	}
+	return client.NewClientWithOpts(opts...)
+}
+
+// defaultDockerEndpoint returns the endpoint LEMC_DOCKER_HOST points at.
+func defaultDockerEndpoint() *DockerEndpoint {
+	return &DockerEndpoint{Name: DefaultDockerEndpoint, Host: os.Getenv("LEMC_DOCKER_HOST")}
+}
+
+// DockerClient returns a Docker client for the named endpoint. An empty name
+// is the default endpoint.
+func DockerClient(name string) (*client.Client, error) {
+	e, err := DockerEndpointByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return e.Client()
+}
+
+const dockerEndpointColumns = `id, name, host, tls_ca, tls_cert, tls_key, created, updated`
+
+// DockerEndpointByName returns the named endpoint. An empty name or
+// DefaultDockerEndpoint is the default endpoint.
+func DockerEndpointByName(name string) (*DockerEndpoint, error) {
+	if name == "" || name == DefaultDockerEndpoint {
+		return defaultDockerEndpoint(), nil
+	}
+	e := &DockerEndpoint{}
+	err := db.Db().Get(e, `SELECT `+dockerEndpointColumns+` FROM docker_endpoints WHERE name = ?`, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("docker endpoint %q is not configured", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// DockerEndpoints returns the default endpoint followed by the configured
+// ones by name.
+func DockerEndpoints() ([]DockerEndpoint, error) {
+	endpoints := []DockerEndpoint{}
+	if err := db.Db().Select(&endpoints, `SELECT `+dockerEndpointColumns+` FROM docker_endpoints ORDER BY name`); err != nil {
+		return nil, err
+	}
+	return append([]DockerEndpoint{*defaultDockerEndpoint()}, endpoints...), nil
+}
+
+// SaveDockerEndpoint validates the endpoint and inserts it, or updates the
+// endpoint with the same name.
+func SaveDockerEndpoint(e *DockerEndpoint) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	query := `
+        INSERT INTO docker_endpoints (name, host, tls_ca, tls_cert, tls_key)
+        VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT(name) DO UPDATE SET
+        host = excluded.host,
+        tls_ca = excluded.tls_ca,
+        tls_cert = excluded.tls_cert,
+        tls_key = excluded.tls_key,
+        updated = CURRENT_TIMESTAMP`
+	_, err := db.Db().Exec(query, e.Name, e.Host, e.TLSCA, e.TLSCert, e.TLSKey)
+	return err
+}
+
+// DeleteDockerEndpoint removes a configured endpoint. Accounts that picked
+// it go back to the default endpoint. sql.ErrNoRows is returned when there
+// is no such endpoint.
+func DeleteDockerEndpoint(name string) error {
+	tx, err := db.Db().Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`DELETE FROM docker_endpoints WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(`UPDATE account_settings SET docker_endpoint = '' WHERE docker_endpoint = ?`, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sshDialer connects to the Docker daemon of an ssh:// host through
+// `ssh host docker system dial-stdio`, like the docker CLI does.
+func sshDialer(u *url.URL) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		target := u.Hostname()
+		if u.User != nil {
+			target = u.User.Username() + "@" + target
+		}
+		args := []string{"-o", "BatchMode=yes"}
+		if u.Port() != "" {
+			args = append(args, "-p", u.Port())
+		}
+		args = append(args, "--", target, "docker", "system", "dial-stdio")
+
+		cmd := exec.Command("ssh", args...)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("ssh %s: %w", target, err)
+		}
+		return &commandConn{cmd: cmd, stdin: stdin, stdout: stdout, addr: u.Host}, nil
+	}
+}
+
+// commandConn is a net.Conn over the stdin and stdout of a command.
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	addr   string
+}
+
+func (c *commandConn) Read(p []byte) (int, error)  { return c.stdout.Read(p) }
+func (c *commandConn) Write(p []byte) (int, error) { return c.stdin.Write(p) }
+
+func (c *commandConn) Close() error {
+	c.stdin.Close()
+	c.stdout.Close()
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+	}
+	c.cmd.Wait()
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr                { return commandAddr("ssh") }
+func (c *commandConn) RemoteAddr() net.Addr               { return commandAddr(c.addr) }
+func (c *commandConn) SetDeadline(t time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type commandAddr string
+
+func (a commandAddr) Network() string { return "ssh" }
+func (a commandAddr) String() string  { return string(a) }
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/jaredfolkins/letemcook/db"
+)
+
+func TestDockerEndpointValidate(t *testing.T) {
+	valid := []DockerEndpoint{
+		{Name: "local", Host: "unix:///var/run/docker.sock"},
+		{Name: "dmz-1", Host: "tcp://10.0.0.5:2376", TLSCA: "ca.pem", TLSCert: "cert.pem", TLSKey: "key.pem"},
+		{Name: "edge", Host: "ssh://deploy@edge.example.com:2222"},
+	}
+	for _, e := range valid {
+		if err := e.Validate(); err != nil {
+			t.Errorf("%s: %v", e.Name, err)
+		}
+	}
+	invalid := []DockerEndpoint{
+		{Name: "default", Host: "unix:///var/run/docker.sock"},
+		{Name: "Bad Name", Host: "unix:///var/run/docker.sock"},
+		{Name: "http", Host: "http://10.0.0.5:2375"},
+		{Name: "half-tls", Host: "tcp://10.0.0.5:2376", TLSCert: "cert.pem"},
+		{Name: "ssh-tls", Host: "ssh://edge", TLSCA: "ca.pem", TLSCert: "cert.pem", TLSKey: "key.pem"},
+	}
+	for _, e := range invalid {
+		if err := e.Validate(); err == nil {
+			t.Errorf("%s: expected %q to be refused", e.Name, e.Host)
+		}
+	}
+}
+
+func TestDockerEndpoints(t *testing.T) {
+	res, err := db.Db().Exec("INSERT INTO accounts (squid, name) VALUES (?, ?)", "endpointsquid", "endpoint-account")
+	if err != nil {
+		t.Fatalf("account: %v", err)
+	}
+	accountID, _ := res.LastInsertId()
+
+	e := &DockerEndpoint{Name: "dmz", Host: "tcp://10.0.0.5:2375"}
+	if err := SaveDockerEndpoint(e); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	e.Host = "tcp://10.0.0.6:2375"
+	if err := SaveDockerEndpoint(e); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	got, err := DockerEndpointByName("dmz")
+	if err != nil || got.Host != "tcp://10.0.0.6:2375" {
+		t.Fatalf("unexpected endpoint %+v: %v", got, err)
+	}
+	list, err := DockerEndpoints()
+	if err != nil || len(list) < 2 || list[0].Name != DefaultDockerEndpoint {
+		t.Fatalf("expected the default endpoint first, got %+v: %v", list, err)
+	}
+
+	if err := UpsertAccountSettings(db.Db(), &AccountSettings{AccountID: accountID, Theme: "default", DockerEndpoint: "dmz"}); err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+	if name, err := AccountDockerEndpoint(accountID); err != nil || name != "dmz" {
+		t.Fatalf("expected the account to use dmz, got %q: %v", name, err)
+	}
+
+	if err := DeleteDockerEndpoint("dmz"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := DockerEndpointByName("dmz"); err == nil {
+		t.Fatal("expected the deleted endpoint to be gone")
+	}
+	if name, _ := AccountDockerEndpoint(accountID); name != "" {
+		t.Fatalf("expected the account back on the default endpoint, got %q", name)
+	}
+	if err := DeleteDockerEndpoint("dmz"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows deleting twice, got %v", err)
+	}
+}
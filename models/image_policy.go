@@ -0,0 +1,156 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/jaredfolkins/letemcook/db"
+)
+
+const defaultRegistry = "docker.io"
+
+// ImagePolicy restricts which container images an account's recipes may use.
+// Allowlist entries are globs matched against the fully qualified repository
+// (for example "docker.io/library/*" or "ghcr.io/acme/**"). A trailing "/**"
+// matches any depth below the prefix. An empty allowlist allows any registry.
+type ImagePolicy struct {
+	Allowlist     []string
+	RequireDigest bool
+}
+
+// ImagePolicyViolation describes a single step image rejected by a policy.
+type ImagePolicyViolation struct {
+	PageID int
+	Recipe string
+	Step   int
+	Image  string
+	Reason string
+}
+
+func (v ImagePolicyViolation) String() string {
+	if v.Recipe == "" {
+		return fmt.Sprintf("%s: %s", v.Image, v.Reason)
+	}
+	return fmt.Sprintf("page %d recipe %s step %d: %s: %s", v.PageID, v.Recipe, v.Step, v.Image, v.Reason)
+}
+
+// NewImagePolicy builds a policy from the newline separated allowlist stored in account settings.
+func NewImagePolicy(allowlist string, requireDigest bool) *ImagePolicy {
+	p := &ImagePolicy{RequireDigest: requireDigest}
+	for _, line := range strings.Split(allowlist, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.Allowlist = append(p.Allowlist, line)
+	}
+	return p
+}
+
+// ImagePolicyByAccountID loads the image policy for an account. Accounts
+// without a settings row get an empty policy which allows everything.
+func ImagePolicyByAccountID(accountID int64) (*ImagePolicy, error) {
+	var row struct {
+		ImageAllowlist string `db:"image_allowlist"`
+		RequireDigest  bool   `db:"require_digest"`
+	}
+	query := `SELECT image_allowlist, require_digest FROM account_settings WHERE account_id = ?`
+	if err := db.Db().Get(&row, query, accountID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &ImagePolicy{}, nil
+		}
+		return nil, err
+	}
+	return NewImagePolicy(row.ImageAllowlist, row.RequireDigest), nil
+}
+
+// Enabled reports whether the policy restricts anything at all.
+func (p *ImagePolicy) Enabled() bool {
+	return p != nil && (len(p.Allowlist) > 0 || p.RequireDigest)
+}
+
+// CheckImage returns the reason an image is rejected, or an empty string when it is allowed.
+func (p *ImagePolicy) CheckImage(image string) string {
+	if !p.Enabled() {
+		return ""
+	}
+	repo, digest := SplitImageReference(image)
+	if p.RequireDigest && !strings.HasPrefix(digest, "sha256:") {
+		return "image must be pinned by digest (@sha256:...)"
+	}
+	if len(p.Allowlist) == 0 {
+		return ""
+	}
+	for _, pattern := range p.Allowlist {
+		if matchRepository(pattern, repo) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("repository %s is not in the registry allowlist", repo)
+}
+
+// CheckRecipe returns a violation for every step image in the recipe the policy rejects.
+func (p *ImagePolicy) CheckRecipe(pageID int, r Recipe) []ImagePolicyViolation {
+	var violations []ImagePolicyViolation
+	for _, st := range r.Steps {
+		if reason := p.CheckImage(st.Image); reason != "" {
+			violations = append(violations, ImagePolicyViolation{
+				PageID: pageID,
+				Recipe: r.Name,
+				Step:   st.Step,
+				Image:  st.Image,
+				Reason: reason,
+			})
+		}
+	}
+	return violations
+}
+
+// CheckYaml returns every violation across all pages and recipes of a cookbook.
+func (p *ImagePolicy) CheckYaml(yd *YamlDefault) []ImagePolicyViolation {
+	var violations []ImagePolicyViolation
+	if !p.Enabled() || yd == nil {
+		return violations
+	}
+	for _, page := range yd.Cookbook.Pages {
+		for _, r := range page.Recipes {
+			violations = append(violations, p.CheckRecipe(page.PageID, r)...)
+		}
+	}
+	return violations
+}
+
+// SplitImageReference returns the fully qualified repository (registry and
+// path, without tag) and the digest, if any, of an image reference.
+func SplitImageReference(ref string) (string, string) {
+	ref = strings.TrimSpace(ref)
+	var digest string
+	if i := strings.Index(ref, "@"); i >= 0 {
+		digest = ref[i+1:]
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) == 1 {
+		return defaultRegistry + "/library/" + ref, digest
+	}
+	first := parts[0]
+	if !strings.ContainsAny(first, ".:") && first != "localhost" {
+		return defaultRegistry + "/" + ref, digest
+	}
+	return ref, digest
+}
+
+func matchRepository(pattern, repo string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return strings.HasPrefix(repo, prefix+"/")
+	}
+	matched, err := path.Match(pattern, repo)
+	return err == nil && matched
+}
@@ -0,0 +1,71 @@
+package models
+
+import "testing"
+
+func TestSplitImageReference(t *testing.T) {
+	tests := []struct {
+		ref    string
+		repo   string
+		digest string
+	}{
+		{"alpine", "docker.io/library/alpine", ""},
+		{"alpine:3.20", "docker.io/library/alpine", ""},
+		{"acme/tool:latest", "docker.io/acme/tool", ""},
+		{"ghcr.io/acme/tool:v1", "ghcr.io/acme/tool", ""},
+		{"localhost:5000/tool", "localhost:5000/tool", ""},
+		{"alpine@sha256:abc", "docker.io/library/alpine", "sha256:abc"},
+		{"ghcr.io/acme/tool:v1@sha256:abc", "ghcr.io/acme/tool", "sha256:abc"},
+	}
+	for _, tt := range tests {
+		repo, digest := SplitImageReference(tt.ref)
+		if repo != tt.repo || digest != tt.digest {
+			t.Errorf("%s: got %s %s want %s %s", tt.ref, repo, digest, tt.repo, tt.digest)
+		}
+	}
+}
+
+func TestImagePolicyCheckImage(t *testing.T) {
+	p := NewImagePolicy("docker.io/library/*\n# comment\n\nghcr.io/acme/**\n", false)
+	allowed := []string{"alpine", "docker.io/library/busybox:1", "ghcr.io/acme/tool", "ghcr.io/acme/team/tool:v2"}
+	for _, img := range allowed {
+		if reason := p.CheckImage(img); reason != "" {
+			t.Errorf("%s: unexpected violation %s", img, reason)
+		}
+	}
+	denied := []string{"evil/alpine", "ghcr.io/other/tool", "ghcr.io/acme"}
+	for _, img := range denied {
+		if p.CheckImage(img) == "" {
+			t.Errorf("%s: expected violation", img)
+		}
+	}
+
+	pinned := NewImagePolicy("", true)
+	if pinned.CheckImage("alpine:3.20") == "" {
+		t.Fatalf("expected digest violation")
+	}
+	if reason := pinned.CheckImage("alpine@sha256:abc"); reason != "" {
+		t.Fatalf("unexpected violation %s", reason)
+	}
+
+	if (&ImagePolicy{}).Enabled() {
+		t.Fatalf("empty policy should be disabled")
+	}
+}
+
+func TestImagePolicyCheckYaml(t *testing.T) {
+	yd := &YamlDefault{}
+	yd.Cookbook.Pages = []Page{{
+		PageID: 2,
+		Recipes: []Recipe{{
+			Name: "deploy",
+			Steps: []Step{
+				{Step: 1, Image: "alpine"},
+				{Step: 2, Image: "quay.io/x/y"},
+			},
+		}},
+	}}
+	v := NewImagePolicy("docker.io/**", false).CheckYaml(yd)
+	if len(v) != 1 || v[0].Step != 2 || v[0].PageID != 2 {
+		t.Fatalf("unexpected violations: %+v", v)
+	}
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/db"
+)
+
+const (
+	PullPolicyAlways       = "always"
+	PullPolicyIfNotPresent = "if-not-present"
+	PullPolicyScheduled    = "scheduled"
+)
+
+// ValidPullPolicy reports whether p is a known pull policy. An empty policy
+// is valid and means PullPolicyIfNotPresent.
+func ValidPullPolicy(p string) bool {
+	switch p {
+	case "", PullPolicyAlways, PullPolicyIfNotPresent, PullPolicyScheduled:
+		return true
+	}
+	return false
+}
+
+const (
+	ImagePullSourceScheduled = "scheduled"
+	ImagePullSourceManual    = "manual"
+	ImagePullSourceJob       = "job"
+)
+
+// ImageState tracks drift between the local copy of an image and its
+// registry, and which local image ID was used by the most recent run.
+type ImageState struct {
+	Created      time.Time    `db:"created"`
+	Updated      time.Time    `db:"updated"`
+	Image        string       `db:"image"`
+	LocalID      string       `db:"local_id"`
+	RemoteDigest string       `db:"remote_digest"`
+	Drifted      bool         `db:"drifted"`
+	Checked      sql.NullTime `db:"checked"`
+	LastRunID    string       `db:"last_run_id"`
+	LastRun      sql.NullTime `db:"last_run"`
+}
+
+// ImagePull is a single entry in the image pull audit trail.
+type ImagePull struct {
+	Created    time.Time `db:"created"`
+	ID         int64     `db:"id"`
+	Image      string    `db:"image"`
+	Source     string    `db:"source"`
+	Actor      string    `db:"actor"`
+	PreviousID string    `db:"previous_id"`
+	NewID      string    `db:"new_id"`
+	Error      string    `db:"error"`
+}
+
+// Changed reports whether the pull replaced the local image.
+func (p ImagePull) Changed() bool {
+	return p.Error == "" && p.NewID != "" && p.NewID != p.PreviousID
+}
+
+// ImageStates returns the recorded state of every known image keyed by name.
+func ImageStates() (map[string]ImageState, error) {
+	var rows []ImageState
+	if err := db.Db().Select(&rows, `SELECT * FROM image_state`); err != nil {
+		return nil, err
+	}
+	states := make(map[string]ImageState, len(rows))
+	for _, r := range rows {
+		states[r.Image] = r
+	}
+	return states, nil
+}
+
+// RecordImageDrift stores the outcome of a drift check for an image.
+func RecordImageDrift(image, localID, remoteDigest string, drifted bool) error {
+	query := `
+        INSERT INTO image_state (image, local_id, remote_digest, drifted, checked)
+        VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+        ON CONFLICT(image) DO UPDATE SET
+        local_id = excluded.local_id,
+        remote_digest = excluded.remote_digest,
+        drifted = excluded.drifted,
+        checked = excluded.checked,
+        updated = CURRENT_TIMESTAMP;`
+	_, err := db.Db().Exec(query, image, localID, remoteDigest, drifted)
+	return err
+}
+
+// RecordImageRun stores the local image ID used by a run, marks the image as
+// used, and returns the ID used by the previous run when it differs, meaning
+// the image was updated since the last run. The first run of an image never
+// reports an update.
+func RecordImageRun(image, localID string) (string, error) {
+	var previous string
+	err := db.Db().Get(&previous, `SELECT last_run_id FROM image_state WHERE image = ?`, image)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	query := `
+        INSERT INTO image_state (image, local_id, last_run_id, last_run)
+        VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+        ON CONFLICT(image) DO UPDATE SET
+        local_id = excluded.local_id,
+        last_run_id = excluded.last_run_id,
+        last_run = excluded.last_run,
+        updated = CURRENT_TIMESTAMP;`
+	if _, err := db.Db().Exec(query, image, localID, localID); err != nil {
+		return "", err
+	}
+	if err := TouchImages(localID); err != nil {
+		return "", err
+	}
+
+	if previous != "" && previous != localID {
+		return previous, nil
+	}
+	return "", nil
+}
+
+// TouchImages records that LEMC used the local images with the given IDs
+// now, by running, pulling or referencing them. The image collector only
+// removes images LEMC used.
+func TouchImages(ids ...string) error {
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		query := `
+        INSERT INTO image_usage (image_id, last_used)
+        VALUES (?, CURRENT_TIMESTAMP)
+        ON CONFLICT(image_id) DO UPDATE SET
+        last_used = excluded.last_used;`
+		if _, err := db.Db().Exec(query, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImageLastUsed returns when LEMC last used each image it tracks, keyed by
+// local image ID.
+func ImageLastUsed() (map[string]time.Time, error) {
+	var rows []struct {
+		ImageID  string    `db:"image_id"`
+		LastUsed time.Time `db:"last_used"`
+	}
+	if err := db.Db().Select(&rows, `SELECT image_id, last_used FROM image_usage`); err != nil {
+		return nil, err
+	}
+	used := make(map[string]time.Time, len(rows))
+	for _, r := range rows {
+		used[r.ImageID] = r.LastUsed
+	}
+	return used, nil
+}
+
+// InsertImagePull appends an entry to the image pull audit trail.
+func InsertImagePull(p *ImagePull) error {
+	query := `INSERT INTO image_pulls (image, source, actor, previous_id, new_id, error) VALUES (?, ?, ?, ?, ?, ?)`
+	res, err := db.Db().Exec(query, p.Image, p.Source, p.Actor, p.PreviousID, p.NewID, p.Error)
+	if err != nil {
+		return err
+	}
+	p.ID, err = res.LastInsertId()
+	return err
+}
+
+// RecentImagePulls returns the newest audit trail entries first.
+func RecentImagePulls(limit int) ([]ImagePull, error) {
+	var pulls []ImagePull
+	query := `SELECT * FROM image_pulls ORDER BY created DESC, id DESC LIMIT ?`
+	if err := db.Db().Select(&pulls, query, limit); err != nil {
+		return nil, err
+	}
+	return pulls, nil
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordImageRun(t *testing.T) {
+	img := "docker.io/library/imagestate-test:latest"
+
+	prev, err := RecordImageRun(img, "sha256:aaa")
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if prev != "" {
+		t.Fatalf("first run should not report an update, got %s", prev)
+	}
+
+	prev, err = RecordImageRun(img, "sha256:aaa")
+	if err != nil || prev != "" {
+		t.Fatalf("same image: prev=%q err=%v", prev, err)
+	}
+
+	prev, err = RecordImageRun(img, "sha256:bbb")
+	if err != nil {
+		t.Fatalf("updated run: %v", err)
+	}
+	if prev != "sha256:aaa" {
+		t.Fatalf("expected previous sha256:aaa, got %q", prev)
+	}
+
+	if err := RecordImageDrift(img, "sha256:bbb", "sha256:ccc", true); err != nil {
+		t.Fatalf("record drift: %v", err)
+	}
+	states, err := ImageStates()
+	if err != nil {
+		t.Fatalf("states: %v", err)
+	}
+	st := states[img]
+	if !st.Drifted || !st.Checked.Valid || st.LastRunID != "sha256:bbb" {
+		t.Fatalf("unexpected state: %+v", st)
+	}
+}
+
+func TestImagePullAudit(t *testing.T) {
+	p := &ImagePull{Image: "alpine", Source: ImagePullSourceManual, Actor: "admin", PreviousID: "a", NewID: "b"}
+	if err := InsertImagePull(p); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if p.ID == 0 {
+		t.Fatalf("expected id")
+	}
+	pulls, err := RecentImagePulls(10)
+	if err != nil {
+		t.Fatalf("recent: %v", err)
+	}
+	if len(pulls) == 0 || pulls[0].ID != p.ID || !pulls[0].Changed() {
+		t.Fatalf("unexpected pulls: %+v", pulls)
+	}
+}
+
+func TestTouchImages(t *testing.T) {
+	if _, err := RecordImageRun("docker.io/library/usage-test:latest", "sha256:run"); err != nil {
+		t.Fatalf("record run: %v", err)
+	}
+	if err := TouchImages("sha256:pulled", ""); err != nil {
+		t.Fatalf("touch: %v", err)
+	}
+	used, err := ImageLastUsed()
+	if err != nil {
+		t.Fatalf("last used: %v", err)
+	}
+	for _, id := range []string{"sha256:run", "sha256:pulled"} {
+		if time.Since(used[id]) > time.Hour {
+			t.Errorf("%s last used %v", id, used[id])
+		}
+	}
+	if _, ok := used[""]; ok {
+		t.Error("an empty image id was recorded")
+	}
+}
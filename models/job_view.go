@@ -5,13 +5,55 @@ import (
 )
 
 type JobInfo struct {
-	ID          string    // Unique identifier (e.g., quartz JobKey string)
-	RecipeName  string    // Name or ID of the associated recipe
-	Username    string    // User associated with the job
-	Type        string    // Type of job (NOW, IN, EVERY)
-	Status      string    // Current status (Scheduled, Running, Completed, Failed, Unknown)
-	CreatedAt   time.Time // When the job definition was created or first scheduled
-	ScheduledAt time.Time // Next scheduled run time (for IN/EVERY)
+	ID            string              `json:"id"`              // Unique identifier (e.g., quartz JobKey string)
+	Queue         string              `json:"queue"`           // Scheduler queue holding the job (now, in, every)
+	RecipeName    string              `json:"recipe_name"`     // Name or ID of the associated recipe
+	Username      string              `json:"username"`        // User associated with the job
+	AccountID     int64               `json:"account_id"`      // Account the job belongs to
+	Type          string              `json:"type"`            // Type of job (NOW, IN, EVERY)
+	Status        string              `json:"status"`          // Current status (Scheduled, Paused, Running, Completed, Failed, Unknown)
+	Paused        bool                `json:"paused"`          // Whether the trigger is paused
+	Interval      string              `json:"interval"`        // Trigger delay or interval (for IN/EVERY)
+	CreatedAt     time.Time           `json:"created_at"`      // When the job definition was created or first scheduled
+	ScheduledAt   time.Time           `json:"scheduled_at"`    // Next scheduled run time (for IN/EVERY)
+	NextFireTimes []time.Time         `json:"next_fire_times"` // Upcoming run times, empty while paused
+	LastResult    *ScheduledJobResult `json:"last_result"`     // Outcome of the most recent run, nil if it never ran
+}
+
+// JobRecoveryEntry describes what startup recovery did with a job whose run
+// times passed while the server was down.
+type JobRecoveryEntry struct {
+	Queue       string
+	JobKey      string
+	RecipeName  string
+	Policy      string    // misfire policy that was applied
+	Missed      int       // run times that passed while the server was down
+	Fired       int       // catch-up runs started, 0 when the missed runs were skipped
+	NextRunTime time.Time // zero when a run once job was dropped
+}
+
+// JobRecoveryReport lists the misfired jobs found when the scheduler queues
+// were recovered at startup.
+type JobRecoveryReport struct {
+	At        time.Time
+	Recovered int // jobs put back in their queues
+	Entries   []JobRecoveryEntry
+}
+
+// DrainState tells whether the job engine refuses new runs for maintenance.
+type DrainState struct {
+	Draining bool
+	Message  string // shown to users whose runs are refused
+	Since    time.Time
+}
+
+// WorkerInfo describes a connected lemc-worker.
+type WorkerInfo struct {
+	Name      string
+	Address   string
+	Labels    []string
+	Connected time.Time
+	Running   int // steps assigned and not done yet
 }
 
 type JobsView struct {
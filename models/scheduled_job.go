@@ -0,0 +1,353 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/db"
+)
+
+// ErrScheduledJobExists is returned when a job key is already queued.
+var ErrScheduledJobExists = errors.New("scheduled job already exists")
+
+// ScheduledJob is a serialized quartz job waiting in one of the scheduler
+// queues. Payload holds the JSON the yeschef package knows how to decode.
+type ScheduledJob struct {
+	Created     time.Time `db:"created"`
+	Updated     time.Time `db:"updated"`
+	ID          int64     `db:"id"`
+	Queue       string    `db:"queue"`
+	JobKey      string    `db:"job_key"`
+	JobGroup    string    `db:"job_group"`
+	NextRunTime int64     `db:"next_run_time"`
+	RecipeName  string    `db:"recipe_name"`
+	Username    string    `db:"username"`
+	AccountID   int64     `db:"account_id"`
+	Payload     string    `db:"payload"`
+
+	// AppID and CookbookID are used to resolve AccountID when it is unset.
+	AppID      string `db:"-"`
+	CookbookID string `db:"-"`
+}
+
+const scheduledJobColumns = `created, updated, id, queue, job_key, job_group, next_run_time, recipe_name, username, account_id, payload`
+
+// InsertScheduledJob queues a job. When replace is false and the key is
+// already queued ErrScheduledJobExists is returned.
+func InsertScheduledJob(j *ScheduledJob, replace bool) error {
+	query := `
+        INSERT INTO scheduled_jobs (queue, job_key, job_group, next_run_time, recipe_name, username, account_id, payload)
+        VALUES (?, ?, ?, ?, ?, ?,
+                COALESCE(NULLIF(?, 0),
+                        (SELECT account_id FROM apps WHERE id = ?),
+                        (SELECT account_id FROM cookbooks WHERE id = ?),
+                        0),
+                ?)`
+	if replace {
+		query += `
+        ON CONFLICT(queue, job_key) DO UPDATE SET
+        job_group = excluded.job_group,
+        next_run_time = excluded.next_run_time,
+        recipe_name = excluded.recipe_name,
+        username = excluded.username,
+        account_id = excluded.account_id,
+        payload = excluded.payload,
+        updated = CURRENT_TIMESTAMP;`
+	} else {
+		query += ` ON CONFLICT(queue, job_key) DO NOTHING;`
+	}
+
+	res, err := db.Db().Exec(query, j.Queue, j.JobKey, j.JobGroup, j.NextRunTime, j.RecipeName, j.Username,
+		j.AccountID, j.AppID, j.CookbookID, j.Payload)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrScheduledJobExists
+	}
+	return nil
+}
+
+// UpdateScheduledJob rewrites the trigger state and payload of a job that is
+// still queued. sql.ErrNoRows is returned when the job has left the queue.
+func UpdateScheduledJob(j *ScheduledJob) error {
+	query := `
+        UPDATE scheduled_jobs SET next_run_time = ?, payload = ?, updated = CURRENT_TIMESTAMP
+        WHERE queue = ? AND job_key = ?`
+	res, err := db.Db().Exec(query, j.NextRunTime, j.Payload, j.Queue, j.JobKey)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// HeadScheduledJob returns the job in queue with the earliest next run time.
+// sql.ErrNoRows is returned when the queue is empty.
+func HeadScheduledJob(queue string) (*ScheduledJob, error) {
+	var j ScheduledJob
+	query := `SELECT ` + scheduledJobColumns + ` FROM scheduled_jobs WHERE queue = ? ORDER BY next_run_time, id LIMIT 1`
+	if err := db.Db().Get(&j, query, queue); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// PopScheduledJob removes and returns the head of queue in a single
+// transaction. sql.ErrNoRows is returned when the queue is empty.
+func PopScheduledJob(queue string) (*ScheduledJob, error) {
+	tx, err := db.Db().Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var j ScheduledJob
+	query := `SELECT ` + scheduledJobColumns + ` FROM scheduled_jobs WHERE queue = ? ORDER BY next_run_time, id LIMIT 1`
+	if err := tx.Get(&j, query, queue); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM scheduled_jobs WHERE id = ?`, j.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// ScheduledJobByKey returns the queued job with the given key.
+func ScheduledJobByKey(queue, jobKey string) (*ScheduledJob, error) {
+	var j ScheduledJob
+	query := `SELECT ` + scheduledJobColumns + ` FROM scheduled_jobs WHERE queue = ? AND job_key = ?`
+	if err := db.Db().Get(&j, query, queue, jobKey); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// DeleteScheduledJob removes and returns the queued job with the given key.
+func DeleteScheduledJob(queue, jobKey string) (*ScheduledJob, error) {
+	tx, err := db.Db().Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var j ScheduledJob
+	query := `SELECT ` + scheduledJobColumns + ` FROM scheduled_jobs WHERE queue = ? AND job_key = ?`
+	if err := tx.Get(&j, query, queue, jobKey); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM scheduled_jobs WHERE id = ?`, j.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// DeleteScheduledJobByID removes a queued job by its row ID.
+func DeleteScheduledJobByID(id int64) error {
+	_, err := db.Db().Exec(`DELETE FROM scheduled_jobs WHERE id = ?`, id)
+	return err
+}
+
+// ScheduledJobKeysLike returns the keys of the jobs in queue whose key
+// matches the SQL LIKE pattern.
+func ScheduledJobKeysLike(queue, pattern string) ([]string, error) {
+	var keys []string
+	query := `SELECT job_key FROM scheduled_jobs WHERE queue = ? AND job_key LIKE ? ORDER BY id`
+	if err := db.Db().Select(&keys, query, queue, pattern); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ScheduledJobsByQueue returns every job in queue ordered by next run time.
+func ScheduledJobsByQueue(queue string) ([]ScheduledJob, error) {
+	var jobs []ScheduledJob
+	query := `SELECT ` + scheduledJobColumns + ` FROM scheduled_jobs WHERE queue = ? ORDER BY next_run_time, id`
+	if err := db.Db().Select(&jobs, query, queue); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// CountScheduledJobs returns the number of jobs in queue.
+func CountScheduledJobs(queue string) (int, error) {
+	var n int
+	err := db.Db().Get(&n, `SELECT COUNT(*) FROM scheduled_jobs WHERE queue = ?`, queue)
+	return n, err
+}
+
+// ClearScheduledJobs removes every job in queue.
+func ClearScheduledJobs(queue string) error {
+	_, err := db.Db().Exec(`DELETE FROM scheduled_jobs WHERE queue = ?`, queue)
+	return err
+}
+
+// Misfire policies decide what happens to the run times of a scheduled job
+// that passed while the server was down.
+const (
+	MisfireFireOnce = "fire_once" // run once to catch up, then follow the trigger
+	MisfireFireAll  = "fire_all"  // replay every missed run
+	MisfireSkip     = "skip"      // drop missed runs and wait for the next one
+)
+
+// ValidMisfirePolicy reports whether p is a known misfire policy. An empty
+// policy is valid and means MisfireFireOnce.
+func ValidMisfirePolicy(p string) bool {
+	switch p {
+	case "", MisfireFireOnce, MisfireFireAll, MisfireSkip:
+		return true
+	}
+	return false
+}
+
+// ScheduledJobPaused is the next run time of a paused job, which sorts it
+// behind every active job in its queue.
+const ScheduledJobPaused = int64(math.MaxInt64)
+
+// ScheduledJobResult is the outcome of the most recent run of a queued job.
+type ScheduledJobResult struct {
+	Created    time.Time `db:"created" json:"-"`
+	Updated    time.Time `db:"updated" json:"-"`
+	Queue      string    `db:"queue" json:"queue"`
+	JobKey     string    `db:"job_key" json:"job_key"`
+	StartedAt  time.Time `db:"started_at" json:"started_at"`
+	FinishedAt time.Time `db:"finished_at" json:"finished_at"`
+	Error      string    `db:"error" json:"error"`
+}
+
+// Succeeded reports whether the run finished without an error.
+func (r *ScheduledJobResult) Succeeded() bool {
+	return r.Error == ""
+}
+
+// UpsertScheduledJobResult records r as the last run of its job.
+func UpsertScheduledJobResult(r *ScheduledJobResult) error {
+	query := `
+        INSERT INTO scheduled_job_results (queue, job_key, started_at, finished_at, error)
+        VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT(queue, job_key) DO UPDATE SET
+        started_at = excluded.started_at,
+        finished_at = excluded.finished_at,
+        error = excluded.error,
+        updated = CURRENT_TIMESTAMP;`
+	_, err := db.Db().Exec(query, r.Queue, r.JobKey, r.StartedAt.UTC(), r.FinishedAt.UTC(), r.Error)
+	return err
+}
+
+// ScheduledJobResultByKey returns the last run of the job with the given key.
+func ScheduledJobResultByKey(queue, jobKey string) (*ScheduledJobResult, error) {
+	var r ScheduledJobResult
+	query := `SELECT created, updated, queue, job_key, started_at, finished_at, error
+        FROM scheduled_job_results WHERE queue = ? AND job_key = ?`
+	if err := db.Db().Get(&r, query, queue, jobKey); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// DeleteScheduledJobResult forgets the last run of the job with the given key.
+func DeleteScheduledJobResult(queue, jobKey string) error {
+	_, err := db.Db().Exec(`DELETE FROM scheduled_job_results WHERE queue = ? AND job_key = ?`, queue, jobKey)
+	return err
+}
+
+// scheduledJobInfoRow is a queued job joined with its last result.
+type scheduledJobInfoRow struct {
+	ScheduledJob
+	StartedAt  sql.NullTime   `db:"started_at"`
+	FinishedAt sql.NullTime   `db:"finished_at"`
+	Error      sql.NullString `db:"error"`
+}
+
+const scheduledJobInfoSelect = `SELECT j.created, j.updated, j.id, j.queue, j.job_key, j.job_group, j.next_run_time,
+                j.recipe_name, j.username, j.account_id, '' AS payload,
+                r.started_at, r.finished_at, r.error
+        FROM scheduled_jobs j
+        LEFT JOIN scheduled_job_results r ON r.queue = j.queue AND r.job_key = j.job_key `
+
+func (r scheduledJobInfoRow) jobInfo() JobInfo {
+	info := JobInfo{
+		ID:          r.JobKey,
+		Queue:       r.Queue,
+		RecipeName:  r.RecipeName,
+		Username:    r.Username,
+		AccountID:   r.AccountID,
+		Type:        strings.ToUpper(r.Queue),
+		Status:      "Scheduled",
+		CreatedAt:   r.Created,
+		ScheduledAt: time.Unix(0, r.NextRunTime),
+	}
+	if r.NextRunTime == ScheduledJobPaused {
+		info.Status = "Paused"
+		info.Paused = true
+		info.ScheduledAt = time.Time{}
+	}
+	if r.StartedAt.Valid {
+		info.LastResult = &ScheduledJobResult{
+			Queue:      r.Queue,
+			JobKey:     r.JobKey,
+			StartedAt:  r.StartedAt.Time,
+			FinishedAt: r.FinishedAt.Time,
+			Error:      r.Error.String,
+		}
+	}
+	return info
+}
+
+// ScheduledJobInfos returns a page of queued jobs ordered by next run time and
+// the total number of queued jobs. An accountID of 0 lists every account.
+func ScheduledJobInfos(accountID int64, page, limit int) ([]JobInfo, int, error) {
+	where := ``
+	args := []interface{}{}
+	if accountID != 0 {
+		where = `WHERE j.account_id = ?`
+		args = append(args, accountID)
+	}
+
+	var total int
+	if err := db.Db().Get(&total, `SELECT COUNT(*) FROM scheduled_jobs j `+where, args...); err != nil {
+		return nil, 0, err
+	}
+
+	var rows []scheduledJobInfoRow
+	query := scheduledJobInfoSelect + where + ` ORDER BY j.next_run_time, j.id LIMIT ? OFFSET ?`
+	args = append(args, limit, (page-1)*limit)
+	if err := db.Db().Select(&rows, query, args...); err != nil {
+		return nil, 0, err
+	}
+
+	jobs := make([]JobInfo, 0, len(rows))
+	for _, r := range rows {
+		jobs = append(jobs, r.jobInfo())
+	}
+	return jobs, total, nil
+}
+
+// ScheduledJobInfoByKey returns the queued job with the given key.
+func ScheduledJobInfoByKey(queue, jobKey string) (*JobInfo, error) {
+	var r scheduledJobInfoRow
+	if err := db.Db().Get(&r, scheduledJobInfoSelect+`WHERE j.queue = ? AND j.job_key = ?`, queue, jobKey); err != nil {
+		return nil, err
+	}
+	info := r.jobInfo()
+	return &info, nil
+}
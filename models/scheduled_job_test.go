@@ -0,0 +1,47 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestScheduledJobQueue(t *testing.T) {
+	const queue = "scheduled-job-test"
+	defer ClearScheduledJobs(queue)
+
+	// The account is resolved from the app when the job does not carry one.
+	first := &ScheduledJob{Queue: queue, JobKey: "first", NextRunTime: 20, Payload: "{}", AppID: "2"}
+	if err := InsertScheduledJob(first, false); err != nil {
+		t.Fatalf("insert first: %v", err)
+	}
+	if err := InsertScheduledJob(&ScheduledJob{Queue: queue, JobKey: "second", NextRunTime: 10, Payload: "{}"}, false); err != nil {
+		t.Fatalf("insert second: %v", err)
+	}
+	if err := InsertScheduledJob(&ScheduledJob{Queue: queue, JobKey: "first", NextRunTime: 5, Payload: "{}"}, false); !errors.Is(err, ErrScheduledJobExists) {
+		t.Fatalf("expected ErrScheduledJobExists, got %v", err)
+	}
+
+	got, err := ScheduledJobByKey(queue, "first")
+	if err != nil {
+		t.Fatalf("by key: %v", err)
+	}
+	if got.AccountID != 1 {
+		t.Fatalf("expected account 1 resolved from app, got %d", got.AccountID)
+	}
+
+	head, err := PopScheduledJob(queue)
+	if err != nil || head.JobKey != "second" {
+		t.Fatalf("expected second at head, got %+v err=%v", head, err)
+	}
+	if n, _ := CountScheduledJobs(queue); n != 1 {
+		t.Fatalf("expected 1 job left, got %d", n)
+	}
+
+	if _, err := DeleteScheduledJob(queue, "first"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := PopScheduledJob(queue); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected empty queue, got %v", err)
+	}
+}
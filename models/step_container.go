@@ -0,0 +1,71 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/db"
+)
+
+// StepContainer is the container of a step that is running. The row outlives
+// a restart of the server so the container can be followed again. Payload is
+// the job the step runs as, for a recipe the now job of the steps left from
+// this one on. LoggedAt is when the container's output was last read, in
+// nanoseconds. Endpoint is the Docker endpoint the container runs on, empty
+// for the default one.
+type StepContainer struct {
+	Created     time.Time `db:"created" json:"created"`
+	ContainerID string    `db:"container_id" json:"container_id"`
+	Queue       string    `db:"queue" json:"queue"`
+	JobKey      string    `db:"job_key" json:"job_key"`
+	RecipeName  string    `db:"recipe_name" json:"recipe_name"`
+	StepID      string    `db:"step_id" json:"step_id"`
+	Username    string    `db:"username" json:"username"`
+	AccountID   int64     `db:"account_id" json:"account_id"`
+	LoggedAt    int64     `db:"logged_at" json:"logged_at"`
+	Payload     string    `db:"payload" json:"payload"`
+	Endpoint    string    `db:"endpoint" json:"endpoint"`
+}
+
+const stepContainerColumns = `created, container_id, queue, job_key, recipe_name, step_id, username, account_id, logged_at, payload, endpoint`
+
+// InsertStepContainer records a step container once it started.
+func InsertStepContainer(c *StepContainer) error {
+	query := `
+        INSERT OR REPLACE INTO step_containers (container_id, queue, job_key, recipe_name, step_id, username, account_id, logged_at, payload, endpoint)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.Db().Exec(query, c.ContainerID, c.Queue, c.JobKey, c.RecipeName, c.StepID, c.Username, c.AccountID, c.LoggedAt, c.Payload, c.Endpoint)
+	return err
+}
+
+// StepContainers returns every recorded step container, oldest first.
+func StepContainers() ([]StepContainer, error) {
+	containers := []StepContainer{}
+	query := `SELECT ` + stepContainerColumns + ` FROM step_containers ORDER BY created, container_id`
+	if err := db.Db().Select(&containers, query); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// TouchStepContainer records that the container's output was read up to
+// loggedAt.
+func TouchStepContainer(containerID string, loggedAt int64) error {
+	_, err := db.Db().Exec(`UPDATE step_containers SET logged_at = ? WHERE container_id = ?`, loggedAt, containerID)
+	return err
+}
+
+// DeleteStepContainer forgets a step container once it is done. sql.ErrNoRows
+// is returned when it was not recorded.
+func DeleteStepContainer(containerID string) error {
+	res, err := db.Db().Exec(`DELETE FROM step_containers WHERE container_id = ?`, containerID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestStepContainers(t *testing.T) {
+	c := &StepContainer{ContainerID: "abc123", Queue: "now", JobKey: "key", StepID: "2", LoggedAt: 1, Payload: "{}"}
+	if err := InsertStepContainer(c); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	defer DeleteStepContainer(c.ContainerID)
+
+	if err := TouchStepContainer(c.ContainerID, 42); err != nil {
+		t.Fatalf("touch: %v", err)
+	}
+	rows, err := StepContainers()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	var found *StepContainer
+	for i := range rows {
+		if rows[i].ContainerID == c.ContainerID {
+			found = &rows[i]
+		}
+	}
+	if found == nil || found.LoggedAt != 42 || found.StepID != "2" || found.Created.IsZero() {
+		t.Fatalf("unexpected step container %+v", found)
+	}
+
+	if err := DeleteStepContainer(c.ContainerID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := DeleteStepContainer(c.ContainerID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows deleting twice, got %v", err)
+	}
+}
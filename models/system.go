@@ -2,7 +2,6 @@ package models
 
 import (
 	"context"
-	"os"
 	"sort"
 	"strings"
 	"time"
@@ -16,8 +15,29 @@ import (
 	"github.com/jaredfolkins/letemcook/util"
 )
 
-// CollectImages gathers unique container images used in all cookbooks and apps.
-func CollectImages() ([]string, error) {
+// ImageRef describes an image referenced by one or more recipe steps.
+type ImageRef struct {
+	Name         string
+	RegistryAuth string
+	PullPolicy   string
+}
+
+// pullPolicyRank orders pull policies so the most eager one wins when
+// several steps reference the same image.
+func pullPolicyRank(policy string) int {
+	switch policy {
+	case PullPolicyAlways:
+		return 2
+	case PullPolicyScheduled:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// CollectImageRefs gathers unique container images used in all cookbooks and
+// apps together with the registry auth and pull policy declared by their steps.
+func CollectImageRefs() ([]ImageRef, error) {
 	cbs, err := AllCookbooks()
 	if err != nil {
 		return nil, err
@@ -27,7 +47,7 @@ func CollectImages() ([]string, error) {
 		return nil, err
 	}
 
-	images := map[string]struct{}{}
+	images := map[string]*ImageRef{}
 
 	extract := func(yml string) {
 		if yml == "" {
@@ -38,8 +58,19 @@ func CollectImages() ([]string, error) {
 			for _, p := range yd.Cookbook.Pages {
 				for _, r := range p.Recipes {
 					for _, s := range r.Steps {
-						if s.Image != "" {
-							images[s.Image] = struct{}{}
+						if s.Image == "" {
+							continue
+						}
+						ref, ok := images[s.Image]
+						if !ok {
+							ref = &ImageRef{Name: s.Image}
+							images[s.Image] = ref
+						}
+						if ref.RegistryAuth == "" {
+							ref.RegistryAuth = s.RegistryAuth
+						}
+						if pullPolicyRank(s.PullPolicy) > pullPolicyRank(ref.PullPolicy) {
+							ref.PullPolicy = s.PullPolicy
 						}
 					}
 				}
@@ -56,39 +87,61 @@ func CollectImages() ([]string, error) {
 		extract(ap.YAMLIndividual)
 	}
 
-	list := make([]string, 0, len(images))
-	for img := range images {
-		list = append(list, img)
+	list := make([]ImageRef, 0, len(images))
+	for _, ref := range images {
+		list = append(list, *ref)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// CollectImages gathers unique container images used in all cookbooks and apps.
+func CollectImages() ([]string, error) {
+	refs, err := CollectImageRefs()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		list = append(list, ref.Name)
 	}
-	sort.Strings(list)
 	return list, nil
 }
 
 // CollectImageInfos gathers metadata about each unique image used by cookbooks and apps.
 func CollectImageInfos() ([]ImageInfo, error) {
-	names, err := CollectImages()
+	refs, err := CollectImageRefs()
 	if err != nil {
 		return nil, err
 	}
 
-	cli, err := client.NewClientWithOpts(
-		client.WithHost(os.Getenv("LEMC_DOCKER_HOST")),
-		client.WithAPIVersionNegotiation(),
-	)
+	states, err := ImageStates()
 	if err != nil {
 		return nil, err
 	}
 
-	infos := make([]ImageInfo, len(names))
+	cli, err := DockerClient("")
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	infos := make([]ImageInfo, len(refs))
 
 	var g errgroup.Group
 	g.SetLimit(4)
 
-	for i, name := range names {
+	for i, ref := range refs {
 		i := i
-		name := name
+		name := ref.Name
+		info := ImageInfo{Name: name, PullPolicy: ref.PullPolicy}
+		if st, ok := states[name]; ok {
+			info.Drifted = st.Drifted
+			if st.Checked.Valid {
+				info.LastChecked = st.Checked.Time
+			}
+		}
 		g.Go(func() error {
-			info := ImageInfo{Name: name}
 			normalized, _, _, err := util.NormalizeImageName(name)
 			if err == nil {
 				inspect, _, ierr := cli.ImageInspectWithRaw(context.Background(), normalized)
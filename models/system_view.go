@@ -19,11 +19,38 @@ type ImageInfo struct {
 	LastUpdated    time.Time
 	Exists         bool
 	NewerAvailable bool
+	Drifted        bool
+	LastChecked    time.Time
+	PullPolicy     string
+}
+
+// UnusedImage is a local image referenced by no cookbook, app or scheduled job.
+type UnusedImage struct {
+	ID       string
+	Endpoint string // the Docker endpoint the image is on
+	Tags     []string
+	Size     int64
+	Created  time.Time
+	LastUsed time.Time
+}
+
+// ImageGCReport describes the outcome of an image prune or dry run.
+type ImageGCReport struct {
+	DryRun      bool
+	Retention   time.Duration
+	Candidates  []UnusedImage
+	Reclaimable int64
+	Removed     []string
+	Errors      []string
 }
 
 type SystemImagesView struct {
 	BaseView
-	Images []ImageInfo
+	Images      []ImageInfo
+	Pulls       []ImagePull
+	Unused      []UnusedImage
+	Reclaimable int64
+	GCReport    *ImageGCReport
 }
 
 type SystemJobsView struct {
@@ -32,9 +59,15 @@ type SystemJobsView struct {
 	CurrentPage int
 	TotalPages  int
 	Limit       int
+	Recovery    JobRecoveryReport
+	DeadJobs    []DeadLetterJob
+	DeadTotal   int
+	Drain       DrainState
+	Workers     []WorkerInfo
 }
 
 type SystemSettingsView struct {
 	BaseView
-	Settings map[string]string
+	Settings  map[string]string
+	Endpoints []DockerEndpointStatus
 }
@@ -27,14 +27,16 @@ type Configuration struct {
 }
 
 type BookNoStorage struct {
-	Environment Environment `yaml:"environment"`
-	Pages       []Page      `yaml:"pages"`
+	Environment    Environment `yaml:"environment"`
+	Pages          []Page      `yaml:"pages"`
+	DockerEndpoint string      `yaml:"docker_endpoint,omitempty"`
 }
 
 type Book struct {
-	Environment Environment `yaml:"environment"`
-	Pages       []Page      `yaml:"pages"`
-	Storage     Storage     `yaml:"storage"`
+	Environment    Environment `yaml:"environment"`
+	Pages          []Page      `yaml:"pages"`
+	Storage        Storage     `yaml:"storage"`
+	DockerEndpoint string      `yaml:"docker_endpoint,omitempty"` // Docker endpoint steps run on, the account's when empty
 }
 
 type Thumbnail struct {
@@ -126,11 +128,23 @@ type Page struct {
 }
 
 type Recipe struct {
-	IsShared    bool        `yaml:"-"` // used for telling the job how to run, as a user or admin
-	Name        string      `yaml:"recipe"`
-	Description string      `yaml:"description"`
-	Form        []FormField `yaml:"form,omitempty"`
-	Steps       []Step      `yaml:"steps"`
+	IsShared    bool            `yaml:"-"` // used for telling the job how to run, as a user or admin
+	Name        string          `yaml:"recipe"`
+	Description string          `yaml:"description"`
+	Form        []FormField     `yaml:"form,omitempty"`
+	Schedule    *RecipeSchedule `yaml:"schedule,omitempty"`
+	Steps       []Step          `yaml:"steps"`
+}
+
+// RecipeSchedule runs the recipe on a trigger without anyone pressing Run.
+// Apps register it with the scheduler when they are created or refreshed.
+// Exactly one of Cron and Every is set.
+type RecipeSchedule struct {
+	Cron    string `yaml:"cron,omitempty"`    // quartz cron expression, seconds first: "0 0 3 * * *"
+	Every   string `yaml:"every,omitempty"`   // interval in the do syntax without the queue: "10.minutes"
+	Scope   string `yaml:"scope,omitempty"`   // individual or shared, defaults to the YAML the recipe is in
+	RunAs   string `yaml:"run_as,omitempty"`  // username the recipe runs as, defaults to the app owner
+	Misfire string `yaml:"misfire,omitempty"` // fire_once (default), fire_all or skip
 }
 
 type FormField struct {
@@ -205,11 +219,18 @@ type Step struct {
 	Name         string   `yaml:"name"`
 	Image        string   `yaml:"image"`
 	RegistryAuth string   `yaml:"registry_auth,omitempty"`
+	PullPolicy   string   `yaml:"pull_policy,omitempty"` // always, if-not-present (default) or scheduled
 	Entrypoint   []string `yaml:"entrypoint,omitempty"`
 	Env          []string `yaml:"env,omitempty"`         // Deprecated: use Environment instead
 	Environment  []string `yaml:"environment,omitempty"` // New field for environment variables
 	Do           string   `yaml:"do"`
+	Misfire      string   `yaml:"misfire,omitempty"`  // in and every steps: fire_once (default), fire_all or skip
+	Until        string   `yaml:"until,omitempty"`    // every steps: no runs after this time, e.g. 2025-07-01 18:00
+	MaxRuns      int      `yaml:"max_runs,omitempty"` // every steps: stop after this many runs
+	Blackout     []string `yaml:"blackout,omitempty"` // every steps: windows without runs, e.g. "sat-sun" or "02:00-04:00 Europe/Berlin"
+	Jitter       string   `yaml:"jitter,omitempty"`   // every steps: random delay added to each run, e.g. 30.seconds
 	Timeout      string   `yaml:"timeout"`
+	RunsOn       []string `yaml:"runs_on,omitempty"` // labels of the lemc-worker to run on, e.g. [dmz]; the server's Docker host when empty
 }
 
 // GetEnvironment returns environment variables, preferring Environment over Env for backward compatibility
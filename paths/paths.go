@@ -28,6 +28,12 @@ const (
 	SystemJobs            = "/lemc/system/jobs"
 	SystemSettingsPartial = "/lemc/system/settings?partial=true"
 	SystemImagesPull      = "/lemc/system/images/pull"
+	SystemImagesPrune     = "/lemc/system/images/prune"
+	SystemEndpoints       = "/lemc/system/settings/endpoints"
+	SystemEndpointsDelete = "/lemc/system/settings/endpoints/delete"
+
+	// API paths
+	ApiJobs = "/lemc/api/jobs"
 
 	// App paths
 	AppCreate         = "/lemc/app/create"
@@ -58,6 +64,7 @@ const (
 	AccountUsersPagePartialPattern          = "/lemc/account/users?page=%d&limit=%d&partial=true"
 	AccountJobsPagePattern                  = "/lemc/account/jobs?page=%d&limit=%d"
 	AccountJobsPagePartialPattern           = "/lemc/account/jobs?page=%d&limit=%d&partial=true"
+	AccountJobActionPattern                 = "/lemc/account/jobs/%s?page=%d&limit=%d&partial=true"
 	AccountUserPermissionCanCreateApps      = "/lemc/account/user/%d/account/%d/permission/can_create_apps"
 	AccountUserPermissionCanViewApps        = "/lemc/account/user/%d/account/%d/permission/can_view_apps"
 	AccountUserPermissionCanCreateCookbooks = "/lemc/account/user/%d/account/%d/permission/can_create_cookbooks"
@@ -70,6 +77,8 @@ const (
 	SystemAccountsPagePartialPattern = "/lemc/system/accounts?page=%d&limit=%d&partial=true"
 	SystemJobsPagePattern            = "/lemc/system/jobs?page=%d&limit=%d"
 	SystemJobsPagePartialPattern     = "/lemc/system/jobs?page=%d&limit=%d&partial=true"
+	SystemJobActionPattern           = "/lemc/system/jobs/%s?page=%d&limit=%d&partial=true"
+	SystemDeadJobActionPattern       = "/lemc/system/jobs/dead/%s?page=%d&limit=%d&partial=true"
 
 	// App template patterns
 	AppThumbnailDownloadPattern       = "/lemc/app/thumbnail/download/%s"
@@ -93,6 +102,7 @@ const (
 	AppAclUserDeletePattern           = "/lemc/app/acl/user/delete/%s/%d"
 	AppJobStatusPattern               = "/lemc/app/job/status/uuid/%s/page/%d/scope/%s"
 	AppJobPattern                     = "/lemc/app/job/%s/uuid/%s/page/%d/recipe/%s"
+	AppJobPlanPattern                 = "/lemc/app/job/plan/%s/uuid/%s/page/%d/recipe/%s"
 
 	// Cookbook template patterns
 	CookbookThumbnailDownloadPattern = "/lemc/cookbook/thumbnail/download/%s?ts=%s"
@@ -122,9 +132,10 @@ const (
 	GenericJobPattern = "/lemc/%s/job/%s/uuid/%s/page/%d/recipe/%s"
 
 	// Theme and static asset patterns
-	ThemeCssPattern  = "/themes/%s/public/css/compiled.css?v=%s"
-	ThemeIconPattern = "/themes/%s/public/imgs/%sx%s.ico?v=%s"
-	WebSocketPattern = "/ws"
+	ThemeCssPattern    = "/themes/%s/public/css/compiled.css?v=%s"
+	ThemeIconPattern   = "/themes/%s/public/imgs/%sx%s.ico?v=%s"
+	WebSocketPattern   = "/ws"
+	EventStreamPattern = "/sse"
 
 	// Existing patterns
 	LockerDownloadPattern = "/lemc/locker/uuid/%s/page/%d/scope/%s/filename/"
@@ -31,6 +31,9 @@ const (
 	LabelTheme          = "Theme"
 	LabelAllowUserReg   = "Allow User Registration"
 	LabelEnableHeckle   = "Enable Heckle Mode"
+	LabelImageAllowlist = "Image Allowlist (one repository glob per line, empty allows all)"
+	LabelRequireDigest  = "Require Image Digest Pinning"
+	LabelDockerEndpoint = "Docker Endpoint (cookbooks can pick their own with docker_endpoint)"
 	LabelOnRegister     = "On Register"
 	LabelPublished      = "Published"
 	LabelDeleted        = "Deleted"
@@ -38,7 +38,29 @@ type ContainerFiles struct {
 	BindGlobalDir         string
 }
 
+// NewContainerFiles returns the locker directories of a step and creates the
+// ones LEMC uses that are missing.
 func NewContainerFiles(jm *JobMeta, is_admin bool) (*ContainerFiles, error) {
+	cf, err := ContainerFilePaths(jm, is_admin)
+	if err != nil {
+		return cf, err
+	}
+
+	for _, dir := range []string{cf.InternalPerUserPublicDir, cf.InternalPerUserPrivateDir, cf.InternalPerUserCacheDir, cf.InternalSharedDir, cf.InternalGlobalDir} {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			err := os.MkdirAll(dir, DirPerm)
+			if err != nil {
+				return cf, err
+			}
+		}
+	}
+
+	return cf, nil
+}
+
+// ContainerFilePaths returns the locker directories of a step without
+// creating them.
+func ContainerFilePaths(jm *JobMeta, is_admin bool) (*ContainerFiles, error) {
 	cf := &ContainerFiles{}
 
 	basedir := SCOPE_INDIVIDUAL_DIR
@@ -69,44 +91,10 @@ func NewContainerFiles(jm *JobMeta, is_admin bool) (*ContainerFiles, error) {
 	}
 
 	cf.InternalPerUserPublicDir = filepath.Join(fm.LemcLocker, jm.UUID, basedir, fm.IndividualUsernameOrSharedUsername, fm.PageString, PUBLIC)
-	if _, err := os.Stat(cf.InternalPerUserPublicDir); os.IsNotExist(err) {
-		err := os.MkdirAll(cf.InternalPerUserPublicDir, DirPerm)
-		if err != nil {
-			return cf, err
-		}
-	}
-
 	cf.InternalPerUserPrivateDir = filepath.Join(fm.LemcLocker, jm.UUID, basedir, fm.IndividualUsernameOrSharedUsername, fm.PageString, PRIVATE)
-	if _, err := os.Stat(cf.InternalPerUserPrivateDir); os.IsNotExist(err) {
-		err := os.MkdirAll(cf.InternalPerUserPrivateDir, DirPerm)
-		if err != nil {
-			return cf, err
-		}
-	}
-
 	cf.InternalPerUserCacheDir = filepath.Join(fm.LemcLocker, jm.UUID, basedir, fm.IndividualUsernameOrSharedUsername, fm.PageString, CACHE)
-	if _, err := os.Stat(cf.InternalPerUserCacheDir); os.IsNotExist(err) {
-		err := os.MkdirAll(cf.InternalPerUserCacheDir, DirPerm)
-		if err != nil {
-			return cf, err
-		}
-	}
-
 	cf.InternalSharedDir = filepath.Join(fm.LemcLocker, jm.UUID, SCOPE_SHARED_DIR)
-	if _, err := os.Stat(cf.InternalSharedDir); os.IsNotExist(err) {
-		err := os.MkdirAll(cf.InternalSharedDir, DirPerm)
-		if err != nil {
-			return cf, err
-		}
-	}
-
 	cf.InternalGlobalDir = filepath.Join(fm.LemcLocker, jm.UUID, GLOBAL_DIR)
-	if _, err := os.Stat(cf.InternalGlobalDir); os.IsNotExist(err) {
-		err := os.MkdirAll(cf.InternalGlobalDir, DirPerm)
-		if err != nil {
-			return cf, err
-		}
-	}
 
 	return cf, nil
 }
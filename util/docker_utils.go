@@ -37,3 +37,13 @@ func NormalizeImageName(imageRef string) (normalizedName string, imageName strin
 
 	return normalizedName, imageNameOnly, tag, nil
 }
+
+// ShortImageID returns the first 12 hex digits of an image or container ID,
+// the way docker prints them.
+func ShortImageID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
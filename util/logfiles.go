@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 type LogFile struct {
 	EventID string
+	RunID   string // tags the lines of a run, see RunLogLines
 	File    *os.File
 	Writer  *bufio.Writer
 	Dir     string
@@ -58,7 +60,40 @@ func NewFileMeta(jm *JobMeta, is_admin bool) (*FileMeta, error) {
 
 func (lf *LogFile) StepWriteToLog(stepid, msg, imageHash, imageName string) {
 	timestamp := time.Now().Format("Mon Jan 2 15:04:05 MST 2006")
-	lf.Writer.WriteString(fmt.Sprintf("[%s] [image:%s] [name:%s] [event:%s] [step:%s] %s\n", timestamp, imageHash, imageName, lf.EventID, stepid, msg))
+	lf.Writer.WriteString(fmt.Sprintf("[%s] [image:%s] [name:%s] [event:%s] [run:%s] [step:%s] %s\n", timestamp, imageHash, imageName, lf.EventID, lf.RunID, stepid, msg))
+}
+
+// PublicDir is the public locker of the page, where its steps leave the
+// files anyone of the scope may download.
+func (fm *FileMeta) PublicDir() string {
+	return filepath.Join(fm.LemcLocker, fm.UUID, fm.Scope, fm.IndividualUsernameOrSharedUsername, fm.PageString, PUBLIC)
+}
+
+// LogFilePath is the log file of the job's recipe, the runs of a recipe all
+// append to it.
+func (fm *FileMeta) LogFilePath(jm *JobMeta) string {
+	fileName := fmt.Sprintf("%s-%s.log", AlphaNumHyphen(fm.PageString), AlphaNumHyphen(jm.RecipeName))
+	return filepath.Join(fm.LemcLocker, jm.UUID, fm.Scope, fm.IndividualUsernameOrSharedUsername, LOGS, fileName)
+}
+
+// RunLogLines returns the lines of a log file written for the run.
+func RunLogLines(path, runID string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tag := fmt.Sprintf("] [run:%s] ", runID)
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if strings.Contains(scanner.Text(), tag) {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	return lines, scanner.Err()
 }
 
 func (fm *FileMeta) OpenLogFile(jm *JobMeta) (*LogFile, error) {
@@ -67,7 +102,8 @@ func (fm *FileMeta) OpenLogFile(jm *JobMeta) (*LogFile, error) {
 		EventID: fmt.Sprintf("%d", time.Now().Unix()),
 	}
 
-	lf.Dir = filepath.Join(fm.LemcLocker, jm.UUID, fm.Scope, fm.IndividualUsernameOrSharedUsername, LOGS)
+	path := fm.LogFilePath(jm)
+	lf.Dir = filepath.Dir(path)
 	if _, err := os.Stat(lf.Dir); os.IsNotExist(err) {
 		err := os.MkdirAll(lf.Dir, DirPerm)
 		if err != nil {
@@ -75,8 +111,7 @@ func (fm *FileMeta) OpenLogFile(jm *JobMeta) (*LogFile, error) {
 		}
 	}
 
-	fileName := fmt.Sprintf("%s-%s.log", AlphaNumHyphen(fm.PageString), AlphaNumHyphen(jm.RecipeName))
-	file, err := os.OpenFile(filepath.Join(lf.Dir, fileName), os.O_RDWR|os.O_CREATE|os.O_APPEND, FilePerm)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, FilePerm)
 	if err != nil {
 		return lf, err
 	}
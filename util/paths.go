@@ -30,6 +30,8 @@ func LockerPath() string {
 	return filepath.Join(EnvPath(), "locker")
 }
 
+// QueuesPath is where the job queues were kept as files before they moved
+// into the database. It is only read to import leftover jobs.
 func QueuesPath() string {
 	return filepath.Join(EnvPath(), "queues")
 }
@@ -85,17 +85,6 @@ func SetupEnvironment() error {
 		os.Setenv("LEMC_DOCKER_HOST", "unix:///var/run/docker.sock")
 	}
 
-	qf := QueuesPath()
-	if err := os.MkdirAll(filepath.Join(qf, "now"), FileMode); err != nil {
-		return err
-	}
-	if err := os.MkdirAll(filepath.Join(qf, "in"), FileMode); err != nil {
-		return err
-	}
-	if err := os.MkdirAll(filepath.Join(qf, "every"), FileMode); err != nil {
-		return err
-	}
-
 	lp := LockerPath()
 	if err := os.MkdirAll(lp, FileMode); err != nil {
 		return err
@@ -0,0 +1,259 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/gorilla/websocket"
+)
+
+// reconnectDelay is how long the agent waits before dialing the server again.
+const reconnectDelay = 5 * time.Second
+
+// Agent connects to a LEMC server and runs the steps it is assigned on the
+// local Docker host.
+type Agent struct {
+	URL    string // websocket URL of the server's worker endpoint
+	Token  string
+	Name   string
+	Labels []string
+	Docker *client.Client
+
+	wmu   sync.Mutex // serializes writes to conn
+	conn  *websocket.Conn
+	mu    sync.Mutex
+	steps map[string]context.CancelFunc
+}
+
+// Run keeps the agent connected until ctx is done, dialing the server again
+// whenever the connection is lost.
+func (a *Agent) Run(ctx context.Context) error {
+	for {
+		err := a.serve(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Printf("worker %s: %v, reconnecting in %s", a.Name, err, reconnectDelay)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// serve runs one connection to the server. Steps still running when it is
+// lost are stopped, the server has failed them already.
+func (a *Agent) serve(ctx context.Context) error {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+a.Token)
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, a.URL, header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("dial %s: %s", a.URL, resp.Status)
+		}
+		return fmt.Errorf("dial %s: %w", a.URL, err)
+	}
+	defer conn.Close()
+
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-connCtx.Done()
+		conn.Close()
+	}()
+
+	a.mu.Lock()
+	a.conn = conn
+	a.steps = make(map[string]context.CancelFunc)
+	a.mu.Unlock()
+
+	if err := a.send(Message{Type: TypeHello, Hello: &Hello{Name: a.Name, Labels: a.Labels}}); err != nil {
+		return err
+	}
+	log.Printf("worker %s connected to %s with labels %s", a.Name, a.URL, strings.Join(a.Labels, ", "))
+
+	var wg sync.WaitGroup
+	for {
+		var m Message
+		if err := conn.ReadJSON(&m); err != nil {
+			cancel()
+			wg.Wait()
+			return err
+		}
+		switch m.Type {
+		case TypeAssign:
+			if m.Assign == nil {
+				continue
+			}
+			stepCtx, stop := context.WithCancel(connCtx)
+			a.mu.Lock()
+			a.steps[m.Assign.ID] = stop
+			a.mu.Unlock()
+			wg.Add(1)
+			go func(as Assignment) {
+				defer wg.Done()
+				defer stop()
+				a.runStep(stepCtx, as)
+				a.mu.Lock()
+				delete(a.steps, as.ID)
+				a.mu.Unlock()
+			}(*m.Assign)
+		case TypeCancel:
+			a.mu.Lock()
+			if stop, ok := a.steps[m.ID]; ok {
+				stop()
+			}
+			a.mu.Unlock()
+		}
+	}
+}
+
+// send writes a message to the server.
+func (a *Agent) send(m Message) error {
+	a.wmu.Lock()
+	defer a.wmu.Unlock()
+	a.mu.Lock()
+	conn := a.conn
+	a.mu.Unlock()
+	if conn == nil {
+		return errors.New("not connected")
+	}
+	return conn.WriteJSON(m)
+}
+
+// runStep runs an assigned step and reports when it is done.
+func (a *Agent) runStep(ctx context.Context, as Assignment) {
+	log.Printf("worker %s: running %s", a.Name, as.Name)
+	exitCode, err := a.runContainer(ctx, as)
+	done := Message{Type: TypeDone, ID: as.ID, ExitCode: exitCode}
+	if err != nil {
+		log.Printf("worker %s: %s: %v", a.Name, as.Name, err)
+		done.Error = err.Error()
+	}
+	if err := a.send(done); err != nil {
+		log.Printf("worker %s: report %s: %v", a.Name, as.Name, err)
+	}
+}
+
+// runContainer pulls the image when needed, starts the step container and
+// streams its output to the server until it exits, times out or the step is
+// cancelled, then removes it. The exit code is returned when the container
+// exited by itself.
+func (a *Agent) runContainer(ctx context.Context, as Assignment) (*int, error) {
+	bg := context.Background()
+	inspect, _, err := a.Docker.ImageInspectWithRaw(bg, as.Image)
+	if err != nil || as.Pull {
+		if err := a.pull(bg, as); err != nil {
+			return nil, err
+		}
+		if inspect, _, err = a.Docker.ImageInspectWithRaw(bg, as.Image); err != nil {
+			return nil, err
+		}
+	}
+
+	a.remove(as.Name)
+	timeout := as.TimeoutSeconds
+	cfg := &container.Config{
+		StopTimeout:  &timeout,
+		Image:        as.Image,
+		Env:          as.Env,
+		AttachStdout: true,
+		AttachStderr: true,
+		Labels:       as.Labels,
+	}
+	resp, err := a.Docker.ContainerCreate(bg, cfg, &container.HostConfig{}, nil, nil, as.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer a.remove(resp.ID)
+	if err := a.Docker.ContainerStart(bg, resp.ID, container.StartOptions{}); err != nil {
+		return nil, err
+	}
+	if err := a.send(Message{Type: TypeStarted, ID: as.ID, ImageID: inspect.ID}); err != nil {
+		return nil, err
+	}
+
+	var exitCode *int
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.streamLogs(bg, as.ID, resp.ID)
+	}()
+
+	statusCh, errCh := a.Docker.ContainerWait(bg, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case st := <-statusCh:
+		code := int(st.StatusCode)
+		exitCode = &code
+	case err := <-errCh:
+		return nil, err
+	case <-time.After(time.Duration(as.TimeoutSeconds) * time.Second):
+		log.Printf("worker %s: %s timed out", a.Name, as.Name)
+		a.stop(resp.ID)
+	case <-ctx.Done():
+		a.stop(resp.ID)
+	}
+	wg.Wait()
+	return exitCode, nil
+}
+
+// streamLogs sends each line the container writes to the server.
+func (a *Agent) streamLogs(ctx context.Context, id, containerID string) {
+	out, err := a.Docker.ContainerLogs(ctx, containerID, container.LogsOptions{ShowStdout: true, ShowStderr: true, Follow: true})
+	if err != nil {
+		log.Printf("worker %s: logs: %v", a.Name, err)
+		return
+	}
+	defer out.Close()
+
+	reader := bufio.NewReader(out)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return
+		}
+		buf := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return
+		}
+		if err := a.send(Message{Type: TypeOutput, ID: id, Line: strings.TrimSpace(string(buf))}); err != nil {
+			return
+		}
+	}
+}
+
+// pull pulls the image of an assignment with its registry auth.
+func (a *Agent) pull(ctx context.Context, as Assignment) error {
+	stream, err := a.Docker.ImagePull(ctx, as.Image, image.PullOptions{RegistryAuth: as.RegistryAuth})
+	if err != nil {
+		return fmt.Errorf("pull %s: %w", as.Image, err)
+	}
+	defer stream.Close()
+	_, err = io.Copy(io.Discard, stream)
+	return err
+}
+
+func (a *Agent) stop(containerID string) {
+	timeout := 10
+	if err := a.Docker.ContainerStop(context.Background(), containerID, container.StopOptions{Timeout: &timeout}); err != nil {
+		log.Printf("worker %s: stop: %v", a.Name, err)
+	}
+}
+
+func (a *Agent) remove(nameOrID string) {
+	_ = a.Docker.ContainerRemove(context.Background(), nameOrID, container.RemoveOptions{RemoveVolumes: true, Force: true})
+}
@@ -0,0 +1,98 @@
+// Package worker holds the protocol spoken between the LEMC server and the
+// lemc-worker agents that run steps on their own Docker host, and the agent
+// itself.
+package worker
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Message types of the worker channel.
+const (
+	TypeHello   = "hello"   // worker -> server, first message with the worker's name and labels
+	TypeAssign  = "assign"  // server -> worker, run a step
+	TypeCancel  = "cancel"  // server -> worker, stop a step
+	TypeStarted = "started" // worker -> server, the step container is running
+	TypeOutput  = "output"  // worker -> server, one line of step output
+	TypeDone    = "done"    // worker -> server, the step container is gone
+)
+
+// Message is one JSON frame of the worker channel.
+type Message struct {
+	Type    string      `json:"type"`
+	Hello   *Hello      `json:"hello,omitempty"`
+	Assign  *Assignment `json:"assign,omitempty"`
+	ID      string      `json:"id,omitempty"` // assignment the message belongs to
+	ImageID string      `json:"image_id,omitempty"`
+	Line    string      `json:"line,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	// ExitCode of the step container, on done when it exited by itself.
+	ExitCode *int `json:"exit_code,omitempty"`
+}
+
+// Hello introduces a worker to the server.
+type Hello struct {
+	Name   string   `json:"name"`
+	Labels []string `json:"labels"`
+}
+
+// Assignment is a step the server hands to a worker.
+type Assignment struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name"` // container name
+	Image          string            `json:"image"`
+	RegistryAuth   string            `json:"registry_auth,omitempty"` // encoded Docker auth header
+	Pull           bool              `json:"pull,omitempty"`          // pull even when the image is present
+	Env            []string          `json:"env"`
+	Labels         map[string]string `json:"labels"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+}
+
+var labelRgx = regexp.MustCompile(`^[a-z0-9][a-z0-9._:-]*$`)
+
+// ValidLabel returns an error when label cannot be matched by runs_on.
+func ValidLabel(label string) error {
+	if !labelRgx.MatchString(label) {
+		return fmt.Errorf("worker label %q must be lower case letters, digits, '.', '_', ':' or '-'", label)
+	}
+	return nil
+}
+
+// ParseLabels splits a comma separated list of labels.
+func ParseLabels(raw string) ([]string, error) {
+	var labels []string
+	for _, l := range strings.Split(raw, ",") {
+		l = strings.ToLower(strings.TrimSpace(l))
+		if l == "" {
+			continue
+		}
+		if err := ValidLabel(l); err != nil {
+			return nil, err
+		}
+		labels = append(labels, l)
+	}
+	return labels, nil
+}
+
+// DefaultLabels are advertised by every worker on top of its own labels.
+func DefaultLabels() []string {
+	return []string{"arch:" + runtime.GOARCH, "os:" + runtime.GOOS}
+}
+
+// MatchLabels reports whether a worker with the labels have can run a step
+// that asks for all of want.
+func MatchLabels(have, want []string) bool {
+	set := make(map[string]bool, len(have))
+	for _, l := range have {
+		set[l] = true
+	}
+	for _, l := range want {
+		if !set[l] {
+			return false
+		}
+	}
+	return true
+}
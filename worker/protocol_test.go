@@ -0,0 +1,26 @@
+package worker
+
+import "testing"
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels(" DMZ, gpu-less ,,zone:b")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(labels) != 3 || labels[0] != "dmz" || labels[1] != "gpu-less" || labels[2] != "zone:b" {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+	if _, err := ParseLabels("dmz,no spaces"); err == nil {
+		t.Fatal("expected an invalid label to be refused")
+	}
+}
+
+func TestMatchLabels(t *testing.T) {
+	have := []string{"arch:amd64", "os:linux", "dmz"}
+	if !MatchLabels(have, []string{"dmz"}) || !MatchLabels(have, nil) {
+		t.Fatal("expected the worker to match")
+	}
+	if MatchLabels(have, []string{"dmz", "gpu"}) {
+		t.Fatal("expected every label to be required")
+	}
+}
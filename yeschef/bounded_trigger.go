@@ -0,0 +1,392 @@
+package yeschef
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/reugn/go-quartz/quartz"
+)
+
+// boundedTriggerDescription prefixes the description of a BoundedTrigger.
+const boundedTriggerDescription = "BoundedTrigger"
+
+// blackoutSearchLimit bounds how far ahead the end of a blackout is searched.
+// Blackouts that cover a whole week never end.
+const blackoutSearchLimit = 8 * 24 * time.Hour
+
+// ErrBlackoutNeverEnds is returned when the blackout windows of a trigger
+// leave no time to run in.
+var ErrBlackoutNeverEnds = errors.New("blackout windows never end")
+
+var untilLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// BoundedTrigger fires every Interval like a quartz.SimpleTrigger, but stops
+// after Until or MaxRuns, moves runs out of blackout windows and delays each
+// run by a random jitter. Runs and Offset are its state and are persisted
+// with the job, so the bounds survive a restart.
+type BoundedTrigger struct {
+	Interval time.Duration `json:"interval"`
+	Until    time.Time     `json:"until"`
+	MaxRuns  int           `json:"max_runs,omitempty"`
+	Blackout []string      `json:"blackout,omitempty"`
+	Jitter   time.Duration `json:"jitter,omitempty"`
+	// Runs counts the run times handed out, including the pending one.
+	Runs int `json:"runs,omitempty"`
+	// Offset is how far the pending run was moved off the interval grid by
+	// jitter and blackouts. It is always less than Interval.
+	Offset time.Duration `json:"offset,omitempty"`
+
+	windows []blackoutWindow
+}
+
+var _ quartz.Trigger = (*BoundedTrigger)(nil)
+
+// NewBoundedTrigger returns a trigger firing every interval within the given
+// bounds. A zero until, maxRuns or jitter leaves that bound off.
+func NewBoundedTrigger(interval time.Duration, until time.Time, maxRuns int, blackout []string, jitter time.Duration) (*BoundedTrigger, error) {
+	t := &BoundedTrigger{Interval: interval, Until: until, MaxRuns: maxRuns, Blackout: blackout, Jitter: jitter}
+	if err := t.init(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// init checks the bounds and parses the blackout windows.
+func (t *BoundedTrigger) init() error {
+	if t.Interval <= 0 {
+		return fmt.Errorf("%w: must be greater than zero", ErrInvalidInterval)
+	}
+	if t.MaxRuns < 0 {
+		return fmt.Errorf("max_runs %d must not be negative", t.MaxRuns)
+	}
+	if t.Jitter < 0 {
+		return fmt.Errorf("jitter %s must not be negative", t.Jitter)
+	}
+	t.windows = t.windows[:0]
+	for _, raw := range t.Blackout {
+		w, err := parseBlackout(raw)
+		if err != nil {
+			return err
+		}
+		t.windows = append(t.windows, w)
+	}
+	if len(t.windows) > 0 {
+		if _, err := t.allowed(time.Now().UnixNano()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NextFireTime returns the first run time one interval after prev that is
+// outside every blackout window, plus jitter. It returns
+// quartz.ErrTriggerExpired once MaxRuns runs were handed out or the next run
+// would be after Until.
+func (t *BoundedTrigger) NextFireTime(prev int64) (int64, error) {
+	if t.MaxRuns > 0 && t.Runs >= t.MaxRuns {
+		return 0, quartz.ErrTriggerExpired
+	}
+
+	grid := prev - t.Offset.Nanoseconds() + t.Interval.Nanoseconds()
+	next := grid
+	if t.Jitter > 0 {
+		next += rand.Int64N(t.Jitter.Nanoseconds())
+	}
+	next, err := t.allowed(next)
+	if err != nil {
+		return 0, err
+	}
+	if t.ended(next) {
+		return 0, quartz.ErrTriggerExpired
+	}
+
+	// A run moved past the end of a blackout continues the grid from there,
+	// so the following runs leave the window as well.
+	if skipped := (next - grid) / t.Interval.Nanoseconds(); skipped > 0 {
+		grid += skipped * t.Interval.Nanoseconds()
+	}
+	t.Runs++
+	t.Offset = time.Duration(next - grid)
+	return next, nil
+}
+
+// ended reports whether a run at ts would be after Until.
+func (t *BoundedTrigger) ended(ts int64) bool {
+	return !t.Until.IsZero() && ts > t.Until.UnixNano()
+}
+
+// allowed returns ts, or the end of the blackout window it falls in.
+func (t *BoundedTrigger) allowed(ts int64) (int64, error) {
+	at := time.Unix(0, ts)
+	limit := at.Add(blackoutSearchLimit)
+	for t.blackedOut(at) {
+		at = at.Truncate(time.Minute).Add(time.Minute)
+		if at.After(limit) {
+			return 0, ErrBlackoutNeverEnds
+		}
+	}
+	return at.UnixNano(), nil
+}
+
+func (t *BoundedTrigger) blackedOut(at time.Time) bool {
+	for _, w := range t.windows {
+		if w.contains(at) {
+			return true
+		}
+	}
+	return false
+}
+
+// clone returns a copy of t whose state can be advanced without touching t.
+func (t *BoundedTrigger) clone() *BoundedTrigger {
+	c := *t
+	c.Blackout = append([]string(nil), t.Blackout...)
+	c.windows = append([]blackoutWindow(nil), t.windows...)
+	return &c
+}
+
+// restart returns a copy of t firing every d from the next time it is asked,
+// keeping its bounds and the runs already handed out. The pending run was
+// never fired, so it is no longer counted.
+func (t *BoundedTrigger) restart(d time.Duration) *BoundedTrigger {
+	c := t.clone()
+	c.Interval = d
+	c.Offset = 0
+	if c.Runs > 0 {
+		c.Runs--
+	}
+	return c
+}
+
+// Description returns the trigger serialized as "BoundedTrigger::<interval>::<json>".
+func (t *BoundedTrigger) Description() string {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Sprintf("%s%s%s", boundedTriggerDescription, quartz.Sep, t.Interval)
+	}
+	return fmt.Sprintf("%s%s%s%s%s", boundedTriggerDescription, quartz.Sep, t.Interval, quartz.Sep, b)
+}
+
+// parseBoundedTrigger restores a BoundedTrigger from its description.
+func parseBoundedTrigger(description string) (*BoundedTrigger, error) {
+	parts := strings.SplitN(description, quartz.Sep, 3)
+	if len(parts) < 3 || parts[0] != boundedTriggerDescription {
+		return nil, fmt.Errorf("invalid trigger format: %s", description)
+	}
+	t := &BoundedTrigger{}
+	if err := json.Unmarshal([]byte(parts[2]), t); err != nil {
+		return nil, fmt.Errorf("invalid bounded trigger: %w", err)
+	}
+	if err := t.init(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// hasBounds reports whether a step sets any of until, max_runs, blackout or
+// jitter.
+func hasBounds(st models.Step) bool {
+	return strings.TrimSpace(st.Until) != "" || st.MaxRuns != 0 || len(st.Blackout) > 0 || strings.TrimSpace(st.Jitter) != ""
+}
+
+// NewStepTrigger returns the trigger an every step fires on: a simple trigger
+// for interval, or a BoundedTrigger when the step sets any bounds.
+func NewStepTrigger(st models.Step, interval time.Duration) (quartz.Trigger, error) {
+	if !hasBounds(st) {
+		return quartz.NewSimpleTrigger(interval), nil
+	}
+
+	var until time.Time
+	if raw := strings.TrimSpace(st.Until); raw != "" {
+		var err error
+		if until, err = parseUntil(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	var jitter time.Duration
+	if raw := strings.TrimSpace(st.Jitter); raw != "" {
+		_, d, err := parseStepDo(EVERY_QUEUE + "." + raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jitter %q: %v", raw, err)
+		}
+		jitter = d
+	}
+
+	return NewBoundedTrigger(interval, until, st.MaxRuns, st.Blackout, jitter)
+}
+
+// ValidateStepBounds checks the until, max_runs, blackout and jitter of a
+// step without scheduling it. Only every steps may set them.
+func ValidateStepBounds(st models.Step) error {
+	if !hasBounds(st) {
+		return nil
+	}
+	queue, d, err := parseStepDo(st.Do)
+	if err != nil {
+		return err
+	}
+	if queue != EVERY_QUEUE {
+		return fmt.Errorf("until, max_runs, blackout and jitter need an every step, not %q", st.Do)
+	}
+	_, err = NewStepTrigger(st, d)
+	return err
+}
+
+func parseUntil(raw string) (time.Time, error) {
+	for _, layout := range untilLayouts {
+		if t, err := time.ParseInLocation(layout, raw, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid until %q (use RFC 3339 or 2006-01-02 15:04)", raw)
+}
+
+// blackoutWindow is a recurring span of time in which a trigger does not
+// fire. Start and end are minutes of the day; an end before the start means
+// the window runs past midnight.
+type blackoutWindow struct {
+	days       [7]bool
+	start, end int
+	loc        *time.Location
+}
+
+// parseBlackout reads a window written as "[days] [HH:MM-HH:MM] [timezone]",
+// for example "sat-sun", "02:00-04:00 Europe/Berlin" or
+// "weekdays 12:00-13:00". Days are a range or comma separated list of
+// three letter names, or "weekdays" or "weekends". The window covers whole
+// days when no time range is given and every day when no days are given.
+func parseBlackout(raw string) (blackoutWindow, error) {
+	w := blackoutWindow{start: 0, end: 24 * 60, loc: time.Local}
+	fields := strings.Fields(strings.ToLower(raw))
+	if len(fields) == 0 {
+		return w, errors.New("empty blackout window")
+	}
+
+	var hasDays, hasTimes bool
+	for _, f := range fields {
+		switch {
+		case !hasTimes && strings.Contains(f, ":"):
+			start, end, err := parseTimeRange(f)
+			if err != nil {
+				return w, fmt.Errorf("blackout %q: %w", raw, err)
+			}
+			w.start, w.end, hasTimes = start, end, true
+		case !hasDays && parseDays(f, &w.days):
+			hasDays = true
+		default:
+			// Location names are case sensitive, look up the original field.
+			loc, err := time.LoadLocation(originalField(raw, f))
+			if err != nil {
+				return w, fmt.Errorf("blackout %q: unknown days or timezone %q", raw, f)
+			}
+			w.loc = loc
+		}
+	}
+	if !hasDays {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	}
+	if !hasDays && !hasTimes {
+		return w, fmt.Errorf("blackout %q needs days or a time range", raw)
+	}
+	return w, nil
+}
+
+func originalField(raw, lower string) string {
+	for _, f := range strings.Fields(raw) {
+		if strings.ToLower(f) == lower {
+			return f
+		}
+	}
+	return lower
+}
+
+// parseDays sets the days named by f and reports whether f named any.
+func parseDays(f string, days *[7]bool) bool {
+	switch f {
+	case "weekdays":
+		f = "mon-fri"
+	case "weekends":
+		f = "sat-sun"
+	}
+
+	var set [7]bool
+	for _, part := range strings.Split(f, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[from]
+		if !ok {
+			return false
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[to]; !ok {
+				return false
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			set[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	*days = set
+	return true
+}
+
+func parseTimeRange(f string) (int, int, error) {
+	from, to, ok := strings.Cut(f, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("time range %q must be HH:MM-HH:MM", f)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("time range %q is empty", f)
+	}
+	return start, end, nil
+}
+
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hour, herr := strconv.Atoi(h)
+	minute, merr := strconv.Atoi(m)
+	if !ok || herr != nil || merr != nil || hour < 0 || hour > 24 || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return hour*60 + minute, nil
+}
+
+func (w blackoutWindow) contains(at time.Time) bool {
+	local := at.In(w.loc)
+	minute := local.Hour()*60 + local.Minute()
+	day := local.Weekday()
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	// The window runs past midnight: the late part belongs to today, the
+	// early part to the window that started yesterday.
+	if minute >= w.start {
+		return w.days[day]
+	}
+	return minute < w.end && w.days[(day+6)%7]
+}
@@ -0,0 +1,201 @@
+package yeschef
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/reugn/go-quartz/quartz"
+)
+
+func TestBoundedTriggerMaxRuns(t *testing.T) {
+	trg, err := NewBoundedTrigger(time.Minute, time.Time{}, 3, nil, 0)
+	if err != nil {
+		t.Fatalf("trigger: %v", err)
+	}
+	prev := time.Now().UnixNano()
+	for i := 0; i < 3; i++ {
+		next, err := trg.NextFireTime(prev)
+		if err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+		if next != prev+int64(time.Minute) {
+			t.Fatalf("run %d: next %d, want %d", i+1, next, prev+int64(time.Minute))
+		}
+		prev = next
+	}
+	if _, err := trg.NextFireTime(prev); !errors.Is(err, quartz.ErrTriggerExpired) {
+		t.Fatalf("expected the trigger to expire after max_runs, got %v", err)
+	}
+}
+
+func TestBoundedTriggerUntil(t *testing.T) {
+	now := time.Now()
+	trg, err := NewBoundedTrigger(time.Hour, now.Add(90*time.Minute), 0, nil, 0)
+	if err != nil {
+		t.Fatalf("trigger: %v", err)
+	}
+	next, err := trg.NextFireTime(now.UnixNano())
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if _, err := trg.NextFireTime(next); !errors.Is(err, quartz.ErrTriggerExpired) {
+		t.Fatalf("expected no run after until, got %v", err)
+	}
+}
+
+func TestBoundedTriggerBlackout(t *testing.T) {
+	trg, err := NewBoundedTrigger(30*time.Minute, time.Time{}, 0, []string{"02:00-04:00 UTC", "weekends UTC"}, 0)
+	if err != nil {
+		t.Fatalf("trigger: %v", err)
+	}
+
+	// Monday 01:45 UTC, the next run would be at 02:15.
+	prev := time.Date(2025, 6, 2, 1, 45, 0, 0, time.UTC)
+	next, err := trg.NextFireTime(prev.UnixNano())
+	if err != nil {
+		t.Fatalf("next: %v", err)
+	}
+	if want := time.Date(2025, 6, 2, 4, 0, 0, 0, time.UTC); !time.Unix(0, next).Equal(want) {
+		t.Fatalf("next run %v, want %v", time.Unix(0, next).UTC(), want)
+	}
+
+	// The interval grid carries on from where it was before the blackout.
+	next, err = trg.NextFireTime(next)
+	if err != nil {
+		t.Fatalf("next: %v", err)
+	}
+	if want := time.Date(2025, 6, 2, 4, 15, 0, 0, time.UTC); !time.Unix(0, next).Equal(want) {
+		t.Fatalf("next run %v, want %v", time.Unix(0, next).UTC(), want)
+	}
+
+	// Friday 23:50 UTC runs again on Monday after the weekend.
+	trg.Offset = 0
+	prev = time.Date(2025, 6, 6, 23, 50, 0, 0, time.UTC)
+	next, err = trg.NextFireTime(prev.UnixNano())
+	if err != nil {
+		t.Fatalf("next: %v", err)
+	}
+	if want := time.Date(2025, 6, 9, 0, 0, 0, 0, time.UTC); !time.Unix(0, next).Equal(want) {
+		t.Fatalf("next run %v, want %v", time.Unix(0, next).UTC(), want)
+	}
+}
+
+func TestBoundedTriggerJitter(t *testing.T) {
+	trg, err := NewBoundedTrigger(time.Minute, time.Time{}, 0, nil, 10*time.Second)
+	if err != nil {
+		t.Fatalf("trigger: %v", err)
+	}
+	start := time.Now().UnixNano()
+	prev := start
+	for i := 1; i <= 20; i++ {
+		next, err := trg.NextFireTime(prev)
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		grid := start + int64(i)*int64(time.Minute)
+		if next < grid || next >= grid+int64(10*time.Second) {
+			t.Fatalf("run %d at offset %s from the grid", i, time.Duration(next-grid))
+		}
+		prev = next
+	}
+}
+
+func TestParseBlackout(t *testing.T) {
+	valid := []string{"sat-sun", "weekdays 12:00-13:00", "fri-mon", "22:00-02:00 Europe/Berlin", "mon,wed 09:00-10:00"}
+	for _, raw := range valid {
+		if _, err := parseBlackout(raw); err != nil {
+			t.Errorf("%q: %v", raw, err)
+		}
+	}
+	invalid := []string{"", "sometimes", "25:00-26:00", "10:00-10:00", "mon-xyz"}
+	for _, raw := range invalid {
+		if _, err := parseBlackout(raw); err == nil {
+			t.Errorf("%q: expected an error", raw)
+		}
+	}
+
+	w, err := parseBlackout("22:00-02:00 UTC")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !w.contains(time.Date(2025, 6, 2, 1, 0, 0, 0, time.UTC)) || w.contains(time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)) {
+		t.Fatal("window past midnight does not match")
+	}
+
+	if _, err := NewBoundedTrigger(time.Minute, time.Time{}, 0, []string{"mon-sun"}, 0); !errors.Is(err, ErrBlackoutNeverEnds) {
+		t.Fatalf("expected a blackout covering the whole week to be rejected, got %v", err)
+	}
+}
+
+func TestValidateStepBounds(t *testing.T) {
+	tests := []struct {
+		name    string
+		step    models.Step
+		wantErr bool
+	}{
+		{"no bounds", models.Step{Do: "in.1.minutes"}, false},
+		{"every with bounds", models.Step{Do: "every.1.minutes", Until: "2030-01-01 12:00", MaxRuns: 5, Blackout: []string{"weekends"}, Jitter: "10.seconds"}, false},
+		{"in with bounds", models.Step{Do: "in.1.minutes", MaxRuns: 5}, true},
+		{"bad until", models.Step{Do: "every.1.minutes", Until: "tomorrow"}, true},
+		{"bad jitter", models.Step{Do: "every.1.minutes", Jitter: "a bit"}, true},
+		{"negative max runs", models.Step{Do: "every.1.minutes", MaxRuns: -1}, true},
+	}
+	for _, tt := range tests {
+		if err := ValidateStepBounds(tt.step); (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMarshalUnmarshalBoundedTrigger(t *testing.T) {
+	job := testEveryJob("bounded", "", time.Now().UnixNano())
+	trg, err := NewBoundedTrigger(10*time.Minute, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), 5, []string{"02:00-04:00 UTC"}, time.Second)
+	if err != nil {
+		t.Fatalf("trigger: %v", err)
+	}
+	trg.Runs, trg.Offset = 2, 300*time.Millisecond
+	job.trigger = trg
+
+	b, err := marshal(job)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	j, err := unmarshalEveryJob(b)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	got, ok := j.Trigger().(*BoundedTrigger)
+	if !ok {
+		t.Fatalf("expected a BoundedTrigger, got %T", j.Trigger())
+	}
+	if got.Description() != trg.Description() || len(got.windows) != 1 {
+		t.Fatalf("trigger %s, want %s", got.Description(), trg.Description())
+	}
+}
+
+func TestJobQueueRecoverBoundedTrigger(t *testing.T) {
+	q := newTestQueue(t, EVERY_QUEUE)
+	now := time.Now().UnixNano()
+
+	ended := testEveryJob("ended", "", now-int64(time.Hour))
+	ended.trigger, _ = NewBoundedTrigger(10*time.Minute, time.Now().Add(-time.Minute), 0, nil, 0)
+	entry, _, err := q.recoverJob(ended, now)
+	if err != nil || entry == nil || entry.Fired != 0 {
+		t.Fatalf("ended: entry=%+v err=%v", entry, err)
+	}
+
+	limited := testEveryJob("limited", models.MisfireFireAll, now-int64(time.Hour))
+	bt, _ := NewBoundedTrigger(10*time.Minute, time.Time{}, 3, nil, 0)
+	bt.Runs = 2
+	limited.trigger = bt
+	entry, replay, err := q.recoverJob(limited, now)
+	if err != nil || replay != 2 || entry.Fired != 2 {
+		t.Fatalf("limited: entry=%+v replay=%d err=%v", entry, replay, err)
+	}
+
+	if n, _ := q.Size(); n != 0 {
+		t.Fatalf("expected finished bounded jobs to be dropped, got %d jobs", n)
+	}
+}
@@ -18,6 +18,8 @@ type Client struct {
 	Xerver *CmdServer
 	Xonn   *websocket.Conn
 	Xend   chan []byte
+	Since  map[string]uint64 // last sequence number of each run the client saw before it connected, nil when it saw nothing
+	Topics []string          // topics the client is sent, all when empty
 	mu     sync.RWMutex
 }
 
@@ -38,7 +40,11 @@ func (x *Client) ReadPump() {
 			break
 		}
 		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
-		x.Xerver.Radio <- message
+		if topics, ok := bytes.CutPrefix(message, []byte(LEMC_SUBSCRIBE)); ok {
+			x.Xerver.Subscribe <- Subscription{Client: x, Topics: ParseTopics(string(topics))}
+			continue
+		}
+		x.Xerver.Radio <- frame{data: message}
 	}
 }
 
@@ -54,23 +60,13 @@ func (x *Client) WritePump() {
 		case message, ok := <-x.Xend:
 			x.Xonn.SetWriteDeadline(time.Now().Add(WRITE_WAIT))
 			if !ok {
-				x.Xonn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
-			w, err := x.Xonn.NextWriter(websocket.TextMessage)
-			if err != nil {
+				// Try again later makes the browser reconnect and resume.
+				x.Xonn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, ""))
 				return
 			}
-			w.Write(message)
-
-			n := len(x.Xend)
-			for i := 0; i < n; i++ {
-				w.Write(newline)
-				w.Write(<-x.Xend)
-			}
 
-			if err := w.Close(); err != nil {
+			// One frame per message, the browser parses each frame as JSON.
+			if err := x.Xonn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
 		case <-ticker.C:
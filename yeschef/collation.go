@@ -73,7 +73,8 @@ func (x *ChefsKiss) CreateMcpAppInstance(appID int64) *McpServer {
 		return nil
 	}
 
-	srv := NewMcpServer(appID, app.UUID, app.YAMLShared)
+	srv := NewMcpServer(appID, app.UUID, app.YAMLShared, app.YAMLIndividual)
+	srv.AccountID = app.AccountID
 	go srv.Run()
 	x.mcpApps[appID] = srv
 	return srv
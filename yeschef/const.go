@@ -15,6 +15,7 @@ const (
 	NOW_QUEUE         = "now"
 	IN_QUEUE          = "in"
 	EVERY_QUEUE       = "every"
+	SCHEDULE_KEY      = "schedule"
 	PYTHON_UNBUFFERED = "PYTHONUNBUFFERED=1"
 	LEMC_CSS_TRUNC    = "lemc.css.trunc;"
 	LEMC_CSS_BUFFER   = "lemc.css.buffer;"
@@ -26,6 +27,10 @@ const (
 	LEMC_JS_TRUNC     = "lemc.js.trunc;"
 	LEMC_ERR          = "lemc.err;"
 	LEMC_ENV          = "lemc.env;"
+	LEMC_REPLAY       = "lemc.replay;"
+	LEMC_SUBSCRIBE    = "lemc.subscribe;"
+	LEMC_EVENT        = "lemc.event;"
+	LEMC_OUTPUT       = "lemc.output;"
 	OWNED_BY          = "LEMC"
 	MAX_MESSAGE_SIZE  = 512
 	JOB_TYPE_APP      = "app"
@@ -35,6 +40,11 @@ const (
 	PONG_WAIT   = 60 * time.Second
 	PING_PERIOD = (PONG_WAIT * 9) / 10
 
+	// REPLAY_BUFFER_SIZE is how many messages are kept per user for clients
+	// that reconnect. CLIENT_BUFFER_SIZE leaves room to replay all of them.
+	REPLAY_BUFFER_SIZE = 1024
+	CLIENT_BUFFER_SIZE = 2 * REPLAY_BUFFER_SIZE
+
 	FILE_MODE fs.FileMode = util.DirPerm
 
 	html_fn = "cache.html"
@@ -10,7 +10,6 @@ import (
 	"io"
 	"log"
 	"net/url"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -23,6 +22,7 @@ import (
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
+	"github.com/jaredfolkins/letemcook/models"
 	"github.com/jaredfolkins/letemcook/paths"
 	"github.com/jaredfolkins/letemcook/util"
 )
@@ -138,12 +138,23 @@ func msg(message, imageHash, imageName string, job *JobRecipe, jm *util.JobMeta,
 		return
 	}
 
+	// Output data is the result of the run, not something to show.
+	if strings.HasPrefix(message, LEMC_OUTPUT) {
+		return
+	}
+
 	jsonData, err := json.Marshal(r)
 	if err != nil {
 		log.Printf("Error converting struct to JSON: %v", err)
 		return
 	}
 
+	publish(job, *r, jsonData)
+}
+
+// jobRecipients returns the users the messages of a job go to, nil when it
+// has none.
+func jobRecipients(job *JobRecipe) []int64 {
 	// Determine target users based on job scope
 	var targetUserIDs []int64
 
@@ -153,7 +164,7 @@ func msg(message, imageHash, imageName string, job *JobRecipe, jm *util.JobMeta,
 		individualUserID, err := strconv.ParseInt(job.UserID, 10, 64)
 		if err != nil {
 			log.Printf("Error parsing individual UserID '%s' for job %s (UUID: %s): %v", job.UserID, job.StepID, job.UUID, err)
-			return // Cannot proceed without a valid user ID
+			return nil // Cannot proceed without a valid user ID
 		}
 		targetUserIDs = []int64{individualUserID}
 
@@ -166,23 +177,29 @@ func msg(message, imageHash, imageName string, job *JobRecipe, jm *util.JobMeta,
 
 	default:
 		log.Printf("Warning: Unknown job scope '%s' encountered for job %s (UUID: %s). Message not sent.", job.Scope, job.StepID, job.UUID)
-		return // Don't send if scope is unknown
+		return nil // Don't send if scope is unknown
 	}
 
 	// Check if we have any valid targets
-	if targetUserIDs == nil || len(targetUserIDs) == 0 {
+	if len(targetUserIDs) == 0 {
 		log.Printf("No valid recipient user IDs determined for StepID %s (UUID: %s, Scope: %s). Message not sent.", job.StepID, job.UUID, job.Scope)
-		return
+		return nil
 	}
+	return targetUserIDs
+}
 
+// publish sends a response to the users of the job and mcpData to the MCP
+// client that started it and the clients observing its app whose user may
+// see the job.
+func publish(job *JobRecipe, r Response, mcpData []byte) {
+	recipients := jobRecipients(job)
+	r.RunID = job.RunID
 	// Send message to the appropriate user(s)
-	for _, userID := range targetUserIDs {
+	for _, userID := range recipients {
 		targetServer := XoxoX.ReadInstance(userID)
 		if targetServer != nil {
-			select {
-			case targetServer.Radio <- jsonData:
-			default:
-				log.Printf("Warning: Radio channel full for user %d, skipping message for job %s", userID, job.StepID)
+			if err := targetServer.Publish(r, jobTopics(job)...); err != nil {
+				log.Printf("Warning: %v for user %d, clients resume message for job %s on reconnect", err, userID, job.StepID)
 			}
 		}
 	}
@@ -191,7 +208,7 @@ func msg(message, imageHash, imageName string, job *JobRecipe, jm *util.JobMeta,
 		if id, err := strconv.ParseInt(job.AppID, 10, 64); err == nil {
 			mcpSrv := XoxoX.ReadMcpAppInstance(id)
 			if mcpSrv != nil {
-				mcpSrv.broadcast(jsonData)
+				mcpSrv.route(job.McpSession, recipients, mcpData)
 			}
 		}
 	}
@@ -203,6 +220,10 @@ type Response struct {
 	ViewType string
 	Cmd      string
 	Msg      string
+	RunID    string            `json:",omitempty"`
+	Seq      uint64            `json:",omitempty"` // per run, see CmdServer.Publish
+	Runs     map[string]uint64 `json:",omitempty"` // set for LEMC_REPLAY
+	Event    *Event            `json:",omitempty"` // set for LEMC_EVENT
 }
 
 func timeoutInSeconds(timeout string) (int, error) {
@@ -311,17 +332,16 @@ func NewHostConfig(cf *util.ContainerFiles, is_admin bool) *container.HostConfig
 	}
 }
 
-func runContainer(server *CmdServer, job *JobRecipe, uri string, env []string) error {
+func runContainer(runCtx context.Context, server *CmdServer, job *JobRecipe, uri string, env []string) error {
 	var err error
-	ctx := context.Background()
+	// The container outlives a cancelled run, only the step's values are kept.
+	ctx := context.WithoutCancel(runCtx)
 
-	cli, err := client.NewClientWithOpts(
-		client.WithHost(os.Getenv("LEMC_DOCKER_HOST")),
-		client.WithAPIVersionNegotiation(),
-	)
+	cli, err := models.DockerClient(jobDockerEndpoint(job))
 	if err != nil {
 		return err
 	}
+	defer cli.Close()
 
 	// Use the environment intended for the container so JobMeta reflects
 	// the correct step-specific values (e.g. LEMC_STEP_ID)
@@ -348,6 +368,7 @@ func runContainer(server *CmdServer, job *JobRecipe, uri string, env []string) e
 		return err
 	}
 	defer lf.CloseLogFile()
+	lf.RunID = job.RunID
 
 	err = deletePreviousContainer(ctx, cli, job, fm.IndividualUsernameOrSharedUsername)
 	if err != nil {
@@ -370,6 +391,12 @@ func runContainer(server *CmdServer, job *JobRecipe, uri string, env []string) e
 	trimmedHash := strings.TrimPrefix(imageInspect.ID, "sha256:")
 	imageHash := trimmedHash[:8]
 
+	if previous, err := models.RecordImageRun(image_name, imageInspect.ID); err != nil {
+		log.Printf("runContainer Error: models.RecordImageRun: %s", err)
+	} else if previous != "" {
+		lf.StepWriteToLog(jm.StepID, fmt.Sprintf("image updated since last run (previous image:%s)", util.ShortImageID(previous)), imageHash, image_name)
+	}
+
 	hostCfg := NewHostConfig(cf, job.Recipe.IsShared)
 
 	//user := os.Geteuid()
@@ -405,6 +432,19 @@ func runContainer(server *CmdServer, job *JobRecipe, uri string, env []string) e
 		return err
 	}
 
+	done := recordStepContainer(runCtx, resp.ID, job)
+	defer done()
+
+	timeout := time.Duration(job.ContainerTimeoutInSeconds) * time.Second
+	return followContainer(ctx, cli, job, resp.ID, image_name, imageHash, jm, cf, lf, fm.IndividualUsernameOrSharedUsername, time.Time{}, timeout)
+}
+
+// followContainer streams the output of a started step container to the log
+// file and the users of the job until the container exits, the step reports
+// an error or the timeout passes, then removes the container. since skips
+// output written before it, for containers followed again after a restart.
+func followContainer(ctx context.Context, cli *client.Client, job *JobRecipe, containerID, image_name, imageHash string, jm *util.JobMeta, cf *util.ContainerFiles, lf *util.LogFile, adminOrUsername string, since time.Time, timeout time.Duration) error {
+	var err error
 	var wg sync.WaitGroup
 	lemcErrCh := make(chan error, 1)
 	wg.Add(1)
@@ -415,8 +455,11 @@ func runContainer(server *CmdServer, job *JobRecipe, uri string, env []string) e
 			ShowStdout: true,
 			Follow:     true,
 		}
+		if !since.IsZero() {
+			logCfg.Since = fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())
+		}
 
-		out, err := cli.ContainerLogs(ctx, resp.ID, logCfg)
+		out, err := cli.ContainerLogs(ctx, containerID, logCfg)
 		if err != nil {
 			log.Println("ContainerLogError:", err)
 			return
@@ -445,35 +488,31 @@ func runContainer(server *CmdServer, job *JobRecipe, uri string, env []string) e
 			}
 
 			s := strings.TrimSpace(string(readBuf))
-
-			if strings.HasPrefix(s, LEMC_ERR) {
-				errMsg := strings.TrimPrefix(s, LEMC_ERR)
-				msg(LEMC_HTML_APPEND+errMsg, imageHash, image_name, job, jm, cf, lf)
-				msg(LEMC_HTML_APPEND+"job failed", imageHash, image_name, job, jm, cf, lf)
-				lemcErrCh <- fmt.Errorf("lemc err: %s", errMsg)
+			if err := stepOutput(s, imageHash, image_name, job, jm, cf, lf); err != nil {
+				lemcErrCh <- err
 				return
 			}
-
-			msg(s, imageHash, image_name, job, jm, cf, lf)
+			touchStepContainer(containerID)
 		}
 	}()
 
-	statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	statusCh, errCh := cli.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
 
 	doneTimeout := make(chan struct{})
-	go timeoutCleanup(ctx, cli, job, resp, doneTimeout)
+	go timeoutCleanup(ctx, cli, containerID, timeout, doneTimeout)
 
 	for {
 		select {
-		case <-statusCh:
+		case st := <-statusCh:
 			close(doneTimeout)
+			setStepExit(ctx, int(st.StatusCode))
 			removeOpts := container.RemoveOptions{
 				RemoveVolumes: true,
 				RemoveLinks:   false,
 				Force:         true,
 			}
 
-			err = cli.ContainerRemove(ctx, resp.ID, removeOpts)
+			err = cli.ContainerRemove(ctx, containerID, removeOpts)
 			if err != nil {
 				log.Println(err)
 				return err
@@ -482,20 +521,20 @@ func runContainer(server *CmdServer, job *JobRecipe, uri string, env []string) e
 			return nil
 		case err := <-lemcErrCh:
 			close(doneTimeout)
-			timeout := 10
-			_ = cli.ContainerStop(ctx, resp.ID, container.StopOptions{Timeout: &timeout})
+			stopTimeout := 10
+			_ = cli.ContainerStop(ctx, containerID, container.StopOptions{Timeout: &stopTimeout})
 			removeOpts := container.RemoveOptions{
 				RemoveVolumes: true,
 				RemoveLinks:   false,
 				Force:         true,
 			}
 
-			_ = cli.ContainerRemove(ctx, resp.ID, removeOpts)
+			_ = cli.ContainerRemove(ctx, containerID, removeOpts)
 			wg.Wait()
 			return err
 		case err := <-errCh:
 			close(doneTimeout)
-			errx := deletePreviousContainer(ctx, cli, job, fm.IndividualUsernameOrSharedUsername)
+			errx := deletePreviousContainer(ctx, cli, job, adminOrUsername)
 			if errx != nil {
 				log.Println(errx)
 			}
@@ -508,17 +547,32 @@ func runContainer(server *CmdServer, job *JobRecipe, uri string, env []string) e
 	}
 }
 
-func timeoutCleanup(ctx context.Context, cli *client.Client, job *JobRecipe, resp container.CreateResponse, doneTimeout chan struct{}) {
+// stepOutput passes a line of step output to the log file, the users of the
+// job and the result of its run. It returns the error a step reports with
+// LEMC_ERR.
+func stepOutput(s, imageHash, imageName string, job *JobRecipe, jm *util.JobMeta, cf *util.ContainerFiles, lf *util.LogFile) error {
+	collectOutput(job, s)
+	if strings.HasPrefix(s, LEMC_ERR) {
+		errMsg := strings.TrimPrefix(s, LEMC_ERR)
+		msg(LEMC_HTML_APPEND+errMsg, imageHash, imageName, job, jm, cf, lf)
+		msg(LEMC_HTML_APPEND+"job failed", imageHash, imageName, job, jm, cf, lf)
+		return fmt.Errorf("lemc err: %s", errMsg)
+	}
+	msg(s, imageHash, imageName, job, jm, cf, lf)
+	return nil
+}
+
+func timeoutCleanup(ctx context.Context, cli *client.Client, containerID string, timeout time.Duration, doneTimeout chan struct{}) {
 	select {
 	case <-doneTimeout:
-	case <-time.After(time.Duration(job.ContainerTimeoutInSeconds) * time.Second):
+	case <-time.After(timeout):
 		log.Println("Image Timeout exceeded")
 		timeout := 10
 		stopOpts := container.StopOptions{
 			Timeout: &timeout,
 		}
 
-		err := cli.ContainerStop(ctx, resp.ID, stopOpts)
+		err := cli.ContainerStop(ctx, containerID, stopOpts)
 		if err != nil {
 			log.Println(err)
 		}
@@ -0,0 +1,168 @@
+package yeschef
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/reugn/go-quartz/logger"
+	"github.com/reugn/go-quartz/quartz"
+)
+
+// ErrDeadLetterRunning is returned when a dead job is retried while its
+// recipe is running.
+var ErrDeadLetterRunning = errors.New("the recipe of this job is running")
+
+// ErrInvalidDeadLetter is returned when a retried job still cannot be decoded
+// or validated.
+var ErrInvalidDeadLetter = errors.New("dead letter job is invalid")
+
+// deadLetterRow moves a queued job that cannot run into the dead letter
+// store. It does not remove the row from its queue.
+func deadLetterRow(row *models.ScheduledJob, reason string, cause error) error {
+	dead := &models.DeadLetterJob{
+		Queue:      row.Queue,
+		JobKey:     row.JobKey,
+		RecipeName: row.RecipeName,
+		Username:   row.Username,
+		AccountID:  row.AccountID,
+		Reason:     reason,
+		Error:      cause.Error(),
+		Payload:    row.Payload,
+	}
+	if err := models.InsertDeadLetterJob(dead); err != nil {
+		logger.Errorf("Failed to dead letter job %s: %v", row.JobKey, err)
+		return err
+	}
+	logger.Warnf("Dead lettered %s job %s (%s): %v", row.Queue, row.JobKey, reason, cause)
+	return nil
+}
+
+// deadLetterJob stores a run once job that returned an error, so it can be
+// retried once the cause is fixed.
+func deadLetterJob(queue string, job quartz.ScheduledJob, cause error) {
+	jq := &jobQueue{Name: queue}
+	row, err := jq.newScheduledJobRow(job)
+	if err != nil {
+		logger.Errorf("Failed to dead letter job %s: %v", job.JobDetail().JobKey().Name(), err)
+		return
+	}
+	deadLetterRow(row, models.DeadLetterFailed, cause)
+}
+
+// failedRecipeJob rebuilds the now job that runs jr, for a recipe that failed
+// or was stopped by a shutdown.
+func failedRecipeJob(jr *JobRecipe) quartz.ScheduledJob {
+	kg := quartz.NewJobKeyWithGroup(LemcJobKey(jr, NOW_QUEUE), jobGroup(jr.UserID, jr.PageID, jr.UUID))
+	opts := quartz.NewDefaultJobDetailOptions()
+	opts.Replace = true
+	trigger := quartz.NewRunOnceTrigger(0)
+	trigger.Expired = true
+	return &scheduledLemcJob{
+		jobDetail:   quartz.NewJobDetailWithOptions(jr, kg, opts),
+		trigger:     trigger,
+		nextRunTime: time.Now().UnixNano(),
+	}
+}
+
+// failedStepJob rebuilds the run once job that runs sj, for a step that
+// failed or was stopped by a shutdown. It is keyed by the step's queue, in
+// or every.
+func failedStepJob(sj *StepJob) quartz.ScheduledJob {
+	queue, d, err := parseStepDo(sj.Step.Do)
+	if err != nil || queue == NOW_QUEUE {
+		queue = IN_QUEUE
+	}
+	rj := sj.RecipeJob
+	kg := quartz.NewJobKeyWithGroup(StepJobKey(rj, queue, sj.Step.Step), jobGroup(rj.UserID, rj.PageID, rj.UUID))
+	trigger := quartz.NewRunOnceTrigger(d)
+	trigger.Expired = true
+	return &scheduledLemcJob{
+		jobDetail:   quartz.NewJobDetail(sj, kg),
+		trigger:     trigger,
+		nextRunTime: time.Now().UnixNano(),
+	}
+}
+
+// queueByName returns the queue and scheduler of any of the three queues.
+func queueByName(name string) (*jobQueue, *quartz.StdScheduler, error) {
+	if name == NOW_QUEUE {
+		if XoxoX == nil || XoxoX.NowQueue == nil || XoxoX.NowScheduler == nil {
+			return nil, nil, ErrSchedulerNotStarted
+		}
+		return XoxoX.NowQueue, XoxoX.NowScheduler, nil
+	}
+	return managedQueue(name)
+}
+
+// RetryDeadLetterJob puts a dead job back in its queue to run right away and
+// removes it from the dead letter store. A non-empty payload replaces the
+// stored one, so a broken job can be fixed before it is retried. When the
+// job is still invalid it stays dead and the error is returned.
+func RetryDeadLetterJob(id int64, payload string) error {
+	if err := acceptingRuns(); err != nil {
+		return err
+	}
+	dead, err := models.DeadLetterJobByID(id)
+	if err != nil {
+		return err
+	}
+	if payload == "" {
+		payload = dead.Payload
+	}
+
+	jq, sched, err := queueByName(dead.Queue)
+	if err != nil {
+		return err
+	}
+	job, err := jq.unmarshal([]byte(payload))
+	if err == nil {
+		err = ValidateScheduledJob(job)
+	}
+	if err != nil {
+		if uerr := models.UpdateDeadLetterJob(id, err.Error(), payload); uerr != nil {
+			logger.Errorf("Failed to update dead letter job %d: %v", id, uerr)
+		}
+		return fmt.Errorf("%w: %v", ErrInvalidDeadLetter, err)
+	}
+
+	var running string
+	if rj := deadLetterRecipe(job.JobDetail().Job()); rj != nil {
+		running = LemcJobKey(rj, NOW_QUEUE)
+		if XoxoX.RunningMan.IsRunning(running) {
+			return ErrDeadLetterRunning
+		}
+	}
+	if dead.Queue == NOW_QUEUE {
+		// DoNow marks the recipe as running before it is queued.
+		XoxoX.RunningMan.Add(running)
+	}
+
+	retry := &scheduledLemcJob{
+		jobDetail:   job.JobDetail(),
+		trigger:     job.Trigger(),
+		nextRunTime: quartz.NowNano(),
+	}
+	if err := jq.Push(retry); err != nil {
+		if dead.Queue == NOW_QUEUE {
+			XoxoX.RunningMan.Remove(running)
+		}
+		return err
+	}
+	sched.Reset()
+	return models.DeleteDeadLetterJob(id)
+}
+
+// deadLetterRecipe returns the recipe a queued job belongs to.
+func deadLetterRecipe(job quartz.Job) *JobRecipe {
+	switch j := job.(type) {
+	case *JobRecipe:
+		return j
+	case *StepJob:
+		return j.RecipeJob
+	case *RecipeScheduleJob:
+		return j.RecipeJob
+	}
+	return nil
+}
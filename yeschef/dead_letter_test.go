@@ -0,0 +1,80 @@
+package yeschef
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/reugn/go-quartz/quartz"
+)
+
+func purgeDeadLetters(t *testing.T) {
+	t.Helper()
+	if _, err := models.PurgeDeadLetterJobs(); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	t.Cleanup(func() { models.PurgeDeadLetterJobs() })
+}
+
+func TestPopDeadLettersInvalidJob(t *testing.T) {
+	purgeDeadLetters(t)
+	q := newTestQueue(t, IN_QUEUE)
+	queueRawJob(t, q, "broken", 1, []byte(`{"job":{}}`))
+
+	if _, err := q.Pop(); err == nil {
+		t.Fatal("expected popping an invalid job to fail")
+	}
+	dead, total, err := models.DeadLetterJobs(1, 10)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if total != 1 || dead[0].JobKey != "broken" || dead[0].Reason != models.DeadLetterInvalid || dead[0].Payload != `{"job":{}}` {
+		t.Fatalf("unexpected dead letters: %+v", dead)
+	}
+}
+
+func TestRetryDeadLetterJob(t *testing.T) {
+	purgeDeadLetters(t)
+	// The scheduler is not started so the retried job stays queued.
+	q := newTestQueue(t, IN_QUEUE)
+	prevQueue, prevSched := XoxoX.InQueue, XoxoX.InScheduler
+	XoxoX.InQueue, XoxoX.InScheduler = q, quartz.NewStdSchedulerWithOptions(quartz.StdSchedulerOptions{}, q, nil)
+	t.Cleanup(func() { XoxoX.InQueue, XoxoX.InScheduler = prevQueue, prevSched })
+
+	job := testStepJob("retry", time.Now().Add(-time.Minute).UnixNano())
+	deadLetterJob(IN_QUEUE, job, errors.New("boom"))
+	dead, _, err := models.DeadLetterJobs(1, 10)
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("expected one dead letter, got %+v err=%v", dead, err)
+	}
+	if dead[0].Reason != models.DeadLetterFailed || dead[0].Error != "boom" || dead[0].RecipeName != "recipe" {
+		t.Fatalf("unexpected dead letter: %+v", dead[0])
+	}
+
+	// A payload that is still broken keeps the job dead with the new error.
+	if err := RetryDeadLetterJob(dead[0].ID, `{"job":{}}`); !errors.Is(err, ErrInvalidDeadLetter) {
+		t.Fatalf("expected ErrInvalidDeadLetter, got %v", err)
+	}
+	if got, err := models.DeadLetterJobByID(dead[0].ID); err != nil || got.Payload != `{"job":{}}` {
+		t.Fatalf("expected the edited payload to be kept, got %+v err=%v", got, err)
+	}
+
+	data, err := marshal(job)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if err := RetryDeadLetterJob(dead[0].ID, string(data)); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if _, err := models.DeadLetterJobByID(dead[0].ID); err == nil {
+		t.Fatal("expected the retried job to leave the dead letter store")
+	}
+	retried, err := q.Get(quartz.NewJobKey("retry"))
+	if err != nil {
+		t.Fatalf("retried job missing: %v", err)
+	}
+	if retried.NextRunTime() > time.Now().UnixNano() {
+		t.Fatalf("expected the retried job to be due now, next run %v", time.Unix(0, retried.NextRunTime()))
+	}
+}
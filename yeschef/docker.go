@@ -0,0 +1,105 @@
+package yeschef
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/jaredfolkins/letemcook/models"
+)
+
+// dockerHealthTimeout bounds the health check of a Docker endpoint.
+const dockerHealthTimeout = 3 * time.Second
+
+// jobDockerEndpoint returns the Docker endpoint the steps of job run on: the
+// one its cookbook picked, else its account's, else the default one.
+func jobDockerEndpoint(job *JobRecipe) string {
+	if job.DockerEndpoint != "" {
+		return job.DockerEndpoint
+	}
+	name, err := models.AccountDockerEndpoint(job.AccountID)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// DockerEndpointStatuses pings every Docker endpoint.
+func DockerEndpointStatuses() ([]models.DockerEndpointStatus, error) {
+	endpoints, err := models.DockerEndpoints()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]models.DockerEndpointStatus, len(endpoints))
+	var wg sync.WaitGroup
+	for i := range endpoints {
+		statuses[i].DockerEndpoint = endpoints[i]
+		wg.Add(1)
+		go func(s *models.DockerEndpointStatus) {
+			defer wg.Done()
+			checkDockerEndpoint(s)
+		}(&statuses[i])
+	}
+	wg.Wait()
+	return statuses, nil
+}
+
+func checkDockerEndpoint(s *models.DockerEndpointStatus) {
+	cli, err := s.Client()
+	if err != nil {
+		s.Error = err.Error()
+		return
+	}
+	defer cli.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), dockerHealthTimeout)
+	defer cancel()
+	v, err := cli.ServerVersion(ctx)
+	if err != nil {
+		s.Error = err.Error()
+		return
+	}
+	s.Healthy = true
+	s.Version = v.Version
+}
+
+// endpointClient is a Docker client of a named endpoint.
+type endpointClient struct {
+	name string
+	cli  *client.Client
+}
+
+// endpointClients returns a client for every reachable Docker endpoint, the
+// default one first. Endpoints that cannot be reached are logged and left
+// out. Close the clients with closeEndpointClients.
+func endpointClients() ([]endpointClient, error) {
+	endpoints, err := models.DockerEndpoints()
+	if err != nil {
+		return nil, err
+	}
+	var clients []endpointClient
+	for _, e := range endpoints {
+		cli, err := e.Client()
+		if err != nil {
+			log.Printf("docker endpoint %s: %v", e.Name, err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), dockerHealthTimeout)
+		_, err = cli.Ping(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("docker endpoint %s is not reachable: %v", e.Name, err)
+			cli.Close()
+			continue
+		}
+		clients = append(clients, endpointClient{name: e.Name, cli: cli})
+	}
+	return clients, nil
+}
+
+func closeEndpointClients(clients []endpointClient) {
+	for _, c := range clients {
+		c.cli.Close()
+	}
+}
@@ -0,0 +1,106 @@
+package yeschef
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// Lifecycle events of a run. They reach websocket users as a LEMC_EVENT
+// response and MCP clients as a notifications/lemc/event notification.
+const (
+	EventRunQueued    = "run.queued"
+	EventRunStarted   = "run.started"
+	EventStepStarted  = "step.started"
+	EventStepFinished = "step.finished"
+	EventRunFinished  = "run.finished"
+	EventRunFailed    = "run.failed"
+	EventRunCancelled = "run.cancelled"
+	mcpEventMethod    = "notifications/lemc/event"
+)
+
+// Event is a lifecycle event of a run.
+type Event struct {
+	Type       string    `json:"type"`
+	RunID      string    `json:"run_id"`
+	UUID       string    `json:"uuid"`
+	PageID     string    `json:"page_id"`
+	Scope      string    `json:"scope"`
+	Recipe     string    `json:"recipe"`
+	Step       int       `json:"step,omitempty"`
+	ExitCode   *int      `json:"exit_code,omitempty"`
+	DurationMS int64     `json:"duration_ms,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// emit sends a lifecycle event of the job's run to its users and MCP
+// clients.
+func emit(job *JobRecipe, ev Event) {
+	ev.RunID = job.RunID
+	ev.UUID = job.UUID
+	ev.PageID = job.PageID
+	ev.Scope = job.Scope
+	ev.Recipe = job.Recipe.Name
+	ev.Time = time.Now()
+	collectEvent(ev)
+	ended := recordRun(job, ev)
+
+	notification, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  mcpEventMethod,
+		"params":  ev,
+	})
+	if err != nil {
+		log.Printf("Error converting event to JSON: %v", err)
+		return
+	}
+	r := Response{
+		UUID:     job.UUID,
+		PageID:   job.PageID,
+		ViewType: job.Scope,
+		Cmd:      LEMC_EVENT,
+		Event:    &ev,
+	}
+	publish(job, r, notification)
+	if ended {
+		notifyRunEnded(job)
+	}
+}
+
+// emitRunEnd sends the event a run ended with: run.finished, run.cancelled
+// when its context was cancelled, or run.failed.
+func emitRunEnd(job *JobRecipe, started time.Time, err error) {
+	ev := Event{Type: EventRunFinished, DurationMS: time.Since(started).Milliseconds()}
+	switch {
+	case errors.Is(err, context.Canceled):
+		ev.Type = EventRunCancelled
+	case err != nil:
+		ev.Type = EventRunFailed
+		ev.Error = err.Error()
+	}
+	emit(job, ev)
+}
+
+// stepResult is filled in by the container of a step.
+type stepResult struct {
+	exitCode *int
+}
+
+type stepResultKey struct{}
+
+// withStepResult passes down where a step stores the exit code of its
+// container.
+func withStepResult(ctx context.Context, res *stepResult) context.Context {
+	return context.WithValue(ctx, stepResultKey{}, res)
+}
+
+// setStepExit stores the exit code of the step container, if the step
+// asked for it.
+func setStepExit(ctx context.Context, code int) {
+	if res, ok := ctx.Value(stepResultKey{}).(*stepResult); ok {
+		res.exitCode = &code
+	}
+}
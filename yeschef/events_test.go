@@ -0,0 +1,65 @@
+package yeschef
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// connectTestUser connects a websocket client of the user and returns it
+// once it got the replay marker.
+func connectTestUser(t *testing.T, userID int64) *Client {
+	t.Helper()
+	prev := XoxoX
+	XoxoX = &ChefsKiss{apps: make(map[int64]*CmdServer)}
+	t.Cleanup(func() { XoxoX = prev })
+
+	xrv := XoxoX.CreateInstance(userID)
+	xlient := &Client{Xerver: xrv, Xend: make(chan []byte, CLIENT_BUFFER_SIZE)}
+	xrv.Provision <- xlient
+	receive(t, xlient)
+	return xlient
+}
+
+func TestEmitSendsEventToUsers(t *testing.T) {
+	xlient := connectTestUser(t, 9043)
+
+	job := &JobRecipe{UUID: "u1", PageID: "2", Scope: "individual", UserID: "9043", RunID: "r1"}
+	job.Recipe.Name = "deploy"
+	code := 1
+	emit(job, Event{Type: EventStepFinished, Step: 3, ExitCode: &code})
+
+	m := receive(t, xlient)
+	if m.Cmd != LEMC_EVENT || m.Event == nil {
+		t.Fatalf("got %+v, want an event", m)
+	}
+	ev := m.Event
+	if ev.Type != EventStepFinished || ev.RunID != "r1" || ev.Recipe != "deploy" || ev.Step != 3 || *ev.ExitCode != 1 {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	if m.UUID != "u1" || m.PageID != "2" || m.ViewType != "individual" {
+		t.Fatalf("event not addressed to the view: %+v", m)
+	}
+}
+
+func TestEmitRunEndTypes(t *testing.T) {
+	xlient := connectTestUser(t, 9044)
+
+	job := &JobRecipe{UUID: "u1", PageID: "1", Scope: "individual", UserID: "9044", RunID: "r2"}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, EventRunFinished},
+		{errors.New("boom"), EventRunFailed},
+		{fmt.Errorf("step: %w", context.Canceled), EventRunCancelled},
+	}
+	for _, tt := range tests {
+		emitRunEnd(job, time.Now(), tt.err)
+		if m := receive(t, xlient); m.Event == nil || m.Event.Type != tt.want {
+			t.Fatalf("run ended with %v: got %+v, want %s", tt.err, m.Event, tt.want)
+		}
+	}
+}
@@ -1,27 +1,52 @@
 package yeschef
 
 import (
-	"os"
+	"strconv"
+	"strings"
 
-	"github.com/docker/docker/client"
+	"github.com/jaredfolkins/letemcook/models"
 )
 
 // CheckJobImages verifies that all step images for the given job
 // exist locally. It returns a slice of image names that are missing.
 func CheckJobImages(job *JobRecipe) ([]string, error) {
-	cli, err := client.NewClientWithOpts(
-		client.WithHost(os.Getenv("LEMC_DOCKER_HOST")),
-		client.WithAPIVersionNegotiation(),
-	)
+	cli, err := models.DockerClient(jobDockerEndpoint(job))
 	if err != nil {
 		return nil, err
 	}
+	defer cli.Close()
 
 	var missing []string
 	for _, st := range job.Recipe.Steps {
-		if !imageExists(cli, st.Image) {
+		if len(st.RunsOn) == 0 && !imageExists(cli, st.Image) {
 			missing = append(missing, st.Image)
 		}
 	}
 	return missing, nil
 }
+
+// CheckJobImagePolicy verifies every step image of the job against the
+// image policy of the given account. Violations are returned as a
+// UserVisibleError so callers can surface them directly.
+func CheckJobImagePolicy(job *JobRecipe, accountID int64) error {
+	policy, err := models.ImagePolicyByAccountID(accountID)
+	if err != nil {
+		return err
+	}
+
+	pageID, _ := strconv.Atoi(job.PageID)
+	violations := policy.CheckRecipe(pageID, job.Recipe)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	var reasons []string
+	for _, v := range violations {
+		reasons = append(reasons, v.Image+": "+v.Reason)
+	}
+	return NewUserVisibleError(
+		"IMAGE_POLICY",
+		"image policy violation: "+strings.Join(reasons, ", "),
+		map[string]interface{}{"violations": violations},
+	)
+}
@@ -0,0 +1,150 @@
+package yeschef
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/jaredfolkins/letemcook/util"
+)
+
+// DefaultImageCheckInterval is used when LEMC_IMAGE_CHECK_INTERVAL is unset.
+const DefaultImageCheckInterval = 6 * time.Hour
+
+var imageDriftMu sync.Mutex
+
+// imageCheckInterval reads LEMC_IMAGE_CHECK_INTERVAL as a Go duration.
+// "0" or "off" disables the background checker.
+func imageCheckInterval() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("LEMC_IMAGE_CHECK_INTERVAL"))
+	switch raw {
+	case "":
+		return DefaultImageCheckInterval
+	case "0", "off", "false":
+		return 0
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		log.Printf("invalid LEMC_IMAGE_CHECK_INTERVAL %q, using %s", raw, DefaultImageCheckInterval)
+		return DefaultImageCheckInterval
+	}
+	return d
+}
+
+// StartImageDriftChecker periodically checks every image referenced by a
+// cookbook or app against its registry until ctx is cancelled.
+func StartImageDriftChecker(ctx context.Context) {
+	interval := imageCheckInterval()
+	if interval == 0 {
+		log.Println("image drift checker disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := CheckImageDrift(); err != nil {
+					log.Printf("image drift check: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// CheckImageDrift compares every referenced image with its registry, records
+// the result and pulls images whose steps use the scheduled pull policy. The
+// local copies on every Docker endpoint are checked. Concurrent calls are
+// skipped while a check is already running.
+func CheckImageDrift() error {
+	if !imageDriftMu.TryLock() {
+		return nil
+	}
+	defer imageDriftMu.Unlock()
+
+	refs, err := models.CollectImageRefs()
+	if err != nil {
+		return err
+	}
+
+	clients, err := endpointClients()
+	if err != nil {
+		return err
+	}
+	defer closeEndpointClients(clients)
+	if len(clients) == 0 {
+		return fmt.Errorf("no docker endpoint is reachable")
+	}
+
+	for _, ref := range refs {
+		if err := checkImageDrift(clients, ref); err != nil {
+			log.Printf("image drift check %s: %v", ref.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkImageDrift checks ref on every endpoint. The first endpoint, the
+// default one when it is reachable, keeps a copy of every referenced image.
+// The others only keep up the images they already have. The image counts as
+// drifted when any copy did.
+func checkImageDrift(clients []endpointClient, ref models.ImageRef) error {
+	spec := ImageSpec{Name: ref.Name, RegistryAuth: ref.RegistryAuth, PullPolicy: ref.PullPolicy}
+	first := clients[0].cli
+
+	// Digest pinned references cannot drift.
+	if strings.Contains(ref.Name, "@") {
+		return models.RecordImageDrift(ref.Name, localImageID(first, ref.Name), "", false)
+	}
+
+	normalized, _, _, err := util.NormalizeImageName(ref.Name)
+	if err != nil {
+		return err
+	}
+
+	remoteDigest, err := getRemoteImageDigest(first, spec)
+	if err != nil {
+		return err
+	}
+
+	drifted := false
+	for i, ep := range clients {
+		inspect, _, inspectErr := ep.cli.ImageInspectWithRaw(context.Background(), normalized)
+		exists := inspectErr == nil
+		if !exists && i > 0 {
+			continue
+		}
+
+		epDrifted := exists && remoteDigest != "" && !digestMatches(inspect, remoteDigest)
+		if ref.PullPolicy == models.PullPolicyScheduled && (epDrifted || !exists) {
+			if err := forcePullImage(ep.cli, spec, models.ImagePullSourceScheduled, ""); err != nil {
+				log.Printf("scheduled pull %s on %s: %v", ref.Name, ep.name, err)
+			} else {
+				epDrifted = false
+			}
+		}
+		drifted = drifted || epDrifted
+	}
+
+	return models.RecordImageDrift(ref.Name, localImageID(first, ref.Name), remoteDigest, drifted)
+}
+
+// digestMatches reports whether the local image corresponds to the remote manifest digest.
+func digestMatches(inspect types.ImageInspect, remoteDigest string) bool {
+	for _, rd := range inspect.RepoDigests {
+		if strings.HasSuffix(rd, "@"+remoteDigest) {
+			return true
+		}
+	}
+	return strings.HasPrefix(inspect.ID, remoteDigest)
+}
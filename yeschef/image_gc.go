@@ -0,0 +1,287 @@
+package yeschef
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/jaredfolkins/letemcook/util"
+	"github.com/reugn/go-quartz/quartz"
+)
+
+// DefaultImageGCRetention is the minimum time an image must have been unused
+// before the scheduled collector removes it.
+const DefaultImageGCRetention = 30 * 24 * time.Hour
+
+var imageGCMu sync.Mutex
+
+// imageGCSettings reads LEMC_IMAGE_GC_INTERVAL and LEMC_IMAGE_GC_RETENTION.
+// The scheduled collector is disabled unless an interval is set.
+func imageGCSettings() (time.Duration, time.Duration) {
+	var interval time.Duration
+	if raw := strings.TrimSpace(os.Getenv("LEMC_IMAGE_GC_INTERVAL")); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			log.Printf("invalid LEMC_IMAGE_GC_INTERVAL %q, image gc disabled", raw)
+		} else {
+			interval = d
+		}
+	}
+
+	retention := DefaultImageGCRetention
+	if raw := strings.TrimSpace(os.Getenv("LEMC_IMAGE_GC_RETENTION")); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			log.Printf("invalid LEMC_IMAGE_GC_RETENTION %q, using %s", raw, DefaultImageGCRetention)
+		} else {
+			retention = d
+		}
+	}
+	return interval, retention
+}
+
+// StartImageGC periodically removes images that have been unused for longer
+// than the configured retention window until ctx is cancelled.
+func StartImageGC(ctx context.Context) {
+	interval, retention := imageGCSettings()
+	if interval == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				report, err := PruneUnusedImages(false, retention)
+				if err != nil {
+					log.Printf("image gc: %v", err)
+					continue
+				}
+				log.Printf("image gc: removed %d images, reclaimed %d bytes", len(report.Removed), report.Reclaimable)
+			}
+		}
+	}()
+}
+
+// referencedImages returns the normalized names of every image referenced by
+// a cookbook, an app or a job waiting in one of the scheduler queues.
+func referencedImages() (map[string]struct{}, error) {
+	names, err := models.CollectImages()
+	if err != nil {
+		return nil, err
+	}
+
+	if XoxoX != nil {
+		for _, q := range []*jobQueue{XoxoX.NowQueue, XoxoX.InQueue, XoxoX.EveryQueue} {
+			if q == nil {
+				continue
+			}
+			jobs, err := q.ScheduledJobs(nil)
+			if err != nil {
+				return nil, fmt.Errorf("scheduled jobs %s: %w", q.Name, err)
+			}
+			for _, sj := range jobs {
+				names = append(names, scheduledJobImages(sj)...)
+			}
+		}
+	}
+
+	refs := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		if key, ok := imageRefKey(name); ok {
+			refs[key] = struct{}{}
+		}
+	}
+	return refs, nil
+}
+
+// imageRefKey normalizes an image reference, a tag or a digest of a local
+// image, so a cookbook reference matches the local image it names. A
+// reference pinned to a digest is keyed by repository and digest, the way
+// docker lists the RepoDigests of an image pulled by digest.
+func imageRefKey(ref string) (string, bool) {
+	if strings.Contains(ref, "@") {
+		repo, digest := models.SplitImageReference(ref)
+		return repo + "@" + digest, digest != ""
+	}
+	normalized, _, _, err := util.NormalizeImageName(ref)
+	return normalized, err == nil
+}
+
+func scheduledJobImages(sj quartz.ScheduledJob) []string {
+	var recipe *JobRecipe
+	var names []string
+	switch j := sj.JobDetail().Job().(type) {
+	case *StepJob:
+		names = append(names, j.Step.Image)
+		recipe = j.RecipeJob
+	case *JobRecipe:
+		recipe = j
+	}
+	if recipe != nil {
+		for _, st := range recipe.Recipe.Steps {
+			names = append(names, st.Image)
+		}
+	}
+	return names
+}
+
+// CollectUnusedImages lists the local images of every Docker endpoint that
+// LEMC pulled, ran or referenced and that are now referenced by no cookbook,
+// app or scheduled job and are not used by any container. Images LEMC never
+// used are left alone.
+func CollectUnusedImages() ([]models.UnusedImage, error) {
+	clients, err := endpointClients()
+	if err != nil {
+		return nil, err
+	}
+	defer closeEndpointClients(clients)
+	return collectUnusedImages(clients)
+}
+
+func collectUnusedImages(clients []endpointClient) ([]models.UnusedImage, error) {
+	refs, err := referencedImages()
+	if err != nil {
+		return nil, err
+	}
+
+	lastUsed, err := models.ImageLastUsed()
+	if err != nil {
+		return nil, err
+	}
+
+	var unused []models.UnusedImage
+	var referenced []string
+	for _, ep := range clients {
+		images, err := ep.cli.ImageList(context.Background(), image.ListOptions{ContainerCount: true})
+		if err != nil {
+			log.Printf("image gc: list images of docker endpoint %s: %v", ep.name, err)
+			continue
+		}
+		epUnused, epReferenced := unusedImages(images, refs, lastUsed)
+		for i := range epUnused {
+			epUnused[i].Endpoint = ep.name
+		}
+		unused = append(unused, epUnused...)
+		referenced = append(referenced, epReferenced...)
+	}
+
+	// Referenced images are in use now, their retention starts over once
+	// nothing references them anymore.
+	if err := models.TouchImages(referenced...); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(unused, func(i, j int) bool { return unused[i].LastUsed.Before(unused[j].LastUsed) })
+	return unused, nil
+}
+
+// unusedImages splits the local images of an endpoint into the ones LEMC
+// used that are no longer referenced and the IDs of the referenced ones.
+func unusedImages(images []image.Summary, refs map[string]struct{}, lastUsed map[string]time.Time) ([]models.UnusedImage, []string) {
+	var unused []models.UnusedImage
+	var referenced []string
+	for _, img := range images {
+		if img.Containers > 0 {
+			continue
+		}
+
+		var tags []string
+		used := false
+		for _, tag := range img.RepoTags {
+			if tag == "<none>:<none>" {
+				continue
+			}
+			tags = append(tags, tag)
+			if key, ok := imageRefKey(tag); ok {
+				if _, ok := refs[key]; ok {
+					used = true
+				}
+			}
+		}
+		// An image pulled by digest has no tags, only its digests.
+		for _, digest := range img.RepoDigests {
+			if key, ok := imageRefKey(digest); ok {
+				if _, ok := refs[key]; ok {
+					used = true
+				}
+			}
+		}
+		if used {
+			referenced = append(referenced, img.ID)
+			continue
+		}
+
+		last, ok := lastUsed[img.ID]
+		if !ok {
+			continue
+		}
+		unused = append(unused, models.UnusedImage{
+			ID:       img.ID,
+			Tags:     tags,
+			Size:     img.Size,
+			Created:  time.Unix(img.Created, 0),
+			LastUsed: last,
+		})
+	}
+
+	return unused, referenced
+}
+
+// PruneUnusedImages removes unused images last used more than retention ago
+// from every Docker endpoint. With dryRun set nothing is removed and the
+// report lists what would be.
+func PruneUnusedImages(dryRun bool, retention time.Duration) (*models.ImageGCReport, error) {
+	if !imageGCMu.TryLock() {
+		return nil, fmt.Errorf("image gc already running")
+	}
+	defer imageGCMu.Unlock()
+
+	clients, err := endpointClients()
+	if err != nil {
+		return nil, err
+	}
+	defer closeEndpointClients(clients)
+
+	unused, err := collectUnusedImages(clients)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make(map[string]*client.Client, len(clients))
+	for _, ep := range clients {
+		endpoints[ep.name] = ep.cli
+	}
+
+	report := &models.ImageGCReport{DryRun: dryRun, Retention: retention}
+	cutoff := time.Now().Add(-retention)
+	for _, img := range unused {
+		if img.LastUsed.After(cutoff) {
+			continue
+		}
+		report.Candidates = append(report.Candidates, img)
+		if dryRun {
+			report.Reclaimable += img.Size
+			continue
+		}
+
+		_, err := endpoints[img.Endpoint].ImageRemove(context.Background(), img.ID, image.RemoveOptions{PruneChildren: true})
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s on %s: %v", util.ShortImageID(img.ID), img.Endpoint, err))
+			continue
+		}
+		report.Removed = append(report.Removed, img.ID)
+		report.Reclaimable += img.Size
+	}
+	return report, nil
+}
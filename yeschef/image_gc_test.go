@@ -0,0 +1,56 @@
+package yeschef
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/reugn/go-quartz/quartz"
+)
+
+func TestScheduledJobImages(t *testing.T) {
+	jr := &JobRecipe{Recipe: models.Recipe{Steps: []models.Step{{Step: 1, Image: "alpine"}, {Step: 2, Image: "busybox"}}}}
+	sj := &scheduledLemcJob{
+		jobDetail: quartz.NewJobDetail(&StepJob{Step: models.Step{Image: "alpine"}, RecipeJob: jr}, quartz.NewJobKey("k")),
+	}
+	got := scheduledJobImages(sj)
+	if len(got) != 3 || got[0] != "alpine" || got[2] != "busybox" {
+		t.Fatalf("unexpected images: %v", got)
+	}
+
+	sj = &scheduledLemcJob{jobDetail: quartz.NewJobDetail(jr, quartz.NewJobKey("k2"))}
+	if got := scheduledJobImages(sj); len(got) != 2 {
+		t.Fatalf("unexpected images: %v", got)
+	}
+}
+
+func TestUnusedImages(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	images := []image.Summary{
+		{ID: "sha256:referenced", RepoTags: []string{"alpine:latest"}},
+		{ID: "sha256:dropped", RepoTags: []string{"busybox:latest"}, Created: old.Unix()},
+		{ID: "sha256:foreign", RepoTags: []string{"postgres:16"}},
+		{ID: "sha256:running", RepoTags: []string{"redis:7"}, Containers: 1},
+		{ID: "sha256:pinned", RepoTags: []string{"<none>:<none>"}, RepoDigests: []string{"ghcr.io/acme/tool@sha256:abc"}},
+	}
+	refs := make(map[string]struct{})
+	for _, ref := range []string{"alpine", "ghcr.io/acme/tool:1.2@sha256:abc"} {
+		key, _ := imageRefKey(ref)
+		refs[key] = struct{}{}
+	}
+	lastUsed := map[string]time.Time{
+		"sha256:referenced": old,
+		"sha256:pinned":     old,
+		"sha256:dropped":    old,
+		"sha256:running":    old,
+	}
+
+	unused, referenced := unusedImages(images, refs, lastUsed)
+	if len(referenced) != 2 || referenced[0] != "sha256:referenced" || referenced[1] != "sha256:pinned" {
+		t.Fatalf("referenced = %v", referenced)
+	}
+	if len(unused) != 1 || unused[0].ID != "sha256:dropped" || !unused[0].LastUsed.Equal(old) {
+		t.Fatalf("expected only the image LEMC used and no longer references, got %+v", unused)
+	}
+}
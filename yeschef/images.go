@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"strings"
 	"time"
 
@@ -20,6 +19,7 @@ import (
 	"github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/errdefs"
+	"github.com/jaredfolkins/letemcook/models"
 	"github.com/jaredfolkins/letemcook/util"
 
 	dockerTypes "github.com/docker/docker/api/types" // Alias to avoid conflict
@@ -35,6 +35,8 @@ type ImageSpec struct {
 	// - "basic:USER:PASSWORD" or "basic:b64(USER:PASSWORD)" for basic auth.
 	// - If no prefix, defaults to basic auth (USER:PASSWORD or b64(USER:PASSWORD)).
 	RegistryAuth string `yaml:"registry_auth,omitempty"`
+	// PullPolicy is one of models.PullPolicyAlways, PullPolicyIfNotPresent or PullPolicyScheduled.
+	PullPolicy string `yaml:"pull_policy,omitempty"`
 }
 
 // getECRAuthToken fetches an ECR authorization token using provided AWS credentials.
@@ -264,53 +266,60 @@ func handleImagePull(cli *client.Client, spec ImageSpec) error {
 	}
 
 	if needsPull {
-		log.Printf("Attempting to pull image reference: %s", pullRef)
+		return pullImageRef(cli, spec, pullRef)
+	}
 
-		// Build auth header for the pull operation using the potentially derived token
-		header, err := buildAuthHeader(spec) // Pass the full spec
-		if err != nil {
-			return fmt.Errorf("failed to prepare authentication for image pull %s: %w", spec.Name, err)
-		}
+	return nil
+}
 
-		opts := dockerTypes.ImagePullOptions{}
-		if header != "" {
-			log.Printf("Using generated registry credentials header for image pull: %s", pullRef)
-			opts.RegistryAuth = header
-		} else {
-			log.Printf("No specific registry credentials provided or derived for image pull: %s. Relying on local Docker config.", pullRef)
-		}
+// pullImageRef pulls the normalized reference unconditionally using the auth in spec.
+func pullImageRef(cli *client.Client, spec ImageSpec, pullRef string) error {
+	ctx := context.Background()
+	log.Printf("Attempting to pull image reference: %s", pullRef)
 
-		stream, err := cli.ImagePull(ctx, pullRef, opts)
-		if err != nil {
-			if errdefs.IsUnauthorized(err) {
-				log.Printf("Authentication failed for image pull %s. Check registry_auth or local Docker config.", pullRef)
-				if header != "" {
-					return fmt.Errorf("authentication failed using provided/derived credentials for image pull %s: %w", pullRef, err)
-				}
-				return fmt.Errorf("authentication failed for image pull %s (check local Docker config): %w", pullRef, err)
-			}
-			if errdefs.IsNotFound(err) {
-				return fmt.Errorf("image %s not found in registry: %w", pullRef, err)
+	// Build auth header for the pull operation using the potentially derived token
+	header, err := buildAuthHeader(spec) // Pass the full spec
+	if err != nil {
+		return fmt.Errorf("failed to prepare authentication for image pull %s: %w", spec.Name, err)
+	}
+
+	opts := dockerTypes.ImagePullOptions{}
+	if header != "" {
+		log.Printf("Using generated registry credentials header for image pull: %s", pullRef)
+		opts.RegistryAuth = header
+	} else {
+		log.Printf("No specific registry credentials provided or derived for image pull: %s. Relying on local Docker config.", pullRef)
+	}
+
+	stream, err := cli.ImagePull(ctx, pullRef, opts)
+	if err != nil {
+		if errdefs.IsUnauthorized(err) {
+			log.Printf("Authentication failed for image pull %s. Check registry_auth or local Docker config.", pullRef)
+			if header != "" {
+				return fmt.Errorf("authentication failed using provided/derived credentials for image pull %s: %w", pullRef, err)
 			}
-			return fmt.Errorf("failed to pull image %s: %w", pullRef, err)
+			return fmt.Errorf("authentication failed for image pull %s (check local Docker config): %w", pullRef, err)
 		}
-		defer stream.Close()
-
-		pullOutput, err := io.ReadAll(stream)
-		if err != nil {
-			log.Printf("Warning: Error reading image pull stream for %s: %v", pullRef, err)
-			return fmt.Errorf("error occurred during image pull stream for %s: %w", pullRef, err)
+		if errdefs.IsNotFound(err) {
+			return fmt.Errorf("image %s not found in registry: %w", pullRef, err)
 		}
+		return fmt.Errorf("failed to pull image %s: %w", pullRef, err)
+	}
+	defer stream.Close()
 
-		outputStr := string(pullOutput)
-		if strings.Contains(outputStr, "\"errorDetail\"") || strings.Contains(outputStr, "\"error\"") {
-			log.Printf("Image pull stream for %s contained error details: %s", pullRef, outputStr)
-			return fmt.Errorf("image pull for %s completed with errors reported in stream", pullRef)
-		}
+	pullOutput, err := io.ReadAll(stream)
+	if err != nil {
+		log.Printf("Warning: Error reading image pull stream for %s: %v", pullRef, err)
+		return fmt.Errorf("error occurred during image pull stream for %s: %w", pullRef, err)
+	}
 
-		log.Printf("Successfully initiated pull for image: %s", pullRef)
+	outputStr := string(pullOutput)
+	if strings.Contains(outputStr, "\"errorDetail\"") || strings.Contains(outputStr, "\"error\"") {
+		log.Printf("Image pull stream for %s contained error details: %s", pullRef, outputStr)
+		return fmt.Errorf("image pull for %s completed with errors reported in stream", pullRef)
 	}
 
+	log.Printf("Successfully initiated pull for image: %s", pullRef)
 	return nil
 }
 
@@ -357,15 +366,65 @@ func getRemoteImageDigest(cli *client.Client, spec ImageSpec) (string, error) {
 }
 
 // PullImage creates a Docker client and pulls the specified image if needed.
-func PullImage(spec ImageSpec) error {
-	cli, err := client.NewClientWithOpts(
-		client.WithHost(os.Getenv("LEMC_DOCKER_HOST")),
-		client.WithAPIVersionNegotiation(),
-	)
+// The attempt is recorded in the image pull audit trail under actor.
+func PullImage(spec ImageSpec, actor string) error {
+	cli, err := models.DockerClient("")
 	if err != nil {
 		return err
 	}
-	return handleImagePull(cli, spec)
+	defer cli.Close()
+	return auditImagePull(cli, spec, models.ImagePullSourceManual, actor, func() error {
+		return handleImagePull(cli, spec)
+	})
+}
+
+// forcePullImage pulls the image regardless of what is cached locally and
+// records the attempt in the image pull audit trail.
+func forcePullImage(cli *client.Client, spec ImageSpec, source, actor string) error {
+	pullRef, _, _, err := util.NormalizeImageName(spec.Name)
+	if err != nil {
+		return fmt.Errorf("failed to parse image name '%s': %w", spec.Name, err)
+	}
+	return auditImagePull(cli, spec, source, actor, func() error {
+		return pullImageRef(cli, spec, pullRef)
+	})
+}
+
+// auditImagePull runs pull and stores the local image ID before and after it.
+func auditImagePull(cli *client.Client, spec ImageSpec, source, actor string, pull func() error) error {
+	entry := &models.ImagePull{
+		Image:      spec.Name,
+		Source:     source,
+		Actor:      actor,
+		PreviousID: localImageID(cli, spec.Name),
+	}
+
+	pullErr := pull()
+	if pullErr != nil {
+		entry.Error = pullErr.Error()
+	}
+	entry.NewID = localImageID(cli, spec.Name)
+	if err := models.TouchImages(entry.NewID); err != nil {
+		log.Printf("Warning: failed to record use of image %s: %v", spec.Name, err)
+	}
+
+	if err := models.InsertImagePull(entry); err != nil {
+		log.Printf("Warning: failed to record image pull for %s: %v", spec.Name, err)
+	}
+	return pullErr
+}
+
+// localImageID returns the ID of the local copy of imageRef, or an empty string if it is not present.
+func localImageID(cli *client.Client, imageRef string) string {
+	normalized, _, _, err := util.NormalizeImageName(imageRef)
+	if err != nil {
+		return ""
+	}
+	inspect, _, err := cli.ImageInspectWithRaw(context.Background(), normalized)
+	if err != nil {
+		return ""
+	}
+	return inspect.ID
 }
 
 // Deprecated functions commented out
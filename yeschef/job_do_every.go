@@ -30,7 +30,7 @@ func DoEvery(st models.Step, job *JobRecipe) error {
 		return err
 	}
 
-	k := LemcJobKey(job, EVERY_QUEUE)
+	k := StepJobKey(job, EVERY_QUEUE, st.Step)
 	// Remove the NOW job check from here - it should only be checked when the scheduled job executes
 	// nowKey := LemcJobKey(job, NOW_QUEUE)
 	// if XoxoX.RunningMan.IsRunning(nowKey) {
@@ -40,7 +40,11 @@ func DoEvery(st models.Step, job *JobRecipe) error {
 	dij := &StepJob{Step: st, RecipeJob: job}
 	kg := quartz.NewJobKeyWithGroup(k, jobGroup(job.UserID, job.PageID, job.UUID))
 	detail := quartz.NewJobDetail(dij, kg)
-	err = XoxoX.EveryScheduler.ScheduleJob(detail, quartz.NewSimpleTrigger(time.Duration(digit)*ts))
+	trigger, err := NewStepTrigger(st, time.Duration(digit)*ts)
+	if err != nil {
+		return err
+	}
+	err = XoxoX.EveryScheduler.ScheduleJob(detail, trigger)
 	if err != nil {
 		return err
 	}
@@ -28,7 +28,7 @@ func DoIn(st models.Step, job *JobRecipe) error {
 		return err
 	}
 
-	k := LemcJobKey(job, IN_QUEUE)
+	k := StepJobKey(job, IN_QUEUE, st.Step)
 
 	dij := &StepJob{Step: st, RecipeJob: job}
 	kg := quartz.NewJobKeyWithGroup(k, jobGroup(job.UserID, job.PageID, job.UUID))
@@ -4,12 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
+	"github.com/google/uuid"
+	"github.com/jaredfolkins/letemcook/models"
 	"github.com/jaredfolkins/letemcook/util"
 	"github.com/reugn/go-quartz/quartz"
 )
@@ -60,27 +61,36 @@ func PerRecipeDeleteRunningContainersByStep(cli *client.Client, job *JobRecipe,
 
 func PerRecipeDeleteAnyExistingJobs(jr *JobRecipe) {
 	nowkey := LemcJobKey(jr, NOW_QUEUE)
-	inkey := LemcJobKey(jr, IN_QUEUE)
-	everykey := LemcJobKey(jr, EVERY_QUEUE)
-
 	XoxoX.NowScheduler.DeleteJob(quartz.NewJobKey(nowkey))
 	XoxoX.NowQueue.Remove(quartz.NewJobKey(nowkey))
 
-	XoxoX.InScheduler.DeleteJob(quartz.NewJobKey(inkey))
-	XoxoX.InQueue.Remove(quartz.NewJobKey(inkey))
-
-	XoxoX.EveryScheduler.DeleteJob(quartz.NewJobKey(everykey))
-	XoxoX.EveryQueue.Remove(quartz.NewJobKey(everykey))
+	// Steps queued by earlier versions share the key of their queue.
+	inkeys := []string{LemcJobKey(jr, IN_QUEUE)}
+	everykeys := []string{LemcJobKey(jr, EVERY_QUEUE)}
+	for _, st := range jr.Recipe.Steps {
+		inkeys = append(inkeys, StepJobKey(jr, IN_QUEUE, st.Step))
+		everykeys = append(everykeys, StepJobKey(jr, EVERY_QUEUE, st.Step))
+	}
+	for _, k := range inkeys {
+		XoxoX.InScheduler.DeleteJob(quartz.NewJobKey(k))
+		XoxoX.InQueue.Remove(quartz.NewJobKey(k))
+	}
+	for _, k := range everykeys {
+		XoxoX.EveryScheduler.DeleteJob(quartz.NewJobKey(k))
+		XoxoX.EveryQueue.Remove(quartz.NewJobKey(k))
+	}
 }
 
 func DoNow(jr *JobRecipe) error {
-	cli, err := client.NewClientWithOpts(
-		client.WithHost(os.Getenv("LEMC_DOCKER_HOST")),
-		client.WithAPIVersionNegotiation(),
-	)
+	if err := acceptingRuns(); err != nil {
+		return err
+	}
+
+	cli, err := models.DockerClient(jobDockerEndpoint(jr))
 	if err != nil {
 		return fmt.Errorf("failed to create Docker client: %w", err)
 	}
+	defer cli.Close()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
@@ -93,7 +103,16 @@ func DoNow(jr *JobRecipe) error {
 	var missingImages []string
 
 	for _, st := range jr.Recipe.Steps {
-		imageSpec := ImageSpec{Name: st.Image, RegistryAuth: st.RegistryAuth}
+		if len(st.RunsOn) > 0 {
+			// Pulled by the worker the step runs on.
+			continue
+		}
+		imageSpec := ImageSpec{Name: st.Image, RegistryAuth: st.RegistryAuth, PullPolicy: st.PullPolicy}
+		if imageSpec.PullPolicy == models.PullPolicyAlways {
+			if err := forcePullImage(cli, imageSpec, models.ImagePullSourceJob, jr.Username); err != nil {
+				log.Printf("Error pulling image %s with pull_policy always: %v", imageSpec.Name, err)
+			}
+		}
 		if !imageExists(cli, imageSpec.Name) {
 			log.Printf("Image %s not found locally, attempting to pull with auth (if provided)", imageSpec.Name)
 			err := handleImagePull(cli, imageSpec)
@@ -106,7 +125,7 @@ func DoNow(jr *JobRecipe) error {
 
 	if len(missingImages) == 0 {
 		for _, st := range jr.Recipe.Steps {
-			if !imageExists(cli, st.Image) {
+			if len(st.RunsOn) == 0 && !imageExists(cli, st.Image) {
 				log.Printf("Image %s still missing after pull attempt.", st.Image)
 				missingImages = append(missingImages, st.Image)
 			}
@@ -147,6 +166,10 @@ func DoNow(jr *JobRecipe) error {
 		}
 	}
 
+	// Callers that watch the run pick its id beforehand.
+	if jr.RunID == "" {
+		jr.RunID = uuid.NewString()
+	}
 	detail := quartz.NewJobDetailWithOptions(jr, kg, jdo)
 	if err := XoxoX.NowScheduler.ScheduleJob(detail, quartz.NewRunOnceTrigger(time.Millisecond*100)); err != nil {
 		XoxoX.RunningMan.mu.Lock()
@@ -154,6 +177,7 @@ func DoNow(jr *JobRecipe) error {
 		XoxoX.RunningMan.mu.Unlock()
 		return fmt.Errorf("failed to schedule job: %w", err)
 	}
+	emit(jr, Event{Type: EventRunQueued})
 
 	return nil
 }
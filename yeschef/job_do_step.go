@@ -4,11 +4,12 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/jaredfolkins/letemcook/models"
 )
 
-func DoStep(ctx context.Context, job *JobRecipe, st models.Step) error {
+func DoStep(ctx context.Context, job *JobRecipe, st models.Step) (err error) {
 	userid, err := strconv.ParseInt(job.UserID, 10, 64)
 	if err != nil {
 		return err
@@ -17,22 +18,7 @@ func DoStep(ctx context.Context, job *JobRecipe, st models.Step) error {
 	xserver := XoxoX.CreateInstance(userid)
 
 	// Prepare step-specific environment variables
-	// Start with a copy of the job-level environment variables
-	stepEnv := make([]string, len(job.Env))
-	copy(stepEnv, job.Env)
-
-	// Append system-defined step env vars
-	stepEnv = append(stepEnv, PYTHON_UNBUFFERED)
-	stepEnv = append(stepEnv, fmt.Sprintf(STEP_ID, st.Step))
-	stepEnv = append(stepEnv, fmt.Sprintf(LEMC_HTML_ID, job.UUID, job.PageID, job.Scope))
-	stepEnv = append(stepEnv, fmt.Sprintf(LEMC_CSS_ID, job.UUID, job.PageID, job.Scope))
-	stepEnv = append(stepEnv, fmt.Sprintf(LEMC_JS_ID, job.UUID, job.PageID, job.Scope))
-
-	// Append user-defined env vars from the step configuration
-	stepEnvVars := st.GetEnvironment()
-	if len(stepEnvVars) > 0 {
-		stepEnv = append(stepEnv, stepEnvVars...)
-	}
+	stepEnv := stepEnvironment(job, st)
 
 	// Work on a copy of the job to avoid races when multiple steps run concurrently
 	jobCopy := *job
@@ -43,10 +29,30 @@ func DoStep(ctx context.Context, job *JobRecipe, st models.Step) error {
 		return err
 	}
 
-	err = runContainer(xserver, &jobCopy, st.Image, stepEnv)
+	started := time.Now()
+	emit(&jobCopy, Event{Type: EventStepStarted, Step: st.Step})
+	res := &stepResult{}
+	ctx = withStepResult(ctx, res)
+	defer func() {
+		ev := Event{Type: EventStepFinished, Step: st.Step, ExitCode: res.exitCode, DurationMS: time.Since(started).Milliseconds()}
+		if err != nil {
+			ev.Error = err.Error()
+		}
+		emit(&jobCopy, ev)
+	}()
+
+	if len(st.RunsOn) > 0 {
+		if err = runOnWorker(ctx, &jobCopy, st, stepEnv); err != nil {
+			err = fmt.Errorf("runOnWorker failed: %w", err)
+			return err
+		}
+		return nil
+	}
+
+	err = runContainer(ctx, xserver, &jobCopy, st.Image, stepEnv)
 	if err != nil {
-		e := fmt.Errorf("runContainer failed: %v", err)
-		return e
+		err = fmt.Errorf("runContainer failed: %v", err)
+		return err
 	}
 
 	return nil
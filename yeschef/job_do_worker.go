@@ -0,0 +1,75 @@
+package yeschef
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/jaredfolkins/letemcook/util"
+	"github.com/jaredfolkins/letemcook/worker"
+)
+
+// runOnWorker runs a step with runs_on on a connected worker with all its
+// labels. The output comes back through the same msg pipeline as a local
+// container, so the log file and users see no difference. Remote steps get
+// no bind mounts, the locker directories live on the server.
+func runOnWorker(ctx context.Context, job *JobRecipe, st models.Step, env []string) error {
+	w, err := pickWorker(st.RunsOn)
+	if err != nil {
+		return err
+	}
+
+	jm := util.NewJobMetaFromEnv(env)
+	cf, err := util.NewContainerFiles(jm, job.Recipe.IsShared)
+	if err != nil {
+		return err
+	}
+	fm, err := util.NewFileMeta(jm, job.Recipe.IsShared)
+	if err != nil {
+		return err
+	}
+	if err := cf.OpenFiles(); err != nil {
+		return err
+	}
+	defer cf.CloseFiles()
+	lf, err := fm.OpenLogFile(jm)
+	if err != nil {
+		return err
+	}
+	defer lf.CloseLogFile()
+	lf.RunID = job.RunID
+
+	parsed, err := url.Parse(st.Image)
+	if err != nil {
+		return fmt.Errorf("failed to parse URI: %w", err)
+	}
+	imageName := strings.TrimPrefix(parsed.Path, "/")
+	auth, err := buildAuthHeader(ImageSpec{Name: st.Image, RegistryAuth: st.RegistryAuth})
+	if err != nil {
+		return err
+	}
+
+	as := worker.Assignment{
+		Name:           jm.GenerateContainerName(job.Recipe.Name, fm.IndividualUsernameOrSharedUsername),
+		Image:          imageName,
+		RegistryAuth:   auth,
+		Pull:           st.PullPolicy == models.PullPolicyAlways,
+		Env:            env,
+		Labels:         createDockerContainerTagMap(job, fm.IndividualUsernameOrSharedUsername),
+		TimeoutSeconds: job.ContainerTimeoutInSeconds,
+	}
+	imageHash := ""
+	started := func(imageID string) {
+		imageHash = util.ShortImageID(imageID)
+		if len(imageHash) > 8 {
+			imageHash = imageHash[:8]
+		}
+		lf.StepWriteToLog(jm.StepID, fmt.Sprintf("running on worker %s", w.Name), imageHash, imageName)
+	}
+	line := func(s string) error {
+		return stepOutput(s, imageHash, imageName, job, jm, cf, lf)
+	}
+	return w.Run(ctx, as, started, line)
+}
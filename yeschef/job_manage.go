@@ -0,0 +1,350 @@
+package yeschef
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/reugn/go-quartz/quartz"
+)
+
+// NextFireTimesLimit is how many upcoming run times are listed per job.
+const NextFireTimesLimit = 5
+
+// ErrUnmanagedQueue is returned when a management action targets a queue
+// other than the in and every queues.
+var ErrUnmanagedQueue = errors.New("only in and every jobs can be managed")
+
+// ErrInvalidInterval is returned when a new interval cannot be parsed or is
+// not greater than zero.
+var ErrInvalidInterval = errors.New("invalid interval")
+
+// ErrSchedulerNotStarted is returned when a management action runs before
+// the job engine has been started.
+var ErrSchedulerNotStarted = errors.New("job scheduler is not started")
+
+// managedQueue returns the queue and scheduler for an in or every job.
+func managedQueue(name string) (*jobQueue, *quartz.StdScheduler, error) {
+	if XoxoX == nil {
+		return nil, nil, ErrSchedulerNotStarted
+	}
+
+	var jq *jobQueue
+	var sched *quartz.StdScheduler
+	switch strings.ToLower(name) {
+	case IN_QUEUE:
+		jq, sched = XoxoX.InQueue, XoxoX.InScheduler
+	case EVERY_QUEUE:
+		jq, sched = XoxoX.EveryQueue, XoxoX.EveryScheduler
+	default:
+		return nil, nil, fmt.Errorf("%w: %q", ErrUnmanagedQueue, name)
+	}
+	if jq == nil || sched == nil {
+		return nil, nil, ErrSchedulerNotStarted
+	}
+	return jq, sched, nil
+}
+
+// triggerInterval returns the delay of a run once trigger or the interval of a
+// simple trigger.
+func triggerInterval(t quartz.Trigger) time.Duration {
+	switch t := t.(type) {
+	case *quartz.SimpleTrigger:
+		return t.Interval
+	case *quartz.RunOnceTrigger:
+		return t.Delay
+	case *BoundedTrigger:
+		return t.Interval
+	}
+	return 0
+}
+
+// cronExpression returns the expression a cron trigger was created from.
+func cronExpression(t *quartz.CronTrigger) string {
+	parts := strings.Split(t.Description(), quartz.Sep)
+	if len(parts) < 2 {
+		return t.Description()
+	}
+	return parts[1]
+}
+
+// NextFireTimes returns up to n upcoming run times of job. A paused job has
+// none and a run once job has at most one.
+func NextFireTimes(job quartz.ScheduledJob, n int) []time.Time {
+	next := job.NextRunTime()
+	if n < 1 || next == models.ScheduledJobPaused {
+		return nil
+	}
+	if opts := job.JobDetail().Options(); opts != nil && opts.Suspended {
+		return nil
+	}
+
+	times := []time.Time{time.Unix(0, next)}
+	if _, ok := job.Trigger().(*quartz.RunOnceTrigger); ok {
+		return times
+	}
+	for len(times) < n {
+		after, err := job.Trigger().NextFireTime(next)
+		if err != nil || after <= next {
+			break
+		}
+		next = after
+		times = append(times, time.Unix(0, next))
+	}
+	return times
+}
+
+// DescribeScheduledJobs fills in the interval and upcoming run times of the in
+// and every jobs in jobs. Jobs that have left their queue are left unchanged.
+func DescribeScheduledJobs(jobs []models.JobInfo) {
+	for i := range jobs {
+		jq, _, err := managedQueue(jobs[i].Queue)
+		if err != nil {
+			continue
+		}
+		job, err := jq.Get(quartz.NewJobKey(jobs[i].ID))
+		if err != nil {
+			continue
+		}
+		jobs[i].Interval = triggerInterval(job.Trigger()).String()
+		if ct, ok := job.Trigger().(*quartz.CronTrigger); ok {
+			jobs[i].Interval = cronExpression(ct)
+		}
+		jobs[i].NextFireTimes = NextFireTimes(job, NextFireTimesLimit)
+	}
+}
+
+// updateScheduledJob applies update to a copy of the queued job and writes it
+// back under the same key, then wakes the scheduler so it picks up the new
+// next run time.
+func updateScheduledJob(queue, key string, update func(job *scheduledLemcJob) error) error {
+	jq, sched, err := managedQueue(queue)
+	if err != nil {
+		return err
+	}
+
+	current, err := jq.Get(quartz.NewJobKey(key))
+	if err != nil {
+		return err
+	}
+	job := &scheduledLemcJob{
+		jobDetail:   current.JobDetail(),
+		trigger:     current.Trigger(),
+		nextRunTime: current.NextRunTime(),
+	}
+	if err := update(job); err != nil {
+		return err
+	}
+	if err := jq.update(job); err != nil {
+		return err
+	}
+	sched.Reset()
+	return nil
+}
+
+// PauseScheduledJob stops the job's trigger from firing until it is resumed.
+func PauseScheduledJob(queue, key string) error {
+	return updateScheduledJob(queue, key, func(job *scheduledLemcJob) error {
+		opts := job.jobDetail.Options()
+		if opts.Suspended {
+			return fmt.Errorf("%w: %s", quartz.ErrJobIsSuspended, key)
+		}
+		opts.Suspended = true
+		job.nextRunTime = models.ScheduledJobPaused
+		return nil
+	})
+}
+
+// ResumeScheduledJob restarts a paused job. The trigger starts over from now,
+// so an every job next runs one interval after it is resumed and an in job
+// runs once its delay has elapsed again.
+func ResumeScheduledJob(queue, key string) error {
+	return updateScheduledJob(queue, key, func(job *scheduledLemcJob) error {
+		opts := job.jobDetail.Options()
+		if !opts.Suspended {
+			return fmt.Errorf("%w: %s", quartz.ErrJobIsActive, key)
+		}
+		opts.Suspended = false
+		return restartTrigger(job, triggerInterval(job.trigger))
+	})
+}
+
+// SetScheduledJobInterval changes how long the job waits before its next run,
+// and for every jobs between runs, without running the recipe. interval uses
+// the recipe do syntax without the queue, for example "10.minutes".
+func SetScheduledJobInterval(queue, key, interval string) error {
+	if _, _, err := managedQueue(queue); err != nil {
+		return err
+	}
+	q, d, err := parseStepDo(strings.ToLower(queue) + "." + strings.TrimSpace(interval))
+	if err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidInterval, interval, err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("%w %q: must be greater than zero", ErrInvalidInterval, interval)
+	}
+
+	return updateScheduledJob(queue, key, func(job *scheduledLemcJob) error {
+		if _, ok := job.trigger.(*quartz.CronTrigger); ok {
+			return fmt.Errorf("%w: job %s runs on a cron schedule", ErrInvalidInterval, key)
+		}
+		switch j := job.jobDetail.Job().(type) {
+		case *StepJob:
+			j.Step.Do = q + "." + strings.TrimSpace(interval)
+		case *RecipeScheduleJob:
+			j.Schedule.Every = strings.TrimSpace(interval)
+		}
+		if job.jobDetail.Options().Suspended {
+			if bt, ok := job.trigger.(*BoundedTrigger); ok {
+				job.trigger = bt.restart(d)
+				return nil
+			}
+			job.trigger = newTrigger(q, d)
+			return nil
+		}
+		return restartTrigger(job, d)
+	})
+}
+
+// newTrigger returns the trigger DoIn or DoEvery would use for d.
+func newTrigger(queue string, d time.Duration) quartz.Trigger {
+	if queue == EVERY_QUEUE {
+		return quartz.NewSimpleTrigger(d)
+	}
+	return quartz.NewRunOnceTrigger(d)
+}
+
+// restartTrigger replaces the job's trigger with a fresh one for d and
+// computes the next run time from now. Cron triggers are kept as they are and
+// bounded triggers keep their bounds and run count.
+func restartTrigger(job *scheduledLemcJob, d time.Duration) error {
+	switch t := job.trigger.(type) {
+	case *quartz.CronTrigger:
+		// Cron triggers are not relative to now, only the next run moves.
+	case *BoundedTrigger:
+		job.trigger = t.restart(d)
+	case *quartz.SimpleTrigger:
+		job.trigger = newTrigger(EVERY_QUEUE, d)
+	default:
+		job.trigger = newTrigger(IN_QUEUE, d)
+	}
+	next, err := job.trigger.NextFireTime(quartz.NowNano())
+	if err != nil {
+		return err
+	}
+	job.nextRunTime = next
+	return nil
+}
+
+// RunScheduledJobNow runs the job's step or recipe immediately in the
+// background. The job stays queued with its trigger untouched and shutdown
+// waits for the run like it does for scheduled ones.
+func RunScheduledJobNow(queue, key string) error {
+	if err := acceptingRuns(); err != nil {
+		return err
+	}
+	jq, _, err := managedQueue(queue)
+	if err != nil {
+		return err
+	}
+	job, err := jq.Get(quartz.NewJobKey(key))
+	if err != nil {
+		return err
+	}
+
+	switch j := job.JobDetail().Job().(type) {
+	case *StepJob:
+		if j.RecipeJob != nil && XoxoX.RunningMan.IsRunning(LemcJobKey(j.RecipeJob, NOW_QUEUE)) {
+			return fmt.Errorf("error: a NOW job is already running for this recipe")
+		}
+	case *RecipeScheduleJob:
+		if XoxoX.RunningMan.IsRunning(LemcJobKey(j.RecipeJob, NOW_QUEUE)) {
+			return fmt.Errorf("error: a recipe is already running")
+		}
+	default:
+		return fmt.Errorf("job %s cannot be run now", key)
+	}
+
+	goTrackedRun(queue, key, job.JobDetail().Job())
+	return nil
+}
+
+// DeleteScheduledJob removes the job from its queue and forgets its last
+// result.
+func DeleteScheduledJob(queue, key string) error {
+	_, sched, err := managedQueue(queue)
+	if err != nil {
+		return err
+	}
+	if err := sched.DeleteJob(quartz.NewJobKey(key)); err != nil {
+		return err
+	}
+	return models.DeleteScheduledJobResult(strings.ToLower(queue), key)
+}
+
+// StepJobStatus reports whether an in or every step of a recipe of the job's
+// page is running, and whether one waits in queue for a trigger that has not
+// fired yet.
+func StepJobStatus(jr *JobRecipe, queue string) (running, queued bool) {
+	prefix := LemcJobKey(jr, queue)
+	if XoxoX == nil || XoxoX.RunningMan == nil {
+		return false, false
+	}
+	if XoxoX.RunningMan.IsRunningPrefix(prefix) {
+		return true, false
+	}
+	q, _, err := managedQueue(queue)
+	if err != nil {
+		return false, false
+	}
+	keys, err := models.ScheduledJobKeysLike(queue, prefix+"%")
+	if err != nil {
+		log.Printf("StepJobStatus %s: %v", prefix, err)
+		return false, false
+	}
+	for _, key := range keys {
+		job, err := q.Get(quartz.NewJobKey(key))
+		if err != nil {
+			continue
+		}
+		if t, ok := job.Trigger().(*quartz.RunOnceTrigger); ok && t.Expired {
+			continue
+		}
+		return false, true
+	}
+	return false, false
+}
+
+// recordStepResult stores the outcome of a step run as the last result of
+// the in or every job that ran it.
+func recordStepResult(sj *StepJob, started time.Time, runErr error) {
+	queue, _, err := parseStepDo(sj.Step.Do)
+	if err != nil || queue == NOW_QUEUE {
+		return
+	}
+	recordJobResult(queue, StepJobKey(sj.RecipeJob, queue, sj.Step.Step), started, runErr)
+}
+
+// recordJobResult stores the outcome of a run as the last result of the job
+// with the given key.
+func recordJobResult(queue, key string, started time.Time, runErr error) {
+	if key == "" {
+		return
+	}
+
+	result := &models.ScheduledJobResult{
+		Queue:      queue,
+		JobKey:     key,
+		StartedAt:  started,
+		FinishedAt: time.Now(),
+	}
+	if runErr != nil {
+		result.Error = runErr.Error()
+	}
+	if err := models.UpsertScheduledJobResult(result); err != nil {
+		log.Printf("record result of job %s: %v", key, err)
+	}
+}
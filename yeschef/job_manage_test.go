@@ -0,0 +1,115 @@
+package yeschef
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/reugn/go-quartz/quartz"
+)
+
+// newManagedTestQueue installs an empty in queue and an idle scheduler on
+// XoxoX for the duration of the test.
+func newManagedTestQueue(t *testing.T) *jobQueue {
+	t.Helper()
+	q := newTestQueue(t, IN_QUEUE)
+	prevQueue, prevSched := XoxoX.InQueue, XoxoX.InScheduler
+	XoxoX.InQueue, XoxoX.InScheduler = q, NewQuartzScheduler(q)
+	t.Cleanup(func() { XoxoX.InQueue, XoxoX.InScheduler = prevQueue, prevSched })
+	return q
+}
+
+func TestNextFireTimes(t *testing.T) {
+	next := time.Now().Add(time.Minute).UnixNano()
+	every := &scheduledLemcJob{
+		jobDetail:   quartz.NewJobDetail(&StepJob{}, quartz.NewJobKey("every")),
+		trigger:     quartz.NewSimpleTrigger(10 * time.Minute),
+		nextRunTime: next,
+	}
+	times := NextFireTimes(every, NextFireTimesLimit)
+	if len(times) != NextFireTimesLimit {
+		t.Fatalf("expected %d fire times, got %d", NextFireTimesLimit, len(times))
+	}
+	if !times[0].Equal(time.Unix(0, next)) || times[4].Sub(times[0]) != 40*time.Minute {
+		t.Fatalf("unexpected fire times: %v", times)
+	}
+
+	once := testStepJob("once", next)
+	if times := NextFireTimes(once, NextFireTimesLimit); len(times) != 1 {
+		t.Fatalf("expected one fire time for a run once job, got %v", times)
+	}
+
+	once.nextRunTime = models.ScheduledJobPaused
+	if times := NextFireTimes(once, NextFireTimesLimit); len(times) != 0 {
+		t.Fatalf("expected no fire times for a paused job, got %v", times)
+	}
+}
+
+func TestPauseResumeScheduledJob(t *testing.T) {
+	q := newManagedTestQueue(t)
+	next := time.Now().Add(time.Minute).UnixNano()
+	if err := q.Push(testStepJob("job", next)); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	if err := PauseScheduledJob(IN_QUEUE, "job"); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	if err := PauseScheduledJob(IN_QUEUE, "job"); !errors.Is(err, quartz.ErrJobIsSuspended) {
+		t.Fatalf("expected ErrJobIsSuspended pausing twice, got %v", err)
+	}
+	job, err := q.Get(quartz.NewJobKey("job"))
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if job.NextRunTime() != models.ScheduledJobPaused || !job.JobDetail().Options().Suspended {
+		t.Fatalf("expected paused job, got next=%d", job.NextRunTime())
+	}
+
+	if err := ResumeScheduledJob(IN_QUEUE, "job"); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	job, err = q.Get(quartz.NewJobKey("job"))
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if job.JobDetail().Options().Suspended || job.NextRunTime() == models.ScheduledJobPaused {
+		t.Fatalf("expected resumed job, got next=%d", job.NextRunTime())
+	}
+}
+
+func TestSetScheduledJobInterval(t *testing.T) {
+	q := newManagedTestQueue(t)
+	if err := q.Push(testStepJob("job", time.Now().Add(time.Minute).UnixNano())); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	if err := SetScheduledJobInterval(IN_QUEUE, "job", "bogus"); !errors.Is(err, ErrInvalidInterval) {
+		t.Fatalf("expected ErrInvalidInterval, got %v", err)
+	}
+	if err := SetScheduledJobInterval(IN_QUEUE, "job", "2.hours"); err != nil {
+		t.Fatalf("set interval: %v", err)
+	}
+
+	job, err := q.Get(quartz.NewJobKey("job"))
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if d := triggerInterval(job.Trigger()); d != 2*time.Hour {
+		t.Fatalf("expected 2h interval, got %v", d)
+	}
+	if sj := job.JobDetail().Job().(*StepJob); sj.Step.Do != "in.2.hours" {
+		t.Fatalf("expected step do to be rewritten, got %q", sj.Step.Do)
+	}
+	if wait := time.Until(time.Unix(0, job.NextRunTime())); wait < time.Hour {
+		t.Fatalf("expected next run about 2h out, got %v", wait)
+	}
+}
+
+func TestManageRejectsNowQueue(t *testing.T) {
+	newManagedTestQueue(t)
+	if err := PauseScheduledJob(NOW_QUEUE, "job"); !errors.Is(err, ErrUnmanagedQueue) {
+		t.Fatalf("expected ErrUnmanagedQueue, got %v", err)
+	}
+}
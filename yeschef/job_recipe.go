@@ -2,11 +2,14 @@ package yeschef
 
 import (
 	"context"
+	"fmt"
 	"log"
-	"strconv"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/jaredfolkins/letemcook/models"
+	"github.com/jaredfolkins/letemcook/util"
 )
 
 type JobRecipe struct {
@@ -14,6 +17,7 @@ type JobRecipe struct {
 	UUID                      string
 	CookbookID                string
 	AppID                     string
+	AccountID                 int64
 	UserID                    string
 	Username                  string
 	PageID                    string
@@ -23,25 +27,40 @@ type JobRecipe struct {
 	ContainerTimeoutInSeconds int
 	Recipe                    models.Recipe
 	RecipientUserIDs          []int64 // Populated for shared jobs
+	DockerEndpoint            string  // Picked by the cookbook, empty for the account's endpoint
+	RunID                     string  // Set for each run, its messages go to RunTopic too
+	McpSession                string  // MCP client that started the run, the only one its output goes to
 }
 
 func (job *JobRecipe) Execute(ctx context.Context) error {
+	err := job.execute(ctx)
+	if err != nil && validateJobRecipe(job) == nil {
+		deadLetterJob(NOW_QUEUE, failedRecipeJob(job), err)
+	}
+	return err
+}
+
+// execute runs the recipe's steps. Scheduled recipes call it directly, their
+// failures are kept as the schedule's last result instead.
+func (job *JobRecipe) execute(ctx context.Context) (err error) {
 	execCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	key := LemcJobKey(job, NOW_QUEUE)
 	defer XoxoX.RunningMan.Remove(key)
+	if job.RunID == "" {
+		job.RunID = uuid.NewString()
+	}
 	log.Printf("JobRecipe: %v \n", key)
 
-	var srv *McpServer
-	if job.AppID != "" {
-		if id, err := strconv.ParseInt(job.AppID, 10, 64); err == nil {
-			srv = XoxoX.ReadMcpAppInstance(id)
+	started := time.Now()
+	emit(job, Event{Type: EventRunStarted})
+	stopped := false
+	defer func() {
+		if !stopped {
+			emitRunEnd(job, started, err)
 		}
-	}
-	if srv != nil {
-		srv.broadcast([]byte("--MCP JOB STARTED--"))
-	}
+	}()
 	/*
 		if err := DeleteCronJobsByPageAndUUID(job.PageID, job.UUID); err != nil {
 			return err
@@ -53,7 +72,18 @@ func (job *JobRecipe) Execute(ctx context.Context) error {
 		steps[st.Step] = st
 	}
 
-	for _, st := range job.Recipe.Steps {
+	run := trackRun(NOW_QUEUE, nil, true)
+	defer run.finish()
+	execCtx = withStepRun(execCtx, run)
+
+	for i, st := range job.Recipe.Steps {
+		if !run.next(remainingSteps(job, i)) {
+			log.Printf("JobRecipe: %v stopped for shutdown before step %d", key, st.Step)
+			// The rest of the run was queued again, it starts again with
+			// the same run ID.
+			stopped = true
+			return nil
+		}
 		do := strings.Trim(st.Do, "")
 		if lemc_do_now_rgx.MatchString(do) {
 			err := DoStep(execCtx, job, st)
@@ -75,9 +105,6 @@ func (job *JobRecipe) Execute(ctx context.Context) error {
 			}
 		}
 	}
-	if srv != nil {
-		srv.broadcast([]byte("--MCP JOB FINISHED--"))
-	}
 	return nil
 }
 
@@ -88,3 +115,25 @@ func (rj *JobRecipe) Description() string {
 	}
 	return desc
 }
+
+// CookbookEnv returns the private and public environment declared in the
+// cookbook YAML.
+func CookbookEnv(yd models.YamlDefault) []string {
+	var env []string
+	env = append(env, yd.Cookbook.Environment.Private...)
+	env = append(env, yd.Cookbook.Environment.Public...)
+	return env
+}
+
+// LemcEnv returns the LEMC_ variables injected into every step of a run.
+func LemcEnv(scope string, userID int64, username, uuid, recipe string, pageID int, downloadURL string) []string {
+	return []string{
+		"LEMC_SCOPE=" + scope,
+		"LEMC_USER_ID=" + fmt.Sprintf("%d", userID),
+		"LEMC_USERNAME=" + username,
+		"LEMC_UUID=" + uuid,
+		"LEMC_RECIPE_NAME=" + util.AlphaNumHyphen(recipe),
+		"LEMC_PAGE_ID=" + fmt.Sprintf("%d", pageID),
+		fmt.Sprintf("LEMC_HTTP_DOWNLOAD_BASE_URL=%s", downloadURL),
+	}
+}
@@ -0,0 +1,210 @@
+package yeschef
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/reugn/go-quartz/quartz"
+)
+
+// recipeScheduleDescription prefixes the description of every
+// RecipeScheduleJob. It tells them apart from step jobs in the every queue.
+const recipeScheduleDescription = "RecipeScheduleJob"
+
+// RecipeScheduleJob runs a whole recipe on the trigger declared in the
+// recipe's schedule block. It is queued in the every queue under the
+// SCHEDULE_KEY name and its recipe's name, so it never collides with an
+// every step or with the schedule of another recipe of the page.
+type RecipeScheduleJob struct {
+	RecipeJob *JobRecipe
+	Schedule  models.RecipeSchedule
+	// Fingerprint identifies the declaration the job was registered from.
+	// Reconciling skips schedules whose fingerprint is unchanged.
+	Fingerprint string
+}
+
+var _ quartz.Job = (*RecipeScheduleJob)(nil)
+
+func (rs *RecipeScheduleJob) Execute(ctx context.Context) error {
+	started := time.Now()
+	err := rs.execute(ctx)
+	recordJobResult(EVERY_QUEUE, RecipeJobKey(rs.RecipeJob, SCHEDULE_KEY), started, err)
+	return err
+}
+
+// execute runs the recipe the way pressing Run does: it refuses to start
+// while the recipe is already running and replaces any in or every steps
+// left from an earlier run.
+func (rs *RecipeScheduleJob) execute(ctx context.Context) error {
+	if err := acceptingRuns(); err != nil {
+		return err
+	}
+	if err := CheckJobImagePolicy(rs.RecipeJob, rs.RecipeJob.AccountID); err != nil {
+		return err
+	}
+
+	key := LemcJobKey(rs.RecipeJob, NOW_QUEUE)
+	XoxoX.RunningMan.mu.Lock()
+	if XoxoX.RunningMan.list[key] {
+		XoxoX.RunningMan.mu.Unlock()
+		return fmt.Errorf("error: a recipe is already running")
+	}
+	XoxoX.RunningMan.list[key] = true
+	XoxoX.RunningMan.mu.Unlock()
+
+	PerRecipeDeleteAnyExistingJobs(rs.RecipeJob)
+
+	// Every firing is a run of its own. JobRecipe.execute clears the running
+	// flag when it returns.
+	rs.RecipeJob.RunID = uuid.NewString()
+	return rs.RecipeJob.execute(ctx)
+}
+
+func (rs *RecipeScheduleJob) Description() string {
+	return fmt.Sprintf("%s: %s", recipeScheduleDescription, rs.RecipeJob.Recipe.Name)
+}
+
+// NewScheduleTrigger returns the trigger a recipe schedule fires on.
+func NewScheduleTrigger(s models.RecipeSchedule) (quartz.Trigger, error) {
+	cron, every := strings.TrimSpace(s.Cron), strings.TrimSpace(s.Every)
+	switch {
+	case cron != "" && every != "":
+		return nil, errors.New("schedule sets both cron and every")
+	case cron != "":
+		t, err := quartz.NewCronTrigger(cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron %q: %w", cron, err)
+		}
+		return t, nil
+	case every != "":
+		_, d, err := parseStepDo(EVERY_QUEUE + "." + every)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidInterval, every, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("%w %q: must be greater than zero", ErrInvalidInterval, every)
+		}
+		return quartz.NewSimpleTrigger(d), nil
+	}
+	return nil, errors.New("schedule needs cron or every")
+}
+
+// ValidateRecipeSchedule checks a schedule block without registering it.
+func ValidateRecipeSchedule(s models.RecipeSchedule) error {
+	if _, err := NewScheduleTrigger(s); err != nil {
+		return err
+	}
+	if !models.ValidMisfirePolicy(s.Misfire) {
+		return fmt.Errorf("schedule misfire %q is not valid (must be 'fire_once', 'fire_all' or 'skip')", s.Misfire)
+	}
+	switch s.Scope {
+	case "", "individual", "shared":
+		return nil
+	}
+	return fmt.Errorf("schedule scope %q is not valid (must be 'individual' or 'shared')", s.Scope)
+}
+
+// RecipeScheduleSpec is a recipe schedule resolved for one app: the job it
+// runs and the schedule block it was declared with.
+type RecipeScheduleSpec struct {
+	Job      *JobRecipe
+	Schedule models.RecipeSchedule
+}
+
+// fingerprint hashes everything that ends up in the registered job, so any
+// change to the recipe, its environment or its schedule is detected.
+func (spec RecipeScheduleSpec) fingerprint() (string, error) {
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// appScheduleKeyPattern is a LIKE pattern matching the keys of every schedule
+// registered for the app with the given UUID, whatever its scope or recipe.
+// It also matches keys from before they carried the recipe name.
+func appScheduleKeyPattern(appUUID string) string {
+	return fmt.Sprintf("[app]%%[uuid:%s][queue:%s]%%", appUUID, SCHEDULE_KEY)
+}
+
+// ReconcileAppSchedules makes the schedules registered for an app match the
+// ones declared in its YAML. New schedules are added, changed ones replaced
+// and the ones no longer declared deleted. Unchanged schedules keep their
+// next run time and paused state. A schedule that fails to register is left
+// out and its error is returned once every other schedule was reconciled.
+func ReconcileAppSchedules(appUUID string, specs []RecipeScheduleSpec) error {
+	if XoxoX == nil || XoxoX.EveryQueue == nil || XoxoX.EveryScheduler == nil {
+		return ErrSchedulerNotStarted
+	}
+
+	registered, err := models.ScheduledJobKeysLike(EVERY_QUEUE, appScheduleKeyPattern(appUUID))
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	declared := map[string]bool{}
+	for _, spec := range specs {
+		key, err := registerRecipeSchedule(spec)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("recipe %q: %w", spec.Job.Recipe.Name, err))
+			continue
+		}
+		declared[key] = true
+	}
+
+	for _, key := range registered {
+		if declared[key] {
+			continue
+		}
+		if err := DeleteScheduledJob(EVERY_QUEUE, key); err != nil && !errors.Is(err, quartz.ErrJobNotFound) {
+			errs = append(errs, fmt.Errorf("delete schedule %s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// registerRecipeSchedule schedules spec unless an identical declaration is
+// already queued, and returns its job key.
+func registerRecipeSchedule(spec RecipeScheduleSpec) (string, error) {
+	if err := validateJobRecipe(spec.Job); err != nil {
+		return "", err
+	}
+	if err := ValidateRecipeSchedule(spec.Schedule); err != nil {
+		return "", err
+	}
+	trigger, err := NewScheduleTrigger(spec.Schedule)
+	if err != nil {
+		return "", err
+	}
+	fingerprint, err := spec.fingerprint()
+	if err != nil {
+		return "", err
+	}
+
+	key := RecipeJobKey(spec.Job, SCHEDULE_KEY)
+	if current, err := XoxoX.EveryQueue.Get(quartz.NewJobKey(key)); err == nil {
+		if rs, ok := current.JobDetail().Job().(*RecipeScheduleJob); ok && rs.Fingerprint == fingerprint {
+			return key, nil
+		}
+	}
+
+	job := &RecipeScheduleJob{RecipeJob: spec.Job, Schedule: spec.Schedule, Fingerprint: fingerprint}
+	opts := quartz.NewDefaultJobDetailOptions()
+	opts.Replace = true
+	kg := quartz.NewJobKeyWithGroup(key, jobGroup(spec.Job.UserID, spec.Job.PageID, spec.Job.UUID))
+	if err := XoxoX.EveryScheduler.ScheduleJob(quartz.NewJobDetailWithOptions(job, kg, opts), trigger); err != nil {
+		return "", err
+	}
+	return key, nil
+}
@@ -0,0 +1,142 @@
+package yeschef
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/reugn/go-quartz/quartz"
+)
+
+// newScheduleTestQueue installs an empty every queue and an idle scheduler on
+// XoxoX for the duration of the test.
+func newScheduleTestQueue(t *testing.T) *jobQueue {
+	t.Helper()
+	q := newTestQueue(t, EVERY_QUEUE)
+	prevQueue, prevSched := XoxoX.EveryQueue, XoxoX.EveryScheduler
+	XoxoX.EveryQueue, XoxoX.EveryScheduler = q, NewQuartzScheduler(q)
+	t.Cleanup(func() { XoxoX.EveryQueue, XoxoX.EveryScheduler = prevQueue, prevSched })
+	return q
+}
+
+func testScheduleSpec(appUUID, recipe string, s models.RecipeSchedule) RecipeScheduleSpec {
+	return RecipeScheduleSpec{
+		Job: &JobRecipe{
+			JobType:  JOB_TYPE_APP,
+			UUID:     appUUID,
+			AppID:    "1",
+			UserID:   "1",
+			Username: "tester",
+			PageID:   "1",
+			Scope:    "shared",
+			Recipe:   models.Recipe{Name: recipe, Schedule: &s},
+		},
+		Schedule: s,
+	}
+}
+
+func TestNewScheduleTrigger(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       models.RecipeSchedule
+		wantErr bool
+	}{
+		{"every", models.RecipeSchedule{Every: "10.minutes"}, false},
+		{"cron", models.RecipeSchedule{Cron: "0 0 3 * * *"}, false},
+		{"both", models.RecipeSchedule{Every: "10.minutes", Cron: "0 0 3 * * *"}, true},
+		{"neither", models.RecipeSchedule{}, true},
+		{"bad every", models.RecipeSchedule{Every: "10.fortnights"}, true},
+		{"bad cron", models.RecipeSchedule{Cron: "whenever"}, true},
+	}
+	for _, tt := range tests {
+		_, err := NewScheduleTrigger(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	if err := ValidateRecipeSchedule(models.RecipeSchedule{Every: "1.hours", Scope: "everyone"}); err == nil {
+		t.Error("expected an invalid scope to be rejected")
+	}
+	if err := ValidateRecipeSchedule(models.RecipeSchedule{Every: "1.hours", Misfire: "sometimes"}); err == nil {
+		t.Error("expected an invalid misfire policy to be rejected")
+	}
+	if err := ValidateRecipeSchedule(models.RecipeSchedule{Every: "1.hours", Misfire: models.MisfireFireAll}); err != nil {
+		t.Errorf("fire_all rejected: %v", err)
+	}
+}
+
+func TestReconcileAppSchedules(t *testing.T) {
+	q := newScheduleTestQueue(t)
+	hourly := testScheduleSpec("app-uuid", "hourly", models.RecipeSchedule{Every: "1.hours"})
+	nightly := testScheduleSpec("app-uuid", "nightly", models.RecipeSchedule{Cron: "0 0 3 * * *"})
+
+	if err := ReconcileAppSchedules("app-uuid", []RecipeScheduleSpec{hourly, nightly}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if n, _ := q.Size(); n != 2 {
+		t.Fatalf("expected 2 schedules, got %d", n)
+	}
+
+	// Both recipes are on the same page, each has its own schedule.
+	hourlyKey := quartz.NewJobKey(RecipeJobKey(hourly.Job, SCHEDULE_KEY))
+	nightlyKey := quartz.NewJobKey(RecipeJobKey(nightly.Job, SCHEDULE_KEY))
+	if hourlyKey.Name() == nightlyKey.Name() {
+		t.Fatalf("schedules of one page share the key %s", hourlyKey.Name())
+	}
+	if _, err := q.Get(nightlyKey); err != nil {
+		t.Fatalf("get nightly: %v", err)
+	}
+	before, err := q.Get(hourlyKey)
+	if err != nil {
+		t.Fatalf("get hourly: %v", err)
+	}
+
+	// Reconciling the same declaration leaves the queued job alone.
+	time.Sleep(time.Millisecond)
+	if err := ReconcileAppSchedules("app-uuid", []RecipeScheduleSpec{hourly, nightly}); err != nil {
+		t.Fatalf("reconcile again: %v", err)
+	}
+	after, err := q.Get(hourlyKey)
+	if err != nil {
+		t.Fatalf("get hourly: %v", err)
+	}
+	if after.NextRunTime() != before.NextRunTime() {
+		t.Errorf("unchanged schedule was rescheduled")
+	}
+
+	// Changing one schedule and dropping the other.
+	hourly.Schedule.Every = "2.hours"
+	if err := ReconcileAppSchedules("app-uuid", []RecipeScheduleSpec{hourly}); err != nil {
+		t.Fatalf("reconcile changed: %v", err)
+	}
+	if n, _ := q.Size(); n != 1 {
+		t.Fatalf("expected 1 schedule, got %d", n)
+	}
+	after, err = q.Get(hourlyKey)
+	if err != nil {
+		t.Fatalf("get hourly: %v", err)
+	}
+	if d := triggerInterval(after.Trigger()); d != 2*time.Hour {
+		t.Errorf("expected the interval to change to 2h, got %v", d)
+	}
+	if _, err := q.Get(nightlyKey); !errors.Is(err, quartz.ErrJobNotFound) {
+		t.Errorf("expected removed schedule to be deleted, got %v", err)
+	}
+}
+
+func TestReconcileAppSchedulesKeepsOtherApps(t *testing.T) {
+	q := newScheduleTestQueue(t)
+	other := testScheduleSpec("other-app", "hourly", models.RecipeSchedule{Every: "1.hours"})
+	if err := ReconcileAppSchedules("other-app", []RecipeScheduleSpec{other}); err != nil {
+		t.Fatalf("reconcile other: %v", err)
+	}
+
+	if err := ReconcileAppSchedules("app-uuid", nil); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if n, _ := q.Size(); n != 1 {
+		t.Fatalf("expected the other app's schedule to stay, got %d jobs", n)
+	}
+}
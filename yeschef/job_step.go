@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/jaredfolkins/letemcook/models"
+	"github.com/reugn/go-quartz/quartz"
 )
 
 type StepJob struct {
@@ -14,9 +16,31 @@ type StepJob struct {
 }
 
 func (dij *StepJob) Execute(ctx context.Context) error {
+	started := time.Now()
+	err := dij.execute(ctx)
+	recordStepResult(dij, started, err)
+	if err != nil && validateStepJob(dij) == nil {
+		// A failed in step has no run left, keep it so it can be retried.
+		if queue, _, perr := parseStepDo(dij.Step.Do); perr == nil && queue == IN_QUEUE {
+			deadLetterJob(IN_QUEUE, failedStepJob(dij), err)
+		}
+	}
+	return err
+}
+
+func (dij *StepJob) execute(ctx context.Context) error {
 	log.Println("StepJob: Execute")
 	log.Printf("StepJob: %v %v %v\n", dij.Step.Image, dij.Step.Step, dij.Step.Do)
 
+	// Every steps start new runs and are refused while draining. In steps
+	// finish runs that were already started.
+	queue, _, _ := parseStepDo(dij.Step.Do)
+	if queue == EVERY_QUEUE {
+		if err := acceptingRuns(); err != nil {
+			return err
+		}
+	}
+
 	execCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -44,6 +68,16 @@ func (dij *StepJob) Execute(ctx context.Context) error {
 		}()
 	}
 
+	// An in step has left its queue, it is put back when a shutdown stops
+	// waiting for it. Every steps are still queued.
+	var self quartz.ScheduledJob
+	if validateStepJob(dij) == nil {
+		self = failedStepJob(dij)
+	}
+	run := trackRun(queue, self, queue == IN_QUEUE)
+	defer run.finish()
+	execCtx = withStepRun(execCtx, run)
+
 	err := DoStep(execCtx, dij.RecipeJob, dij.Step)
 	if err != nil {
 		cancel()
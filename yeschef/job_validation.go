@@ -80,6 +80,10 @@ func ValidateScheduledJob(job quartz.ScheduledJob) error {
 		if err := validateJobRecipe(j); err != nil {
 			return err
 		}
+	case *RecipeScheduleJob:
+		if err := validateJobRecipe(j.RecipeJob); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -1,8 +1,6 @@
 package yeschef
 
 import (
-	"os"
-	"path/filepath"
 	"testing"
 	"time"
 
@@ -12,19 +10,11 @@ import (
 
 func TestJobValidation_NilJobDetail_ShouldNotPanic(t *testing.T) {
 	// Setup a temporary queue for testing
-	tmpDir := t.TempDir()
-	queue := &jobQueue{
-		Path: tmpDir,
-		Name: IN_QUEUE,
-	}
+	queue := newTestQueue(t, IN_QUEUE)
 
-	// Create a malformed job file that will result in nil JobDetail
+	// Queue a malformed job that will result in nil JobDetail
 	malformedJobData := []byte(`{"job": null, "job_key": "test", "trigger": "test"}`)
-	jobFile := filepath.Join(tmpDir, "1234567890.json")
-	err := os.WriteFile(jobFile, malformedJobData, 0644)
-	if err != nil {
-		t.Fatalf("Failed to write test job file: %v", err)
-	}
+	queueRawJob(t, queue, "test", 1234567890, malformedJobData)
 
 	// Attempt to pop - this should not panic
 	job, err := queue.Pop()
@@ -41,18 +31,7 @@ func TestJobValidation_NilJobDetail_ShouldNotPanic(t *testing.T) {
 
 func TestJobValidation_ValidJob_ShouldPass(t *testing.T) {
 	// Setup
-	tmpDir := t.TempDir()
-	queue := &jobQueue{
-		Path: tmpDir,
-		Name: IN_QUEUE,
-	}
-
-	// Initialize XoxoX if not already done
-	if XoxoX == nil {
-		XoxoX = &ChefsKiss{
-			RunningMan: NewRunningMan(),
-		}
-	}
+	queue := newTestQueue(t, IN_QUEUE)
 
 	// Create a valid step job
 	stepJob := &StepJob{
@@ -109,18 +88,7 @@ func TestJobValidation_ValidJob_ShouldPass(t *testing.T) {
 
 func TestJobValidation_ExpiredJob_ShouldBeHandled(t *testing.T) {
 	// Setup
-	tmpDir := t.TempDir()
-	queue := &jobQueue{
-		Path: tmpDir,
-		Name: IN_QUEUE,
-	}
-
-	// Initialize XoxoX if not already done
-	if XoxoX == nil {
-		XoxoX = &ChefsKiss{
-			RunningMan: NewRunningMan(),
-		}
-	}
+	queue := newTestQueue(t, IN_QUEUE)
 
 	// Create job data that represents an expired IN job with proper trigger format
 	expiredJobData := []byte(`{
@@ -154,11 +122,7 @@ func TestJobValidation_ExpiredJob_ShouldBeHandled(t *testing.T) {
 		"next_run_time": 1000000000
 	}`)
 
-	jobFile := filepath.Join(tmpDir, "1000000000.json")
-	err := os.WriteFile(jobFile, expiredJobData, 0644)
-	if err != nil {
-		t.Fatalf("Failed to write expired job file: %v", err)
-	}
+	queueRawJob(t, queue, "expired-job", 1000000000, expiredJobData)
 
 	// Pop should handle expired job gracefully
 	job, err := queue.Pop()
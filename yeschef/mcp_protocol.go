@@ -0,0 +1,226 @@
+package yeschef
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// McpProtocolVersions are the MCP revisions the server speaks, newest first.
+var McpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// JSON-RPC error codes.
+const (
+	mcpParseError       = -32700
+	mcpInvalidRequest   = -32600
+	mcpMethodNotFound   = -32601
+	mcpInvalidParams    = -32602
+	mcpInternalError    = -32603
+	mcpResourceNotFound = -32002
+)
+
+// mcpSessionIdle is how long a Streamable HTTP session is kept without
+// requests.
+const mcpSessionIdle = time.Hour
+
+type jsonrpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// McpErrorResponse encodes a JSON-RPC error for a request that never reached
+// the server, like one that could not be parsed.
+func McpErrorResponse(id json.RawMessage, code int, msg string) []byte {
+	b, _ := json.Marshal(jsonrpcResponse{JSONRPC: "2.0", ID: id, Error: &jsonrpcError{Code: code, Message: msg}})
+	return b
+}
+
+// McpInvalidRequest is the response to a request the transport refuses.
+func McpInvalidRequest(id json.RawMessage, msg string) []byte {
+	return McpErrorResponse(id, mcpInvalidRequest, msg)
+}
+
+// McpParseError is the response to a body that is not JSON-RPC.
+func McpParseError() []byte {
+	return McpErrorResponse(json.RawMessage("null"), mcpParseError, "parse error")
+}
+
+// McpSupportsVersion reports whether the server speaks the MCP revision.
+func McpSupportsVersion(v string) bool {
+	for _, s := range McpProtocolVersions {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateMcpVersion answers the revision a client asked for with the same
+// one when the server speaks it, or with the newest the server speaks.
+func negotiateMcpVersion(requested string) string {
+	if McpSupportsVersion(requested) {
+		return requested
+	}
+	return McpProtocolVersions[0]
+}
+
+// reply sends the result of a request back to the client, on the request
+// itself for Streamable HTTP and on the stream of the client otherwise.
+func (srv *McpServer) reply(env *mcpEnvelope, result interface{}) {
+	srv.respond(env, jsonrpcResponse{JSONRPC: "2.0", ID: env.Msg.ID, Result: result})
+}
+
+func (srv *McpServer) sendError(env *mcpEnvelope, code int, msg string) {
+	srv.respond(env, jsonrpcResponse{JSONRPC: "2.0", ID: env.Msg.ID, Error: &jsonrpcError{Code: code, Message: msg}})
+}
+
+// toolError reports a tool that failed to run as a tool result, so the
+// model calling it can see what went wrong.
+func (srv *McpServer) toolError(env *mcpEnvelope, err error) {
+	srv.reply(env, map[string]interface{}{
+		"content": []map[string]string{{"type": "text", "text": err.Error()}},
+		"isError": true,
+	})
+}
+
+func (srv *McpServer) respond(env *mcpEnvelope, resp jsonrpcResponse) {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("MCP marshal response: %v", err)
+		return
+	}
+	if env.Reply != nil {
+		env.Reply <- b
+		return
+	}
+	env.Client.Send <- b
+}
+
+func (srv *McpServer) handleInitialize(env *mcpEnvelope) {
+	var params struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ClientInfo      struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"clientInfo"`
+	}
+	if err := json.Unmarshal(env.Msg.Params, &params); err != nil {
+		srv.sendError(env, mcpInvalidParams, fmt.Sprintf("params: %v", err))
+		return
+	}
+	if params.ProtocolVersion == "" {
+		srv.sendError(env, mcpInvalidParams, "params: protocolVersion is required")
+		return
+	}
+	version := negotiateMcpVersion(params.ProtocolVersion)
+	env.Client.ProtocolVersion = version
+	log.Printf("MCP client %s %s initialized with protocol %s", params.ClientInfo.Name, params.ClientInfo.Version, version)
+
+	srv.reply(env, map[string]interface{}{
+		"protocolVersion": version,
+		"capabilities": map[string]interface{}{
+			"tools":     map[string]interface{}{"listChanged": false},
+			"resources": map[string]interface{}{"subscribe": true, "listChanged": true},
+		},
+		"serverInfo": map[string]string{
+			"name":    "letemcook",
+			"version": buildVersion(),
+		},
+		"instructions": fmt.Sprintf("Recipes of the app %s run as tools. Lifecycle events arrive as %s notifications.", srv.AppUUID, mcpEventMethod),
+	})
+}
+
+// buildVersion is the module version the server was built from.
+func buildVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "devel"
+}
+
+// NewClient creates a client of the server with a session id of its own.
+// Callers provision it.
+func (srv *McpServer) NewClient(userID, accountID int64, apiKey string) *McpClient {
+	return &McpClient{
+		Server:    srv,
+		Send:      make(chan []byte, 64),
+		UserID:    userID,
+		AccountID: accountID,
+		ApiKey:    apiKey,
+		SessionID: uuid.NewString(),
+		lastSeen:  time.Now(),
+	}
+}
+
+// NewSession starts a Streamable HTTP session. Its client gets the
+// notifications of its runs on the stream a GET with its session id opens.
+func (srv *McpServer) NewSession(userID, accountID int64, apiKey string) *McpClient {
+	c := srv.NewClient(userID, accountID, apiKey)
+	srv.mu.Lock()
+	if srv.sessions == nil {
+		srv.sessions = make(map[string]*McpClient)
+	}
+	var idle []*McpClient
+	for id, s := range srv.sessions {
+		if time.Since(s.lastSeen) > mcpSessionIdle {
+			delete(srv.sessions, id)
+			idle = append(idle, s)
+		}
+	}
+	srv.sessions[c.SessionID] = c
+	srv.mu.Unlock()
+
+	for _, s := range idle {
+		srv.Deprovision <- s
+	}
+	srv.Provision <- c
+	return c
+}
+
+// Session returns the client of a Streamable HTTP session, nil when the
+// session is unknown or ended.
+func (srv *McpServer) Session(id string) *McpClient {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	c := srv.sessions[id]
+	if c != nil {
+		c.lastSeen = time.Now()
+	}
+	return c
+}
+
+// EndSession ends a Streamable HTTP session. It returns false when there is
+// no such session.
+func (srv *McpServer) EndSession(id string) bool {
+	srv.mu.Lock()
+	c, ok := srv.sessions[id]
+	delete(srv.sessions, id)
+	srv.mu.Unlock()
+	if ok {
+		srv.Deprovision <- c
+	}
+	return ok
+}
+
+// Call handles a request of the client and returns its response, for
+// transports that answer on the request.
+func (srv *McpServer) Call(ctx context.Context, c *McpClient, msg *McpMessage) ([]byte, error) {
+	env := &mcpEnvelope{Msg: msg, Client: c, Reply: make(chan []byte, 1)}
+	select {
+	case srv.Inbound <- env:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case b := <-env.Reply:
+		return b, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
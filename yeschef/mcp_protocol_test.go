@@ -0,0 +1,212 @@
+package yeschef
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func mcpTestServer(t *testing.T) *McpServer {
+	t.Helper()
+	srv := NewMcpServer(1, "app-uuid", "", "")
+	go srv.Run()
+	return srv
+}
+
+func mcpCall(t *testing.T, srv *McpServer, c *McpClient, raw string) map[string]json.RawMessage {
+	t.Helper()
+	var msg McpMessage
+	if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+		t.Fatalf("unmarshal request: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	b, err := srv.Call(ctx, c, &msg)
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	var resp map[string]json.RawMessage
+	if err := json.Unmarshal(b, &resp); err != nil {
+		t.Fatalf("unmarshal response %s: %v", b, err)
+	}
+	return resp
+}
+
+func TestMcpInitializeNegotiatesVersion(t *testing.T) {
+	srv := mcpTestServer(t)
+	c := srv.NewSession(1, 1, "key")
+
+	tests := []struct {
+		requested string
+		want      string
+	}{
+		{"2025-03-26", "2025-03-26"},
+		{"2099-01-01", McpProtocolVersions[0]},
+	}
+	for _, tt := range tests {
+		resp := mcpCall(t, srv, c, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"`+tt.requested+`","clientInfo":{"name":"test","version":"1"}}}`)
+		var result struct {
+			ProtocolVersion string `json:"protocolVersion"`
+			ServerInfo      struct {
+				Name string `json:"name"`
+			} `json:"serverInfo"`
+		}
+		if err := json.Unmarshal(resp["result"], &result); err != nil {
+			t.Fatalf("result: %v", err)
+		}
+		if result.ProtocolVersion != tt.want {
+			t.Errorf("requested %s: got %s, want %s", tt.requested, result.ProtocolVersion, tt.want)
+		}
+		if result.ServerInfo.Name != "letemcook" {
+			t.Errorf("server name = %q", result.ServerInfo.Name)
+		}
+	}
+
+	resp := mcpCall(t, srv, c, `{"jsonrpc":"2.0","id":2,"method":"initialize","params":{}}`)
+	if _, ok := resp["error"]; !ok {
+		t.Errorf("initialize without protocolVersion should fail, got %v", resp)
+	}
+}
+
+func TestMcpErrorsAndPing(t *testing.T) {
+	srv := mcpTestServer(t)
+	c := srv.NewSession(1, 1, "key")
+
+	resp := mcpCall(t, srv, c, `{"jsonrpc":"2.0","id":"a","method":"ping"}`)
+	if string(resp["id"]) != `"a"` || string(resp["result"]) != "{}" {
+		t.Errorf("ping = %v", resp)
+	}
+
+	resp = mcpCall(t, srv, c, `{"jsonrpc":"2.0","id":3,"method":"nope"}`)
+	var e jsonrpcError
+	if err := json.Unmarshal(resp["error"], &e); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if e.Code != mcpMethodNotFound {
+		t.Errorf("code = %d, want %d", e.Code, mcpMethodNotFound)
+	}
+
+	srv.Enqueue(c, &McpMessage{JSONRPC: "2.0", Method: "notifications/initialized"})
+	srv.Enqueue(c, &McpMessage{JSONRPC: "2.0", Method: "notifications/unknown"})
+	select {
+	case b := <-c.Send:
+		t.Errorf("notification answered with %s", b)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMcpSessions(t *testing.T) {
+	srv := mcpTestServer(t)
+	c := srv.NewSession(1, 1, "key")
+	if c.SessionID == "" {
+		t.Fatal("session without id")
+	}
+	if srv.Session(c.SessionID) != c {
+		t.Fatal("session not found")
+	}
+	if !srv.EndSession(c.SessionID) {
+		t.Fatal("EndSession = false")
+	}
+	if srv.Session(c.SessionID) != nil {
+		t.Error("ended session still found")
+	}
+	if srv.EndSession(c.SessionID) {
+		t.Error("ended session ended twice")
+	}
+	if _, ok := <-c.Send; ok {
+		t.Error("stream of ended session still open")
+	}
+}
+
+func TestMcpParseErrorResponse(t *testing.T) {
+	var resp map[string]json.RawMessage
+	if err := json.Unmarshal(McpParseError(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	var e jsonrpcError
+	if err := json.Unmarshal(resp["error"], &e); err != nil {
+		t.Fatal(err)
+	}
+	if string(resp["id"]) != "null" || e.Code != mcpParseError {
+		t.Errorf("parse error = %s", McpParseError())
+	}
+}
+
+func TestMcpRunOutputRouting(t *testing.T) {
+	srv := mcpTestServer(t)
+	srv.AppID = 7
+	saved := XoxoX
+	XoxoX = &ChefsKiss{apps: make(map[int64]*CmdServer), mcpApps: map[int64]*McpServer{7: srv}}
+	defer func() { XoxoX = saved }()
+
+	starter := srv.NewSession(1, 1, "key")
+	other := srv.NewSession(2, 1, "key")
+	observer := srv.NewSession(3, 1, "key")
+	srv.Observe(observer, true)
+
+	receives := func(c *McpClient) bool {
+		select {
+		case <-c.Send:
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}
+
+	job := &JobRecipe{AppID: "7", UUID: "app-uuid", PageID: "1", Scope: "shared", McpSession: starter.SessionID, RecipientUserIDs: []int64{1, 2, 3}}
+	emit(job, Event{Type: EventRunStarted})
+	if !receives(starter) || !receives(observer) {
+		t.Error("starter and observer should get the run's events")
+	}
+	if receives(other) {
+		t.Error("another session got the run's events")
+	}
+
+	job.McpSession = ""
+	emit(job, Event{Type: EventRunStarted})
+	if !receives(observer) {
+		t.Error("observer should get runs started outside MCP")
+	}
+	if receives(starter) || receives(other) {
+		t.Error("runs started outside MCP reached a session")
+	}
+
+	// Observers only get the runs their user may see.
+	job.RecipientUserIDs = []int64{1, 2}
+	emit(job, Event{Type: EventRunStarted})
+	if receives(observer) {
+		t.Error("observer got a shared run its user may not see")
+	}
+	private := &JobRecipe{AppID: "7", UUID: "app-uuid", PageID: "1", Scope: "individual", UserID: "1"}
+	emit(private, Event{Type: EventRunStarted})
+	if receives(observer) {
+		t.Error("observer got the individual run of another user")
+	}
+	private.UserID = "3"
+	emit(private, Event{Type: EventRunStarted})
+	if !receives(observer) {
+		t.Error("observer should get its user's individual runs")
+	}
+
+	if got := srv.StreamClient("key", starter.SessionID); got != starter {
+		t.Errorf("StreamClient = %v", got)
+	}
+	if got := srv.StreamClient("other-key", starter.SessionID); got != nil {
+		t.Error("StreamClient matched another API key")
+	}
+}
+
+func TestMcpObserve(t *testing.T) {
+	srv := mcpTestServer(t)
+	c := srv.NewSession(1, 1, "key")
+	resp := mcpCall(t, srv, c, `{"jsonrpc":"2.0","id":1,"method":"lemc.observe","params":{"all":true}}`)
+	if string(resp["result"]) != `{"all":true}` {
+		t.Errorf("lemc.observe = %v", resp)
+	}
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	if !c.observeAll {
+		t.Error("client does not observe all runs")
+	}
+}
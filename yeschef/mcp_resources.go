@@ -0,0 +1,455 @@
+package yeschef
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/jaredfolkins/letemcook/paths"
+	"github.com/jaredfolkins/letemcook/util"
+	"gopkg.in/yaml.v3"
+)
+
+// The resources of an app are lemc://app/<uuid>/ followed by:
+//
+//	wiki/<page>                   the wiki of a page
+//	yaml/<scope>                  the YAML of the app without its secrets
+//	runs/<page>/<recipe>          the last runs of a recipe
+//	run/<run_id>/log              the log of a run
+//	locker/<scope>/<page>/<file>  a file of the public locker of a page
+//
+// Individual runs and lockers are those of the API key's user.
+const (
+	mcpResourceFileMax = 10 << 20 // larger locker files are downloaded
+	mcpResourceUpdated = "notifications/resources/updated"
+	mcpResourcesListed = "notifications/resources/list_changed"
+	mimeYAML           = "application/yaml"
+	mimeJSON           = "application/json"
+)
+
+var errResourceNotFound = errors.New("resource not found")
+
+// ResourceTemplate describes resources by their URI template for
+// resources/templates/list.
+type ResourceTemplate struct {
+	URITemplate string `json:"uriTemplate"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+// resourceURI is the URI of a resource of the app, its elements escaped.
+func (srv *McpServer) resourceURI(elem ...string) string {
+	escaped := make([]string, len(elem))
+	for i, e := range elem {
+		escaped[i] = url.PathEscape(e)
+	}
+	return fmt.Sprintf("lemc://app/%s/%s", srv.AppUUID, strings.Join(escaped, "/"))
+}
+
+// resourcePath splits the URI of a resource of the app into its elements.
+func (srv *McpServer) resourcePath(uri string) ([]string, bool) {
+	rest, ok := strings.CutPrefix(uri, fmt.Sprintf("lemc://app/%s/", srv.AppUUID))
+	if !ok {
+		return nil, false
+	}
+	elem := strings.Split(rest, "/")
+	for i, e := range elem {
+		u, err := url.PathUnescape(e)
+		if err != nil {
+			return nil, false
+		}
+		elem[i] = u
+	}
+	return elem, true
+}
+
+func (srv *McpServer) resourceTemplates() []ResourceTemplate {
+	return []ResourceTemplate{
+		{URITemplate: fmt.Sprintf("lemc://app/%s/runs/{page}/{recipe}", srv.AppUUID), Name: "Recipe runs", Description: "The last runs of a recipe, newest first", MimeType: mimeJSON},
+		{URITemplate: fmt.Sprintf("lemc://app/%s/run/{run_id}/log", srv.AppUUID), Name: "Run log", Description: "The log lines the steps of a run wrote", MimeType: "text/plain"},
+		{URITemplate: fmt.Sprintf("lemc://app/%s/locker/{scope}/{page}/{file}", srv.AppUUID), Name: "Locker file", Description: "A file of the public locker of a page"},
+	}
+}
+
+func (srv *McpServer) handleResourcesList(env *mcpEnvelope) {
+	var shared, individual models.YamlDefault
+	if err := yaml.Unmarshal([]byte(srv.YAML), &shared); err != nil {
+		srv.sendError(env, mcpInternalError, fmt.Sprintf("yaml: %v", err))
+		return
+	}
+	if err := yaml.Unmarshal([]byte(srv.YAMLIndividual), &individual); err != nil {
+		srv.sendError(env, mcpInternalError, fmt.Sprintf("yaml: %v", err))
+		return
+	}
+
+	var resources []ResourceDescriptor
+	for _, p := range shared.Cookbook.Pages {
+		if _, ok := shared.Cookbook.Storage.Wikis[p.PageID]; ok {
+			uri := srv.resourceURI("wiki", strconv.Itoa(p.PageID))
+			resources = append(resources, ResourceDescriptor{URI: uri, Name: fmt.Sprintf("Page %d Wiki", p.PageID), MimeType: "text/html"})
+		}
+	}
+	for _, scope := range mcpScopes {
+		resources = append(resources, ResourceDescriptor{
+			URI:         srv.resourceURI("yaml", scope),
+			Name:        fmt.Sprintf("%s YAML", scope),
+			Description: "The cookbook YAML of the app with its secrets masked",
+			MimeType:    mimeYAML,
+		})
+	}
+
+	userID := strconv.FormatInt(env.Client.UserID, 10)
+	for _, tool := range srv.Tools {
+		ref, ok := srv.recipes[tool.Name]
+		if !ok {
+			continue
+		}
+		page := strconv.Itoa(ref.Page)
+		resources = append(resources, ResourceDescriptor{
+			URI:         srv.resourceURI("runs", page, ref.Recipe),
+			Name:        fmt.Sprintf("Runs of %s", ref.Recipe),
+			Description: fmt.Sprintf("The last runs of the recipe %q of page %d", ref.Recipe, ref.Page),
+			MimeType:    mimeJSON,
+		})
+		for _, r := range RecipeRuns(srv.appID(), page, ref.Recipe, userID) {
+			resources = append(resources, ResourceDescriptor{
+				URI:         srv.resourceURI("run", r.RunID, "log"),
+				Name:        fmt.Sprintf("Log of run %s", r.RunID),
+				Description: fmt.Sprintf("%s run of %s started %s, %s", r.Scope, ref.Recipe, r.Started.Format("2006-01-02 15:04:05"), r.Status),
+				MimeType:    "text/plain",
+			})
+		}
+	}
+
+	pages := map[int]bool{}
+	for _, yd := range []models.YamlDefault{shared, individual} {
+		for _, p := range yd.Cookbook.Pages {
+			pages[p.PageID] = true
+		}
+	}
+	var pageIDs []int
+	for id := range pages {
+		pageIDs = append(pageIDs, id)
+	}
+	sort.Ints(pageIDs)
+	for _, page := range pageIDs {
+		for _, scope := range mcpScopes {
+			dir, err := srv.lockerDir(env.Client, scope, page)
+			if err != nil {
+				continue
+			}
+			entries, _ := os.ReadDir(dir)
+			for _, e := range entries {
+				info, err := e.Info()
+				if err != nil || e.IsDir() {
+					continue
+				}
+				resources = append(resources, ResourceDescriptor{
+					URI:      srv.resourceURI("locker", scope, strconv.Itoa(page), e.Name()),
+					Name:     e.Name(),
+					MimeType: fileMimeType(e.Name(), nil),
+					Size:     info.Size(),
+				})
+			}
+		}
+	}
+	srv.reply(env, map[string]interface{}{"resources": resources})
+}
+
+func (srv *McpServer) handleResourcesRead(env *mcpEnvelope) {
+	var params struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(env.Msg.Params, &params); err != nil {
+		srv.sendError(env, mcpInvalidParams, fmt.Sprintf("params: %v", err))
+		return
+	}
+	content, err := srv.readResource(env.Client, params.URI)
+	if errors.Is(err, errResourceNotFound) {
+		srv.sendError(env, mcpResourceNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		srv.sendError(env, mcpInternalError, err.Error())
+		return
+	}
+	srv.reply(env, map[string]interface{}{"contents": []ResourceContent{content}})
+}
+
+// readResource reads a resource of the app for the client.
+func (srv *McpServer) readResource(c *McpClient, uri string) (ResourceContent, error) {
+	content := ResourceContent{URI: uri}
+	elem, ok := srv.resourcePath(uri)
+	if !ok {
+		return content, fmt.Errorf("%w: unknown resource %s", errResourceNotFound, uri)
+	}
+
+	switch {
+	case len(elem) == 2 && elem[0] == "wiki":
+		pageID, _ := strconv.Atoi(elem[1])
+		var yd models.YamlDefault
+		if err := yaml.Unmarshal([]byte(srv.YAML), &yd); err != nil {
+			return content, fmt.Errorf("yaml: %v", err)
+		}
+		w, ok := yd.Cookbook.Storage.Wikis[pageID]
+		if !ok {
+			return content, fmt.Errorf("%w: no wiki for page %s", errResourceNotFound, elem[1])
+		}
+		dec, err := base64.StdEncoding.DecodeString(w)
+		if err != nil {
+			return content, fmt.Errorf("decode: %v", err)
+		}
+		content.MimeType, content.Text = "text/html", string(dec)
+
+	case len(elem) == 2 && elem[0] == "yaml":
+		doc := srv.YAML
+		switch elem[1] {
+		case mcpScopeShared:
+		case mcpScopeIndividual:
+			doc = srv.YAMLIndividual
+		default:
+			return content, fmt.Errorf("%w: unknown scope %s", errResourceNotFound, elem[1])
+		}
+		text, err := strippedYAML(doc)
+		if err != nil {
+			return content, err
+		}
+		content.MimeType, content.Text = mimeYAML, text
+
+	case len(elem) == 3 && elem[0] == "runs":
+		page, _ := strconv.Atoi(elem[1])
+		if !srv.hasRecipe(page, elem[2]) {
+			return content, fmt.Errorf("%w: no recipe %s on page %s", errResourceNotFound, elem[2], elem[1])
+		}
+		runs := RecipeRuns(srv.appID(), elem[1], elem[2], strconv.FormatInt(c.UserID, 10))
+		if runs == nil {
+			runs = []RunSummary{}
+		}
+		b, _ := json.MarshalIndent(map[string]interface{}{"runs": runs}, "", "  ")
+		content.MimeType, content.Text = mimeJSON, string(b)
+
+	case len(elem) == 3 && elem[0] == "run" && elem[2] == "log":
+		lines, ok, err := RunLog(srv.appID(), elem[1], strconv.FormatInt(c.UserID, 10))
+		if !ok {
+			return content, fmt.Errorf("%w: unknown run %s", errResourceNotFound, elem[1])
+		}
+		if err != nil {
+			return content, fmt.Errorf("log of run %s: %v", elem[1], err)
+		}
+		content.MimeType = "text/plain"
+		if len(lines) > 0 {
+			content.Text = strings.Join(lines, "\n") + "\n"
+		}
+
+	case len(elem) == 4 && elem[0] == "locker":
+		return srv.readLockerFile(c, content, elem[1], elem[2], elem[3])
+
+	default:
+		return content, fmt.Errorf("%w: unknown resource %s", errResourceNotFound, uri)
+	}
+	return content, nil
+}
+
+// readLockerFile reads a file of the public locker of a page. Text is
+// returned as is, anything else base64 encoded.
+func (srv *McpServer) readLockerFile(c *McpClient, content ResourceContent, scope, pageStr, name string) (ResourceContent, error) {
+	page, err := strconv.Atoi(pageStr)
+	if err != nil || (scope != mcpScopeShared && scope != mcpScopeIndividual) || name == "." || name == ".." || filepath.Base(name) != name {
+		return content, fmt.Errorf("%w: no locker file %s", errResourceNotFound, content.URI)
+	}
+	dir, err := srv.lockerDir(c, scope, page)
+	if err != nil {
+		return content, err
+	}
+	path := filepath.Join(dir, name)
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return content, fmt.Errorf("%w: no locker file %s", errResourceNotFound, content.URI)
+	}
+	if info.Size() > mcpResourceFileMax {
+		return content, fmt.Errorf("%s is larger than %d bytes, download it from %s", name, mcpResourceFileMax,
+			fmt.Sprintf(paths.LockerDownloadPattern, srv.AppUUID, page, scope)+url.PathEscape(name))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return content, err
+	}
+
+	content.MimeType = fileMimeType(name, data)
+	if isTextMime(content.MimeType) && utf8.Valid(data) {
+		content.Text = string(data)
+	} else {
+		content.Blob = base64.StdEncoding.EncodeToString(data)
+	}
+	return content, nil
+}
+
+// lockerDir is the public locker of a page of the scope for the client, the
+// individual one of the API key's user. The user needs the same rights as
+// to run the scope's recipes.
+func (srv *McpServer) lockerDir(c *McpClient, scope string, page int) (string, error) {
+	user, err := srv.scopeUser(c, scope)
+	if err != nil {
+		return "", err
+	}
+	jm := &util.JobMeta{UUID: srv.AppUUID, PageID: strconv.Itoa(page)}
+	if scope == mcpScopeIndividual {
+		jm.UserID, jm.Username = strconv.FormatInt(user.ID, 10), user.Username
+	}
+	fm, err := util.NewFileMeta(jm, scope == mcpScopeShared)
+	if err != nil {
+		return "", err
+	}
+	return fm.PublicDir(), nil
+}
+
+func (srv *McpServer) hasRecipe(page int, recipe string) bool {
+	for _, ref := range srv.recipes {
+		if ref.Page == page && ref.Recipe == recipe {
+			return true
+		}
+	}
+	return false
+}
+
+// fileMimeType guesses the MIME type of a file by its extension, else by its
+// content when there is some.
+func fileMimeType(name string, data []byte) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	if data != nil {
+		return http.DetectContentType(data)
+	}
+	return "application/octet-stream"
+}
+
+func isTextMime(t string) bool {
+	t, _, _ = strings.Cut(t, ";")
+	return strings.HasPrefix(t, "text/") || t == mimeJSON || t == mimeYAML || t == "application/xml" || strings.HasSuffix(t, "+json") || strings.HasSuffix(t, "+xml")
+}
+
+// strippedYAML is a YAML of the app without its storage and with the values
+// of its secrets masked like a plan masks them: the private variables, the
+// variables with secret looking names and registry credentials.
+func strippedYAML(doc string) (string, error) {
+	var yd models.YamlDefaultNoStorage
+	if err := yaml.Unmarshal([]byte(doc), &yd); err != nil {
+		return "", fmt.Errorf("yaml: %v", err)
+	}
+	book := &yd.Cookbook
+	private := book.Environment.Private
+	book.Environment.Private = maskEnv(private, private)
+	book.Environment.Public = maskEnv(book.Environment.Public, private)
+	for i := range book.Pages {
+		for j := range book.Pages[i].Recipes {
+			steps := book.Pages[i].Recipes[j].Steps
+			for k := range steps {
+				steps[k].Env = maskEnv(steps[k].Env, private)
+				steps[k].Environment = maskEnv(steps[k].Environment, private)
+				if steps[k].RegistryAuth != "" {
+					steps[k].RegistryAuth = maskedValue
+				}
+			}
+		}
+	}
+	b, err := yaml.Marshal(yd)
+	if err != nil {
+		return "", fmt.Errorf("yaml: %v", err)
+	}
+	return string(b), nil
+}
+
+func (srv *McpServer) handleSubscribe(env *mcpEnvelope, subscribe bool) {
+	var params struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(env.Msg.Params, &params); err != nil {
+		srv.sendError(env, mcpInvalidParams, fmt.Sprintf("params: %v", err))
+		return
+	}
+	if _, ok := srv.resourcePath(params.URI); !ok {
+		srv.sendError(env, mcpResourceNotFound, fmt.Sprintf("unknown resource %s", params.URI))
+		return
+	}
+	srv.mu.Lock()
+	if subscribe {
+		if env.Client.subscribed == nil {
+			env.Client.subscribed = make(map[string]bool)
+		}
+		env.Client.subscribed[params.URI] = true
+	} else {
+		delete(env.Client.subscribed, params.URI)
+	}
+	srv.mu.Unlock()
+	srv.reply(env, struct{}{})
+}
+
+// notifyRunEnded tells the MCP clients of the job's app that the run ended,
+// see McpServer.runEnded.
+func notifyRunEnded(job *JobRecipe) {
+	id, err := strconv.ParseInt(job.AppID, 10, 64)
+	if err != nil {
+		return
+	}
+	if srv := XoxoX.ReadMcpAppInstance(id); srv != nil {
+		srv.runEnded(job)
+	}
+}
+
+// runEnded tells the clients that may see the ended run of the job that the
+// list of resources changed, and the clients subscribed to the runs of its
+// recipe, its log or the public locker of its page that those were updated.
+func (srv *McpServer) runEnded(job *JobRecipe) {
+	scope := mcpScopeIndividual
+	if job.Recipe.IsShared {
+		scope = mcpScopeShared
+	}
+	updated := []string{
+		srv.resourceURI("runs", job.PageID, job.Recipe.Name),
+		srv.resourceURI("run", job.RunID, "log"),
+	}
+	locker := srv.resourceURI("locker", scope, job.PageID) + "/"
+	listChanged, _ := json.Marshal(map[string]string{"jsonrpc": "2.0", "method": mcpResourcesListed})
+
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	for c := range srv.Clients {
+		if !job.Recipe.IsShared && strconv.FormatInt(c.UserID, 10) != job.UserID {
+			continue
+		}
+		trySend(c, listChanged)
+		for uri := range c.subscribed {
+			if slices.Contains(updated, uri) || strings.HasPrefix(uri, locker) {
+				b, _ := json.Marshal(map[string]interface{}{
+					"jsonrpc": "2.0",
+					"method":  mcpResourceUpdated,
+					"params":  map[string]string{"uri": uri},
+				})
+				trySend(c, b)
+			}
+		}
+	}
+}
+
+// trySend queues a message for the client, dropping it when the client
+// falls behind.
+func trySend(c *McpClient, b []byte) {
+	select {
+	case c.Send <- b:
+	default:
+	}
+}
@@ -0,0 +1,184 @@
+package yeschef
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/util"
+)
+
+func TestStrippedYAML(t *testing.T) {
+	doc := `cookbook:
+  environment:
+    public: [REGION=eu, API_TOKEN=abc]
+    private: [DB_PASS=hunter2]
+  pages:
+    - page: 1
+      name: Ops
+      recipes:
+        - recipe: deploy
+          steps:
+            - step: 1
+              image: docker.io/app
+              registry_auth: c2VjcmV0
+              environment: [DB_PASS=hunter2, MODE=fast]
+  storage:
+    wikis:
+      1: PGgxPldpa2k8L2gxPg==
+`
+	text, err := strippedYAML(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "abc", "c2VjcmV0", "PGgxPldpa2k8L2gxPg=="} {
+		if strings.Contains(text, secret) {
+			t.Errorf("stripped yaml has %s:\n%s", secret, text)
+		}
+	}
+	for _, kept := range []string{"REGION=eu", "MODE=fast", "DB_PASS=" + maskedValue, "docker.io/app"} {
+		if !strings.Contains(text, kept) {
+			t.Errorf("stripped yaml lacks %s:\n%s", kept, text)
+		}
+	}
+}
+
+func TestMcpRunHistoryResources(t *testing.T) {
+	srv, c, perm := mcpRunApp(t)
+	setAppPerm(t, perm, true, true)
+	jr, _, err := srv.recipeJob(c, mcpScopeIndividual, 1, "mine", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jr.RunID = "run-" + t.Name()
+
+	recordRun(jr, Event{Type: EventRunQueued, Time: time.Now()})
+	jm := util.NewJobMetaFromEnv(jr.Env)
+	fm, _ := util.NewFileMeta(jm, false)
+	for _, runID := range []string{jr.RunID, "another-run"} {
+		lf, err := fm.OpenLogFile(jm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lf.RunID = runID
+		lf.StepWriteToLog("1", "output of "+runID, "abc", "img")
+		lf.CloseLogFile()
+	}
+	if !recordRun(jr, Event{Type: EventRunFinished, Time: time.Now()}) {
+		t.Error("run.finished did not end the run")
+	}
+
+	runs, err := srv.readResource(c, srv.resourceURI("runs", "1", "mine"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(runs.Text, jr.RunID) || !strings.Contains(runs.Text, `"status": "finished"`) {
+		t.Errorf("runs = %s", runs.Text)
+	}
+
+	logURI := srv.resourceURI("run", jr.RunID, "log")
+	lg, err := srv.readResource(c, logURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(lg.Text, "output of "+jr.RunID) || strings.Contains(lg.Text, "another-run") {
+		t.Errorf("log = %q", lg.Text)
+	}
+
+	stranger := srv.NewClient(c.UserID+1000, c.AccountID, "other")
+	if _, err := srv.readResource(stranger, logURI); !errors.Is(err, errResourceNotFound) {
+		t.Errorf("individual run log of another user: %v", err)
+	}
+	if _, err := srv.readResource(c, srv.resourceURI("runs", "1", "nope")); !errors.Is(err, errResourceNotFound) {
+		t.Errorf("runs of an unknown recipe: %v", err)
+	}
+
+	// Subscribers of the run's resources hear it ended, users who may not
+	// see it hear nothing.
+	env := &mcpEnvelope{Msg: &McpMessage{ID: json.RawMessage(`1`), Params: json.RawMessage(`{"uri":"` + logURI + `"}`)}, Client: c, Reply: make(chan []byte, 1)}
+	srv.handleSubscribe(env, true)
+	<-env.Reply
+	srv.Clients[c], srv.Clients[stranger] = true, true
+	srv.runEnded(jr)
+
+	var methods []string
+	for len(c.Send) > 0 {
+		var n struct {
+			Method string `json:"method"`
+			Params struct {
+				URI string `json:"uri"`
+			} `json:"params"`
+		}
+		json.Unmarshal(<-c.Send, &n)
+		methods = append(methods, n.Method+" "+n.Params.URI)
+	}
+	want := []string{mcpResourcesListed + " ", mcpResourceUpdated + " " + logURI}
+	if strings.Join(methods, ",") != strings.Join(want, ",") {
+		t.Errorf("notifications = %q, want %q", methods, want)
+	}
+	if len(stranger.Send) != 0 {
+		t.Error("user who may not see the run was notified")
+	}
+}
+
+func TestMcpLockerResources(t *testing.T) {
+	srv, c, perm := mcpRunApp(t)
+	setAppPerm(t, perm, true, true)
+	dir, err := srv.lockerDir(c, mcpScopeIndividual, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	os.WriteFile(filepath.Join(dir, "report.txt"), []byte("all good"), 0o644)
+	os.WriteFile(filepath.Join(dir, "chart.png"), png, 0o644)
+
+	txt, err := srv.readResource(c, srv.resourceURI("locker", mcpScopeIndividual, "1", "report.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txt.Text != "all good" || !strings.HasPrefix(txt.MimeType, "text/plain") {
+		t.Errorf("report.txt = %+v", txt)
+	}
+	img, err := srv.readResource(c, srv.resourceURI("locker", mcpScopeIndividual, "1", "chart.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.MimeType != "image/png" || img.Text != "" || img.Blob == "" {
+		t.Errorf("chart.png = %+v", img)
+	}
+	if _, err := srv.readResource(c, srv.resourceURI("locker", mcpScopeIndividual, "1", "../../logs")); !errors.Is(err, errResourceNotFound) {
+		t.Errorf("path outside the locker: %v", err)
+	}
+
+	env := &mcpEnvelope{Msg: &McpMessage{ID: json.RawMessage(`1`)}, Client: c, Reply: make(chan []byte, 1)}
+	srv.handleResourcesList(env)
+	list := string(<-env.Reply)
+	for _, uri := range []string{
+		srv.resourceURI("locker", mcpScopeIndividual, "1", "chart.png"),
+		srv.resourceURI("yaml", mcpScopeShared),
+		srv.resourceURI("runs", "1", "deploy"),
+	} {
+		if !strings.Contains(list, uri) {
+			t.Errorf("resources/list lacks %s: %s", uri, list)
+		}
+	}
+
+	// Without the individual permission the user's locker is out of reach.
+	setAppPerm(t, perm, true, false)
+	chart := srv.resourceURI("locker", mcpScopeIndividual, "1", "chart.png")
+	if _, err := srv.readResource(c, chart); err == nil {
+		t.Error("read an individual locker file without the individual permission")
+	}
+	env = &mcpEnvelope{Msg: &McpMessage{ID: json.RawMessage(`2`)}, Client: c, Reply: make(chan []byte, 1)}
+	srv.handleResourcesList(env)
+	if list := string(<-env.Reply); strings.Contains(list, chart) {
+		t.Errorf("resources/list has %s without the individual permission", chart)
+	}
+}
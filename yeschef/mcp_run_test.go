@@ -0,0 +1,148 @@
+package yeschef
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/jaredfolkins/letemcook/db"
+	"github.com/jaredfolkins/letemcook/models"
+	"gopkg.in/yaml.v3"
+)
+
+// mcpRunApp creates an app whose owner is the returned MCP client, with a
+// recipe "deploy" on page 1 in both YAML and "mine" only in the individual
+// one.
+func mcpRunApp(t *testing.T) (*McpServer, *McpClient, *models.PermApp) {
+	t.Helper()
+	recipe := func(name string) models.Recipe {
+		return models.Recipe{
+			Name:  name,
+			Form:  []models.FormField{{Variable: "target", Type: "text"}},
+			Steps: []models.Step{{Step: 1, Image: "docker.io/test", Do: "now", Timeout: "1.minutes"}},
+		}
+	}
+	shared := models.NewYamlIndividual()
+	shared.Cookbook.Pages = []models.Page{{PageID: 1, Name: "Ops", Recipes: []models.Recipe{recipe("deploy")}}}
+	individual := models.NewYamlIndividual()
+	individual.Cookbook.Pages = []models.Page{{PageID: 1, Name: "Ops", Recipes: []models.Recipe{recipe("deploy"), recipe("mine")}}}
+	ys, _ := yaml.Marshal(shared)
+	yi, _ := yaml.Marshal(individual)
+
+	tx, err := db.Db().Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	acc, err := models.AccountCreate("MCP "+t.Name(), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usr := models.NewUser()
+	usr.Username = fmt.Sprintf("mcp-runner-%d", acc.ID)
+	usr.Email = fmt.Sprintf("mcp-runner-%d@example.com", acc.ID)
+	usr.Hash = "x"
+	if usr.ID, err = models.CreateUserWithAccountID(usr, acc.ID, tx); err != nil {
+		t.Fatal(err)
+	}
+	cb := &models.Cookbook{AccountID: acc.ID, OwnerID: usr.ID, Name: "CB", YamlShared: string(ys), YamlIndividual: string(yi)}
+	if err := cb.Create(tx); err != nil {
+		t.Fatal(err)
+	}
+	app := &models.App{AccountID: acc.ID, OwnerID: usr.ID, CookbookID: cb.ID, Name: "App", YAMLShared: string(ys), YAMLIndividual: string(yi), IsMcpEnabled: true, IsActive: true}
+	if err := app.Create(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	perm, err := models.AppPermissionsByUserAccountAndApp(usr.ID, acc.ID, app.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewMcpServer(app.ID, app.UUID, app.YAMLShared, app.YAMLIndividual)
+	srv.AccountID = acc.ID
+	return srv, srv.NewClient(usr.ID, acc.ID, perm.ApiKey), perm
+}
+
+func setAppPerm(t *testing.T, perm *models.PermApp, shared, individual bool) {
+	t.Helper()
+	perm.CanShared, perm.CanIndividual = shared, individual
+	tx, err := db.Db().Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := perm.UpsertappPermissions(tx); err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMcpRecipeJobRunsAsKeyUser(t *testing.T) {
+	srv, c, perm := mcpRunApp(t)
+	setAppPerm(t, perm, true, true)
+	uid := fmt.Sprintf("%d", c.UserID)
+
+	jr, _, err := srv.recipeJob(c, mcpScopeIndividual, 1, "mine", []byte(`{"target":"web1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jr.UserID != uid || jr.Username != fmt.Sprintf("mcp-runner-%d", c.AccountID) || jr.Scope != mcpScopeIndividual || jr.Recipe.IsShared {
+		t.Errorf("individual job = %+v", jr)
+	}
+	if !slices.Equal(jr.RecipientUserIDs, []int64{c.UserID}) {
+		t.Errorf("recipients = %v", jr.RecipientUserIDs)
+	}
+	for _, want := range []string{"TARGET=web1", "LEMC_SCOPE=individual", "LEMC_USER_ID=" + uid, "LEMC_USERNAME=" + jr.Username} {
+		if !slices.Contains(jr.Env, want) {
+			t.Errorf("env %v lacks %s", jr.Env, want)
+		}
+	}
+
+	jr, _, err = srv.recipeJob(c, mcpScopeShared, 1, "deploy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jr.Scope != mcpScopeShared || !jr.Recipe.IsShared || !slices.Contains(jr.RecipientUserIDs, c.UserID) {
+		t.Errorf("shared job = %+v", jr)
+	}
+
+	if _, _, err := srv.recipeJob(c, mcpScopeShared, 1, "mine", nil); err == nil {
+		t.Error("recipe of the individual YAML ran shared")
+	}
+	if _, _, err := srv.recipeJob(c, mcpScopeShared, 1, "deploy", []byte(`{"nope":"x"}`)); !errors.Is(err, errInvalidArgs) {
+		t.Errorf("unknown argument error = %v", err)
+	}
+
+	setAppPerm(t, perm, true, false)
+	if _, _, err := srv.recipeJob(c, mcpScopeIndividual, 1, "mine", nil); err == nil {
+		t.Error("ran individual without can_individual")
+	}
+	setAppPerm(t, perm, false, true)
+	if _, _, err := srv.recipeJob(c, mcpScopeShared, 1, "deploy", nil); err == nil {
+		t.Error("ran shared without can_shared")
+	}
+}
+
+func TestMcpRecipeToolScopes(t *testing.T) {
+	srv, _, _ := mcpRunApp(t)
+	if ref := srv.recipes["page-1-deploy"]; !slices.Equal(ref.Scopes, []string{mcpScopeShared, mcpScopeIndividual}) {
+		t.Errorf("deploy scopes = %v", ref.Scopes)
+	}
+	if ref := srv.recipes["page-1-mine"]; !slices.Equal(ref.Scopes, []string{mcpScopeIndividual}) {
+		t.Errorf("mine scopes = %v", ref.Scopes)
+	}
+
+	scope, rest, err := scopeArg([]byte(`{"lemc_scope":"individual","scope":"x"}`), mcpScopeArg, mcpScopeShared, mcpScopes)
+	if err != nil || scope != mcpScopeIndividual || string(rest) != `{"scope":"x"}` {
+		t.Errorf("scopeArg = %s, %s, %v", scope, rest, err)
+	}
+	if _, _, err := scopeArg([]byte(`{"scope":"shared"}`), "scope", mcpScopeIndividual, []string{mcpScopeIndividual}); err == nil {
+		t.Error("scope outside the tool's scopes accepted")
+	}
+}
@@ -3,14 +3,16 @@ package yeschef
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
-	"regexp"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/jaredfolkins/letemcook/models"
 	"github.com/jaredfolkins/letemcook/paths"
@@ -36,11 +38,27 @@ type McpClient struct {
 	UserID    int64
 	AccountID int64
 	ApiKey    string
+	// SessionID identifies the client, the runs it starts are tagged with
+	// it. Streamable HTTP clients send it as their Mcp-Session-Id.
+	SessionID       string
+	ProtocolVersion string // negotiated by initialize
+
+	lastSeen   time.Time       // guarded by McpServer.mu
+	observeAll bool            // gets the output of every run of the app, guarded by McpServer.mu
+	subscribed map[string]bool // resource uris, guarded by McpServer.mu
 }
 
 type mcpEnvelope struct {
 	Msg    *McpMessage
 	Client *McpClient
+	Reply  chan []byte // set when the response goes back on the request
+}
+
+// mcpReply is the result of a request answered after handleMessage returned,
+// like a tool call waiting for its run.
+type mcpReply struct {
+	env    *mcpEnvelope
+	result interface{}
 }
 
 func (c *McpClient) ReadPump() {
@@ -89,56 +107,130 @@ func (c *McpClient) WritePump() {
 
 // McpServer manages a set of McpClients.
 type McpServer struct {
-	mu          sync.RWMutex
-	Clients     map[*McpClient]bool
-	Inbound     chan *mcpEnvelope
-	Provision   chan *McpClient
-	Deprovision chan *McpClient
-	AppUUID     string
-	AppID       int64
-	YAML        string
-	Tools       []ToolDescriptor
-}
-
-func NewMcpServer(appID int64, uuid string, yamlStr string) *McpServer {
+	mu             sync.RWMutex
+	Clients        map[*McpClient]bool
+	Inbound        chan *mcpEnvelope
+	Provision      chan *McpClient
+	Deprovision    chan *McpClient
+	AppUUID        string
+	AppID          int64
+	AccountID      int64
+	YAML           string // shared YAML of the app
+	YAMLIndividual string
+	Tools          []ToolDescriptor
+
+	recipes  map[string]recipeRef  // per recipe tools by name
+	replies  chan mcpReply         // results of requests answered late
+	sessions map[string]*McpClient // Streamable HTTP sessions by id, guarded by mu
+}
+
+func NewMcpServer(appID int64, uuid string, yamlStr, yamlIndividual string) *McpServer {
 	srv := &McpServer{
-		Clients:     make(map[*McpClient]bool),
-		Inbound:     make(chan *mcpEnvelope, 64),
-		Provision:   make(chan *McpClient),
-		Deprovision: make(chan *McpClient),
-		AppUUID:     uuid,
-		AppID:       appID,
-		YAML:        yamlStr,
+		Clients:        make(map[*McpClient]bool),
+		Inbound:        make(chan *mcpEnvelope, 64),
+		Provision:      make(chan *McpClient),
+		Deprovision:    make(chan *McpClient),
+		replies:        make(chan mcpReply, 16),
+		AppUUID:        uuid,
+		AppID:          appID,
+		YAML:           yamlStr,
+		YAMLIndividual: yamlIndividual,
 	}
 	srv.Tools = []ToolDescriptor{
 		{
 			Name:        "run-recipe",
 			Description: "Run a recipe by page and name",
+			InputSchema: withWaitSchema(map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"page":   map[string]interface{}{"type": "integer"},
+					"recipe": map[string]interface{}{"type": "string"},
+					"scope":  scopeSchema(mcpScopes),
+				},
+				"required": []string{"page", "recipe"},
+			}),
+		},
+		{
+			Name:        "plan-recipe",
+			Description: "Show what running a recipe would do without running it",
 			InputSchema: map[string]interface{}{
 				"type": "object",
 				"properties": map[string]interface{}{
 					"page":   map[string]interface{}{"type": "integer"},
 					"recipe": map[string]interface{}{"type": "string"},
+					"scope":  scopeSchema(mcpScopes),
 				},
 				"required": []string{"page", "recipe"},
 			},
 		},
+		{
+			Name:        "get-run-result",
+			Description: "Get the status, output, data, exit status and artifacts of a run started by a tool",
+			InputSchema: withWaitSchema(map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"run_id": map[string]interface{}{"type": "string"},
+				},
+				"required": []string{"run_id"},
+			}),
+		},
+	}
+	var shared, individual models.YamlDefault
+	if err := yaml.Unmarshal([]byte(yamlStr), &shared); err != nil {
+		log.Printf("MCP app %s tools: shared yaml: %v", uuid, err)
+		return srv
 	}
+	if err := yaml.Unmarshal([]byte(yamlIndividual), &individual); err != nil {
+		log.Printf("MCP app %s tools: individual yaml: %v", uuid, err)
+		return srv
+	}
+	tools, recipes := recipeTools(&shared, &individual)
+	srv.Tools = append(srv.Tools, tools...)
+	srv.recipes = recipes
 	return srv
 }
 
-func (srv *McpServer) broadcast(b []byte) {
+// route sends the output of a run to the client that started it, by its
+// session id, and to the clients observing every run whose user is one of
+// the run's recipients, the users the web UI shows it to. Runs started
+// outside MCP have no session and only reach observers.
+func (srv *McpServer) route(session string, recipients []int64, b []byte) {
 	srv.mu.RLock()
 	defer srv.mu.RUnlock()
 	for c := range srv.Clients {
-		select {
-		case c.Send <- b:
+		switch {
+		case session != "" && c.SessionID == session:
+		case c.observeAll && slices.Contains(recipients, c.UserID):
 		default:
+			continue
 		}
+		trySend(c, b)
 	}
 }
 
+// Observe makes the client get the output of every run of the app its user
+// may see instead of only its own runs, or stop doing so.
+func (srv *McpServer) Observe(c *McpClient, all bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	c.observeAll = all
+}
+
+// StreamClient returns the connected client with the session id, nil when it
+// is gone or belongs to another API key.
+func (srv *McpServer) StreamClient(apiKey, session string) *McpClient {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	for c := range srv.Clients {
+		if c.SessionID == session && c.ApiKey == apiKey {
+			return c
+		}
+	}
+	return nil
+}
+
 // FindClient returns the first client associated with the given API key.
+// Clients that know their session id use StreamClient.
 func (srv *McpServer) FindClient(apiKey string) *McpClient {
 	srv.mu.RLock()
 	defer srv.mu.RUnlock()
@@ -183,7 +275,7 @@ type jsonrpcResponse struct {
 	JSONRPC string          `json:"jsonrpc"`
 	ID      json.RawMessage `json:"id,omitempty"`
 	Result  interface{}     `json:"result,omitempty"`
-	Error   interface{}     `json:"error,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
 }
 
 // ToolDescriptor describes a single MCP tool.
@@ -205,6 +297,7 @@ type ResourceDescriptor struct {
 	Name        string `json:"name"`
 	Description string `json:"description,omitempty"`
 	MimeType    string `json:"mimeType,omitempty"`
+	Size        int64  `json:"size,omitempty"`
 }
 
 // ResourceContent represents the content returned by resources/read.
@@ -217,12 +310,20 @@ type ResourceContent struct {
 
 func (srv *McpServer) handleMessage(env *mcpEnvelope) {
 	switch env.Msg.Method {
+	case "initialize":
+		srv.handleInitialize(env)
+	case "notifications/initialized", "notifications/cancelled":
+		// Nothing waits for these.
+	case "ping":
+		srv.reply(env, struct{}{})
 	case "lemc.pages":
 		srv.handlePages(env)
 	case "lemc.recipes":
 		srv.handleRecipes(env)
 	case "lemc.apps":
 		srv.handleApps(env)
+	case "lemc.observe":
+		srv.handleObserve(env)
 	case "lemc.run":
 		srv.handleRun(env)
 	case "tools/list":
@@ -233,15 +334,26 @@ func (srv *McpServer) handleMessage(env *mcpEnvelope) {
 		srv.handleResourcesList(env)
 	case "resources/read":
 		srv.handleResourcesRead(env)
+	case "resources/templates/list":
+		srv.reply(env, map[string]interface{}{"resourceTemplates": srv.resourceTemplates()})
+	case "resources/subscribe":
+		srv.handleSubscribe(env, true)
+	case "resources/unsubscribe":
+		srv.handleSubscribe(env, false)
 	default:
+		if env.Msg.Method == "" || len(env.Msg.ID) == 0 {
+			// Responses and notifications the server does not use.
+			return
+		}
 		log.Printf("MCP unknown method: %s", env.Msg.Method)
+		srv.sendError(env, mcpMethodNotFound, fmt.Sprintf("method not found: %s", env.Msg.Method))
 	}
 }
 
 func (srv *McpServer) handlePages(env *mcpEnvelope) {
 	var yd models.YamlDefault
 	if err := yaml.Unmarshal([]byte(srv.YAML), &yd); err != nil {
-		srv.sendError(env, fmt.Sprintf("yaml: %v", err))
+		srv.sendError(env, mcpInternalError, fmt.Sprintf("yaml: %v", err))
 		return
 	}
 	yd.UUID = srv.AppUUID
@@ -259,15 +371,13 @@ func (srv *McpServer) handlePages(env *mcpEnvelope) {
 		}
 		pages = append(pages, pi)
 	}
-	resp := jsonrpcResponse{JSONRPC: "2.0", ID: env.Msg.ID, Result: map[string]interface{}{"pages": pages}}
-	b, _ := json.Marshal(resp)
-	env.Client.Send <- b
+	srv.reply(env, map[string]interface{}{"pages": pages})
 }
 
 func (srv *McpServer) handleRecipes(env *mcpEnvelope) {
 	var yd models.YamlDefault
 	if err := yaml.Unmarshal([]byte(srv.YAML), &yd); err != nil {
-		srv.sendError(env, fmt.Sprintf("yaml: %v", err))
+		srv.sendError(env, mcpInternalError, fmt.Sprintf("yaml: %v", err))
 		return
 	}
 	var recipes []McpRecipeSummary
@@ -276,9 +386,7 @@ func (srv *McpServer) handleRecipes(env *mcpEnvelope) {
 			recipes = append(recipes, McpRecipeSummary{Name: r.Name, Description: r.Description})
 		}
 	}
-	resp := jsonrpcResponse{JSONRPC: "2.0", ID: env.Msg.ID, Result: map[string]interface{}{"recipes": recipes}}
-	b, _ := json.Marshal(resp)
-	env.Client.Send <- b
+	srv.reply(env, map[string]interface{}{"recipes": recipes})
 }
 
 func (srv *McpServer) handleApps(env *mcpEnvelope) {
@@ -287,7 +395,7 @@ func (srv *McpServer) handleApps(env *mcpEnvelope) {
 		Limit int `json:"limit"`
 	}
 	if err := json.Unmarshal(env.Msg.Params, &params); err != nil {
-		srv.sendError(env, fmt.Sprintf("params: %v", err))
+		srv.sendError(env, mcpInvalidParams, fmt.Sprintf("params: %v", err))
 		return
 	}
 	if params.Page < 1 {
@@ -302,12 +410,12 @@ func (srv *McpServer) handleApps(env *mcpEnvelope) {
 
 	total, err := models.Countapps(userID, accountID)
 	if err != nil {
-		srv.sendError(env, err.Error())
+		srv.sendError(env, mcpInternalError, err.Error())
 		return
 	}
 	apps, err := models.Apps(userID, accountID, params.Page, params.Limit)
 	if err != nil {
-		srv.sendError(env, err.Error())
+		srv.sendError(env, mcpInternalError, err.Error())
 		return
 	}
 	totalPages := 0
@@ -318,39 +426,51 @@ func (srv *McpServer) handleApps(env *mcpEnvelope) {
 	for _, a := range apps {
 		infos = append(infos, McpAppInfo{UUID: a.UUID, Name: a.Name, Description: a.Description})
 	}
-	resp := jsonrpcResponse{JSONRPC: "2.0", ID: env.Msg.ID, Result: map[string]interface{}{"apps": infos, "page": params.Page, "total_pages": totalPages}}
-	b, _ := json.Marshal(resp)
-	env.Client.Send <- b
+	srv.reply(env, map[string]interface{}{"apps": infos, "page": params.Page, "total_pages": totalPages})
 }
 
 func (srv *McpServer) handleRun(env *mcpEnvelope) {
 	var params struct {
 		Page   int    `json:"page"`
 		Recipe string `json:"recipe"`
+		Scope  string `json:"scope"`
 	}
 	if err := json.Unmarshal(env.Msg.Params, &params); err != nil {
-		srv.sendError(env, fmt.Sprintf("params: %v", err))
+		srv.sendError(env, mcpInvalidParams, fmt.Sprintf("params: %v", err))
 		return
 	}
-	if err := srv.runRecipe(params.Page, params.Recipe); err != nil {
-		srv.sendError(env, err.Error())
+	if params.Scope == "" {
+		params.Scope = mcpScopeShared
+	}
+	if _, err := srv.runRecipe(env.Client, params.Scope, params.Page, params.Recipe, nil); err != nil {
+		srv.sendError(env, mcpInternalError, err.Error())
 		return
 	}
-	resp := jsonrpcResponse{JSONRPC: "2.0", ID: env.Msg.ID, Result: "ok"}
-	b, _ := json.Marshal(resp)
-	env.Client.Send <- b
+	srv.reply(env, "ok")
+}
+
+// handleObserve opts the client in or out of the output of every run of the
+// app, for dashboards.
+func (srv *McpServer) handleObserve(env *mcpEnvelope) {
+	var params struct {
+		All bool `json:"all"`
+	}
+	if err := json.Unmarshal(env.Msg.Params, &params); err != nil {
+		srv.sendError(env, mcpInvalidParams, fmt.Sprintf("params: %v", err))
+		return
+	}
+	srv.Observe(env.Client, params.All)
+	srv.reply(env, map[string]bool{"all": params.All})
 }
 
 func (srv *McpServer) handleToolsList(env *mcpEnvelope) {
-	resp := jsonrpcResponse{JSONRPC: "2.0", ID: env.Msg.ID, Result: map[string]interface{}{"tools": srv.Tools}}
-	b, _ := json.Marshal(resp)
-	env.Client.Send <- b
+	srv.reply(env, map[string]interface{}{"tools": srv.Tools})
 }
 
 func (srv *McpServer) handleToolsCall(env *mcpEnvelope) {
 	var params ToolCallParams
 	if err := json.Unmarshal(env.Msg.Params, &params); err != nil {
-		srv.sendError(env, fmt.Sprintf("params: %v", err))
+		srv.sendError(env, mcpInvalidParams, fmt.Sprintf("params: %v", err))
 		return
 	}
 	switch params.Name {
@@ -360,82 +480,95 @@ func (srv *McpServer) handleToolsCall(env *mcpEnvelope) {
 			Recipe string `json:"recipe"`
 		}
 		if err := json.Unmarshal(params.Arguments, &args); err != nil {
-			srv.sendError(env, fmt.Sprintf("args: %v", err))
+			srv.sendError(env, mcpInvalidParams, fmt.Sprintf("args: %v", err))
+			return
+		}
+		wait, rest, err := waitArgs(params.Arguments)
+		if err != nil {
+			srv.sendError(env, mcpInvalidParams, fmt.Sprintf("args: %v", err))
+			return
+		}
+		scope, _, err := scopeArg(rest, "scope", mcpScopeShared, mcpScopes)
+		if err != nil {
+			srv.sendError(env, mcpInvalidParams, fmt.Sprintf("args: %v", err))
+			return
+		}
+		srv.callRun(env, scope, args.Page, args.Recipe, nil, wait)
+	case "get-run-result":
+		var args struct {
+			RunID string `json:"run_id"`
+		}
+		if err := json.Unmarshal(params.Arguments, &args); err != nil {
+			srv.sendError(env, mcpInvalidParams, fmt.Sprintf("args: %v", err))
+			return
+		}
+		wait, _, err := waitArgs(params.Arguments)
+		if err != nil {
+			srv.sendError(env, mcpInvalidParams, fmt.Sprintf("args: %v", err))
+			return
+		}
+		res, ok := srv.runResult(env.Client, args.RunID)
+		if !ok {
+			srv.toolError(env, fmt.Errorf("unknown run %s", args.RunID))
+			return
+		}
+		srv.waitReply(env, res, wait)
+	case "plan-recipe":
+		var args struct {
+			Page   int    `json:"page"`
+			Recipe string `json:"recipe"`
+		}
+		if err := json.Unmarshal(params.Arguments, &args); err != nil {
+			srv.sendError(env, mcpInvalidParams, fmt.Sprintf("args: %v", err))
 			return
 		}
-		if err := srv.runRecipe(args.Page, args.Recipe); err != nil {
-			srv.sendError(env, err.Error())
+		scope, _, err := scopeArg(params.Arguments, "scope", mcpScopeShared, mcpScopes)
+		if err != nil {
+			srv.sendError(env, mcpInvalidParams, fmt.Sprintf("args: %v", err))
+			return
+		}
+		plan, err := srv.planRecipe(env.Client, scope, args.Page, args.Recipe)
+		if err != nil {
+			srv.toolError(env, err)
 			return
 		}
+		text, _ := json.MarshalIndent(plan, "", "  ")
 		result := map[string]interface{}{
-			"content": []map[string]string{{"type": "text", "text": "ok"}},
+			"content": []map[string]string{{"type": "text", "text": string(text)}},
 		}
-		resp := jsonrpcResponse{JSONRPC: "2.0", ID: env.Msg.ID, Result: result}
-		b, _ := json.Marshal(resp)
-		env.Client.Send <- b
+		srv.reply(env, result)
 	default:
-		srv.sendError(env, "unknown tool")
-	}
-}
-
-func (srv *McpServer) handleResourcesList(env *mcpEnvelope) {
-	var yd models.YamlDefault
-	if err := yaml.Unmarshal([]byte(srv.YAML), &yd); err != nil {
-		srv.sendError(env, fmt.Sprintf("yaml: %v", err))
-		return
-	}
-	var resources []ResourceDescriptor
-	for _, p := range yd.Cookbook.Pages {
-		if _, ok := yd.Cookbook.Storage.Wikis[p.PageID]; ok {
-			uri := fmt.Sprintf("lemc://app/%s/wiki/%d", srv.AppUUID, p.PageID)
-			resources = append(resources, ResourceDescriptor{URI: uri, Name: fmt.Sprintf("Page %d Wiki", p.PageID), MimeType: "text/html"})
+		ref, ok := srv.recipes[params.Name]
+		if !ok {
+			srv.sendError(env, mcpInvalidParams, fmt.Sprintf("unknown tool: %s", params.Name))
+			return
 		}
+		wait, rest, err := waitArgs(params.Arguments)
+		if err != nil {
+			srv.sendError(env, mcpInvalidParams, fmt.Sprintf("args: %v", err))
+			return
+		}
+		scope, rest, err := scopeArg(rest, mcpScopeArg, ref.Scopes[0], ref.Scopes)
+		if err != nil {
+			srv.sendError(env, mcpInvalidParams, fmt.Sprintf("args: %v", err))
+			return
+		}
+		srv.callRun(env, scope, ref.Page, ref.Recipe, rest, wait)
 	}
-	resp := jsonrpcResponse{JSONRPC: "2.0", ID: env.Msg.ID, Result: map[string]interface{}{"resources": resources}}
-	b, _ := json.Marshal(resp)
-	env.Client.Send <- b
 }
 
-func (srv *McpServer) handleResourcesRead(env *mcpEnvelope) {
-	var params struct {
-		URI string `json:"uri"`
-	}
-	if err := json.Unmarshal(env.Msg.Params, &params); err != nil {
-		srv.sendError(env, fmt.Sprintf("params: %v", err))
-		return
+// recipeJob builds the JobRecipe for a recipe of the scope's YAML along with
+// the YAML. It runs as the user of the client's API key, who needs the app
+// permission of the scope, like a run from the web UI. args are the values
+// of the recipe's form, errors in them wrap errInvalidArgs.
+func (srv *McpServer) recipeJob(c *McpClient, scope string, page int, recipeName string, args json.RawMessage) (*JobRecipe, *models.YamlDefault, error) {
+	doc := srv.YAML
+	if scope == mcpScopeIndividual {
+		doc = srv.YAMLIndividual
 	}
-	wikiRegex := regexp.MustCompile(`^lemc://app/[^/]+/wiki/(\d+)$`)
-	matches := wikiRegex.FindStringSubmatch(params.URI)
-	if len(matches) != 2 {
-		srv.sendError(env, "unknown resource")
-		return
-	}
-	pageID, _ := strconv.Atoi(matches[1])
 	var yd models.YamlDefault
-	if err := yaml.Unmarshal([]byte(srv.YAML), &yd); err != nil {
-		srv.sendError(env, fmt.Sprintf("yaml: %v", err))
-		return
-	}
-	w, ok := yd.Cookbook.Storage.Wikis[pageID]
-	if !ok {
-		srv.sendError(env, "resource not found")
-		return
-	}
-	dec, err := base64.StdEncoding.DecodeString(w)
-	if err != nil {
-		srv.sendError(env, fmt.Sprintf("decode: %v", err))
-		return
-	}
-	content := ResourceContent{URI: params.URI, MimeType: "text/html", Text: string(dec)}
-	resp := jsonrpcResponse{JSONRPC: "2.0", ID: env.Msg.ID, Result: map[string]interface{}{"contents": []ResourceContent{content}}}
-	b, _ := json.Marshal(resp)
-	env.Client.Send <- b
-}
-
-func (srv *McpServer) runRecipe(page int, recipeName string) error {
-	var yd models.YamlDefault
-	if err := yaml.Unmarshal([]byte(srv.YAML), &yd); err != nil {
-		return fmt.Errorf("yaml: %v", err)
+	if err := yaml.Unmarshal([]byte(doc), &yd); err != nil {
+		return nil, nil, fmt.Errorf("yaml: %v", err)
 	}
 	var rec models.Recipe
 	found := false
@@ -451,56 +584,192 @@ func (srv *McpServer) runRecipe(page int, recipeName string) error {
 		}
 	}
 	if !found {
-		return fmt.Errorf("recipe not found")
+		return nil, nil, fmt.Errorf("recipe not found in the %s yaml", scope)
+	}
+	form, err := formEnv(rec.Form, args)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errInvalidArgs, err)
 	}
 
-	var envVars []string
-	envVars = append(envVars, yd.Cookbook.Environment.Private...)
-	envVars = append(envVars, yd.Cookbook.Environment.Public...)
-	envVars = append(envVars, fmt.Sprintf("LEMC_STEP_ID=%d", 1))
-	envVars = append(envVars, "LEMC_SCOPE=shared")
-	envVars = append(envVars, fmt.Sprintf("LEMC_UUID=%s", srv.AppUUID))
-	envVars = append(envVars, fmt.Sprintf("LEMC_RECIPE_NAME=%s", util.AlphaNumHyphen(rec.Name)))
-	envVars = append(envVars, fmt.Sprintf("LEMC_PAGE_ID=%d", page))
+	user, err := srv.scopeUser(c, scope)
+	if err != nil {
+		return nil, nil, err
+	}
+	recipients := []int64{user.ID}
+	if scope == mcpScopeShared {
+		if recipients, err = models.GetUserIDsForSharedApp(srv.AppUUID); err != nil {
+			return nil, nil, fmt.Errorf("shared recipients: %w", err)
+		}
+	}
+
+	// The same variables a run from the web UI gets.
+	envVars := CookbookEnv(yd)
+	envVars = append(envVars, form...)
+	envVars = append(envVars, LemcEnv(scope, user.ID, user.Username, srv.AppUUID, rec.Name, page, fmt.Sprintf(paths.LockerDownloadPattern, srv.AppUUID, page, scope))...)
 
+	rec.IsShared = scope == mcpScopeShared
 	jr := &JobRecipe{
-		JobType:  JOB_TYPE_APP,
-		UUID:     srv.AppUUID,
-		AppID:    fmt.Sprintf("%d", srv.AppID),
-		PageID:   fmt.Sprintf("%d", page),
-		UserID:   "0",
-		Username: "mcp",
-		Scope:    "shared",
-		Env:      envVars,
-		Recipe:   rec,
-	}
-
-	srv.broadcast([]byte("--MCP JOB STARTED--"))
+		JobType:          JOB_TYPE_APP,
+		UUID:             srv.AppUUID,
+		AppID:            fmt.Sprintf("%d", srv.AppID),
+		AccountID:        srv.AccountID,
+		PageID:           fmt.Sprintf("%d", page),
+		UserID:           fmt.Sprintf("%d", user.ID),
+		Username:         user.Username,
+		Scope:            scope,
+		Env:              envVars,
+		Recipe:           rec,
+		RecipientUserIDs: recipients,
+		DockerEndpoint:   yd.Cookbook.DockerEndpoint,
+	}
+	return jr, &yd, nil
+}
+
+// scopeUser returns the user of the client's API key when they may use the
+// scope of the app, see ScopeUser.
+func (srv *McpServer) scopeUser(c *McpClient, scope string) (*models.User, error) {
+	if scope != mcpScopeShared && scope != mcpScopeIndividual {
+		return nil, fmt.Errorf("%w: unknown scope %s", errInvalidArgs, scope)
+	}
+	return ScopeUser(c.UserID, c.AccountID, srv.AppID, scope)
+}
+
+// ScopeUser returns the user when they may run the recipes of a scope of the
+// app: they are neither disabled nor deleted and have the permission for the
+// scope.
+func ScopeUser(userID, accountID, appID int64, scope string) (*models.User, error) {
+	user, err := models.UserByIDAndAccountID(userID, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("user %d: %w", userID, err)
+	}
+	if user.IsDisabled || user.IsDeleted {
+		return nil, fmt.Errorf("user %s is disabled", user.Username)
+	}
+	perm, err := models.AppPermissionsByUserAccountAndApp(userID, accountID, appID)
+	if err != nil {
+		return nil, err
+	}
+	switch scope {
+	case mcpScopeShared:
+		if !perm.CanShared {
+			return nil, fmt.Errorf("user %s may not use the shared recipes of this app", user.Username)
+		}
+	case mcpScopeIndividual:
+		if !perm.CanIndividual {
+			return nil, fmt.Errorf("user %s may not use the individual recipes of this app", user.Username)
+		}
+	default:
+		return nil, fmt.Errorf("unknown scope %s", scope)
+	}
+	return user, nil
+}
+
+// runRecipe queues a run of the recipe for the client and returns its id.
+// The output of the run goes to the client and its result is collected for
+// get-run-result.
+func (srv *McpServer) runRecipe(c *McpClient, scope string, page int, recipeName string, args json.RawMessage) (string, error) {
+	jr, _, err := srv.recipeJob(c, scope, page, recipeName, args)
+	if err != nil {
+		return "", err
+	}
+	jr.McpSession = c.SessionID
+
+	if err := CheckJobImagePolicy(jr, srv.AccountID); err != nil {
+		return "", err
+	}
+
+	// The run reports its progress to the clients with lifecycle events.
+	jr.RunID = uuid.NewString()
+	watchRun(jr)
 	if err := DoNow(jr); err != nil {
-		return err
+		unwatchRun(jr.RunID)
+		return "", err
 	}
-	srv.broadcast([]byte("--MCP JOB FINISHED--"))
-	return nil
+	return jr.RunID, nil
+}
+
+// callRun answers a tool call running a recipe, with the run id right away
+// or with the result of the run when the call waits for it.
+func (srv *McpServer) callRun(env *mcpEnvelope, scope string, page int, recipeName string, args json.RawMessage, wait time.Duration) {
+	runID, err := srv.runRecipe(env.Client, scope, page, recipeName, args)
+	if errors.Is(err, errInvalidArgs) {
+		srv.sendError(env, mcpInvalidParams, fmt.Sprintf("args: %v", err))
+		return
+	}
+	if err != nil {
+		srv.toolError(env, err)
+		return
+	}
+	res, _ := RunResultOf(srv.appID(), runID)
+	srv.waitReply(env, res, wait)
 }
 
-func (srv *McpServer) sendError(env *mcpEnvelope, msg string) {
-	resp := jsonrpcResponse{JSONRPC: "2.0", ID: env.Msg.ID, Error: msg}
-	b, _ := json.Marshal(resp)
-	env.Client.Send <- b
+// runResult returns the result of a watched run of the app when the client
+// may see it: the individual runs of the user of its API key, and the shared
+// runs when that user may use the shared recipes.
+func (srv *McpServer) runResult(c *McpClient, runID string) (RunResult, bool) {
+	res, ok := RunResultOf(srv.appID(), runID)
+	if !ok {
+		return RunResult{}, false
+	}
+	if res.scope == util.SCOPE_SHARED {
+		if _, err := ScopeUser(c.UserID, c.AccountID, srv.AppID, mcpScopeShared); err != nil {
+			return RunResult{}, false
+		}
+	} else if res.userID != strconv.FormatInt(c.UserID, 10) {
+		return RunResult{}, false
+	}
+	return res, true
+}
+
+// waitReply answers with the result of a run. When the call waits and the run
+// has not ended, the answer comes from Run once it ended or wait passed, so
+// other requests are handled meanwhile.
+func (srv *McpServer) waitReply(env *mcpEnvelope, res RunResult, wait time.Duration) {
+	if wait == 0 || res.Ended != nil {
+		srv.reply(env, runToolResult(res))
+		return
+	}
+	go func() {
+		res, _ := WaitRun(srv.appID(), res.RunID, wait)
+		srv.replies <- mcpReply{env: env, result: runToolResult(res)}
+	}()
+}
+
+func (srv *McpServer) appID() string {
+	return strconv.FormatInt(srv.AppID, 10)
+}
+
+// planRecipe computes the plan of a recipe without running it.
+func (srv *McpServer) planRecipe(c *McpClient, scope string, page int, recipeName string) (*JobPlan, error) {
+	jr, yd, err := srv.recipeJob(c, scope, page, recipeName, nil)
+	if err != nil {
+		return nil, err
+	}
+	return PlanJob(jr, yd.Cookbook.Environment.Private)
 }
 
 func (srv *McpServer) Run() {
 	for {
 		select {
 		case c := <-srv.Provision:
+			srv.mu.Lock()
 			srv.Clients[c] = true
+			srv.mu.Unlock()
 		case c := <-srv.Deprovision:
+			srv.mu.Lock()
 			if _, ok := srv.Clients[c]; ok {
 				delete(srv.Clients, c)
 				close(c.Send)
 			}
+			srv.mu.Unlock()
 		case env := <-srv.Inbound:
 			srv.handleMessage(env)
+		case r := <-srv.replies:
+			// The client of a stream may be gone by now.
+			if r.env.Reply != nil || srv.Clients[r.env.Client] {
+				srv.reply(r.env, r.result)
+			}
 		}
 	}
 }
@@ -137,7 +137,7 @@ func TestMcpServerPagesAndRecipes(t *testing.T) {
 	yamlStr := sampleYAML()
 	app, _ := createSampleApp(t, yamlStr)
 
-	srv := NewMcpServer(app.ID, app.UUID, yamlStr)
+	srv := NewMcpServer(app.ID, app.UUID, yamlStr, yamlStr)
 	client := &McpClient{Send: make(chan []byte, 2)}
 
 	env := &mcpEnvelope{Msg: &McpMessage{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "lemc.pages"}, Client: client}
@@ -189,7 +189,7 @@ func TestMcpServerApps(t *testing.T) {
 	yamlStr := sampleYAML()
 	app, perm := createSampleApp(t, yamlStr)
 
-	srv := NewMcpServer(app.ID, app.UUID, yamlStr)
+	srv := NewMcpServer(app.ID, app.UUID, yamlStr, yamlStr)
 	client := &McpClient{Send: make(chan []byte, 2), UserID: perm.UserID, AccountID: perm.AccountID}
 
 	params := struct {
@@ -229,7 +229,7 @@ func TestMcpServerToolsAndResources(t *testing.T) {
 	yamlStr := sampleYAML()
 	app, perm := createSampleApp(t, yamlStr)
 
-	srv := NewMcpServer(app.ID, app.UUID, yamlStr)
+	srv := NewMcpServer(app.ID, app.UUID, yamlStr, yamlStr)
 	client := &McpClient{Send: make(chan []byte, 2), UserID: perm.UserID, AccountID: perm.AccountID}
 
 	env := &mcpEnvelope{Msg: &McpMessage{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "tools/list"}, Client: client}
@@ -0,0 +1,367 @@
+package yeschef
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/models"
+)
+
+// mcpToolNameMax is the longest tool name MCP clients accept.
+const mcpToolNameMax = 64
+
+var (
+	mcpToolNameInvalid = regexp.MustCompile(`[^a-z0-9_-]+`)
+	mcpFormVariable    = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+)
+
+// Scopes a recipe runs in, the YAML of the app it is taken from.
+const (
+	mcpScopeShared     = "shared"
+	mcpScopeIndividual = "individual"
+)
+
+var mcpScopes = []string{mcpScopeShared, mcpScopeIndividual}
+
+// Recipe tools take their scope as lemc_scope, next to lemc_wait and
+// lemc_timeout, so it never collides with a form field. Form fields named
+// with the lemc_ prefix are left out of the tools.
+const (
+	mcpScopeArg          = "lemc_scope"
+	mcpReservedArgPrefix = "lemc_"
+)
+
+// errInvalidArgs marks the errors of a tool call caused by its arguments.
+var errInvalidArgs = errors.New("invalid arguments")
+
+// recipeRef is the recipe a per recipe tool runs and the scopes whose YAML
+// has it, the first is the default.
+type recipeRef struct {
+	Page   int
+	Recipe string
+	Scopes []string
+}
+
+// recipeToolName names the tool of a recipe after its page and recipe, e.g.
+// page-1-deploy-web for the recipe "Deploy web" on page 1.
+func recipeToolName(page int, recipe string) string {
+	name := mcpToolNameInvalid.ReplaceAllString(strings.ToLower(recipe), "-")
+	name = fmt.Sprintf("page-%d-%s", page, strings.Trim(name, "-"))
+	if len(name) > mcpToolNameMax {
+		name = name[:mcpToolNameMax]
+	}
+	return name
+}
+
+// formVariable is the argument name of a form field, the name the web form
+// submits it under.
+func formVariable(f models.FormField) string {
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(f.GetVariable())
+}
+
+// formArg reports whether a form field named name is an argument of its
+// recipe tool.
+func formArg(name string) bool {
+	return mcpFormVariable.MatchString(name) && !strings.HasPrefix(strings.ToLower(name), mcpReservedArgPrefix)
+}
+
+// formSchema generates the JSON Schema of the arguments of a recipe from its
+// form. Every form field is a string, radio and select fields are limited to
+// their options and default to the first like the web form.
+func formSchema(form []models.FormField) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for _, f := range form {
+		name := formVariable(f)
+		if !formArg(name) {
+			continue
+		}
+		prop := map[string]interface{}{"type": "string"}
+		if f.Description != "" {
+			prop["description"] = f.Description
+		}
+		if f.IsSelectType() {
+			var values []string
+			for _, o := range f.GetOptions() {
+				values = append(values, o.Value)
+			}
+			if len(values) > 0 {
+				prop["enum"] = values
+				prop["default"] = values[0]
+			}
+		} else if p := f.GetPlaceholder(); p != "" {
+			prop["examples"] = []string{p}
+		}
+		if f.Type == "password" {
+			prop["writeOnly"] = true
+		}
+		properties[name] = prop
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// formEnv turns the arguments of a recipe tool into the environment variables
+// submitting its web form would, one uppercased variable per field. Fields
+// without an argument are sent like an untouched form: radio and select with
+// their first option, the others empty.
+func formEnv(form []models.FormField, raw json.RawMessage) ([]string, error) {
+	args := map[string]json.RawMessage{}
+	if len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return nil, fmt.Errorf("arguments must be an object: %v", err)
+		}
+	}
+
+	var env []string
+	known := map[string]bool{}
+	for _, f := range form {
+		name := formVariable(f)
+		if !formArg(name) {
+			continue
+		}
+		known[name] = true
+
+		var value string
+		options := f.GetOptions()
+		if a, ok := args[name]; ok {
+			if err := json.Unmarshal(a, &value); err != nil {
+				return nil, fmt.Errorf("argument %s must be a string", name)
+			}
+			if f.IsSelectType() && len(options) > 0 && !hasOption(options, value) {
+				return nil, fmt.Errorf("argument %s must be one of the options of the field", name)
+			}
+		} else if f.IsSelectType() && len(options) > 0 {
+			value = options[0].Value
+		}
+		env = append(env, fmt.Sprintf("%s=%s", strings.ToUpper(name), value))
+	}
+
+	for name := range args {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown argument %s", name)
+		}
+	}
+	return env, nil
+}
+
+func hasOption(options []models.FormFieldOption, value string) bool {
+	for _, o := range options {
+		if o.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// recipeTools describes a tool for every recipe of the shared and individual
+// YAML of an app. A recipe in both is one tool taking either scope, its
+// schema comes from the shared YAML. Recipes whose names collide once made
+// tool names get a numbered suffix.
+func recipeTools(shared, individual *models.YamlDefault) ([]ToolDescriptor, map[string]recipeRef) {
+	type recipeKey struct {
+		page   int
+		recipe string
+	}
+	var tools []ToolDescriptor
+	refs := map[string]recipeRef{}
+	names := map[recipeKey]string{}
+	docs := []struct {
+		scope string
+		yd    *models.YamlDefault
+	}{{mcpScopeShared, shared}, {mcpScopeIndividual, individual}}
+	for _, doc := range docs {
+		if doc.yd == nil {
+			continue
+		}
+		for _, p := range doc.yd.Cookbook.Pages {
+			for _, r := range p.Recipes {
+				key := recipeKey{p.PageID, r.Name}
+				if name, ok := names[key]; ok {
+					ref := refs[name]
+					ref.Scopes = append(ref.Scopes, doc.scope)
+					refs[name] = ref
+					continue
+				}
+
+				base := recipeToolName(p.PageID, r.Name)
+				name := base
+				for i := 2; ; i++ {
+					if _, taken := refs[name]; !taken {
+						break
+					}
+					suffix := fmt.Sprintf("-%d", i)
+					name = base[:min(len(base), mcpToolNameMax-len(suffix))] + suffix
+				}
+				names[key] = name
+				refs[name] = recipeRef{Page: p.PageID, Recipe: r.Name, Scopes: []string{doc.scope}}
+
+				desc := fmt.Sprintf("Run the recipe %q of the page %q", r.Name, p.Name)
+				if r.Description != "" {
+					desc += ": " + r.Description
+				}
+				tools = append(tools, ToolDescriptor{
+					Name:        name,
+					Description: desc,
+					InputSchema: withWaitSchema(formSchema(r.Form)),
+				})
+			}
+		}
+	}
+
+	// The scopes of a tool are known once both YAML were read.
+	for i, tool := range tools {
+		properties := tool.InputSchema["properties"].(map[string]interface{})
+		properties[mcpScopeArg] = scopeSchema(refs[tool.Name].Scopes)
+		tools[i] = tool
+	}
+	return tools, refs
+}
+
+// scopeSchema is the scope argument of the tools running recipes.
+func scopeSchema(scopes []string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "string",
+		"enum":        scopes,
+		"default":     scopes[0],
+		"description": "Run the recipe of the shared YAML of the app or of the individual YAML of the API key's user",
+	}
+}
+
+// scopeArg takes the scope argument, named name, out of the arguments of a
+// tool call. It returns def when there is none.
+func scopeArg(raw json.RawMessage, name, def string, scopes []string) (string, json.RawMessage, error) {
+	args := map[string]json.RawMessage{}
+	if len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return "", nil, fmt.Errorf("arguments must be an object: %v", err)
+		}
+	}
+	scope := def
+	if s, ok := args[name]; ok {
+		if err := json.Unmarshal(s, &scope); err != nil {
+			return "", nil, fmt.Errorf("argument %s must be a string", name)
+		}
+		delete(args, name)
+	}
+	for _, s := range scopes {
+		if s == scope {
+			rest, _ := json.Marshal(args)
+			return scope, rest, nil
+		}
+	}
+	return "", nil, fmt.Errorf("argument %s must be one of %s", name, strings.Join(scopes, ", "))
+}
+
+// Waiting for a run in a tool call.
+const (
+	mcpWaitDefault = time.Minute
+	mcpWaitMax     = 10 * time.Minute
+)
+
+// waitSchema is added to the input schema of the tools that run recipes.
+var waitSchema = map[string]interface{}{
+	"lemc_wait": map[string]interface{}{
+		"type":        "boolean",
+		"description": "Wait for the run to end and return its output, data, exit status and artifacts",
+	},
+	"lemc_timeout": map[string]interface{}{
+		"type":        "integer",
+		"description": "Seconds to wait before returning the run id to poll with get-run-result",
+		"minimum":     1,
+		"maximum":     int(mcpWaitMax / time.Second),
+	},
+}
+
+// withWaitSchema adds the wait arguments to an input schema.
+func withWaitSchema(schema map[string]interface{}) map[string]interface{} {
+	properties, _ := schema["properties"].(map[string]interface{})
+	if properties == nil {
+		properties = map[string]interface{}{}
+		schema["properties"] = properties
+	}
+	for k, v := range waitSchema {
+		properties[k] = v
+	}
+	return schema
+}
+
+// waitArgs takes the wait arguments out of the arguments of a tool call. It
+// returns how long to wait, zero when the call does not wait, and the other
+// arguments.
+func waitArgs(raw json.RawMessage) (time.Duration, json.RawMessage, error) {
+	args := map[string]json.RawMessage{}
+	if len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return 0, nil, fmt.Errorf("arguments must be an object: %v", err)
+		}
+	}
+	var wait bool
+	if w, ok := args["lemc_wait"]; ok {
+		if err := json.Unmarshal(w, &wait); err != nil {
+			return 0, nil, fmt.Errorf("argument lemc_wait must be a boolean")
+		}
+	}
+	timeout := mcpWaitDefault
+	if t, ok := args["lemc_timeout"]; ok {
+		var secs int
+		if err := json.Unmarshal(t, &secs); err != nil || secs < 1 {
+			return 0, nil, fmt.Errorf("argument lemc_timeout must be a positive number of seconds")
+		}
+		timeout = min(time.Duration(secs)*time.Second, mcpWaitMax)
+	}
+	delete(args, "lemc_wait")
+	delete(args, "lemc_timeout")
+	rest, _ := json.Marshal(args)
+	if !wait {
+		return 0, rest, nil
+	}
+	return timeout, rest, nil
+}
+
+// runToolResult is the result of a tool call for a run: a summary, the
+// output, data and artifacts as text, and the run result as structured
+// content. A run that failed or was cancelled is a tool error.
+func runToolResult(res RunResult) map[string]interface{} {
+	var summary string
+	switch {
+	case res.Ended == nil:
+		summary = fmt.Sprintf("run %s is %s, call get-run-result with its run_id for the result", res.RunID, res.Status)
+	case res.ExitCode != nil:
+		summary = fmt.Sprintf("run %s %s, exit code %d", res.RunID, res.Status, *res.ExitCode)
+	default:
+		summary = fmt.Sprintf("run %s %s", res.RunID, res.Status)
+	}
+	if res.Error != "" {
+		summary += ": " + res.Error
+	}
+	if len(res.Pending) > 0 {
+		var steps []string
+		for _, p := range res.Pending {
+			steps = append(steps, fmt.Sprintf("step %d (%s)", p.Step, p.Do))
+		}
+		summary += ", still queued to run later: " + strings.Join(steps, ", ")
+	}
+
+	content := []map[string]string{{"type": "text", "text": summary}}
+	if res.Output != "" {
+		content = append(content, map[string]string{"type": "text", "text": res.Output})
+	}
+	for _, d := range res.Data {
+		content = append(content, map[string]string{"type": "text", "text": string(d)})
+	}
+	if len(res.Artifacts) > 0 {
+		content = append(content, map[string]string{"type": "text", "text": "artifacts:\n" + strings.Join(res.Artifacts, "\n")})
+	}
+	return map[string]interface{}{
+		"content":           content,
+		"structuredContent": res,
+		"isError":           res.Status == RunStatusFailed || res.Status == RunStatusCancelled,
+	}
+}
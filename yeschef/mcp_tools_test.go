@@ -0,0 +1,154 @@
+package yeschef
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"gopkg.in/yaml.v3"
+)
+
+var testForm = []models.FormField{
+	{Variable: "target-host", Description: "Host to deploy to", Type: "text", Defaults: []string{"web1"}},
+	{Variable: "env", Type: "select", Options: []models.FormFieldOption{{Label: "Staging", Value: "staging"}, {Label: "Production", Value: "prod"}}},
+	{Name: "token", Type: "password"},
+	{Variable: "bad.name", Type: "text"},
+}
+
+func TestRecipeToolName(t *testing.T) {
+	tests := []struct {
+		page   int
+		recipe string
+		want   string
+	}{
+		{1, "deploy", "page-1-deploy"},
+		{2, "Deploy web!", "page-2-deploy-web"},
+		{3, strings.Repeat("x", 100), "page-3-" + strings.Repeat("x", 57)},
+	}
+	for _, tt := range tests {
+		if got := recipeToolName(tt.page, tt.recipe); got != tt.want {
+			t.Errorf("recipeToolName(%d, %q) = %q, want %q", tt.page, tt.recipe, got, tt.want)
+		}
+	}
+}
+
+func TestFormSchema(t *testing.T) {
+	b, _ := json.Marshal(formSchema(testForm))
+	var schema struct {
+		Properties           map[string]map[string]interface{} `json:"properties"`
+		AdditionalProperties bool                              `json:"additionalProperties"`
+	}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.Properties) != 3 || schema.AdditionalProperties {
+		t.Fatalf("schema = %s", b)
+	}
+	host := schema.Properties["target_host"]
+	if host["type"] != "string" || host["description"] != "Host to deploy to" {
+		t.Errorf("target_host = %v", host)
+	}
+	env := schema.Properties["env"]
+	if !reflect.DeepEqual(env["enum"], []interface{}{"staging", "prod"}) || env["default"] != "staging" {
+		t.Errorf("env = %v", env)
+	}
+	if schema.Properties["token"]["writeOnly"] != true {
+		t.Errorf("token = %v", schema.Properties["token"])
+	}
+}
+
+func TestFormEnv(t *testing.T) {
+	got, err := formEnv(testForm, json.RawMessage(`{"target_host":"web2","env":"prod"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"TARGET_HOST=web2", "ENV=prod", "TOKEN="}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("formEnv = %v, want %v", got, want)
+	}
+
+	got, err = formEnv(testForm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"TARGET_HOST=", "ENV=staging", "TOKEN="}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("formEnv without arguments = %v, want %v", got, want)
+	}
+
+	for _, raw := range []string{`{"env":"dev"}`, `{"nope":"x"}`, `{"target_host":1}`, `[]`} {
+		if _, err := formEnv(testForm, json.RawMessage(raw)); err == nil {
+			t.Errorf("formEnv(%s) should fail", raw)
+		}
+	}
+}
+
+func TestRecipeTools(t *testing.T) {
+	yd := models.NewYamlIndividual()
+	yd.Cookbook.Pages = []models.Page{{
+		PageID: 1,
+		Name:   "Ops",
+		Recipes: []models.Recipe{
+			{Name: "deploy web", Description: "Ship it", Form: testForm},
+			{Name: "deploy-web"},
+		},
+	}}
+	b, _ := yaml.Marshal(yd)
+	srv := NewMcpServer(1, "app-uuid", string(b), "")
+
+	var names []string
+	for _, tool := range srv.Tools {
+		names = append(names, tool.Name)
+	}
+	want := []string{"run-recipe", "plan-recipe", "get-run-result", "page-1-deploy-web", "page-1-deploy-web-2"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("tools = %v, want %v", names, want)
+	}
+	if d := srv.Tools[3].Description; !strings.Contains(d, "Ship it") {
+		t.Errorf("description = %q", d)
+	}
+	if ref := srv.recipes["page-1-deploy-web-2"]; ref.Page != 1 || ref.Recipe != "deploy-web" {
+		t.Errorf("ref = %+v", ref)
+	}
+
+	go srv.Run()
+	c := srv.NewSession(1, 1, "key")
+	resp := mcpCall(t, srv, c, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"page-1-deploy-web","arguments":{"env":"dev"}}}`)
+	var e jsonrpcError
+	if err := json.Unmarshal(resp["error"], &e); err != nil || e.Code != mcpInvalidParams {
+		t.Errorf("call with an invalid option = %v", resp)
+	}
+}
+
+func TestRecipeToolsReservedArgs(t *testing.T) {
+	yd := models.NewYamlIndividual()
+	yd.Cookbook.Pages = []models.Page{{
+		PageID: 1,
+		Name:   "Ops",
+		Recipes: []models.Recipe{{Name: "deploy", Form: []models.FormField{
+			{Variable: "scope", Type: "text"},
+			{Variable: "lemc_wait", Type: "text"},
+		}}},
+	}}
+	tools, _ := recipeTools(yd, nil)
+	if len(tools) != 1 {
+		t.Fatalf("tools = %v", tools)
+	}
+	properties := tools[0].InputSchema["properties"].(map[string]interface{})
+	if scope := properties["scope"].(map[string]interface{}); scope["type"] != "string" || scope["enum"] != nil {
+		t.Errorf("form field scope = %v", scope)
+	}
+	if scope := properties[mcpScopeArg].(map[string]interface{}); !reflect.DeepEqual(scope["enum"], []string{mcpScopeShared}) {
+		t.Errorf("lemc_scope = %v", scope)
+	}
+	if wait := properties["lemc_wait"].(map[string]interface{}); wait["type"] != "boolean" {
+		t.Errorf("lemc_wait = %v", wait)
+	}
+
+	env, err := formEnv(yd.Cookbook.Pages[0].Recipes[0].Form, json.RawMessage(`{"scope":"prod"}`))
+	if err != nil || !reflect.DeepEqual(env, []string{"SCOPE=prod"}) {
+		t.Errorf("formEnv = %v, %v", env, err)
+	}
+}
@@ -0,0 +1,289 @@
+package yeschef
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/reugn/go-quartz/quartz"
+)
+
+// DefaultMisfireThreshold is how late a running scheduler may be before it
+// skips a run instead of firing it.
+const DefaultMisfireThreshold = 48 * time.Hour
+
+// maxCatchUpRuns caps how many missed runs the fire_all policy replays.
+const maxCatchUpRuns = 100
+
+// maxMissedScan caps how many missed run times of a cron trigger are counted.
+const maxMissedScan = 10000
+
+var (
+	recoveryMu     sync.Mutex
+	recoveryReport = &models.JobRecoveryReport{}
+)
+
+// misfireThreshold reads LEMC_MISFIRE_THRESHOLD. Misfires found at startup are
+// handled by the job's misfire policy, the threshold only applies to runs the
+// scheduler falls behind on while it is running.
+func misfireThreshold() time.Duration {
+	threshold := DefaultMisfireThreshold
+	if raw := strings.TrimSpace(os.Getenv("LEMC_MISFIRE_THRESHOLD")); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			log.Printf("invalid LEMC_MISFIRE_THRESHOLD %q, using %s", raw, DefaultMisfireThreshold)
+		} else {
+			threshold = d
+		}
+	}
+	return threshold
+}
+
+// misfirePolicy returns the misfire policy declared for job, fire_once when
+// none was declared.
+func misfirePolicy(job quartz.Job) string {
+	var policy string
+	switch j := job.(type) {
+	case *StepJob:
+		policy = j.Step.Misfire
+	case *RecipeScheduleJob:
+		policy = j.Schedule.Misfire
+	}
+	if policy == "" {
+		return models.MisfireFireOnce
+	}
+	return policy
+}
+
+// missedRuns counts the run times of trigger from next up to now and returns
+// the first run time after now. A run once trigger misses at most one run and
+// has no run time left. Cron triggers are counted up to maxMissedScan.
+func missedRuns(trigger quartz.Trigger, next, now int64) (int, int64) {
+	if next > now {
+		return 0, next
+	}
+
+	switch t := trigger.(type) {
+	case *quartz.RunOnceTrigger:
+		return 1, 0
+	case *quartz.SimpleTrigger:
+		interval := t.Interval.Nanoseconds()
+		if interval <= 0 {
+			return 1, 0
+		}
+		missed := (now-next)/interval + 1
+		return int(missed), next + missed*interval
+	}
+
+	missed := 0
+	for next <= now && missed < maxMissedScan {
+		missed++
+		after, err := trigger.NextFireTime(next)
+		if err != nil || after <= next {
+			return missed, 0
+		}
+		next = after
+	}
+	if next <= now {
+		after, err := trigger.NextFireTime(now)
+		if err != nil {
+			return missed, 0
+		}
+		next = after
+	}
+	return missed, next
+}
+
+// recoverJob rewrites a queued job in place at startup, applying its misfire
+// policy when run times passed while the server was down. Jobs with nothing
+// left to run are removed from the queue. It returns a report entry for misfired jobs and how many missed runs the
+// caller should replay.
+func (jq *jobQueue) recoverJob(job quartz.ScheduledJob, now int64) (*models.JobRecoveryEntry, int, error) {
+	next := job.NextRunTime()
+	if opts := job.JobDetail().Options(); next == models.ScheduledJobPaused || (opts != nil && opts.Suspended) {
+		return nil, 0, jq.restore(job)
+	}
+
+	// Count on a copy of a bounded trigger, its state is advanced per run.
+	trigger := job.Trigger()
+	bounded, isBounded := trigger.(*BoundedTrigger)
+	if isBounded {
+		trigger = bounded.clone()
+	}
+	missed, upcoming := missedRuns(trigger, next, now)
+	if missed == 0 {
+		return nil, 0, jq.restore(job)
+	}
+
+	entry := &models.JobRecoveryEntry{
+		Queue:  jq.Name,
+		JobKey: job.JobDetail().JobKey().Name(),
+		Policy: misfirePolicy(job.JobDetail().Job()),
+		Missed: missed,
+	}
+	if row, err := jq.newScheduledJobRow(job); err == nil {
+		entry.RecipeName = row.RecipeName
+	}
+
+	if isBounded && bounded.ended(now) {
+		// The schedule ended while the server was down.
+		return entry, 0, jq.drop(job)
+	}
+
+	replay := 0
+	switch entry.Policy {
+	case models.MisfireSkip:
+		if upcoming == 0 {
+			// A run once job that missed its run is dropped.
+			return entry, 0, jq.drop(job)
+		}
+		next = upcoming
+	case models.MisfireFireAll:
+		replay = min(missed, maxCatchUpRuns)
+		entry.Fired = replay
+		if upcoming == 0 {
+			// Nothing runs after the missed runs, replaying them is all
+			// that is left of the job.
+			return entry, replay, jq.drop(job)
+		}
+		next = upcoming
+	default:
+		next = now
+		entry.Fired = 1
+	}
+	trigger = job.Trigger()
+	if isBounded {
+		// Skipped runs do not count against max_runs, replayed ones do. The
+		// interval grid starts over from the recovered run, outside blackouts.
+		restarted := bounded.clone()
+		restarted.Offset = 0
+		restarted.Runs += replay
+		allowed, err := restarted.allowed(next)
+		if err != nil {
+			return entry, 0, err
+		}
+		next, trigger = allowed, restarted
+	}
+	entry.NextRunTime = time.Unix(0, next)
+
+	recovered := &scheduledLemcJob{
+		jobDetail:   job.JobDetail(),
+		trigger:     trigger,
+		nextRunTime: next,
+	}
+	return entry, replay, jq.restore(recovered)
+}
+
+// catchUpRgx matches the key of the row that keeps the catch-up runs of a job
+// left by a shutdown.
+var catchUpRgx = regexp.MustCompile(`^(.*)\[catch-up:(\d+)\]$`)
+
+// catchUpJob returns the row that keeps n catch-up runs of job until the
+// next start, or nil when there are none left. It is paused so the scheduler
+// never fires it, Recover replays it instead.
+func catchUpJob(job quartz.ScheduledJob, n int) quartz.ScheduledJob {
+	if n <= 0 {
+		return nil
+	}
+	opts := quartz.NewDefaultJobDetailOptions()
+	if o := job.JobDetail().Options(); o != nil {
+		*opts = *o
+	}
+	opts.Replace = true
+	key := job.JobDetail().JobKey()
+	name := fmt.Sprintf("%s[catch-up:%d]", catchUpOf(key.Name()), n)
+	return &scheduledLemcJob{
+		jobDetail:   quartz.NewJobDetailWithOptions(job.JobDetail().Job(), quartz.NewJobKeyWithGroup(name, key.Group()), opts),
+		trigger:     job.Trigger(),
+		nextRunTime: models.ScheduledJobPaused,
+	}
+}
+
+// catchUpRuns returns how many runs the row with key keeps when it is a
+// catch-up row.
+func catchUpRuns(key string) (int, bool) {
+	m := catchUpRgx.FindStringSubmatch(key)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[2])
+	if err != nil {
+		return 0, false
+	}
+	return min(n, maxCatchUpRuns), true
+}
+
+// catchUpOf returns the key of the job a catch-up row with key belongs to,
+// which is key itself for other rows.
+func catchUpOf(key string) string {
+	if m := catchUpRgx.FindStringSubmatch(key); m != nil {
+		return m[1]
+	}
+	return key
+}
+
+// replayMissedRuns runs job n times one after the other so catch-up runs of
+// the same step never overlap. Shutdown waits for the run going on, the runs
+// left are put back in queue and replayed on the next start.
+func replayMissedRuns(ctx context.Context, queue string, job quartz.ScheduledJob, n int) {
+	key := catchUpOf(job.JobDetail().JobKey().Name())
+	r := trackRun(queue, nil, true)
+	defer r.finish()
+	for i := 0; i < n; i++ {
+		if !r.nextAfter(catchUpJob(job, n-i), catchUpJob(job, n-i-1)) {
+			return
+		}
+		if err := job.JobDetail().Job().Execute(ctx); err != nil {
+			log.Printf("catch up run %d/%d of %s: %v", i+1, n, key, err)
+		}
+	}
+}
+
+// resetRecoveryReport starts a new startup report.
+func resetRecoveryReport() {
+	recoveryMu.Lock()
+	defer recoveryMu.Unlock()
+	recoveryReport = &models.JobRecoveryReport{At: time.Now()}
+}
+
+// addRecovered records a recovered job and, when it misfired, what recovery
+// did with it.
+func addRecovered(entry *models.JobRecoveryEntry) {
+	recoveryMu.Lock()
+	defer recoveryMu.Unlock()
+	recoveryReport.Recovered++
+	if entry != nil {
+		recoveryReport.Entries = append(recoveryReport.Entries, *entry)
+	}
+}
+
+// LastRecoveryReport returns a copy of the report built when the queues were
+// recovered at startup.
+func LastRecoveryReport() models.JobRecoveryReport {
+	recoveryMu.Lock()
+	defer recoveryMu.Unlock()
+	report := *recoveryReport
+	report.Entries = append([]models.JobRecoveryEntry(nil), recoveryReport.Entries...)
+	return report
+}
+
+// logRecoveryReport writes the startup report to the log.
+func logRecoveryReport() {
+	report := LastRecoveryReport()
+	log.Printf("recovered %d scheduled jobs, %d of them missed runs while down", report.Recovered, len(report.Entries))
+	for _, e := range report.Entries {
+		switch {
+		case e.Fired == 0:
+			log.Printf("misfire %s %s (%s): skipped %d missed runs", e.Queue, e.JobKey, e.Policy, e.Missed)
+		default:
+			log.Printf("misfire %s %s (%s): %d missed runs, caught up with %d", e.Queue, e.JobKey, e.Policy, e.Missed, e.Fired)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package yeschef
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/reugn/go-quartz/quartz"
+)
+
+func testEveryJob(key, misfire string, next int64) *scheduledLemcJob {
+	job := testStepJob(key, next)
+	sj := job.jobDetail.Job().(*StepJob)
+	sj.Step.Do = "every.10.minutes"
+	sj.Step.Misfire = misfire
+	job.trigger = quartz.NewSimpleTrigger(10 * time.Minute)
+	return job
+}
+
+func TestMissedRuns(t *testing.T) {
+	now := time.Now().UnixNano()
+	every := quartz.NewSimpleTrigger(10 * time.Minute)
+
+	if missed, next := missedRuns(every, now+1, now); missed != 0 || next != now+1 {
+		t.Fatalf("future run: missed=%d next=%d", missed, next)
+	}
+
+	start := now - int64(25*time.Minute)
+	missed, next := missedRuns(every, start, now)
+	if missed != 3 || next != start+int64(30*time.Minute) {
+		t.Fatalf("simple trigger: missed=%d next=%v", missed, time.Unix(0, next))
+	}
+
+	if missed, next := missedRuns(quartz.NewRunOnceTrigger(time.Minute), start, now); missed != 1 || next != 0 {
+		t.Fatalf("run once trigger: missed=%d next=%d", missed, next)
+	}
+
+	cron, err := quartz.NewCronTrigger("0 * * * * *")
+	if err != nil {
+		t.Fatalf("cron: %v", err)
+	}
+	first, _ := cron.NextFireTime(now - int64(10*time.Minute))
+	missed, next = missedRuns(cron, first, now)
+	if missed < 9 || missed > 10 || next <= now {
+		t.Fatalf("cron trigger: missed=%d next=%v", missed, time.Unix(0, next))
+	}
+}
+
+func TestJobQueueRecoverMisfirePolicies(t *testing.T) {
+	q := newTestQueue(t, EVERY_QUEUE)
+	now := time.Now().UnixNano()
+	start := now - int64(25*time.Minute)
+
+	tests := []struct {
+		policy string
+		replay int
+		next   int64
+	}{
+		{"", 0, now},
+		{models.MisfireFireOnce, 0, now},
+		{models.MisfireFireAll, 3, start + int64(30*time.Minute)},
+		{models.MisfireSkip, 0, start + int64(30*time.Minute)},
+	}
+	for _, tt := range tests {
+		key := "misfire-" + tt.policy
+		entry, replay, err := q.recoverJob(testEveryJob(key, tt.policy, start), now)
+		if err != nil {
+			t.Fatalf("%s: recover: %v", key, err)
+		}
+		if entry == nil || entry.Missed != 3 || replay != tt.replay {
+			t.Fatalf("%s: entry=%+v replay=%d", key, entry, replay)
+		}
+		job, err := q.Get(quartz.NewJobKey(key))
+		if err != nil {
+			t.Fatalf("%s: get: %v", key, err)
+		}
+		if job.NextRunTime() != tt.next {
+			t.Fatalf("%s: next run %v, want %v", key, time.Unix(0, job.NextRunTime()), time.Unix(0, tt.next))
+		}
+	}
+}
+
+func TestJobQueueRecoverSkipsRunOnce(t *testing.T) {
+	q := newTestQueue(t, IN_QUEUE)
+	now := time.Now().UnixNano()
+
+	job := testStepJob("skip-once", now-int64(time.Hour))
+	job.jobDetail.Job().(*StepJob).Step.Misfire = models.MisfireSkip
+	entry, _, err := q.recoverJob(job, now)
+	if err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	if entry == nil || entry.Fired != 0 || !entry.NextRunTime.IsZero() {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	if n, _ := q.Size(); n != 0 {
+		t.Fatalf("expected skipped run once job to be dropped, got %d jobs", n)
+	}
+
+	future := testStepJob("future", now+int64(time.Hour))
+	if entry, _, err := q.recoverJob(future, now); err != nil || entry != nil {
+		t.Fatalf("future job: entry=%+v err=%v", entry, err)
+	}
+	if got, err := q.Get(quartz.NewJobKey("future")); err != nil || got.NextRunTime() != future.nextRunTime {
+		t.Fatalf("future job not kept as is: %v", err)
+	}
+}
+
+func TestJobQueueRecoverReport(t *testing.T) {
+	q := newTestQueue(t, EVERY_QUEUE)
+	now := time.Now().UnixNano()
+	if err := q.Push(testEveryJob("late", models.MisfireSkip, now-int64(time.Hour))); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if err := q.Push(testEveryJob("on-time", "", now+int64(time.Hour))); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	resetRecoveryReport()
+	if err := q.Recover(context.Background(), quartz.NewStdSchedulerWithOptions(quartz.StdSchedulerOptions{}, q, nil)); err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+
+	report := LastRecoveryReport()
+	if report.Recovered != 2 || len(report.Entries) != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if e := report.Entries[0]; e.JobKey != "late" || e.Policy != models.MisfireSkip || e.Missed != 7 || e.Fired != 0 {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+}
+
+func TestStartQueueRecoversBeforeFiring(t *testing.T) {
+	q := newTestQueue(t, EVERY_QUEUE)
+	now := time.Now().UnixNano()
+	if err := q.Push(testEveryJob("overdue", models.MisfireSkip, now-int64(time.Hour))); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	s := NewQuartzScheduler(q)
+	if s.IsStarted() {
+		t.Fatal("expected the scheduler to wait for its queue to be recovered")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		s.Wait(context.Background())
+	})
+	resetRecoveryReport()
+	startQueue(ctx, q, s)
+
+	if !s.IsStarted() {
+		t.Fatal("scheduler not started")
+	}
+	job, err := q.Get(quartz.NewJobKey("overdue"))
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if job.NextRunTime() <= now {
+		t.Fatalf("overdue job was not rescheduled by its misfire policy, next run %v", time.Unix(0, job.NextRunTime()))
+	}
+	report := LastRecoveryReport()
+	if len(report.Entries) != 1 || report.Entries[0].Policy != models.MisfireSkip || report.Entries[0].Fired != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+func TestShutdownPersistsCatchUpRuns(t *testing.T) {
+	q := newTestQueue(t, IN_QUEUE)
+	prevQueue, prevSched := XoxoX.InQueue, XoxoX.InScheduler
+	XoxoX.InQueue, XoxoX.InScheduler = q, quartz.NewStdSchedulerWithOptions(quartz.StdSchedulerOptions{}, q, nil)
+	t.Cleanup(func() { XoxoX.InQueue, XoxoX.InScheduler = prevQueue, prevSched })
+	resetEngine(t)
+
+	job := testStepJob("late", time.Now().UnixNano())
+	if got := catchUpJob(job, 0); got != nil {
+		t.Fatalf("expected no catch-up row without runs left, got %v", got.JobDetail().JobKey())
+	}
+
+	// The engine stops before the first catch-up run starts.
+	engine.mu.Lock()
+	engine.stopping = true
+	engine.mu.Unlock()
+	replayMissedRuns(context.Background(), IN_QUEUE, job, 3)
+
+	row, err := q.Get(quartz.NewJobKey("late[catch-up:3]"))
+	if err != nil {
+		t.Fatalf("expected the catch-up runs to be persisted: %v", err)
+	}
+	if row.NextRunTime() != models.ScheduledJobPaused {
+		t.Fatalf("catch-up row is not paused, next run %d", row.NextRunTime())
+	}
+	if got := catchUpJob(row, 2).JobDetail().JobKey().Name(); got != "late[catch-up:2]" {
+		t.Fatalf("catch-up key of a catch-up row %q", got)
+	}
+
+	resetRecoveryReport()
+	if err := q.Recover(context.Background(), XoxoX.InScheduler); err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	if report := LastRecoveryReport(); report.Recovered != 0 {
+		t.Fatalf("catch-up row recovered as a job: %+v", report)
+	}
+	// Still stopping, the replay puts the runs straight back.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, err := q.Get(quartz.NewJobKey("late[catch-up:3]"))
+		engine.mu.Lock()
+		running := len(engine.running)
+		engine.mu.Unlock()
+		if err == nil && running == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the replay to keep its runs: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
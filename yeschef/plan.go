@@ -0,0 +1,231 @@
+package yeschef
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/jaredfolkins/letemcook/util"
+)
+
+const maskedValue = "********"
+
+var secretKeyRgx = regexp.MustCompile(`(?i)(secret|passw|token|api_?key|private|credential)`)
+
+// MountPlan describes a bind mount a step container would receive.
+type MountPlan struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// StepPlan describes what running a single step would do.
+type StepPlan struct {
+	Step           int         `json:"step"`
+	Name           string      `json:"name"`
+	Image          string      `json:"image"`
+	ResolvedImage  string      `json:"resolved_image"`
+	Digest         string      `json:"digest,omitempty"`
+	PullPolicy     string      `json:"pull_policy"`
+	Env            []string    `json:"env"`
+	Mounts         []MountPlan `json:"mounts"`
+	TimeoutSeconds int         `json:"timeout_seconds"`
+	Do             string      `json:"do"`
+	Trigger        string      `json:"trigger"`
+	RunsOn         []string    `json:"runs_on,omitempty"`
+	Queue          string      `json:"queue"`
+	JobKey         string      `json:"job_key"`
+	Errors         []string    `json:"errors,omitempty"`
+}
+
+// JobPlan is a dry run of a JobRecipe. It is computed without talking to Docker.
+type JobPlan struct {
+	Recipe string     `json:"recipe"`
+	UUID   string     `json:"uuid"`
+	PageID string     `json:"page_id"`
+	Scope  string     `json:"scope"`
+	JobKey string     `json:"job_key"`
+	Steps  []StepPlan `json:"steps"`
+}
+
+// stepEnvironment returns the environment a step container is started with.
+func stepEnvironment(job *JobRecipe, st models.Step) []string {
+	// Start with a copy of the job-level environment variables
+	stepEnv := make([]string, len(job.Env))
+	copy(stepEnv, job.Env)
+
+	// Append system-defined step env vars
+	stepEnv = append(stepEnv, PYTHON_UNBUFFERED)
+	stepEnv = append(stepEnv, fmt.Sprintf(STEP_ID, st.Step))
+	stepEnv = append(stepEnv, fmt.Sprintf(LEMC_HTML_ID, job.UUID, job.PageID, job.Scope))
+	stepEnv = append(stepEnv, fmt.Sprintf(LEMC_CSS_ID, job.UUID, job.PageID, job.Scope))
+	stepEnv = append(stepEnv, fmt.Sprintf(LEMC_JS_ID, job.UUID, job.PageID, job.Scope))
+
+	// Append user-defined env vars from the step configuration
+	stepEnvVars := st.GetEnvironment()
+	if len(stepEnvVars) > 0 {
+		stepEnv = append(stepEnv, stepEnvVars...)
+	}
+	return stepEnv
+}
+
+// parseStepDo returns the queue a step's "do" value schedules on and the
+// delay or interval it describes. Zero is returned for "now".
+func parseStepDo(do string) (string, time.Duration, error) {
+	do = strings.TrimSpace(do)
+	switch {
+	case lemc_do_now_rgx.MatchString(do):
+		return NOW_QUEUE, 0, nil
+	case lemc_do_in_rgx.MatchString(do), lemc_do_every_rgx.MatchString(do):
+		parts := strings.SplitN(do, ".", 3)
+		digit, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return "", 0, err
+		}
+		ts, err := Ts(parts[2])
+		if err != nil {
+			return "", 0, err
+		}
+		queue := IN_QUEUE
+		if parts[0] == EVERY_QUEUE {
+			queue = EVERY_QUEUE
+		}
+		return queue, time.Duration(digit) * ts, nil
+	}
+	return "", 0, fmt.Errorf("unknown do value %q", do)
+}
+
+// stepBounds describes the until, max_runs, blackout and jitter of a step.
+func stepBounds(st models.Step) string {
+	var bounds []string
+	if st.Until != "" {
+		bounds = append(bounds, "until "+st.Until)
+	}
+	if st.MaxRuns > 0 {
+		bounds = append(bounds, fmt.Sprintf("at most %d times", st.MaxRuns))
+	}
+	if len(st.Blackout) > 0 {
+		bounds = append(bounds, "except "+strings.Join(st.Blackout, ", "))
+	}
+	if st.Jitter != "" {
+		bounds = append(bounds, "with up to "+st.Jitter+" jitter")
+	}
+	if len(bounds) == 0 {
+		return ""
+	}
+	return ", " + strings.Join(bounds, ", ")
+}
+
+// maskEnv hides the values of private cookbook variables and of any variable
+// whose name looks like a secret.
+func maskEnv(env []string, private []string) []string {
+	secret := map[string]bool{}
+	for _, p := range private {
+		if k, _, ok := strings.Cut(p, "="); ok {
+			secret[k] = true
+		}
+	}
+
+	masked := make([]string, 0, len(env))
+	for _, kv := range env {
+		k, v, ok := strings.Cut(kv, "=")
+		if ok && v != "" && (secret[k] || secretKeyRgx.MatchString(k)) {
+			kv = k + "=" + maskedValue
+		}
+		masked = append(masked, kv)
+	}
+	return masked
+}
+
+// PlanJob computes what DoNow would do for the job without touching Docker.
+// Values of the private variables and of secret looking keys are masked.
+func PlanJob(job *JobRecipe, private []string) (*JobPlan, error) {
+	if job.Recipe.Name == "" {
+		return nil, NewUserVisibleError("RECIPE_NOT_FOUND", "recipe not found", nil)
+	}
+
+	plan := &JobPlan{
+		Recipe: job.Recipe.Name,
+		UUID:   job.UUID,
+		PageID: job.PageID,
+		Scope:  job.Scope,
+		JobKey: LemcJobKey(job, NOW_QUEUE),
+	}
+
+	for _, st := range job.Recipe.Steps {
+		sp := StepPlan{
+			Step:       st.Step,
+			Name:       st.Name,
+			Image:      st.Image,
+			PullPolicy: st.PullPolicy,
+			Do:         st.Do,
+		}
+		if sp.PullPolicy == "" {
+			sp.PullPolicy = models.PullPolicyIfNotPresent
+		}
+		if !models.ValidPullPolicy(st.PullPolicy) {
+			sp.Errors = append(sp.Errors, fmt.Sprintf("pull_policy %q is not valid (must be 'always', 'if-not-present' or 'scheduled')", st.PullPolicy))
+		}
+
+		if resolved, _, _, err := util.NormalizeImageName(st.Image); err == nil {
+			sp.ResolvedImage = resolved
+		} else {
+			sp.Errors = append(sp.Errors, err.Error())
+		}
+		// Only a digest pinned image is known to run that digest.
+		_, sp.Digest = models.SplitImageReference(st.Image)
+
+		stepEnv := stepEnvironment(job, st)
+		sp.Env = maskEnv(stepEnv, private)
+
+		jm := util.NewJobMetaFromEnv(stepEnv)
+		if cf, err := util.ContainerFilePaths(jm, job.Recipe.IsShared); err == nil {
+			for _, m := range NewHostConfig(cf, job.Recipe.IsShared).Mounts {
+				sp.Mounts = append(sp.Mounts, MountPlan{Source: m.Source, Target: m.Target})
+			}
+		} else {
+			sp.Errors = append(sp.Errors, err.Error())
+		}
+
+		timeout, err := timeoutInSeconds(st.Timeout)
+		if err != nil {
+			sp.Errors = append(sp.Errors, "timeout: "+err.Error())
+		}
+		sp.TimeoutSeconds = timeout
+
+		queue, d, err := parseStepDo(st.Do)
+		if err != nil {
+			sp.Errors = append(sp.Errors, err.Error())
+		} else {
+			sp.Queue = queue
+			sp.JobKey = LemcJobKey(job, queue)
+			if queue != NOW_QUEUE {
+				sp.JobKey = StepJobKey(job, queue, st.Step)
+			}
+			switch queue {
+			case NOW_QUEUE:
+				sp.Trigger = "runs immediately"
+			case IN_QUEUE:
+				sp.Trigger = fmt.Sprintf("runs once after %s", d)
+			case EVERY_QUEUE:
+				sp.Trigger = fmt.Sprintf("runs every %s", d) + stepBounds(st)
+			}
+		}
+		if err := ValidateStepBounds(st); err != nil {
+			sp.Errors = append(sp.Errors, err.Error())
+		}
+		if len(st.RunsOn) > 0 {
+			sp.RunsOn = st.RunsOn
+			sp.Mounts = nil
+			sp.Trigger += ", on a worker labeled " + strings.Join(st.RunsOn, ", ")
+			if err := ValidateRunsOn(st); err != nil {
+				sp.Errors = append(sp.Errors, err.Error())
+			}
+		}
+
+		plan.Steps = append(plan.Steps, sp)
+	}
+	return plan, nil
+}
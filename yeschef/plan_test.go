@@ -0,0 +1,85 @@
+package yeschef
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/jaredfolkins/letemcook/util"
+)
+
+func TestParseStepDo(t *testing.T) {
+	cases := []struct {
+		do    string
+		queue string
+		d     time.Duration
+	}{
+		{"now", NOW_QUEUE, 0},
+		{"in.5.minutes", IN_QUEUE, 5 * time.Minute},
+		{"every.2.hours", EVERY_QUEUE, 2 * time.Hour},
+	}
+	for _, c := range cases {
+		queue, d, err := parseStepDo(c.do)
+		if err != nil {
+			t.Fatalf("%s: %v", c.do, err)
+		}
+		if queue != c.queue || d != c.d {
+			t.Errorf("%s: got %s %s, want %s %s", c.do, queue, d, c.queue, c.d)
+		}
+	}
+	if _, _, err := parseStepDo("sometime"); err == nil {
+		t.Errorf("expected error for unknown do value")
+	}
+}
+
+func TestMaskEnv(t *testing.T) {
+	env := []string{"DB_HOST=localhost", "DB_PASS=hunter2", "GITHUB_TOKEN=abc", "EMPTY_SECRET=", "LEMC_UUID=u1"}
+	got := maskEnv(env, []string{"DB_PASS=hunter2"})
+	want := []string{"DB_HOST=localhost", "DB_PASS=" + maskedValue, "GITHUB_TOKEN=" + maskedValue, "EMPTY_SECRET=", "LEMC_UUID=u1"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("env[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPlanJobHasNoSideEffects(t *testing.T) {
+	uuid := "plan-side-effects"
+	pinned := "docker.io/library/alpine@sha256:0123456789abcdef"
+	if _, err := models.RecordImageRun("alpine", "sha256:local"); err != nil {
+		t.Fatalf("record run: %v", err)
+	}
+	job := &JobRecipe{
+		JobType:  JOB_TYPE_APP,
+		UUID:     uuid,
+		AppID:    "1",
+		UserID:   "1",
+		Username: "planner",
+		PageID:   "1",
+		Scope:    "individual",
+		Env:      LemcEnv("individual", 1, "planner", uuid, "deploy", 1, ""),
+		Recipe: models.Recipe{Name: "deploy", Steps: []models.Step{
+			{Step: 1, Image: "alpine", Do: "now", Timeout: "1.minutes"},
+			{Step: 2, Image: pinned, Do: "now", Timeout: "1.minutes"},
+		}},
+	}
+
+	plan, err := PlanJob(job, nil)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(plan.Steps) != 2 || len(plan.Steps[0].Mounts) == 0 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	if d := plan.Steps[0].Digest; d != "" {
+		t.Errorf("unpinned image got digest %q", d)
+	}
+	if d := plan.Steps[1].Digest; d != "sha256:0123456789abcdef" {
+		t.Errorf("pinned image digest = %q", d)
+	}
+	if _, err := os.Stat(filepath.Join(util.LockerPath(), uuid)); !os.IsNotExist(err) {
+		t.Errorf("planning created the locker of the app, stat err=%v", err)
+	}
+}
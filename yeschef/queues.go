@@ -1,16 +1,15 @@
 package yeschef
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
-	"log"
-	"math"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
 	"sync"
 
+	"github.com/jaredfolkins/letemcook/models"
 	"github.com/jaredfolkins/letemcook/util"
 	"github.com/reugn/go-quartz/logger"
 	"github.com/reugn/go-quartz/quartz"
@@ -45,7 +44,18 @@ type serializedStepJob struct {
 	NextRunTime int64                    `json:"next_run_time"`
 }
 
+type serializedRecipeScheduleJob struct {
+	Job         *RecipeScheduleJob       `json:"job"`
+	JobKey      string                   `json:"job_key"`
+	Description string                   `json:"description"`
+	Group       string                   `json:"group"`
+	Options     *quartz.JobDetailOptions `json:"job_options"`
+	Trigger     string                   `json:"trigger"`
+	NextRunTime int64                    `json:"next_run_time"`
+}
+
 type serializedJob struct {
+	Version     int                      `json:"version"`
 	Job         quartz.Job               `json:"job"`
 	JobKey      string                   `json:"job_key"`
 	Description string                   `json:"description"`
@@ -55,109 +65,222 @@ type serializedJob struct {
 	NextRunTime int64                    `json:"next_run_time"`
 }
 
+// jobQueue is a quartz.JobQueue stored in the scheduled_jobs table. Path is
+// the directory the queue used to be persisted in, one JSON file per job,
+// and is only read to import those files.
 type jobQueue struct {
 	mu   sync.Mutex
 	Path string
 	Name string
 }
 
-// Recover scans the queue directory for previously persisted jobs and
-// schedules them with the provided scheduler. This allows the system to
-// restore jobs after an unexpected shutdown or crash.
-func (jq *jobQueue) Recover(s *quartz.StdScheduler) error {
-	// First, collect all jobs while holding the mutex
-	var jobsToSchedule []quartz.ScheduledJob
-	var filesToRemove []string
-	var invalidFilesToRemove []string
+var _ quartz.JobQueue = (*jobQueue)(nil)
+
+func NewQuartzQueue(name string) *jobQueue {
+	path := filepath.Join(util.QueuesPath(), name)
+	return &jobQueue{Path: path, Name: name}
+}
+
+func (jq *jobQueue) unmarshal(data []byte) (quartz.ScheduledJob, error) {
+	data, err := upgradePayload(data)
+	if err != nil {
+		return nil, err
+	}
+	switch jq.Name {
+	case NOW_QUEUE:
+		return unmarshalRecipeJob(data)
+	case IN_QUEUE:
+		return unmarshalInStepJob(data)
+	case EVERY_QUEUE:
+		return unmarshalEveryJob(data)
+	}
+	return nil, fmt.Errorf("unknown queue name: %s", jq.Name)
+}
+
+// newScheduledJobRow serializes job into a scheduled_jobs row for the queue.
+func (jq *jobQueue) newScheduledJobRow(job quartz.ScheduledJob) (*models.ScheduledJob, error) {
+	serialized, err := marshal(job)
+	if err != nil {
+		return nil, err
+	}
+
+	key := job.JobDetail().JobKey()
+	row := &models.ScheduledJob{
+		Queue:       jq.Name,
+		JobKey:      key.Name(),
+		JobGroup:    key.Group(),
+		NextRunTime: job.NextRunTime(),
+		Payload:     string(serialized),
+	}
+
+	var recipe *JobRecipe
+	switch j := job.JobDetail().Job().(type) {
+	case *JobRecipe:
+		recipe = j
+	case *StepJob:
+		recipe = j.RecipeJob
+		row.RecipeName = j.Step.Name
+	case *RecipeScheduleJob:
+		recipe = j.RecipeJob
+	}
+	if recipe != nil {
+		if recipe.Recipe.Name != "" {
+			row.RecipeName = recipe.Recipe.Name
+		}
+		row.Username = recipe.Username
+		row.AccountID = recipe.AccountID
+		row.AppID = recipe.AppID
+		row.CookbookID = recipe.CookbookID
+	}
+	return row, nil
+}
 
+// Recover imports any job files left in the legacy queue directory, then
+// restores every persisted job for the provided scheduler. Jobs whose run
+// times passed while the server was down are handled by their misfire policy
+// and added to the startup report. Each job is rewritten in place, so a crash
+// during recovery leaves the jobs not yet restored queued as they were. Jobs
+// that cannot be restored are dead lettered. Catch-up runs left by a shutdown
+// are replayed with ctx. This allows the system to restore jobs after an
+// unexpected shutdown or crash.
+func (jq *jobQueue) Recover(ctx context.Context, s *quartz.StdScheduler) error {
 	jq.mu.Lock()
-	files, err := os.ReadDir(jq.Path)
+	if err := jq.importQueueFiles(); err != nil {
+		logger.Errorf("Recover import queue files: %v", err)
+	}
+
+	rows, err := models.ScheduledJobsByQueue(jq.Name)
+	jq.mu.Unlock()
 	if err != nil {
-		jq.mu.Unlock()
 		return err
 	}
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
+	now := quartz.NowNano()
+	for _, row := range rows {
+		job, err := jq.unmarshal([]byte(row.Payload))
+		if err == nil {
+			err = ValidateScheduledJob(job)
 		}
-
-		path := filepath.Join(jq.Path, file.Name())
-		data, err := os.ReadFile(path)
 		if err != nil {
-			logger.Errorf("Recover read job: %v", err)
+			logger.Errorf("Recover invalid job %s: %v", row.JobKey, err)
+			jq.deadLetterQueued(&row, models.DeadLetterInvalid, err)
 			continue
 		}
-
-		var job quartz.ScheduledJob
-		switch jq.Name {
-		case NOW_QUEUE:
-			job, err = unmarshalRecipeJob(data)
-		case IN_QUEUE:
-			job, err = unmarshalInStepJob(data)
-		case EVERY_QUEUE:
-			job, err = unmarshalEveryStepJob(data)
-		default:
-			logger.Errorf("Recover unknown queue name: %s", jq.Name)
+		if n, ok := catchUpRuns(row.JobKey); ok {
+			if err := jq.drop(job); err != nil {
+				logger.Errorf("Recover catch up runs %s: %v", row.JobKey, err)
+				continue
+			}
+			go replayMissedRuns(ctx, jq.Name, job, n)
 			continue
 		}
+		entry, replay, err := jq.recoverJob(job, now)
 		if err != nil {
-			logger.Errorf("Recover unmarshal job: %v", err)
-			invalidFilesToRemove = append(invalidFilesToRemove, path)
+			logger.Errorf("Recover job %s: %v", row.JobKey, err)
+			jq.deadLetterQueued(&row, models.DeadLetterUnrestored, err)
 			continue
 		}
-
-		// Validate the job before scheduling
-		if err := ValidateScheduledJob(job); err != nil {
-			logger.Errorf("Recover job validation failed: %v", err)
-			invalidFilesToRemove = append(invalidFilesToRemove, path)
-			continue
+		addRecovered(entry)
+		if replay > 0 {
+			go replayMissedRuns(ctx, jq.Name, job, replay)
 		}
+	}
+	s.Reset()
 
-		jobsToSchedule = append(jobsToSchedule, job)
-		filesToRemove = append(filesToRemove, path)
+	return nil
+}
+
+// deadLetterQueued moves a row that is still in its queue to the dead letter
+// store. The row only leaves the queue once it is stored there.
+func (jq *jobQueue) deadLetterQueued(row *models.ScheduledJob, reason string, cause error) {
+	if err := deadLetterRow(row, reason, cause); err != nil {
+		return
+	}
+	if err := models.DeleteScheduledJobByID(row.ID); err != nil {
+		logger.Errorf("Failed to remove dead lettered job %s: %v", row.JobKey, err)
 	}
+}
 
-	// Remove valid files while still holding the mutex
-	for _, path := range filesToRemove {
-		if err := os.Remove(path); err != nil {
-			logger.Errorf("Recover remove job file: %v", err)
-		}
+// restore stores a recovered job in place of its row.
+func (jq *jobQueue) restore(job quartz.ScheduledJob) error {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+
+	row, err := jq.newScheduledJobRow(job)
+	if err != nil {
+		return err
 	}
+	return models.InsertScheduledJob(row, true)
+}
 
-	// Remove invalid files to prevent future problems
-	for _, path := range invalidFilesToRemove {
-		if err := os.Remove(path); err != nil {
-			logger.Errorf("Recover remove invalid job file: %v", err)
-		} else {
-			logger.Infof("Removed invalid job file during recovery: %s", path)
-		}
+// drop removes a recovered job that has nothing left to run.
+func (jq *jobQueue) drop(job quartz.ScheduledJob) error {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+
+	_, err := models.DeleteScheduledJob(jq.Name, job.JobDetail().JobKey().Name())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
 	}
-	jq.mu.Unlock()
+	return err
+}
 
-	// Now schedule jobs without holding the mutex
-	for _, job := range jobsToSchedule {
-		if err := s.ScheduleJob(job.JobDetail(), job.Trigger()); err != nil {
-			logger.Errorf("Recover schedule job: %v", err)
+// importQueueFiles moves jobs persisted as files by earlier versions into the
+// scheduled_jobs table. Imported and unreadable files are removed, followed
+// by the directory once it is empty, so the import only ever runs once.
+func (jq *jobQueue) importQueueFiles() error {
+	files, err := os.ReadDir(jq.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
 		}
+		return err
 	}
 
-	return nil
-}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
 
-var _ quartz.JobQueue = (*jobQueue)(nil)
+		path := filepath.Join(jq.Path, file.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			logger.Errorf("Import read job file %s: %v", path, err)
+			continue
+		}
 
-func NewQuartzQueue(name string) *jobQueue {
-	dataFolder := util.QueuesPath()
-	if _, err := os.Stat(dataFolder); os.IsNotExist(err) {
-		err = os.MkdirAll(dataFolder, FILE_MODE)
+		job, err := jq.unmarshal(data)
+		if err == nil {
+			err = ValidateScheduledJob(job)
+		}
 		if err != nil {
-			log.Fatalf("failed to create queues directory: %v", err)
+			logger.Errorf("Import invalid job file %s: %v", path, err)
+			deadLetterRow(&models.ScheduledJob{Queue: jq.Name, JobKey: file.Name(), Payload: string(data)}, models.DeadLetterInvalid, err)
+			os.Remove(path)
+			continue
+		}
+
+		row, err := jq.newScheduledJobRow(job)
+		if err != nil {
+			logger.Errorf("Import job file %s: %v", path, err)
+			continue
+		}
+		if err := models.InsertScheduledJob(row, false); err != nil {
+			if !errors.Is(err, models.ErrScheduledJobExists) {
+				logger.Errorf("Import job file %s: %v", path, err)
+				continue
+			}
+			logger.Warnf("Import job file %s: job %s is already queued", path, row.JobKey)
+		}
+		if err := os.Remove(path); err != nil {
+			logger.Errorf("Import remove job file %s: %v", path, err)
 		}
 	}
 
-	path := filepath.Join(dataFolder, name)
-	return &jobQueue{Path: path, Name: name}
+	if remaining, err := os.ReadDir(jq.Path); err == nil && len(remaining) == 0 {
+		os.Remove(jq.Path)
+	}
+	return nil
 }
 
 func (jq *jobQueue) Push(job quartz.ScheduledJob) error {
@@ -166,22 +289,43 @@ func (jq *jobQueue) Push(job quartz.ScheduledJob) error {
 
 	logger.Debugf("Push job: %s", job.JobDetail().JobKey())
 
-	var err error
-	var serialized []byte
-	serialized, err = marshal(job)
+	row, err := jq.newScheduledJobRow(job)
 	if err != nil {
 		return err
 	}
 
-	// Store with .json extension for UI compatibility
-	if err = os.WriteFile(fmt.Sprintf("%s/%d.json", jq.Path, job.NextRunTime()),
-		serialized, util.FilePerm); err != nil {
+	opts := job.JobDetail().Options()
+	replace := opts != nil && opts.Replace
+	if err := models.InsertScheduledJob(row, replace); err != nil {
+		if errors.Is(err, models.ErrScheduledJobExists) {
+			return fmt.Errorf("%w: %s", quartz.ErrJobAlreadyExists, row.JobKey)
+		}
 		logger.Errorf("Failed to write job: %s", err)
 		return err
 	}
 	return nil
 }
 
+// update rewrites a job that is still queued in place, keeping its key. It
+// returns quartz.ErrJobNotFound when the job has already left the queue, for
+// example because the scheduler just popped it.
+func (jq *jobQueue) update(job quartz.ScheduledJob) error {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+
+	row, err := jq.newScheduledJobRow(job)
+	if err != nil {
+		return err
+	}
+	if err := models.UpdateScheduledJob(row); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %s", quartz.ErrJobNotFound, row.JobKey)
+		}
+		return err
+	}
+	return nil
+}
+
 func (jq *jobQueue) Pop() (quartz.ScheduledJob, error) {
 	jq.mu.Lock()
 	defer jq.mu.Unlock()
@@ -192,40 +336,25 @@ func (jq *jobQueue) Pop() (quartz.ScheduledJob, error) {
 		return nil, err
 	}
 
-	job, err := findHead(jq)
+	row, err := models.PopScheduledJob(jq.Name)
 	if err != nil {
-		return nil, err
-	}
-
-	// Validate the job before proceeding
-	if err := ValidateScheduledJob(job); err != nil {
-		logger.Errorf("Job validation failed: %v", err)
-		// Remove the invalid job file to prevent repeated failures
-		timestamp := job.NextRunTime()
-		jsonPath := fmt.Sprintf("%s/%d.json", jq.Path, timestamp)
-		plainPath := fmt.Sprintf("%s/%d", jq.Path, timestamp)
-
-		// Try to remove both possible file formats
-		if removeErr := os.Remove(jsonPath); removeErr != nil {
-			os.Remove(plainPath) // Try plain format if json fails
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", quartz.ErrQueueEmpty, jq.Name)
 		}
-
 		return nil, err
 	}
 
-	// Try both .json and non-.json filenames for backward compatibility
-	timestamp := job.NextRunTime()
-	jsonPath := fmt.Sprintf("%s/%d.json", jq.Path, timestamp)
-	plainPath := fmt.Sprintf("%s/%d", jq.Path, timestamp)
-
-	// Try to remove .json file first, then plain file
-	err = os.Remove(jsonPath)
+	// Invalid jobs have already been removed with the pop so they cannot
+	// cause repeated failures. They are kept in the dead letter store.
+	job, err := jq.unmarshal([]byte(row.Payload))
 	if err != nil {
-		err = os.Remove(plainPath)
+		logger.Errorf("Failed to unmarshal job %s: %v", row.JobKey, err)
+		deadLetterRow(row, models.DeadLetterInvalid, err)
+		return nil, err
 	}
-
-	if err != nil {
-		logger.Errorf("Failed to delete job: %s", err)
+	if err := ValidateScheduledJob(job); err != nil {
+		logger.Errorf("Job validation failed: %v", err)
+		deadLetterRow(row, models.DeadLetterInvalid, err)
 		return nil, err
 	}
 
@@ -239,214 +368,89 @@ func (jq *jobQueue) Head() (quartz.ScheduledJob, error) {
 	jq.mu.Lock()
 	defer jq.mu.Unlock()
 
-	job, err := findHead(jq)
-	if err != nil && err.Error() != "no jobs found" {
+	row, err := models.HeadScheduledJob(jq.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", quartz.ErrQueueEmpty, jq.Name)
+		}
 		logger.Errorf("Failed to find job head: %s", err)
 		return nil, err
 	}
 
-	if job != nil {
-		// Validate the job before returning
-		if err := ValidateScheduledJob(job); err != nil {
-			logger.Errorf("Job validation failed in Head: %v", err)
-			return nil, err
-		}
+	job, err := jq.unmarshal([]byte(row.Payload))
+	if err == nil {
+		err = ValidateScheduledJob(job)
 	}
-
-	return job, err
-}
-
-func findHead(jq *jobQueue) (quartz.ScheduledJob, error) {
-	fileInfo, err := os.ReadDir(jq.Path)
 	if err != nil {
-		return nil, err
-	}
-
-	var lastUpdate int64 = math.MaxInt64
-	var filename string
-	for _, file := range fileInfo {
-		if !file.IsDir() {
-			name := file.Name()
-			var timeStr string
-
-			// Handle both .json and non-.json filenames for backward compatibility
-			if strings.HasSuffix(name, ".json") {
-				timeStr = strings.TrimSuffix(name, ".json")
-			} else {
-				timeStr = name
-			}
-
-			time, err := strconv.ParseInt(timeStr, 10, 64)
-			if err == nil && time < lastUpdate {
-				lastUpdate = time
-				filename = name
-			}
+		// Move the broken head out of the queue so the scheduler does not
+		// spin on it.
+		logger.Errorf("Job validation failed in Head: %v", err)
+		if derr := models.DeleteScheduledJobByID(row.ID); derr == nil {
+			deadLetterRow(row, models.DeadLetterInvalid, err)
 		}
-	}
-
-	if lastUpdate == math.MaxInt64 {
-		return nil, errors.New("no jobs found")
-	}
-
-	data, err := os.ReadFile(fmt.Sprintf("%s/%s", jq.Path, filename))
-	if err != nil {
 		return nil, err
 	}
-
-	var job quartz.ScheduledJob
-
-	switch jq.Name {
-	case NOW_QUEUE:
-		var err error
-		job, err = unmarshalRecipeJob(data)
-		if err != nil {
-			return nil, err
-		}
-	case IN_QUEUE:
-		job, err = unmarshalInStepJob(data)
-		if err != nil {
-			return nil, err
-		}
-	case EVERY_QUEUE:
-		job, err = unmarshalEveryStepJob(data)
-		if err != nil {
-			return nil, err
-		}
-	default:
-		return nil, fmt.Errorf("unknown queue name: %s", jq.Name)
-	}
-
 	return job, nil
 }
 
+// Get returns the job with the key's name. Job key names are unique within a
+// queue so the group is not compared.
 func (jq *jobQueue) Get(jobKey *quartz.JobKey) (quartz.ScheduledJob, error) {
 	jq.mu.Lock()
 	defer jq.mu.Unlock()
 
-	fileInfo, err := os.ReadDir(jq.Path)
+	row, err := models.ScheduledJobByKey(jq.Name, jobKey.Name())
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", quartz.ErrJobNotFound, jobKey)
+		}
 		return nil, err
 	}
-	for _, file := range fileInfo {
-		if !file.IsDir() {
-			data, err := os.ReadFile(fmt.Sprintf("%s/%s", jq.Path, file.Name()))
-			if err == nil {
-				var erri error
-				var job quartz.ScheduledJob
-
-				switch jq.Name {
-				case NOW_QUEUE:
-					job, erri = unmarshalRecipeJob(data)
-					if erri != nil {
-						logger.Errorf("Failed to unmarshal recipe job in Get: %v", erri)
-						continue
-					}
-				case IN_QUEUE:
-					job, erri = unmarshalInStepJob(data)
-					if erri != nil {
-						logger.Errorf("Failed to unmarshal in step job in Get: %v", erri)
-						continue
-					}
-				case EVERY_QUEUE:
-					job, erri = unmarshalEveryStepJob(data)
-					if erri != nil {
-						logger.Errorf("Failed to unmarshal every step job in Get: %v", erri)
-						continue
-					}
-				default:
-					return nil, fmt.Errorf("unknown queue name: %s", jq.Name)
-				}
-
-				// Validate the job before checking the key
-				if err := ValidateScheduledJob(job); err != nil {
-					logger.Errorf("Job validation failed in Get: %v", err)
-					continue
-				}
-
-				if jobKey.Equals(job.JobDetail().JobKey()) {
-					return job, nil
-				}
-			}
-		}
+
+	job, err := jq.unmarshal([]byte(row.Payload))
+	if err != nil {
+		logger.Errorf("Failed to unmarshal job in Get: %v", err)
+		return nil, err
 	}
-	return nil, errors.New("no jobs found")
+	if err := ValidateScheduledJob(job); err != nil {
+		logger.Errorf("Job validation failed in Get: %v", err)
+		return nil, err
+	}
+	return job, nil
 }
 
+// Remove removes the job with the key's name. Like Get it ignores the group so
+// callers can remove a job knowing only its LemcJobKey.
 func (jq *jobQueue) Remove(jobKey *quartz.JobKey) (quartz.ScheduledJob, error) {
 	jq.mu.Lock()
 	defer jq.mu.Unlock()
 
 	logger.Debugf("Removing job: %s", jobKey.Name())
 
-	fileInfo, err := os.ReadDir(jq.Path)
+	row, err := models.DeleteScheduledJob(jq.Name, jobKey.Name())
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", quartz.ErrJobNotFound, jobKey)
+		}
 		return nil, err
 	}
-	for _, file := range fileInfo {
-		if !file.IsDir() {
-			path := fmt.Sprintf("%s/%s", jq.Path, file.Name())
-			data, err := os.ReadFile(path)
-			if err == nil {
-				var erri error
-				var job quartz.ScheduledJob
-
-				switch jq.Name {
-				case NOW_QUEUE:
-					job, erri = unmarshalRecipeJob(data)
-					if erri != nil {
-						logger.Errorf("Failed to unmarshal recipe job in Remove: %v", erri)
-						continue
-					}
-				case IN_QUEUE:
-					job, erri = unmarshalInStepJob(data)
-					if erri != nil {
-						logger.Errorf("Failed to unmarshal in step job in Remove: %v", erri)
-						continue
-					}
-				case EVERY_QUEUE:
-					job, erri = unmarshalEveryStepJob(data)
-					if erri != nil {
-						logger.Errorf("Failed to unmarshal every step job in Remove: %v", erri)
-						continue
-					}
-				default:
-					return nil, fmt.Errorf("unknown queue name: %s", jq.Name)
-				}
-
-				// Validate the job before checking the key
-				if err := ValidateScheduledJob(job); err != nil {
-					logger.Errorf("Job validation failed in Remove: %v", err)
-					// Still try to remove the file if the key matches
-					if jobKey.Name() == file.Name() || strings.TrimSuffix(file.Name(), ".json") == jobKey.Name() {
-						remErr := os.Remove(path)
-						if remErr != nil {
-							return nil, remErr
-						}
-						return nil, fmt.Errorf("removed invalid job file: %s", path)
-					}
-					continue
-				}
-
-				if jobKey.Name() == job.JobDetail().JobKey().Name() {
-					if err = os.Remove(path); err == nil {
-						return job, nil
-					}
-				}
-			}
-		}
+
+	job, err := jq.unmarshal([]byte(row.Payload))
+	if err == nil {
+		err = ValidateScheduledJob(job)
+	}
+	if err != nil {
+		logger.Errorf("Job validation failed in Remove: %v", err)
+		return nil, fmt.Errorf("removed invalid job %s: %w", row.JobKey, err)
 	}
-	return nil, errors.New("no jobs found")
+	return job, nil
 }
 
 func (jq *jobQueue) Size() (int, error) {
 	jq.mu.Lock()
 	defer jq.mu.Unlock()
 
-	files, err := os.ReadDir(jq.Path)
-	if err != nil {
-		return 0, err
-	}
-	return len(files), nil
+	return models.CountScheduledJobs(jq.Name)
 }
 
 func (jq *jobQueue) Clear() error {
@@ -454,5 +458,5 @@ func (jq *jobQueue) Clear() error {
 	defer jq.mu.Unlock()
 
 	logger.Infof("Clearing queue: %s", jq.Name)
-	return os.RemoveAll(jq.Path)
+	return models.ClearScheduledJobs(jq.Name)
 }
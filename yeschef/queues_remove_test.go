@@ -1,8 +1,6 @@
 package yeschef
 
 import (
-	"os"
-	"path/filepath"
 	"testing"
 	"time"
 
@@ -10,10 +8,9 @@ import (
 	"github.com/reugn/go-quartz/quartz"
 )
 
-// TestRemoveInvalidJobFile ensures Remove deletes an invalid job file and returns a useful error.
-func TestRemoveInvalidJobFile(t *testing.T) {
-	dir := t.TempDir()
-	q := &jobQueue{Path: dir, Name: IN_QUEUE}
+// TestRemoveInvalidJob ensures Remove deletes an invalid job and returns a useful error.
+func TestRemoveInvalidJob(t *testing.T) {
+	q := newTestQueue(t, IN_QUEUE)
 
 	// Create an invalid step job (missing RecipeJob)
 	stepJob := &StepJob{Step: models.Step{Step: 1, Name: "", Image: ""}, RecipeJob: nil}
@@ -23,21 +20,13 @@ func TestRemoveInvalidJobFile(t *testing.T) {
 	if err != nil {
 		t.Fatalf("marshal: %v", err)
 	}
-
-	path := filepath.Join(dir, "invalid.json")
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		t.Fatalf("write: %v", err)
-	}
+	queueRawJob(t, q, "invalid", sj.nextRunTime, data)
 
 	_, err = q.Remove(quartz.NewJobKey("invalid"))
 	if err == nil {
 		t.Fatal("expected error from Remove")
 	}
-	if _, statErr := os.Stat(path); !os.IsNotExist(statErr) {
-		t.Fatalf("expected file to be removed, stat err=%v", statErr)
-	}
-
-	if err.Error() == "removed invalid job file: <nil>" {
-		t.Fatalf("unexpected error message: %v", err)
+	if n, _ := q.Size(); n != 0 {
+		t.Fatalf("expected invalid job to be removed, size=%d", n)
 	}
 }
@@ -1,15 +1,62 @@
 package yeschef
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/jaredfolkins/letemcook/models"
 	"github.com/reugn/go-quartz/quartz"
 )
 
+// newTestQueue returns an empty queue whose legacy directory is a temp dir.
+func newTestQueue(t *testing.T, name string) *jobQueue {
+	t.Helper()
+	q := &jobQueue{Path: t.TempDir(), Name: name}
+	if err := q.Clear(); err != nil {
+		t.Fatalf("clear: %v", err)
+	}
+	t.Cleanup(func() { q.Clear() })
+	if XoxoX == nil {
+		XoxoX = &ChefsKiss{}
+	}
+	if XoxoX.RunningMan == nil {
+		XoxoX.RunningMan = NewRunningMan()
+	}
+	return q
+}
+
+// queueRawJob inserts a serialized job straight into the queue's table.
+func queueRawJob(t *testing.T, q *jobQueue, key string, next int64, payload []byte) {
+	t.Helper()
+	row := &models.ScheduledJob{Queue: q.Name, JobKey: key, NextRunTime: next, Payload: string(payload)}
+	if err := models.InsertScheduledJob(row, false); err != nil {
+		t.Fatalf("insert %s: %v", key, err)
+	}
+}
+
+func testStepJob(key string, next int64) *scheduledLemcJob {
+	stepJob := &StepJob{
+		Step: models.Step{Step: 1, Name: "step", Image: "alpine", Do: "in.1.minutes", Timeout: "1.minutes"},
+		RecipeJob: &JobRecipe{
+			JobType:  "IN",
+			UUID:     "uuid",
+			AppID:    "1",
+			UserID:   "1",
+			Username: "tester",
+			PageID:   "1",
+			Scope:    "individual",
+			Recipe:   models.Recipe{Name: "recipe"},
+		},
+	}
+	jd := quartz.NewJobDetail(stepJob, quartz.NewJobKeyWithGroup(key, "group"))
+	return &scheduledLemcJob{jobDetail: jd, trigger: quartz.NewRunOnceTrigger(time.Minute), nextRunTime: next}
+}
+
 func TestNewQuartzQueue(t *testing.T) {
 	q := NewQuartzQueue("test")
 	if q.Name != "test" {
@@ -18,18 +65,96 @@ func TestNewQuartzQueue(t *testing.T) {
 }
 
 func TestJobQueueSizeEmpty(t *testing.T) {
-	q := &jobQueue{Path: t.TempDir(), Name: "temp"}
+	q := newTestQueue(t, "temp")
 	if n, err := q.Size(); err != nil || n != 0 {
 		t.Fatalf("expected empty queue, got n=%d err=%v", n, err)
 	}
+	if _, err := q.Head(); !errors.Is(err, quartz.ErrQueueEmpty) {
+		t.Fatalf("expected ErrQueueEmpty from Head, got %v", err)
+	}
+}
+
+func TestJobQueueOrderAndSameTimestamp(t *testing.T) {
+	q := newTestQueue(t, IN_QUEUE)
+	next := time.Now().Add(time.Hour).UnixNano()
+
+	// Jobs sharing a next run time used to overwrite each other on disk.
+	for _, key := range []string{"b", "a"} {
+		if err := q.Push(testStepJob(key, next)); err != nil {
+			t.Fatalf("push %s: %v", key, err)
+		}
+	}
+	if err := q.Push(testStepJob("early", next-1)); err != nil {
+		t.Fatalf("push early: %v", err)
+	}
+
+	if n, _ := q.Size(); n != 3 {
+		t.Fatalf("expected 3 jobs, got %d", n)
+	}
+
+	var order []string
+	for i := 0; i < 3; i++ {
+		job, err := q.Pop()
+		if err != nil {
+			t.Fatalf("pop: %v", err)
+		}
+		order = append(order, job.JobDetail().JobKey().Name())
+	}
+	if fmt.Sprint(order) != "[early b a]" {
+		t.Fatalf("unexpected pop order: %v", order)
+	}
+	if _, err := q.Pop(); !errors.Is(err, quartz.ErrQueueEmpty) {
+		t.Fatalf("expected ErrQueueEmpty, got %v", err)
+	}
+}
+
+func TestJobQueueUniqueKeys(t *testing.T) {
+	q := newTestQueue(t, IN_QUEUE)
+	next := time.Now().Add(time.Hour).UnixNano()
+
+	// Two in steps of one recipe are two jobs.
+	first := testStepJob("", next)
+	rj := first.jobDetail.Job().(*StepJob).RecipeJob
+	firstKey := StepJobKey(rj, IN_QUEUE, 1)
+	secondKey := StepJobKey(rj, IN_QUEUE, 2)
+	if firstKey == secondKey {
+		t.Fatalf("steps 1 and 2 share the key %s", firstKey)
+	}
+	for _, key := range []string{firstKey, secondKey} {
+		if err := q.Push(testStepJob(key, next)); err != nil {
+			t.Fatalf("push %s: %v", key, err)
+		}
+	}
+
+	// The same step queued again collides.
+	if err := q.Push(testStepJob(secondKey, next+1)); !errors.Is(err, quartz.ErrJobAlreadyExists) {
+		t.Fatalf("expected ErrJobAlreadyExists, got %v", err)
+	}
+
+	replacement := testStepJob(secondKey, next+2)
+	replacement.jobDetail = quartz.NewJobDetailWithOptions(replacement.jobDetail.Job(), replacement.jobDetail.JobKey(),
+		&quartz.JobDetailOptions{Replace: true})
+	if err := q.Push(replacement); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+
+	job, err := q.Get(quartz.NewJobKey(secondKey))
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if job.NextRunTime() != next+2 {
+		t.Fatalf("expected replaced job, got next run %d", job.NextRunTime())
+	}
+	if n, _ := q.Size(); n != 2 {
+		t.Fatalf("expected 2 jobs, got %d", n)
+	}
 }
 
 func TestJobQueueRecover(t *testing.T) {
-	tmpDir := t.TempDir()
-	q := &jobQueue{Path: tmpDir, Name: NOW_QUEUE}
+	q := newTestQueue(t, NOW_QUEUE)
 	config := quartz.StdSchedulerOptions{}
 
-	job := &JobRecipe{UUID: "u", JobType: "now", UserID: "test-user", PageID: "test-page"}
+	job := &JobRecipe{UUID: "u", JobType: "now", UserID: "test-user", PageID: "test-page", Scope: "individual"}
 	jd := quartz.NewJobDetail(job, quartz.NewJobKey("recover"))
 	trg := quartz.NewRunOnceTrigger(time.Hour)
 	next := time.Now().Add(time.Hour).Unix()
@@ -39,20 +164,11 @@ func TestJobQueueRecover(t *testing.T) {
 		t.Fatalf("push: %v", err)
 	}
 
-	origPath := filepath.Join(tmpDir, fmt.Sprintf("%d.json", next))
-	if _, err := os.Stat(origPath); err != nil {
-		t.Fatalf("job file missing: %v", err)
-	}
-
 	newScheduler := quartz.NewStdSchedulerWithOptions(config, q, nil)
-	if err := q.Recover(newScheduler); err != nil {
+	if err := q.Recover(context.Background(), newScheduler); err != nil {
 		t.Fatalf("recover: %v", err)
 	}
 
-	if _, err := os.Stat(origPath); !os.IsNotExist(err) {
-		t.Fatalf("original job file still exists")
-	}
-
 	jobs, err := q.ScheduledJobs(nil)
 	if err != nil {
 		t.Fatalf("scheduled jobs: %v", err)
@@ -64,3 +180,73 @@ func TestJobQueueRecover(t *testing.T) {
 		t.Fatalf("wrong job key: %s", jobs[0].JobDetail().JobKey().Name())
 	}
 }
+
+func TestJobQueueRecoverInPlace(t *testing.T) {
+	purgeDeadLetters(t)
+	q := newTestQueue(t, IN_QUEUE)
+	next := time.Now().Add(time.Hour).UnixNano()
+	if err := q.Push(testStepJob("kept", next)); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	queueRawJob(t, q, "broken", next, []byte(`{"job":{}}`))
+	before, err := models.ScheduledJobByKey(IN_QUEUE, "kept")
+	if err != nil {
+		t.Fatalf("get row: %v", err)
+	}
+
+	if err := q.Recover(context.Background(), quartz.NewStdSchedulerWithOptions(quartz.StdSchedulerOptions{}, q, nil)); err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+
+	after, err := models.ScheduledJobByKey(IN_QUEUE, "kept")
+	if err != nil {
+		t.Fatalf("recovered job missing: %v", err)
+	}
+	if after.ID != before.ID {
+		t.Fatalf("expected the row to be rewritten in place, id %d became %d", before.ID, after.ID)
+	}
+	if n, _ := q.Size(); n != 1 {
+		t.Fatalf("expected the invalid job to leave the queue, size %d", n)
+	}
+	dead, total, err := models.DeadLetterJobs(1, 10)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if total != 1 || dead[0].JobKey != "broken" || dead[0].Reason != models.DeadLetterInvalid {
+		t.Fatalf("unexpected dead letters: %+v", dead)
+	}
+}
+
+func TestJobQueueRecoverImportsQueueFiles(t *testing.T) {
+	q := newTestQueue(t, IN_QUEUE)
+	next := time.Now().Add(time.Hour).UnixNano()
+
+	for i, key := range []string{"file-a", "file-b"} {
+		data, err := marshal(testStepJob(key, next))
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		path := filepath.Join(q.Path, fmt.Sprintf("%d.json", next+int64(i)))
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(q.Path, "broken.json"), []byte("{"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	s := quartz.NewStdSchedulerWithOptions(quartz.StdSchedulerOptions{}, q, nil)
+	if err := q.Recover(context.Background(), s); err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+
+	if _, err := os.Stat(q.Path); !os.IsNotExist(err) {
+		t.Fatalf("expected legacy queue directory to be removed, stat err=%v", err)
+	}
+	if n, _ := q.Size(); n != 2 {
+		t.Fatalf("expected 2 imported jobs, got %d", n)
+	}
+	if _, err := q.Get(quartz.NewJobKey("file-b")); err != nil {
+		t.Fatalf("imported job missing: %v", err)
+	}
+}
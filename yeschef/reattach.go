@@ -0,0 +1,340 @@
+package yeschef
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/jaredfolkins/letemcook/util"
+	"github.com/reugn/go-quartz/quartz"
+)
+
+// stepTouchInterval is how often the output position of a step container is
+// saved. A reattached container may repeat up to this much of its output.
+const stepTouchInterval = 5 * time.Second
+
+// stepTouches holds when each followed container last saved its position.
+var stepTouches sync.Map
+
+type stepRunKey struct{}
+
+// withStepRun passes the run a step belongs to down to its container.
+func withStepRun(ctx context.Context, r *runningJob) context.Context {
+	return context.WithValue(ctx, stepRunKey{}, r)
+}
+
+// stepRunFrom returns the run a step belongs to, nil when it has none.
+func stepRunFrom(ctx context.Context) *runningJob {
+	r, _ := ctx.Value(stepRunKey{}).(*runningJob)
+	return r
+}
+
+// recordStepContainer stores the started container of a step with the job
+// it runs as, so it can be followed again when the server restarts while it
+// runs. The returned func forgets the container once the step is done.
+func recordStepContainer(ctx context.Context, containerID string, job *JobRecipe) func() {
+	r := stepRunFrom(ctx)
+	if r == nil || r.job == nil {
+		return func() {}
+	}
+	payload, err := marshal(r.job)
+	if err != nil {
+		log.Printf("record step container %s: %v", util.ShortImageID(containerID), err)
+		return func() {}
+	}
+	row := &models.StepContainer{
+		ContainerID: containerID,
+		Queue:       r.queue,
+		JobKey:      r.job.JobDetail().JobKey().Name(),
+		RecipeName:  job.Recipe.Name,
+		StepID:      job.StepID,
+		Username:    job.Username,
+		AccountID:   job.AccountID,
+		LoggedAt:    time.Now().UnixNano(),
+		Payload:     string(payload),
+		Endpoint:    jobDockerEndpoint(job),
+	}
+	if err := models.InsertStepContainer(row); err != nil {
+		log.Printf("record step container %s: %v", util.ShortImageID(containerID), err)
+		return func() {}
+	}
+	r.setContainer(containerID)
+	return func() {
+		r.setContainer("")
+		forgetStepContainer(containerID)
+	}
+}
+
+// touchStepContainer saves that the container's output has been read up to
+// now, at most once per stepTouchInterval.
+func touchStepContainer(containerID string) {
+	now := time.Now()
+	if last, ok := stepTouches.Load(containerID); ok && now.Sub(last.(time.Time)) < stepTouchInterval {
+		return
+	}
+	stepTouches.Store(containerID, now)
+	if err := models.TouchStepContainer(containerID, now.UnixNano()); err != nil {
+		log.Printf("touch step container %s: %v", util.ShortImageID(containerID), err)
+	}
+}
+
+// forgetStepContainer drops a step container that is done.
+func forgetStepContainer(containerID string) {
+	stepTouches.Delete(containerID)
+	if err := models.DeleteStepContainer(containerID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("forget step container %s: %v", util.ShortImageID(containerID), err)
+	}
+}
+
+// decodeStepContainer returns the job a recorded step container runs as. A
+// recipe run is a now job whose first step is the one in the container, in
+// and every steps are stored as run once step jobs.
+func decodeStepContainer(row *models.StepContainer) (quartz.ScheduledJob, error) {
+	data, err := upgradePayload([]byte(row.Payload))
+	if err != nil {
+		return nil, err
+	}
+	var job quartz.ScheduledJob
+	if row.Queue == NOW_QUEUE {
+		job, err = unmarshalRecipeJob(data)
+	} else {
+		job, err = unmarshalInStepJob(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch j := job.JobDetail().Job().(type) {
+	case *JobRecipe:
+		if len(j.Recipe.Steps) == 0 {
+			return nil, fmt.Errorf("recipe %s has no steps left", j.Recipe.Name)
+		}
+	case *StepJob:
+		if j.RecipeJob == nil {
+			return nil, fmt.Errorf("step job has no recipe")
+		}
+	default:
+		return nil, fmt.Errorf("unexpected step container job %T", j)
+	}
+	return job, ValidateScheduledJob(job)
+}
+
+// ReattachStepContainers looks for the step containers that were running
+// when the server stopped. Containers still there are followed again, their
+// output goes to the step's log file and users, and once they exit the step
+// is finished the way it would have been: the rest of the recipe runs, or
+// the run is dead lettered when the step failed. Steps whose container is
+// gone are dead lettered as interrupted.
+func ReattachStepContainers(ctx context.Context) {
+	rows, err := models.StepContainers()
+	if err != nil {
+		log.Printf("reattach step containers: %v", err)
+		return
+	}
+
+	// The default endpoint is always looked at for containers it does not
+	// know.
+	byEndpoint := map[string][]models.StepContainer{"": nil}
+	for _, row := range rows {
+		byEndpoint[row.Endpoint] = append(byEndpoint[row.Endpoint], row)
+	}
+	for endpoint, rows := range byEndpoint {
+		reattachEndpoint(ctx, endpoint, rows)
+	}
+}
+
+// reattachEndpoint reattaches the recorded step containers of one Docker
+// endpoint.
+func reattachEndpoint(ctx context.Context, endpoint string, rows []models.StepContainer) {
+	cli, err := models.DockerClient(endpoint)
+	if err != nil {
+		log.Printf("reattach step containers: %v", err)
+		return
+	}
+
+	owned, err := cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", "OWNED_BY="+OWNED_BY)),
+	})
+	if err != nil {
+		log.Printf("reattach step containers: %v", err)
+		return
+	}
+	found := make(map[string]bool, len(owned))
+	for _, c := range owned {
+		found[c.ID] = true
+	}
+
+	reattached := 0
+	for i := range rows {
+		row := rows[i]
+		if !found[row.ContainerID] {
+			interruptStepContainer(&row, fmt.Errorf("container %s of step %s is gone", util.ShortImageID(row.ContainerID), row.StepID))
+			continue
+		}
+		delete(found, row.ContainerID)
+		inspect, err := cli.ContainerInspect(ctx, row.ContainerID)
+		if err != nil {
+			log.Printf("reattach step container %s: %v", util.ShortImageID(row.ContainerID), err)
+			continue
+		}
+		reattached++
+		go reattachStepContainer(ctx, cli, row, inspect)
+	}
+	for id := range found {
+		log.Printf("container %s is labeled %s but was not started by a step this server knows, leaving it", util.ShortImageID(id), OWNED_BY)
+	}
+	if len(rows) > 0 {
+		log.Printf("reattached %d of %d step containers", reattached, len(rows))
+	}
+}
+
+// reattachStepContainer follows a step container left by an earlier server
+// and finishes its step.
+func reattachStepContainer(ctx context.Context, cli *client.Client, row models.StepContainer, inspect types.ContainerJSON) {
+	job, err := decodeStepContainer(&row)
+	if err != nil {
+		log.Printf("reattach step container %s: %v", util.ShortImageID(row.ContainerID), err)
+		forgetStepContainer(row.ContainerID)
+		deadLetterRow(stepContainerRow(&row), models.DeadLetterInvalid, err)
+		return
+	}
+
+	var jr *JobRecipe
+	var st models.Step
+	switch j := job.JobDetail().Job().(type) {
+	case *JobRecipe:
+		jr, st = j, j.Recipe.Steps[0]
+	case *StepJob:
+		jr, st = j.RecipeJob, j.Step
+	}
+
+	started, err := time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+	if err != nil {
+		started = row.Created
+	}
+	key := LemcJobKey(jr, NOW_QUEUE)
+	XoxoX.RunningMan.Add(key)
+
+	run := trackRun(row.Queue, job, false)
+	run.setContainer(row.ContainerID)
+	err = followStepContainer(ctx, cli, &row, inspect, jr, st, started)
+	run.setContainer("")
+	run.finish()
+	forgetStepContainer(row.ContainerID)
+
+	switch j := job.JobDetail().Job().(type) {
+	case *StepJob:
+		XoxoX.RunningMan.Remove(key)
+		recordStepResult(j, started, err)
+		if err != nil && row.Queue == IN_QUEUE {
+			deadLetterJob(IN_QUEUE, job, err)
+		}
+	case *JobRecipe:
+		if err != nil {
+			XoxoX.RunningMan.Remove(key)
+			deadLetterJob(NOW_QUEUE, job, err)
+			return
+		}
+		if len(j.Recipe.Steps) == 1 {
+			XoxoX.RunningMan.Remove(key)
+			return
+		}
+		// The rest of the recipe runs as it would have, it clears the
+		// running flag when it returns.
+		rest := remainingSteps(j, 1).JobDetail().Job().(*JobRecipe)
+		if err := rest.Execute(ctx); err != nil {
+			log.Printf("reattached recipe %s: %v", j.Recipe.Name, err)
+		}
+	}
+}
+
+// followStepContainer streams the output of a reattached container from
+// where the earlier server stopped reading it until it exits.
+func followStepContainer(ctx context.Context, cli *client.Client, row *models.StepContainer, inspect types.ContainerJSON, jr *JobRecipe, st models.Step, started time.Time) error {
+	userid, err := strconv.ParseInt(jr.UserID, 10, 64)
+	if err != nil {
+		return err
+	}
+	XoxoX.CreateInstance(userid)
+
+	jobCopy := *jr
+	jobCopy.StepID = fmt.Sprintf("%d", st.Step)
+	jobCopy.ContainerTimeoutInSeconds, err = timeoutInSeconds(st.Timeout)
+	if err != nil {
+		return err
+	}
+
+	jm := util.NewJobMetaFromEnv(inspect.Config.Env)
+	cf, err := util.NewContainerFiles(jm, jr.Recipe.IsShared)
+	if err != nil {
+		return err
+	}
+	fm, err := util.NewFileMeta(jm, jr.Recipe.IsShared)
+	if err != nil {
+		return err
+	}
+	if err := cf.OpenFiles(); err != nil {
+		return err
+	}
+	defer cf.CloseFiles()
+	lf, err := fm.OpenLogFile(jm)
+	if err != nil {
+		return err
+	}
+	defer lf.CloseLogFile()
+	lf.RunID = jr.RunID
+
+	imageName := inspect.Config.Image
+	imageHash := util.ShortImageID(inspect.Image)
+	if len(imageHash) > 8 {
+		imageHash = imageHash[:8]
+	}
+	lf.StepWriteToLog(jm.StepID, "reattached to the step container after a server restart", imageHash, imageName)
+	log.Printf("reattached %s step %s of %s to container %s", row.Queue, row.StepID, jr.Recipe.Name, util.ShortImageID(row.ContainerID))
+
+	timeout := time.Duration(jobCopy.ContainerTimeoutInSeconds)*time.Second - time.Since(started)
+	if timeout < 0 {
+		timeout = 0
+	}
+	since := time.Unix(0, row.LoggedAt)
+	return followContainer(context.Background(), cli, &jobCopy, row.ContainerID, imageName, imageHash, jm, cf, lf, fm.IndividualUsernameOrSharedUsername, since, timeout)
+}
+
+// interruptStepContainer finishes a step whose container disappeared while
+// the server was down. In steps and recipe runs are dead lettered so they can
+// be retried from that step, every steps record the failed run.
+func interruptStepContainer(row *models.StepContainer, cause error) {
+	log.Printf("step %s of %s was interrupted: %v", row.StepID, row.RecipeName, cause)
+	forgetStepContainer(row.ContainerID)
+	if row.Queue == EVERY_QUEUE {
+		if job, err := decodeStepContainer(row); err == nil {
+			sj := job.JobDetail().Job().(*StepJob)
+			recordJobResult(EVERY_QUEUE, StepJobKey(sj.RecipeJob, EVERY_QUEUE, sj.Step.Step), row.Created, cause)
+		}
+		return
+	}
+	deadLetterRow(stepContainerRow(row), models.DeadLetterInterrupted, cause)
+}
+
+// stepContainerRow returns the queue row a recorded step container is dead
+// lettered as.
+func stepContainerRow(row *models.StepContainer) *models.ScheduledJob {
+	return &models.ScheduledJob{
+		Queue:      row.Queue,
+		JobKey:     row.JobKey,
+		RecipeName: row.RecipeName,
+		Username:   row.Username,
+		AccountID:  row.AccountID,
+		Payload:    row.Payload,
+	}
+}
@@ -0,0 +1,112 @@
+package yeschef
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/jaredfolkins/letemcook/models"
+)
+
+func TestRecordStepContainer(t *testing.T) {
+	resetEngine(t)
+	jr := testThreeStepRecipe()
+	run := trackRun(NOW_QUEUE, nil, true)
+	defer run.finish()
+	run.next(remainingSteps(jr, 1))
+
+	step := *jr
+	step.StepID = "2"
+	done := recordStepContainer(withStepRun(context.Background(), run), "container-two", &step)
+	if run.container != "container-two" {
+		t.Fatalf("run is not waiting on the container: %q", run.container)
+	}
+
+	rows, err := models.StepContainers()
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected one step container, got %d: %v", len(rows), err)
+	}
+	if rows[0].Queue != NOW_QUEUE || rows[0].StepID != "2" || rows[0].RecipeName != "recipe" {
+		t.Fatalf("unexpected step container %+v", rows[0])
+	}
+	job, err := decodeStepContainer(&rows[0])
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if steps := job.JobDetail().Job().(*JobRecipe).Recipe.Steps; len(steps) != 2 || steps[0].Step != 2 {
+		t.Fatalf("expected the steps left from step two, got %+v", steps)
+	}
+
+	done()
+	if run.container != "" {
+		t.Fatal("run still waits on the container")
+	}
+	if err := models.DeleteStepContainer("container-two"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected the container to be forgotten, got %v", err)
+	}
+}
+
+func TestRecordStepContainerEveryStep(t *testing.T) {
+	resetEngine(t)
+	sj := testEveryJob("every", "", 0).jobDetail.Job().(*StepJob)
+	run := trackRun(EVERY_QUEUE, failedStepJob(sj), false)
+	defer run.finish()
+
+	done := recordStepContainer(withStepRun(context.Background(), run), "container-every", sj.RecipeJob)
+	defer done()
+	rows, err := models.StepContainers()
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected one step container, got %d: %v", len(rows), err)
+	}
+	job, err := decodeStepContainer(&rows[0])
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got, want := job.JobDetail().JobKey().Name(), StepJobKey(sj.RecipeJob, EVERY_QUEUE, sj.Step.Step); got != want {
+		t.Fatalf("job key %s, want %s", got, want)
+	}
+}
+
+func TestInterruptStepContainer(t *testing.T) {
+	purgeDeadLetters(t)
+	jr := testThreeStepRecipe()
+	payload, err := marshal(remainingSteps(jr, 1))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	row := &models.StepContainer{ContainerID: "gone", Queue: NOW_QUEUE, JobKey: "key", RecipeName: "recipe", StepID: "2", Payload: string(payload)}
+	if err := models.InsertStepContainer(row); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	interruptStepContainer(row, errors.New("container is gone"))
+
+	dead, total, err := models.DeadLetterJobs(1, 10)
+	if err != nil || total != 1 {
+		t.Fatalf("expected one dead letter job, got %d: %v", total, err)
+	}
+	if dead[0].Reason != models.DeadLetterInterrupted || dead[0].Payload != string(payload) {
+		t.Fatalf("unexpected dead letter job %+v", dead[0])
+	}
+	if err := models.DeleteStepContainer("gone"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected the container to be forgotten, got %v", err)
+	}
+}
+
+func TestShutdownLeavesStepContainers(t *testing.T) {
+	q := newTestQueue(t, NOW_QUEUE)
+	shutdownNowQueue(t, q)
+	resetEngine(t)
+
+	run := trackRun(NOW_QUEUE, remainingSteps(testThreeStepRecipe(), 1), true)
+	run.setContainer("still-running")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	Shutdown(ctx)
+
+	if n, _ := q.Size(); n != 0 {
+		t.Fatalf("expected the step left to its container, got %d queued jobs", n)
+	}
+}
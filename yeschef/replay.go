@@ -0,0 +1,226 @@
+package yeschef
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errRadioFull is returned by Publish when the radio of a user is full. The
+// message is still kept, Run sends it ahead of the next one.
+var errRadioFull = errors.New("radio channel full")
+
+// runSeqExpire is how long the sequence number of a run that published
+// nothing is kept.
+const runSeqExpire = 24 * time.Hour
+
+var runIDRgx = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// frame is a message on its way to the clients of a user. pos orders the
+// frames of a user for the radio and the ring. Messages from runs are
+// sequenced per run and carry their topics, a frame without topics goes to
+// every client.
+type frame struct {
+	pos    uint64
+	run    string
+	seq    uint64
+	topics []string
+	data   []byte
+}
+
+// runSeq is the last sequence number handed out for a run.
+type runSeq struct {
+	seq  uint64
+	last time.Time
+}
+
+// replayRing keeps the last messages sent to a user, oldest first, so a
+// client that lost its connection can be sent what it missed.
+type replayRing struct {
+	frames []frame
+	start  int
+	n      int
+}
+
+func newReplayRing(size int) *replayRing {
+	return &replayRing{frames: make([]frame, size)}
+}
+
+// add keeps a frame, dropping the oldest one when the ring is full.
+func (r *replayRing) add(f frame) {
+	if len(r.frames) == 0 {
+		return
+	}
+	if r.n < len(r.frames) {
+		r.frames[(r.start+r.n)%len(r.frames)] = f
+		r.n++
+		return
+	}
+	r.frames[r.start] = f
+	r.start = (r.start + 1) % len(r.frames)
+}
+
+// between returns the kept frames after pos and before until.
+func (r *replayRing) between(pos, until uint64) []frame {
+	var out []frame
+	for i := 0; i < r.n; i++ {
+		f := r.frames[(r.start+i)%len(r.frames)]
+		if f.pos > pos && f.pos < until {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// since returns the frames of runs a client missed, and for each run in the
+// ring the sequence number its replayed frames follow. seen holds the last
+// sequence number the client saw of each run, a nil seen is a client that
+// saw nothing yet and misses nothing. A run the reconnecting client does not
+// know started while it was away and is sent from its first kept frame, as
+// is a run whose gap is older than the ring, which then skips what was
+// dropped.
+func (r *replayRing) since(seen map[string]uint64) (map[string]uint64, []frame) {
+	base := make(map[string]uint64)
+	for i := 0; i < r.n; i++ {
+		f := r.frames[(r.start+i)%len(r.frames)]
+		if f.run == "" {
+			continue
+		}
+		if _, ok := base[f.run]; ok {
+			if seen == nil {
+				base[f.run] = f.seq
+			}
+			continue
+		}
+		switch last, ok := seen[f.run]; {
+		case seen == nil:
+			base[f.run] = f.seq
+		case !ok || last < f.seq-1:
+			base[f.run] = f.seq - 1
+		default:
+			base[f.run] = last
+		}
+	}
+	if seen == nil {
+		return base, nil
+	}
+	var out []frame
+	for i := 0; i < r.n; i++ {
+		f := r.frames[(r.start+i)%len(r.frames)]
+		if f.run != "" && f.seq > base[f.run] {
+			out = append(out, f)
+		}
+	}
+	return base, out
+}
+
+// ParseSince reads the runs a client saw, a comma separated list of
+// <run id>:<seq>, dropping the entries that are not well formed.
+func ParseSince(raw string) map[string]uint64 {
+	seen := make(map[string]uint64)
+	for _, s := range strings.Split(raw, ",") {
+		run, n, ok := strings.Cut(strings.TrimSpace(s), ":")
+		if !ok || !runIDRgx.MatchString(run) {
+			continue
+		}
+		if seq, err := strconv.ParseUint(n, 10, 64); err == nil {
+			seen[run] = seq
+		}
+	}
+	return seen
+}
+
+// FormatSince writes the runs a client saw the way ParseSince reads them.
+func FormatSince(seen map[string]uint64) string {
+	runs := make([]string, 0, len(seen))
+	for run, seq := range seen {
+		runs = append(runs, fmt.Sprintf("%s:%d", run, seq))
+	}
+	sort.Strings(runs)
+	return strings.Join(runs, ",")
+}
+
+// Publish stamps a response of a run with the next sequence number of the
+// run, keeps it for replay and hands it to the clients subscribed to one of
+// the topics.
+func (xrv *CmdServer) Publish(r Response, topics ...string) error {
+	xrv.mu.Lock()
+	defer xrv.mu.Unlock()
+	if r.RunID != "" {
+		r.Seq = xrv.nextSeq(r.RunID, time.Now())
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	xrv.pos++
+	f := frame{pos: xrv.pos, run: r.RunID, seq: r.Seq, topics: topics, data: data}
+	xrv.ring.add(f)
+	select {
+	case xrv.Radio <- f:
+		return nil
+	default:
+		return errRadioFull
+	}
+}
+
+// nextSeq hands out the next sequence number of a run. The runs that
+// published nothing for runSeqExpire are forgotten when a new one starts.
+func (xrv *CmdServer) nextSeq(runID string, now time.Time) uint64 {
+	rs, ok := xrv.runs[runID]
+	if !ok {
+		for id, old := range xrv.runs {
+			if now.Sub(old.last) > runSeqExpire {
+				delete(xrv.runs, id)
+			}
+		}
+		rs = &runSeq{}
+		xrv.runs[runID] = rs
+	}
+	rs.seq++
+	rs.last = now
+	return rs.seq
+}
+
+// replay sends a client the replay marker and the frames of its topics it
+// missed. The marker tells the client which sequence number the replayed
+// frames of each run follow.
+func (xrv *CmdServer) replay(xlient *Client, seen map[string]uint64) {
+	xrv.mu.Lock()
+	base, frames := xrv.ring.since(seen)
+	xrv.mu.Unlock()
+
+	marker, err := json.Marshal(Response{Cmd: LEMC_REPLAY, Runs: base})
+	if err != nil {
+		return
+	}
+	if !xrv.deliver(xlient, marker) {
+		return
+	}
+	for _, f := range frames {
+		if xlient.wants(f) && !xrv.deliver(xlient, f.data) {
+			return
+		}
+	}
+}
+
+// missed returns the frames the radio dropped before f, Run sends them
+// first so connected clients see every message.
+func (xrv *CmdServer) missed(f frame) []frame {
+	if f.pos == 0 {
+		return nil
+	}
+	sent := xrv.sent
+	xrv.sent = f.pos
+	if f.pos <= sent+1 {
+		return nil
+	}
+	xrv.mu.Lock()
+	defer xrv.mu.Unlock()
+	return xrv.ring.between(sent, f.pos)
+}
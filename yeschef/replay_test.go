@@ -0,0 +1,195 @@
+package yeschef
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReplayRingSince(t *testing.T) {
+	r := newReplayRing(4)
+	frames := []frame{{run: "a", seq: 1}, {run: "a", seq: 2}, {run: "b", seq: 1}, {run: "a", seq: 3}, {run: "b", seq: 2}, {run: "a", seq: 4}}
+	for i, f := range frames {
+		f.pos = uint64(i + 1)
+		f.data = []byte(fmt.Sprintf("%s%d", f.run, f.seq))
+		r.add(f)
+	}
+
+	tests := []struct {
+		name   string
+		seen   map[string]uint64
+		base   string
+		frames string
+	}{
+		{"fresh client", nil, "a:4,b:2", ""},
+		{"up to date", map[string]uint64{"a": 4, "b": 2}, "a:4,b:2", ""},
+		{"gap", map[string]uint64{"a": 3, "b": 1}, "a:3,b:1", "b2a4"},
+		{"older than the ring", map[string]uint64{"a": 1, "b": 2}, "a:2,b:2", "a3a4"},
+		{"run started while away", map[string]uint64{"a": 4}, "a:4,b:0", "b1b2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, frames := r.since(tt.seen)
+			got := ""
+			for _, f := range frames {
+				got += string(f.data)
+			}
+			if FormatSince(base) != tt.base || got != tt.frames {
+				t.Fatalf("since(%v) = %s %q, want %s %q", tt.seen, FormatSince(base), got, tt.base, tt.frames)
+			}
+		})
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	got := ParseSince("run-b:7, run-a:3,bogus,run c:1,run-d:x")
+	if FormatSince(got) != "run-a:3,run-b:7" {
+		t.Fatalf("ParseSince = %v", got)
+	}
+}
+
+func receive(t *testing.T, xlient *Client) Response {
+	t.Helper()
+	select {
+	case data := <-xlient.Xend:
+		var r Response
+		if err := json.Unmarshal(data, &r); err != nil {
+			t.Fatalf("unmarshal %s: %v", data, err)
+		}
+		return r
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message")
+	}
+	return Response{}
+}
+
+func TestCmdServerReplaysGapOnReconnect(t *testing.T) {
+	xrv := NewServer()
+	go xrv.Run()
+
+	first := &Client{Xerver: xrv, Xend: make(chan []byte, CLIENT_BUFFER_SIZE)}
+	xrv.Provision <- first
+	if m := receive(t, first); m.Cmd != LEMC_REPLAY || len(m.Runs) != 0 {
+		t.Fatalf("marker = %+v, want no runs", m)
+	}
+	for _, r := range []Response{{RunID: "r1", Msg: "a"}, {RunID: "r2", Msg: "x"}, {RunID: "r1", Msg: "b"}, {RunID: "r1", Msg: "c"}} {
+		r.Cmd = LEMC_HTML_APPEND
+		if err := xrv.Publish(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each run is sequenced on its own.
+	for _, want := range []string{"r1:1", "r2:1", "r1:2"} {
+		if m := receive(t, first); fmt.Sprintf("%s:%d", m.RunID, m.Seq) != want {
+			t.Fatalf("got %+v, want %s", m, want)
+		}
+	}
+	xrv.Deprovision <- first
+
+	again := &Client{Xerver: xrv, Xend: make(chan []byte, CLIENT_BUFFER_SIZE), Since: map[string]uint64{"r1": 1}}
+	xrv.Provision <- again
+	if m := receive(t, again); m.Cmd != LEMC_REPLAY || FormatSince(m.Runs) != "r1:1,r2:0" {
+		t.Fatalf("marker = %+v, want replay from r1:1,r2:0", m)
+	}
+	for _, want := range []string{"x", "b", "c"} {
+		if m := receive(t, again); m.Msg != want {
+			t.Fatalf("replayed %+v, want %q", m, want)
+		}
+	}
+}
+
+func TestCmdServerClosesLaggingClient(t *testing.T) {
+	xrv := NewServer()
+	go xrv.Run()
+
+	slow := &Client{Xerver: xrv, Xend: make(chan []byte, 3)}
+	xrv.Provision <- slow
+	for i := 0; i < 3; i++ {
+		if err := xrv.Publish(Response{Msg: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The marker and two messages fill the buffer, the third overflows it.
+	time.Sleep(100 * time.Millisecond)
+
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-slow.Xend:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("lagging client was not closed")
+		}
+	}
+}
+
+func TestCmdServerSendsSubscribedTopics(t *testing.T) {
+	xrv := NewServer()
+	go xrv.Run()
+
+	page := &Client{Xerver: xrv, Xend: make(chan []byte, CLIENT_BUFFER_SIZE), Topics: []string{AppTopic("u1", "1", "individual")}}
+	all := &Client{Xerver: xrv, Xend: make(chan []byte, CLIENT_BUFFER_SIZE)}
+	xrv.Provision <- page
+	xrv.Provision <- all
+	receive(t, page)
+	receive(t, all)
+
+	if err := xrv.Publish(Response{RunID: "r2", Msg: "other"}, AppTopic("u2", "1", "individual"), RunTopic("r2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := xrv.Publish(Response{RunID: "r1", Msg: "mine"}, AppTopic("u1", "1", "individual"), RunTopic("r1")); err != nil {
+		t.Fatal(err)
+	}
+	if m := receive(t, page); m.Msg != "mine" || m.Seq != 1 {
+		t.Fatalf("page got %+v, want only its topic", m)
+	}
+	for _, want := range []string{"other", "mine"} {
+		if m := receive(t, all); m.Msg != want {
+			t.Fatalf("unsubscribed client got %+v, want %q", m, want)
+		}
+	}
+
+	xrv.Subscribe <- Subscription{Client: page, Topics: []string{RunTopic("r2")}}
+	if err := xrv.Publish(Response{Msg: "run"}, AppTopic("u2", "1", "individual"), RunTopic("r2")); err != nil {
+		t.Fatal(err)
+	}
+	if m := receive(t, page); m.Msg != "run" {
+		t.Fatalf("page got %+v after subscribing to the run", m)
+	}
+}
+
+func TestCmdServerSendsFramesTheRadioDropped(t *testing.T) {
+	xrv := NewServer()
+	xlient := &Client{Xerver: xrv, Xend: make(chan []byte, CLIENT_BUFFER_SIZE)}
+	xrv.Clients[xlient] = true
+
+	// Nothing runs the server, the radio fills and drops the last message.
+	for i := 0; i <= cap(xrv.Radio); i++ {
+		xrv.Publish(Response{RunID: "r", Msg: "x"})
+	}
+	go xrv.Run()
+	if m := receive(t, xlient); m.Seq != 1 {
+		t.Fatalf("got seq %d, want 1", m.Seq)
+	}
+	if err := xrv.Publish(Response{RunID: "r", Msg: "last"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := uint64(2)
+	for want <= uint64(cap(xrv.Radio))+2 {
+		if m := receive(t, xlient); m.Seq != want {
+			t.Fatalf("got seq %d, want %d", m.Seq, want)
+		}
+		want++
+	}
+}
+
+func TestParseTopics(t *testing.T) {
+	got := ParseTopics("app:u1:page:1:scope:shared, run:abc,bogus,run:a b")
+	if len(got) != 2 || got[0] != "app:u1:page:1:scope:shared" || got[1] != "run:abc" {
+		t.Fatalf("ParseTopics = %q", got)
+	}
+}
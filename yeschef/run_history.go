@@ -0,0 +1,156 @@
+package yeschef
+
+import (
+	"errors"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/util"
+)
+
+// runHistoryMax is how many runs of a recipe its history keeps.
+const runHistoryMax = 50
+
+// RunSummary is a run in the history of its recipe.
+type RunSummary struct {
+	RunID    string     `json:"run_id"`
+	Status   string     `json:"status"`
+	Scope    string     `json:"scope"`
+	Username string     `json:"username,omitempty"`
+	Step     int        `json:"step,omitempty"`
+	ExitCode *int       `json:"exit_code,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Ended    *time.Time `json:"ended,omitempty"`
+
+	userID  string
+	logFile string
+}
+
+type runHistoryKey struct {
+	appID  string
+	page   string
+	recipe string
+}
+
+// runHistory holds the last runs of every recipe, newest first. It lives as
+// long as the process, the log files of the runs outlive it.
+var runHistory = struct {
+	mu   sync.Mutex
+	runs map[runHistoryKey][]*RunSummary
+}{runs: make(map[runHistoryKey][]*RunSummary)}
+
+// recordRun follows the run of the job in the history of its recipe. It
+// reports whether the event ended the run.
+func recordRun(job *JobRecipe, ev Event) bool {
+	if job.AppID == "" || job.RunID == "" {
+		return false
+	}
+	key := runHistoryKey{appID: job.AppID, page: job.PageID, recipe: job.Recipe.Name}
+
+	runHistory.mu.Lock()
+	defer runHistory.mu.Unlock()
+	var run *RunSummary
+	for _, r := range runHistory.runs[key] {
+		if r.RunID == job.RunID {
+			run = r
+			break
+		}
+	}
+	if run == nil {
+		jm := util.NewJobMetaFromEnv(job.Env)
+		fm, _ := util.NewFileMeta(jm, job.Recipe.IsShared)
+		run = &RunSummary{
+			RunID:    job.RunID,
+			Status:   RunStatusQueued,
+			Scope:    fm.Scope,
+			Username: job.Username,
+			Started:  ev.Time,
+			userID:   job.UserID,
+			logFile:  fm.LogFilePath(jm),
+		}
+		runs := append([]*RunSummary{run}, runHistory.runs[key]...)
+		if len(runs) > runHistoryMax {
+			runs = runs[:runHistoryMax]
+		}
+		runHistory.runs[key] = runs
+	}
+	if run.Ended != nil {
+		return false
+	}
+
+	switch ev.Type {
+	case EventRunStarted, EventStepStarted:
+		run.Status = RunStatusRunning
+		if ev.Step > 0 {
+			run.Step = ev.Step
+		}
+	case EventStepFinished:
+		run.ExitCode = ev.ExitCode
+		if ev.Error != "" {
+			run.Error = ev.Error
+		}
+	default:
+		status, ok := runEndStatus[ev.Type]
+		if !ok {
+			return false
+		}
+		run.Status = status
+		if ev.Type == EventRunFailed {
+			run.Error = ev.Error
+		}
+		if ev.Type == EventRunFinished && len(deferredSteps(job)) > 0 {
+			run.Status = RunStatusPending
+		}
+		ended := ev.Time
+		run.Ended = &ended
+		return true
+	}
+	return false
+}
+
+// RecipeRuns returns the runs in the history of a recipe of the app the user
+// may see, newest first: the shared runs and the user's individual runs.
+func RecipeRuns(appID, page, recipe, userID string) []RunSummary {
+	runHistory.mu.Lock()
+	defer runHistory.mu.Unlock()
+	var runs []RunSummary
+	for _, r := range runHistory.runs[runHistoryKey{appID: appID, page: page, recipe: recipe}] {
+		if r.visibleTo(userID) {
+			runs = append(runs, *r)
+		}
+	}
+	return runs
+}
+
+// RunLog returns the log lines of a run in the history of the app, and
+// whether the user may see such a run.
+func RunLog(appID, runID, userID string) ([]string, bool, error) {
+	runHistory.mu.Lock()
+	var logFile string
+	for key, runs := range runHistory.runs {
+		if key.appID != appID {
+			continue
+		}
+		for _, r := range runs {
+			if r.RunID == runID && r.visibleTo(userID) {
+				logFile = r.logFile
+			}
+		}
+	}
+	runHistory.mu.Unlock()
+	if logFile == "" {
+		return nil, false, nil
+	}
+	lines, err := util.RunLogLines(logFile, runID)
+	if errors.Is(err, fs.ErrNotExist) {
+		// No step wrote to it yet.
+		return nil, true, nil
+	}
+	return lines, true, err
+}
+
+func (r *RunSummary) visibleTo(userID string) bool {
+	return r.Scope == util.SCOPE_SHARED || r.userID == userID
+}
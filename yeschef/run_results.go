@@ -0,0 +1,278 @@
+package yeschef
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/paths"
+	"github.com/jaredfolkins/letemcook/util"
+)
+
+// Status of a watched run.
+const (
+	RunStatusQueued    = "queued"
+	RunStatusRunning   = "running"
+	RunStatusFinished  = "finished"
+	RunStatusFailed    = "failed"
+	RunStatusCancelled = "cancelled"
+	// RunStatusPending is a run whose steps that run right away finished,
+	// while its in and every steps wait in their queues.
+	RunStatusPending = "pending"
+)
+
+const (
+	// runOutputMax is how much step output a result keeps, the end of the
+	// output wins.
+	runOutputMax = 64 * 1024
+	// runResultKeep is how long the result of an ended run can be fetched.
+	runResultKeep = time.Hour
+	// runResultExpire is how long the result of a run that never ended is
+	// kept, for example when the server lost track of it.
+	runResultExpire = 24 * time.Hour
+)
+
+// PendingStep is a step of a run deferred to the in or every queue.
+type PendingStep struct {
+	Step int    `json:"step"`
+	Do   string `json:"do"`
+}
+
+// RunResult is what a watched run produced: the plain output lines of its
+// steps, the payloads its steps sent with LEMC_OUTPUT and the files it left
+// in the public locker of its page.
+type RunResult struct {
+	RunID     string            `json:"run_id"`
+	Status    string            `json:"status"`
+	Step      int               `json:"step,omitempty"`
+	ExitCode  *int              `json:"exit_code,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Output    string            `json:"output,omitempty"`
+	Data      []json.RawMessage `json:"data,omitempty"`
+	Artifacts []string          `json:"artifacts,omitempty"`
+	Pending   []PendingStep     `json:"pending_steps,omitempty"`
+	Started   time.Time         `json:"started"`
+	Ended     *time.Time        `json:"ended,omitempty"`
+
+	appID     string
+	userID    string
+	publicDir string
+	scope     string
+	uuid      string
+	page      string
+	deferred  []PendingStep
+	done      chan struct{}
+}
+
+// runEndStatus is the status a run ends in by the event ending it.
+var runEndStatus = map[string]string{
+	EventRunFinished:  RunStatusFinished,
+	EventRunFailed:    RunStatusFailed,
+	EventRunCancelled: RunStatusCancelled,
+}
+
+// runResults holds the runs someone waits for, by run id.
+var runResults = struct {
+	mu   sync.Mutex
+	runs map[string]*RunResult
+}{runs: make(map[string]*RunResult)}
+
+// watchRun starts collecting the result of the job's run. It must be called
+// before the run is queued.
+func watchRun(job *JobRecipe) *RunResult {
+	fm, _ := util.NewFileMeta(util.NewJobMetaFromEnv(job.Env), job.Recipe.IsShared)
+	res := &RunResult{
+		RunID:     job.RunID,
+		Status:    RunStatusQueued,
+		Started:   time.Now(),
+		appID:     job.AppID,
+		userID:    job.UserID,
+		publicDir: fm.PublicDir(),
+		scope:     fm.Scope,
+		uuid:      job.UUID,
+		page:      job.PageID,
+		deferred:  deferredSteps(job),
+		done:      make(chan struct{}),
+	}
+
+	runResults.mu.Lock()
+	defer runResults.mu.Unlock()
+	pruneRunResults(time.Now())
+	runResults.runs[job.RunID] = res
+	return res
+}
+
+// pruneRunResults forgets the results of runs that ended more than
+// runResultKeep ago, and of runs that started more than runResultExpire ago
+// and never ended. The caller holds runResults.mu.
+func pruneRunResults(now time.Time) {
+	for id, r := range runResults.runs {
+		if (r.Ended != nil && now.Sub(*r.Ended) > runResultKeep) || (r.Ended == nil && now.Sub(r.Started) > runResultExpire) {
+			delete(runResults.runs, id)
+		}
+	}
+}
+
+// deferredSteps returns the steps of the job's recipe that run later from
+// the in or every queue instead of with the run.
+func deferredSteps(job *JobRecipe) []PendingStep {
+	var pending []PendingStep
+	for _, st := range job.Recipe.Steps {
+		do := strings.TrimSpace(st.Do)
+		if lemc_do_in_rgx.MatchString(do) || lemc_do_every_rgx.MatchString(do) {
+			pending = append(pending, PendingStep{Step: st.Step, Do: do})
+		}
+	}
+	return pending
+}
+
+// unwatchRun forgets a run that was never queued.
+func unwatchRun(runID string) {
+	runResults.mu.Lock()
+	defer runResults.mu.Unlock()
+	delete(runResults.runs, runID)
+}
+
+// RunResultOf returns a snapshot of the result of a watched run of the app,
+// and whether it is known.
+func RunResultOf(appID, runID string) (RunResult, bool) {
+	runResults.mu.Lock()
+	defer runResults.mu.Unlock()
+	res, ok := runResults.runs[runID]
+	if !ok || res.appID != appID {
+		return RunResult{}, false
+	}
+	return res.snapshot(), true
+}
+
+// WaitRun waits until the watched run ended or the timeout passed, and
+// returns its result then.
+func WaitRun(appID, runID string, timeout time.Duration) (RunResult, bool) {
+	runResults.mu.Lock()
+	res, ok := runResults.runs[runID]
+	runResults.mu.Unlock()
+	if !ok || res.appID != appID {
+		return RunResult{}, false
+	}
+	select {
+	case <-res.done:
+	case <-time.After(timeout):
+	}
+	return RunResultOf(appID, runID)
+}
+
+func (r *RunResult) snapshot() RunResult {
+	s := *r
+	s.Data = append([]json.RawMessage(nil), r.Data...)
+	s.Artifacts = append([]string(nil), r.Artifacts...)
+	s.Pending = append([]PendingStep(nil), r.Pending...)
+	return s
+}
+
+// watched returns the result collected for the job's run, nil when nobody
+// watches it. The caller holds runResults.mu.
+func watched(job *JobRecipe) *RunResult {
+	if job.RunID == "" {
+		return nil
+	}
+	return runResults.runs[job.RunID]
+}
+
+// collectOutput adds a line of step output to the result of the run. Plain
+// lines and errors become its output, LEMC_OUTPUT payloads its data.
+func collectOutput(job *JobRecipe, line string) {
+	runResults.mu.Lock()
+	defer runResults.mu.Unlock()
+	res := watched(job)
+	if res == nil {
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(line, LEMC_OUTPUT):
+		payload := strings.TrimSpace(strings.TrimPrefix(line, LEMC_OUTPUT))
+		if json.Valid([]byte(payload)) {
+			res.Data = append(res.Data, json.RawMessage(payload))
+		} else {
+			b, _ := json.Marshal(payload)
+			res.Data = append(res.Data, b)
+		}
+		return
+	case strings.HasPrefix(line, LEMC_ERR):
+		line = strings.TrimPrefix(line, LEMC_ERR)
+	case strings.HasPrefix(line, "lemc."):
+		return
+	}
+	res.Output += line + "\n"
+	if len(res.Output) > runOutputMax {
+		res.Output = res.Output[len(res.Output)-runOutputMax:]
+	}
+}
+
+// collectEvent follows the lifecycle of the run in its result.
+func collectEvent(ev Event) {
+	runResults.mu.Lock()
+	defer runResults.mu.Unlock()
+	res := runResults.runs[ev.RunID]
+	if res == nil || res.Ended != nil {
+		return
+	}
+
+	switch ev.Type {
+	case EventRunStarted, EventStepStarted:
+		res.Status = RunStatusRunning
+		if ev.Step > 0 {
+			res.Step = ev.Step
+		}
+		return
+	case EventStepFinished:
+		res.ExitCode = ev.ExitCode
+		if ev.Error != "" {
+			res.Error = ev.Error
+		}
+		return
+	}
+	status, ok := runEndStatus[ev.Type]
+	if !ok {
+		return
+	}
+	res.Status = status
+	if ev.Type == EventRunFailed {
+		res.Error = ev.Error
+	}
+	if ev.Type == EventRunFinished && len(res.deferred) > 0 {
+		res.Status = RunStatusPending
+		res.Pending = res.deferred
+	}
+	ended := ev.Time
+	res.Ended = &ended
+	res.Artifacts = res.artifacts()
+	close(res.done)
+}
+
+// artifacts links the files of the public locker of the run's page written
+// since the run started.
+func (r *RunResult) artifacts() []string {
+	entries, err := os.ReadDir(r.publicDir)
+	if err != nil {
+		return nil
+	}
+	page, _ := strconv.Atoi(r.page)
+	var links []string
+	for _, e := range entries {
+		info, err := e.Info()
+		// File times can be coarser than the clock.
+		if err != nil || e.IsDir() || info.ModTime().Before(r.Started.Truncate(time.Second)) {
+			continue
+		}
+		links = append(links, fmt.Sprintf(paths.LockerDownloadPattern, r.uuid, page, r.scope)+url.PathEscape(e.Name()))
+	}
+	sort.Strings(links)
+	return links
+}
@@ -0,0 +1,266 @@
+package yeschef
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/models"
+)
+
+func watchedTestJob(t *testing.T) *JobRecipe {
+	t.Helper()
+	saved := XoxoX
+	XoxoX = &ChefsKiss{apps: make(map[int64]*CmdServer), mcpApps: make(map[int64]*McpServer)}
+	t.Cleanup(func() { XoxoX = saved })
+	job := &JobRecipe{
+		RunID:  "run-" + strings.ReplaceAll(t.Name(), "/", "-"),
+		AppID:  "7",
+		UUID:   "app-uuid",
+		PageID: "1",
+		Scope:  "shared",
+		Env:    []string{"LEMC_UUID=app-uuid", "LEMC_PAGE_ID=1", "LEMC_SCOPE=shared"},
+		Recipe: models.Recipe{Name: "deploy", IsShared: true},
+	}
+	watchRun(job)
+	t.Cleanup(func() { unwatchRun(job.RunID) })
+	return job
+}
+
+func TestRunResultCollectsOutputAndEnd(t *testing.T) {
+	job := watchedTestJob(t)
+	res := runResults.runs[job.RunID]
+
+	if err := os.MkdirAll(res.publicDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(res.publicDir, "report.csv"), []byte("a,b"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	emit(job, Event{Type: EventStepStarted, Step: 1})
+	collectOutput(job, "hello")
+	collectOutput(job, LEMC_HTML_APPEND+"<p>ui only</p>")
+	collectOutput(job, LEMC_OUTPUT+`{"deployed":true}`)
+	collectOutput(job, LEMC_OUTPUT+"plain")
+	collectOutput(job, LEMC_ERR+"boom")
+	code := 3
+	emit(job, Event{Type: EventStepFinished, Step: 1, ExitCode: &code})
+
+	got, ok := RunResultOf("7", job.RunID)
+	if !ok || got.Status != RunStatusRunning || got.Ended != nil {
+		t.Fatalf("running result = %+v", got)
+	}
+	if _, ok := RunResultOf("8", job.RunID); ok {
+		t.Error("result of another app's run")
+	}
+
+	emitRunEnd(job, time.Now(), errTest("lemc err: boom"))
+	got, _ = WaitRun("7", job.RunID, time.Second)
+	if got.Status != RunStatusFailed || got.Error != "lemc err: boom" || got.ExitCode == nil || *got.ExitCode != 3 {
+		t.Errorf("result = %+v", got)
+	}
+	if got.Output != "hello\nboom\n" {
+		t.Errorf("output = %q", got.Output)
+	}
+	if len(got.Data) != 2 || string(got.Data[0]) != `{"deployed":true}` || string(got.Data[1]) != `"plain"` {
+		t.Errorf("data = %s", got.Data)
+	}
+	want := "/lemc/locker/uuid/app-uuid/page/1/scope/shared/filename/report.csv"
+	if len(got.Artifacts) != 1 || got.Artifacts[0] != want {
+		t.Errorf("artifacts = %v, want %s", got.Artifacts, want)
+	}
+}
+
+type errTest string
+
+func (e errTest) Error() string { return string(e) }
+
+func TestWaitRunTimesOut(t *testing.T) {
+	job := watchedTestJob(t)
+	start := time.Now()
+	got, ok := WaitRun("7", job.RunID, 20*time.Millisecond)
+	if !ok || got.Ended != nil || got.Status != RunStatusQueued {
+		t.Fatalf("result = %+v", got)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Error("WaitRun returned before the timeout")
+	}
+}
+
+func TestRunResultPendingSteps(t *testing.T) {
+	job := watchedTestJob(t)
+	unwatchRun(job.RunID)
+	job.Recipe.Steps = []models.Step{
+		{Step: 1, Do: "now"},
+		{Step: 2, Do: "in.5.minutes"},
+		{Step: 3, Do: "every.1.hours"},
+	}
+	watchRun(job)
+
+	emit(job, Event{Type: EventRunFinished, Time: time.Now()})
+	got, ok := RunResultOf("7", job.RunID)
+	if !ok || got.Status != RunStatusPending || got.Ended == nil {
+		t.Fatalf("result = %+v", got)
+	}
+	want := []PendingStep{{Step: 2, Do: "in.5.minutes"}, {Step: 3, Do: "every.1.hours"}}
+	if len(got.Pending) != 2 || got.Pending[0] != want[0] || got.Pending[1] != want[1] {
+		t.Errorf("pending steps = %+v", got.Pending)
+	}
+	summary := runToolResult(got)["content"].([]map[string]string)[0]["text"]
+	if !strings.Contains(summary, "step 2 (in.5.minutes), step 3 (every.1.hours)") {
+		t.Errorf("summary = %q", summary)
+	}
+}
+
+func TestPruneRunResults(t *testing.T) {
+	now := time.Now()
+	longAgo := now.Add(-2 * runResultExpire)
+	recent := now.Add(-time.Minute)
+	runResults.mu.Lock()
+	runResults.runs["prune-stale"] = &RunResult{Started: longAgo}
+	runResults.runs["prune-ended"] = &RunResult{Started: longAgo, Ended: &longAgo}
+	runResults.runs["prune-running"] = &RunResult{Started: recent}
+	runResults.runs["prune-fresh"] = &RunResult{Started: longAgo, Ended: &recent}
+	pruneRunResults(now)
+	var kept []string
+	for _, id := range []string{"prune-stale", "prune-ended", "prune-running", "prune-fresh"} {
+		if _, ok := runResults.runs[id]; ok {
+			kept = append(kept, id)
+			delete(runResults.runs, id)
+		}
+	}
+	runResults.mu.Unlock()
+	if strings.Join(kept, ",") != "prune-running,prune-fresh" {
+		t.Errorf("kept %v", kept)
+	}
+}
+
+func TestRunToolResult(t *testing.T) {
+	code := 0
+	ended := time.Now()
+	res := RunResult{RunID: "r1", Status: RunStatusFinished, ExitCode: &code, Ended: &ended, Output: "done\n", Artifacts: []string{"/a"}}
+	b, _ := json.Marshal(runToolResult(res))
+	var result struct {
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+		StructuredContent RunResult `json:"structuredContent"`
+		IsError           bool      `json:"isError"`
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.IsError || len(result.Content) != 3 || result.Content[0].Text != "run r1 finished, exit code 0" {
+		t.Errorf("result = %s", b)
+	}
+	if result.StructuredContent.RunID != "r1" {
+		t.Errorf("structured content = %+v", result.StructuredContent)
+	}
+
+	pending := runToolResult(RunResult{RunID: "r2", Status: RunStatusRunning})
+	if pending["isError"] != false || !strings.Contains(pending["content"].([]map[string]string)[0]["text"], "get-run-result") {
+		t.Errorf("pending result = %v", pending)
+	}
+}
+
+func TestWaitArgs(t *testing.T) {
+	wait, rest, err := waitArgs(json.RawMessage(`{"lemc_wait":true,"lemc_timeout":5,"env":"prod"}`))
+	if err != nil || wait != 5*time.Second || string(rest) != `{"env":"prod"}` {
+		t.Errorf("waitArgs = %v, %s, %v", wait, rest, err)
+	}
+	wait, _, err = waitArgs(json.RawMessage(`{"lemc_timeout":5}`))
+	if err != nil || wait != 0 {
+		t.Errorf("waitArgs without lemc_wait = %v, %v", wait, err)
+	}
+	wait, _, _ = waitArgs(json.RawMessage(`{"lemc_wait":true,"lemc_timeout":100000}`))
+	if wait != mcpWaitMax {
+		t.Errorf("wait = %v, want %v", wait, mcpWaitMax)
+	}
+	if _, _, err := waitArgs(json.RawMessage(`{"lemc_wait":"yes"}`)); err == nil {
+		t.Error("lemc_wait must be a boolean")
+	}
+}
+
+func TestMcpGetRunResultWaits(t *testing.T) {
+	job := watchedTestJob(t)
+	srv, key, perm := mcpRunApp(t)
+	setAppPerm(t, perm, true, true)
+	go srv.Run()
+	c := srv.NewSession(key.UserID, key.AccountID, perm.ApiKey)
+	unwatchRun(job.RunID)
+	job.AppID = srv.appID()
+	watchRun(job)
+
+	done := make(chan map[string]json.RawMessage)
+	go func() {
+		done <- mcpCall(t, srv, c, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"get-run-result","arguments":{"run_id":"`+job.RunID+`","lemc_wait":true}}}`)
+	}()
+
+	// Other requests are answered while the call waits.
+	if resp := mcpCall(t, srv, c, `{"jsonrpc":"2.0","id":2,"method":"ping"}`); string(resp["result"]) != "{}" {
+		t.Errorf("ping = %v", resp)
+	}
+	emitRunEnd(job, time.Now(), nil)
+
+	resp := <-done
+	var result struct {
+		StructuredContent RunResult `json:"structuredContent"`
+	}
+	if err := json.Unmarshal(resp["result"], &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.StructuredContent.Status != RunStatusFinished {
+		t.Errorf("result = %s", resp["result"])
+	}
+
+	resp = mcpCall(t, srv, c, `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"get-run-result","arguments":{"run_id":"nope"}}}`)
+	if !strings.Contains(string(resp["result"]), `"isError":true`) {
+		t.Errorf("unknown run = %s", resp["result"])
+	}
+}
+
+func TestMcpGetRunResultVisibility(t *testing.T) {
+	job := watchedTestJob(t)
+	srv, key, perm := mcpRunApp(t)
+	go srv.Run()
+	c := srv.NewSession(key.UserID, key.AccountID, perm.ApiKey)
+	unwatchRun(job.RunID)
+	job.AppID = srv.appID()
+	watchRun(job)
+
+	get := func() string {
+		resp := mcpCall(t, srv, c, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"get-run-result","arguments":{"run_id":"`+job.RunID+`"}}}`)
+		return string(resp["result"])
+	}
+
+	setAppPerm(t, perm, false, true)
+	if res := get(); !strings.Contains(res, "unknown run") {
+		t.Errorf("shared run without can shared = %s", res)
+	}
+	setAppPerm(t, perm, true, false)
+	if res := get(); strings.Contains(res, `"isError":true`) {
+		t.Errorf("shared run with can shared = %s", res)
+	}
+
+	// Individual runs are only visible to their user.
+	unwatchRun(job.RunID)
+	job.Scope = "individual"
+	job.Recipe.IsShared = false
+	job.Env = []string{"LEMC_UUID=app-uuid", "LEMC_PAGE_ID=1", "LEMC_SCOPE=individual", fmt.Sprintf("LEMC_USER_ID=%d", key.UserID+1)}
+	job.UserID = fmt.Sprintf("%d", key.UserID+1)
+	watchRun(job)
+	if res := get(); !strings.Contains(res, "unknown run") {
+		t.Errorf("individual run of another user = %s", res)
+	}
+	unwatchRun(job.RunID)
+	job.UserID = fmt.Sprintf("%d", key.UserID)
+	watchRun(job)
+	if res := get(); strings.Contains(res, `"isError":true`) {
+		t.Errorf("own individual run = %s", res)
+	}
+}
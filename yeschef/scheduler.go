@@ -1,9 +1,7 @@
 package yeschef
 
 import (
-	"fmt"
-	"os"
-
+	"github.com/jaredfolkins/letemcook/models"
 	"github.com/reugn/go-quartz/logger"
 	"github.com/reugn/go-quartz/quartz"
 )
@@ -13,43 +11,20 @@ func (jq *jobQueue) ScheduledJobs(matchers []quartz.Matcher[quartz.ScheduledJob]
 	defer jq.mu.Unlock()
 
 	logger.Trace("ScheduledJobs")
-	var jobs []quartz.ScheduledJob
-	fileInfo, err := os.ReadDir(jq.Path)
+	rows, err := models.ScheduledJobsByQueue(jq.Name)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, file := range fileInfo {
-		if !file.IsDir() {
-			data, err := os.ReadFile(fmt.Sprintf("%s/%s", jq.Path, file.Name()))
-			if err == nil {
-				var erri error
-				var job quartz.ScheduledJob
-
-				switch jq.Name {
-				case NOW_QUEUE:
-					job, erri = unmarshalRecipeJob(data)
-					if erri != nil {
-						return nil, erri
-					}
-				case IN_QUEUE:
-					job, erri = unmarshalInStepJob(data)
-					if erri != nil {
-						return nil, erri
-					}
-				case EVERY_QUEUE:
-					job, erri = unmarshalEveryStepJob(data)
-					if erri != nil {
-						return nil, erri
-					}
-				default:
-					return nil, fmt.Errorf("unknown queue name: %s", jq.Name)
-				}
-
-				if isMatch(job, matchers) {
-					jobs = append(jobs, job)
-				}
-			}
+	var jobs []quartz.ScheduledJob
+	for _, row := range rows {
+		job, err := jq.unmarshal([]byte(row.Payload))
+		if err != nil {
+			logger.Errorf("Failed to unmarshal job %s in ScheduledJobs: %v", row.JobKey, err)
+			continue
+		}
+		if isMatch(job, matchers) {
+			jobs = append(jobs, job)
 		}
 	}
 
@@ -2,16 +2,84 @@ package yeschef
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
-	"github.com/reugn/go-quartz/job"
 	"github.com/reugn/go-quartz/quartz"
 )
 
+// payloadVersion is the version of the format jobs are persisted in. Bump it
+// whenever a change to a persisted struct needs existing payloads to be
+// rewritten, and register the upgrade from the previous version in
+// payloadUpgrades.
+const payloadVersion = 1
+
+// ErrPayloadVersion is returned for a payload written by a newer LEMC.
+var ErrPayloadVersion = errors.New("job payload version is not supported")
+
+// payloadUpgrade rewrites a decoded payload of one version into the next.
+type payloadUpgrade func(payload map[string]json.RawMessage) error
+
+// payloadUpgrades holds the upgrade from each version to the one after it.
+var payloadUpgrades = map[int]payloadUpgrade{
+	0: upgradePayloadV0,
+}
+
+// upgradePayload brings a persisted job up to payloadVersion, one version at
+// a time. Payloads written before jobs were versioned are version 0.
+func upgradePayload(data []byte) ([]byte, error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+
+	version := 0
+	if raw, ok := payload["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, fmt.Errorf("invalid payload version: %s", raw)
+		}
+	}
+	if version == payloadVersion {
+		return data, nil
+	}
+	if version < 0 || version > payloadVersion {
+		return nil, fmt.Errorf("%w: %d", ErrPayloadVersion, version)
+	}
+
+	for ; version < payloadVersion; version++ {
+		upgrade, ok := payloadUpgrades[version]
+		if !ok {
+			return nil, fmt.Errorf("no upgrade from payload version %d", version)
+		}
+		if err := upgrade(payload); err != nil {
+			return nil, fmt.Errorf("upgrade payload version %d: %w", version, err)
+		}
+	}
+	payload["version"], _ = json.Marshal(payloadVersion)
+	return json.Marshal(payload)
+}
+
+// upgradePayloadV0 unwraps recipe jobs that were persisted inside the
+// isolated job wrapper, {"Job": {...}}, by recovering the now queue.
+func upgradePayloadV0(payload map[string]json.RawMessage) error {
+	var wrapped map[string]json.RawMessage
+	if err := json.Unmarshal(payload["job"], &wrapped); err != nil {
+		// null or not an object, left for the queue to reject
+		return nil
+	}
+	inner, ok := wrapped["Job"]
+	if len(wrapped) != 1 || !ok || len(inner) == 0 || inner[0] != '{' {
+		return nil
+	}
+	payload["job"] = inner
+	return nil
+}
+
 func marshal(job quartz.ScheduledJob) ([]byte, error) {
 	var serialized serializedJob
+	serialized.Version = payloadVersion
 	serialized.Job = job.JobDetail().Job()
 	serialized.Description = job.JobDetail().Job().Description()
 	serialized.JobKey = job.JobDetail().JobKey().Name()
@@ -22,31 +90,104 @@ func marshal(job quartz.ScheduledJob) ([]byte, error) {
 	return json.Marshal(serialized)
 }
 
-func unmarshalEveryStepJob(encoded []byte) (quartz.ScheduledJob, error) {
-	var nj serializedStepJob
+// unmarshalEveryJob decodes a job from the every queue, which holds both
+// every steps and recipe schedules.
+func unmarshalEveryJob(encoded []byte) (quartz.ScheduledJob, error) {
+	var peek struct {
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(encoded, &peek); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(peek.Description, recipeScheduleDescription) {
+		return unmarshalRecipeScheduleJob(encoded)
+	}
+	return unmarshalEveryStepJob(encoded)
+}
+
+func unmarshalRecipeScheduleJob(encoded []byte) (quartz.ScheduledJob, error) {
+	var nj serializedRecipeScheduleJob
 	if err := json.Unmarshal(encoded, &nj); err != nil {
 		return nil, err
 	}
 
-	if nj.Job == nil {
-		return nil, fmt.Errorf("job is nil in serializedStepJob")
+	if nj.Job == nil || nj.Job.RecipeJob == nil {
+		return nil, fmt.Errorf("job is nil in serializedRecipeScheduleJob")
+	}
+	if nj.Options == nil {
+		nj.Options = quartz.NewDefaultJobDetailOptions()
 	}
 
 	jobKey := quartz.NewJobKeyWithGroup(nj.JobKey, nj.Group)
 	jobDetail := quartz.NewJobDetailWithOptions(nj.Job, jobKey, nj.Options)
-	triggerOpts := strings.Split(nj.Trigger, quartz.Sep)
 
+	triggerOpts := strings.Split(nj.Trigger, quartz.Sep)
 	if len(triggerOpts) < 2 {
 		return nil, fmt.Errorf("invalid trigger format: %s", nj.Trigger)
 	}
 
-	interval, err := time.ParseDuration(triggerOpts[1])
-	if err != nil {
-		return nil, fmt.Errorf("invalid interval format: %s", triggerOpts[1])
+	var trigger quartz.Trigger
+	if triggerOpts[0] == "CronTrigger" {
+		if len(triggerOpts) < 3 {
+			return nil, fmt.Errorf("invalid trigger format: %s", nj.Trigger)
+		}
+		loc, err := time.LoadLocation(triggerOpts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron location: %s", triggerOpts[2])
+		}
+		if trigger, err = quartz.NewCronTriggerWithLoc(triggerOpts[1], loc); err != nil {
+			return nil, err
+		}
+	} else {
+		interval, err := time.ParseDuration(triggerOpts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval format: %s", triggerOpts[1])
+		}
+		trigger = quartz.NewSimpleTrigger(interval)
 	}
 
+	return &scheduledLemcJob{
+		jobDetail:   jobDetail,
+		trigger:     trigger,
+		nextRunTime: nj.NextRunTime,
+	}, nil
+}
+
+func unmarshalEveryStepJob(encoded []byte) (quartz.ScheduledJob, error) {
+	var nj serializedStepJob
+	if err := json.Unmarshal(encoded, &nj); err != nil {
+		return nil, err
+	}
+
+	if nj.Job == nil {
+		return nil, fmt.Errorf("job is nil in serializedStepJob")
+	}
+	if nj.Options == nil {
+		nj.Options = quartz.NewDefaultJobDetailOptions()
+	}
+
+	jobKey := quartz.NewJobKeyWithGroup(nj.JobKey, nj.Group)
+	jobDetail := quartz.NewJobDetailWithOptions(nj.Job, jobKey, nj.Options)
+
 	var trigger quartz.Trigger
-	trigger = quartz.NewSimpleTrigger(interval)
+	if strings.HasPrefix(nj.Trigger, boundedTriggerDescription+quartz.Sep) {
+		bt, err := parseBoundedTrigger(nj.Trigger)
+		if err != nil {
+			return nil, err
+		}
+		trigger = bt
+	} else {
+		triggerOpts := strings.Split(nj.Trigger, quartz.Sep)
+		if len(triggerOpts) < 2 {
+			return nil, fmt.Errorf("invalid trigger format: %s", nj.Trigger)
+		}
+
+		interval, err := time.ParseDuration(triggerOpts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval format: %s", triggerOpts[1])
+		}
+		trigger = quartz.NewSimpleTrigger(interval)
+	}
 
 	return &scheduledLemcJob{
 		jobDetail:   jobDetail,
@@ -64,10 +205,15 @@ func unmarshalRecipeJob(encoded []byte) (quartz.ScheduledJob, error) {
 	if nj.Job == nil {
 		return nil, fmt.Errorf("job is nil in serializedRecipeJob")
 	}
+	if nj.Options == nil {
+		nj.Options = quartz.NewDefaultJobDetailOptions()
+	}
 
 	jk := quartz.NewJobKeyWithGroup(nj.JobKey, nj.Group)
-	isolatedJob := job.NewIsolatedJob(nj.Job)
-	jobDetail := quartz.NewJobDetailWithOptions(isolatedJob, jk, nj.Options)
+	// The recipe is not wrapped in an isolated job: a run once job cannot
+	// overlap itself, and the wrapper would change the payload when the job
+	// is queued again by recovery or a dead letter retry.
+	jobDetail := quartz.NewJobDetailWithOptions(nj.Job, jk, nj.Options)
 	triggerOpts := strings.Split(nj.Trigger, quartz.Sep)
 
 	if len(triggerOpts) < 2 {
@@ -103,6 +249,9 @@ func unmarshalInStepJob(encoded []byte) (quartz.ScheduledJob, error) {
 	if nj.Job == nil {
 		return nil, fmt.Errorf("job is nil in serializedStepJob")
 	}
+	if nj.Options == nil {
+		nj.Options = quartz.NewDefaultJobDetailOptions()
+	}
 
 	jobKey := quartz.NewJobKeyWithGroup(nj.JobKey, nj.Group)
 	jobDetail := quartz.NewJobDetailWithOptions(nj.Job, jobKey, nj.Options)
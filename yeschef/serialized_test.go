@@ -1,7 +1,12 @@
 package yeschef
 
 import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -67,3 +72,99 @@ func TestMarshalUnmarshalInStepJob(t *testing.T) {
 		t.Errorf("step mismatch")
 	}
 }
+
+func TestMarshalUnmarshalRecipeScheduleJob(t *testing.T) {
+	rs := &RecipeScheduleJob{
+		RecipeJob:   &JobRecipe{UUID: "u", UserID: "1", PageID: "1", Scope: "shared", Recipe: models.Recipe{Name: "nightly"}},
+		Schedule:    models.RecipeSchedule{Cron: "0 0 3 * * *"},
+		Fingerprint: "abc",
+	}
+	trg, err := NewScheduleTrigger(rs.Schedule)
+	if err != nil {
+		t.Fatalf("trigger: %v", err)
+	}
+	sj := &scheduledLemcJob{jobDetail: quartz.NewJobDetail(rs, quartz.NewJobKey("sk")), trigger: trg, nextRunTime: 11}
+	b, err := marshal(sj)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	j, err := unmarshalEveryJob(b)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	got, ok := j.JobDetail().Job().(*RecipeScheduleJob)
+	if !ok {
+		t.Fatalf("expected a RecipeScheduleJob, got %T", j.JobDetail().Job())
+	}
+	if got.Fingerprint != "abc" || got.RecipeJob.Recipe.Name != "nightly" {
+		t.Errorf("unexpected job %+v", got)
+	}
+	if j.Trigger().Description() != trg.Description() {
+		t.Errorf("trigger %s, want %s", j.Trigger().Description(), trg.Description())
+	}
+}
+
+// TestLoadPayloadFixtures loads jobs persisted by earlier versions. Each file
+// in testdata/payloads is named after the queue it was taken from. Add a
+// fixture for the old format whenever payloadVersion is bumped.
+func TestLoadPayloadFixtures(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "payloads", "*.json"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no payload fixtures: %v", err)
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		jq := &jobQueue{Name: strings.SplitN(name, "_", 2)[0]}
+
+		job, err := jq.unmarshal(data)
+		if err != nil {
+			t.Fatalf("%s: unmarshal: %v", name, err)
+		}
+		if err := ValidateScheduledJob(job); err != nil {
+			t.Fatalf("%s: validate: %v", name, err)
+		}
+		if rj := deadLetterRecipe(job.JobDetail().Job()); rj == nil || rj.Recipe.Name == "" {
+			t.Fatalf("%s: recipe not decoded from %T", name, job.JobDetail().Job())
+		}
+
+		// Saved again, the job is written in the current version.
+		b, err := marshal(job)
+		if err != nil {
+			t.Fatalf("%s: marshal: %v", name, err)
+		}
+		var peek struct {
+			Version int `json:"version"`
+		}
+		if err := json.Unmarshal(b, &peek); err != nil || peek.Version != payloadVersion {
+			t.Fatalf("%s: saved as version %d, want %d", name, peek.Version, payloadVersion)
+		}
+		again, err := jq.unmarshal(b)
+		if err != nil {
+			t.Fatalf("%s: unmarshal saved job: %v", name, err)
+		}
+		if again.Trigger().Description() != job.Trigger().Description() || again.NextRunTime() != job.NextRunTime() {
+			t.Fatalf("%s: saved job changed", name)
+		}
+	}
+}
+
+func TestUpgradePayloadUnwrapsIsolatedJob(t *testing.T) {
+	b, err := upgradePayload([]byte(`{"job":{"Job":{"UUID":"u"}},"job_key":"k"}`))
+	if err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	if want := `{"job":{"UUID":"u"},"job_key":"k","version":1}`; string(b) != want {
+		t.Fatalf("upgraded to %s, want %s", b, want)
+	}
+}
+
+func TestUpgradePayloadNewerVersion(t *testing.T) {
+	_, err := upgradePayload([]byte(`{"version":99,"job":{}}`))
+	if !errors.Is(err, ErrPayloadVersion) {
+		t.Fatalf("expected ErrPayloadVersion, got %v", err)
+	}
+}
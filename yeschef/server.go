@@ -7,17 +7,32 @@ import (
 type CmdServer struct {
 	mu          sync.RWMutex
 	Clients     map[*Client]bool
-	Radio       chan []byte
+	Radio       chan frame
 	Provision   chan *Client
 	Deprovision chan *Client
+	Subscribe   chan Subscription
+
+	pos  uint64             // position of the last frame published, guarded by mu
+	runs map[string]*runSeq // sequence numbers of the runs, guarded by mu
+	sent uint64             // position of the last frame Run sent, only used by Run
+	ring *replayRing
+}
+
+// Subscription replaces the topics of a client.
+type Subscription struct {
+	Client *Client
+	Topics []string
 }
 
 func NewServer() *CmdServer {
 	return &CmdServer{
-		Radio:       make(chan []byte, 1024),
+		Radio:       make(chan frame, 1024),
 		Provision:   make(chan *Client),
 		Deprovision: make(chan *Client),
+		Subscribe:   make(chan Subscription),
 		Clients:     make(map[*Client]bool),
+		runs:        make(map[string]*runSeq),
+		ring:        newReplayRing(REPLAY_BUFFER_SIZE),
 	}
 }
 
@@ -26,19 +41,38 @@ func (xrv *CmdServer) Run() {
 		select {
 		case xlient := <-xrv.Provision:
 			xrv.Clients[xlient] = true
+			xrv.replay(xlient, xlient.Since)
 		case xlient := <-xrv.Deprovision:
 			if _, ok := xrv.Clients[xlient]; ok {
 				delete(xrv.Clients, xlient)
 				close(xlient.Xend)
 			}
-		case msg := <-xrv.Radio:
-			for xlient := range xrv.Clients {
-				select {
-				case xlient.Xend <- msg:
-				default:
-					// Drop the message for this client if their buffer is full
+		case sub := <-xrv.Subscribe:
+			if _, ok := xrv.Clients[sub.Client]; ok {
+				sub.Client.Topics = sub.Topics
+			}
+		case f := <-xrv.Radio:
+			for _, f := range append(xrv.missed(f), f) {
+				for xlient := range xrv.Clients {
+					if xlient.wants(f) {
+						xrv.deliver(xlient, f.data)
+					}
 				}
 			}
 		}
 	}
 }
+
+// deliver queues a message for a client. A client whose buffer is full fell
+// behind, it is closed so it reconnects and resumes from the last message it
+// saw instead of silently missing this one.
+func (xrv *CmdServer) deliver(xlient *Client, msg []byte) bool {
+	select {
+	case xlient.Xend <- msg:
+		return true
+	default:
+		delete(xrv.Clients, xlient)
+		close(xlient.Xend)
+		return false
+	}
+}
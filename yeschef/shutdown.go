@@ -0,0 +1,264 @@
+package yeschef
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/jaredfolkins/letemcook/util"
+	"github.com/reugn/go-quartz/quartz"
+)
+
+// DefaultShutdownTimeout is how long a shutdown waits for running steps to
+// finish before the steps left are persisted.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// DefaultDrainMessage is shown to users whose runs are refused while the
+// engine is drained and no message was given.
+const DefaultDrainMessage = "LEMC is under maintenance, new runs are paused. Please try again later."
+
+// engine is the state shared by the drain toggle and the shutdown path.
+var engine = struct {
+	mu       sync.Mutex
+	drain    models.DrainState
+	stopping bool
+	running  map[*runningJob]struct{}
+	idle     chan struct{}   // closed once stopping and nothing is running
+	ctx      context.Context // cancelled on shutdown
+	cancel   context.CancelFunc
+}{running: make(map[*runningJob]struct{})}
+
+// runningJob is a run the engine waits for on shutdown. job is what is left
+// of the run. When requeue is set it is put back in queue if the shutdown
+// deadline passes first, runs that stay queued anyway, like every steps, are
+// not. container is the step container running, which is followed again
+// after the restart instead.
+type runningJob struct {
+	queue     string
+	job       quartz.ScheduledJob
+	requeue   bool
+	container string
+}
+
+// ShutdownTimeout reads LEMC_SHUTDOWN_TIMEOUT.
+func ShutdownTimeout() time.Duration {
+	timeout := DefaultShutdownTimeout
+	if raw := strings.TrimSpace(os.Getenv("LEMC_SHUTDOWN_TIMEOUT")); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			log.Printf("invalid LEMC_SHUTDOWN_TIMEOUT %q, using %s", raw, DefaultShutdownTimeout)
+		} else {
+			timeout = d
+		}
+	}
+	return timeout
+}
+
+// SetDrain turns drain mode on or off. While drained, new runs are refused
+// with message, running ones and their in steps carry on.
+func SetDrain(on bool, message string) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if !on {
+		engine.drain = models.DrainState{}
+		return
+	}
+	message = strings.TrimSpace(message)
+	if message == "" {
+		message = DefaultDrainMessage
+	}
+	since := engine.drain.Since
+	if !engine.drain.Draining {
+		since = time.Now()
+	}
+	engine.drain = models.DrainState{Draining: true, Message: message, Since: since}
+}
+
+// DrainStatus returns the current drain mode.
+func DrainStatus() models.DrainState {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	return engine.drain
+}
+
+// acceptingRuns returns a user visible error when new runs are refused,
+// because the engine is drained or shutting down.
+func acceptingRuns() error {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if engine.stopping {
+		return NewUserVisibleError("SHUTTING_DOWN", "LEMC is restarting, please try again in a moment.", nil)
+	}
+	if engine.drain.Draining {
+		return NewUserVisibleError("DRAINING", engine.drain.Message, nil)
+	}
+	return nil
+}
+
+// engineContext returns the context runs started outside the schedulers get,
+// it is cancelled when the engine shuts down.
+func engineContext() context.Context {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if engine.ctx == nil {
+		return context.Background()
+	}
+	return engine.ctx
+}
+
+// goTrackedRun runs job in the background as a run shutdown waits for. Its
+// steps stay queued, so nothing is put back when the deadline passes.
+func goTrackedRun(queue, key string, job quartz.Job) {
+	r := trackRun(queue, nil, false)
+	ctx := engineContext()
+	go func() {
+		defer r.finish()
+		if err := job.Execute(ctx); err != nil {
+			log.Printf("run %s job %s now: %v", queue, key, err)
+		}
+	}()
+}
+
+// trackRun registers a run so shutdown waits for it.
+func trackRun(queue string, job quartz.ScheduledJob, requeue bool) *runningJob {
+	r := &runningJob{queue: queue, job: job, requeue: requeue}
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	engine.running[r] = struct{}{}
+	return r
+}
+
+// next records what is left of the run before its next step starts. It
+// returns false when the engine is shutting down, the steps left have then
+// been put back in queue and the run must stop.
+func (r *runningJob) next(job quartz.ScheduledJob) bool {
+	return r.nextAfter(job, job)
+}
+
+// nextAfter is next for a run whose next part is tracked on its own once it
+// started: left is what is left before it starts and after once it did.
+func (r *runningJob) nextAfter(left, after quartz.ScheduledJob) bool {
+	engine.mu.Lock()
+	if _, ok := engine.running[r]; !ok {
+		// Persisted by a shutdown that gave up waiting.
+		engine.mu.Unlock()
+		return false
+	}
+	if !engine.stopping {
+		r.job = after
+		engine.mu.Unlock()
+		return true
+	}
+	r.job = left
+	engine.mu.Unlock()
+
+	r.persist()
+	r.finish()
+	return false
+}
+
+// setContainer records the step container the run is waiting on, or that it
+// is done with it.
+func (r *runningJob) setContainer(id string) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	r.container = id
+}
+
+// finish removes the run once it returned.
+func (r *runningJob) finish() {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	delete(engine.running, r)
+	if engine.stopping && len(engine.running) == 0 && engine.idle != nil {
+		close(engine.idle)
+		engine.idle = nil
+	}
+}
+
+// persist puts what is left of the run back in its queue so it runs again
+// after the restart.
+func (r *runningJob) persist() {
+	if !r.requeue || r.job == nil {
+		return
+	}
+	jq, _, err := queueByName(r.queue)
+	if err != nil {
+		log.Printf("persist %s job %s: %v", r.queue, r.job.JobDetail().JobKey().Name(), err)
+		return
+	}
+	if err := jq.Push(r.job); err != nil {
+		log.Printf("persist %s job %s: %v", r.queue, r.job.JobDetail().JobKey().Name(), err)
+		return
+	}
+	log.Printf("persisted %s job %s for the next start", r.queue, r.job.JobDetail().JobKey().Name())
+}
+
+// remainingSteps returns the now job that runs the steps of jr from index
+// i on.
+func remainingSteps(jr *JobRecipe, i int) quartz.ScheduledJob {
+	rest := *jr
+	rest.Recipe.Steps = append([]models.Step(nil), jr.Recipe.Steps[i:]...)
+	return failedRecipeJob(&rest)
+}
+
+// Shutdown stops the job engine. New runs are refused, the schedulers stop
+// firing and running steps are given until ctx is done to finish. Steps that
+// did not get to run are put back in their queues, queued jobs stay where
+// they are, and all of them are recovered on the next start. Step containers
+// still running are left to be reattached.
+func Shutdown(ctx context.Context) {
+	engine.mu.Lock()
+	if engine.stopping {
+		engine.mu.Unlock()
+		return
+	}
+	engine.stopping = true
+	idle := make(chan struct{})
+	if len(engine.running) == 0 {
+		close(idle)
+	} else {
+		engine.idle = idle
+	}
+	if engine.cancel != nil {
+		engine.cancel()
+	}
+	engine.mu.Unlock()
+
+	if XoxoX != nil {
+		for _, s := range []*quartz.StdScheduler{XoxoX.NowScheduler, XoxoX.InScheduler, XoxoX.EveryScheduler} {
+			if s != nil {
+				s.Stop()
+			}
+		}
+	}
+
+	select {
+	case <-idle:
+		log.Printf("job engine stopped, no runs left")
+		return
+	case <-ctx.Done():
+	}
+
+	engine.mu.Lock()
+	left := make([]*runningJob, 0, len(engine.running))
+	for r := range engine.running {
+		left = append(left, r)
+		delete(engine.running, r)
+	}
+	engine.idle = nil
+	engine.mu.Unlock()
+
+	log.Printf("job engine stopped with %d runs still going", len(left))
+	for _, r := range left {
+		if r.container != "" {
+			log.Printf("left container %s of %s job %s running to follow after the restart", util.ShortImageID(r.container), r.queue, r.job.JobDetail().JobKey().Name())
+			continue
+		}
+		r.persist()
+	}
+}
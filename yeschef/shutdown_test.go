@@ -0,0 +1,171 @@
+package yeschef
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/reugn/go-quartz/quartz"
+)
+
+// resetEngine clears drain mode and any shutdown left by an earlier test.
+func resetEngine(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		engine.mu.Lock()
+		defer engine.mu.Unlock()
+		engine.drain = models.DrainState{}
+		engine.stopping = false
+		engine.running = make(map[*runningJob]struct{})
+		engine.idle = nil
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// shutdownNowQueue points the now queue at q for a shutdown test.
+func shutdownNowQueue(t *testing.T, q *jobQueue) {
+	t.Helper()
+	prevQueue, prevSched := XoxoX.NowQueue, XoxoX.NowScheduler
+	XoxoX.NowQueue, XoxoX.NowScheduler = q, quartz.NewStdSchedulerWithOptions(quartz.StdSchedulerOptions{}, q, nil)
+	t.Cleanup(func() { XoxoX.NowQueue, XoxoX.NowScheduler = prevQueue, prevSched })
+}
+
+func testThreeStepRecipe() *JobRecipe {
+	jr := testStepJob("unused", 0).jobDetail.Job().(*StepJob).RecipeJob
+	jr.Recipe.Steps = []models.Step{
+		{Step: 1, Name: "one", Image: "alpine", Do: "now", Timeout: "1.minutes"},
+		{Step: 2, Name: "two", Image: "alpine", Do: "now", Timeout: "1.minutes"},
+		{Step: 3, Name: "three", Image: "alpine", Do: "now", Timeout: "1.minutes"},
+	}
+	return jr
+}
+
+func TestDrainRefusesNewRuns(t *testing.T) {
+	newTestQueue(t, NOW_QUEUE)
+	resetEngine(t)
+
+	SetDrain(true, "")
+	err := DoNow(&JobRecipe{})
+	uve := GetUserVisibleError(err)
+	if uve == nil || uve.Code != "DRAINING" || uve.Message != DefaultDrainMessage {
+		t.Fatalf("expected a drain error, got %v", err)
+	}
+	if err := RunScheduledJobNow(EVERY_QUEUE, "key"); GetUserVisibleError(err) == nil {
+		t.Fatalf("expected run now to be refused, got %v", err)
+	}
+
+	SetDrain(true, "back at noon")
+	if s := DrainStatus(); !s.Draining || s.Message != "back at noon" || s.Since.IsZero() {
+		t.Fatalf("unexpected drain state: %+v", s)
+	}
+
+	SetDrain(false, "")
+	if err := acceptingRuns(); err != nil {
+		t.Fatalf("expected runs to be accepted after the drain, got %v", err)
+	}
+}
+
+func TestShutdownPersistsStepsLeft(t *testing.T) {
+	q := newTestQueue(t, NOW_QUEUE)
+	shutdownNowQueue(t, q)
+	resetEngine(t)
+
+	jr := testThreeStepRecipe()
+	run := trackRun(NOW_QUEUE, nil, true)
+	if !run.next(remainingSteps(jr, 1)) {
+		t.Fatal("expected the run to go on before the shutdown")
+	}
+
+	// Step two is still running when the deadline passes.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	Shutdown(ctx)
+
+	if err := acceptingRuns(); GetUserVisibleError(err) == nil {
+		t.Fatalf("expected new runs to be refused after shutdown, got %v", err)
+	}
+	if run.next(remainingSteps(jr, 2)) {
+		t.Fatal("expected the run to stop after the shutdown")
+	}
+	run.finish()
+
+	rows, err := models.ScheduledJobsByQueue(NOW_QUEUE)
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected one persisted job, got %d: %v", len(rows), err)
+	}
+	job, err := q.unmarshal([]byte(rows[0].Payload))
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	steps := job.JobDetail().Job().(*JobRecipe).Recipe.Steps
+	if len(steps) != 2 || steps[0].Step != 2 || len(jr.Recipe.Steps) != 3 {
+		t.Fatalf("persisted steps %+v", steps)
+	}
+}
+
+func TestShutdownWaitsForRunningSteps(t *testing.T) {
+	q := newTestQueue(t, NOW_QUEUE)
+	shutdownNowQueue(t, q)
+	resetEngine(t)
+
+	jr := testThreeStepRecipe()
+	run := trackRun(NOW_QUEUE, remainingSteps(jr, 0), true)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		// The recipe reaches its next step and stops there.
+		run.next(remainingSteps(jr, 1))
+		run.finish()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	Shutdown(ctx)
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("shutdown did not return once the run stopped")
+	}
+
+	rows, err := models.ScheduledJobsByQueue(NOW_QUEUE)
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected one persisted job, got %d: %v", len(rows), err)
+	}
+}
+
+// blockingJob is a job that runs until release is closed.
+type blockingJob struct {
+	release chan struct{}
+	done    chan struct{}
+}
+
+func (j *blockingJob) Execute(ctx context.Context) error {
+	<-j.release
+	close(j.done)
+	return nil
+}
+
+func (j *blockingJob) Description() string { return "blocking job" }
+
+func TestShutdownWaitsForRunNow(t *testing.T) {
+	resetEngine(t)
+
+	job := &blockingJob{release: make(chan struct{}), done: make(chan struct{})}
+	goTrackedRun(EVERY_QUEUE, "key", job)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(job.release)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	Shutdown(ctx)
+	select {
+	case <-job.done:
+	default:
+		t.Fatal("shutdown returned before the run started with run now")
+	}
+	if ctx.Err() != nil {
+		t.Fatal("shutdown did not return once the run finished")
+	}
+}
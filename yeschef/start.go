@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/hibiken/asynq"
+	"github.com/jaredfolkins/letemcook/models"
 	"github.com/reugn/go-quartz/quartz"
 )
 
@@ -23,6 +25,7 @@ type ErrHandler struct{}
 
 func (er ErrHandler) HandleError(ctx context.Context, task *asynq.Task, err error) {
 	log.Printf("XoxoX Error: %s\n", err.Error())
+	deadLetterRow(&models.ScheduledJob{Queue: task.Type(), Payload: string(task.Payload())}, models.DeadLetterFailed, err)
 }
 
 type RunningMan struct {
@@ -48,6 +51,19 @@ func (rm *RunningMan) IsRunning(key string) bool {
 	return rm.list[key]
 }
 
+// IsRunningPrefix reports whether a job whose key starts with prefix is
+// running.
+func (rm *RunningMan) IsRunningPrefix(prefix string) bool {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	for key := range rm.list {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func (rm *RunningMan) Remove(key string) {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
@@ -64,18 +80,6 @@ func Start() {
 	everyJq := NewQuartzQueue(EVERY_QUEUE)
 	everyScheduler := NewQuartzScheduler(everyJq)
 
-	// Recover any jobs that were persisted to disk before a crash or
-	// shutdown. Errors are logged but do not stop startup.
-	if err := nowJq.Recover(nowScheduler); err != nil {
-		log.Printf("recover now queue: %v", err)
-	}
-	if err := inJq.Recover(inScheduler); err != nil {
-		log.Printf("recover in queue: %v", err)
-	}
-	if err := everyJq.Recover(everyScheduler); err != nil {
-		log.Printf("recover every queue: %v", err)
-	}
-
 	XoxoX = &ChefsKiss{
 		mu:             sync.RWMutex{},
 		apps:           make(map[int64]*CmdServer),
@@ -88,17 +92,44 @@ func Start() {
 		EveryQueue:     everyJq,
 		EveryScheduler: everyScheduler,
 	}
+
+	// Recover any jobs that were persisted before a crash or shutdown once
+	// XoxoX is set, recovered jobs may fire right away. Each scheduler only
+	// starts once its queue is recovered. Errors are logged but do not stop
+	// startup.
+	ctx, cancel := context.WithCancel(context.Background())
+	engine.mu.Lock()
+	engine.ctx, engine.cancel = ctx, cancel
+	engine.mu.Unlock()
+
+	resetRecoveryReport()
+	startQueue(ctx, nowJq, nowScheduler)
+	startQueue(ctx, inJq, inScheduler)
+	startQueue(ctx, everyJq, everyScheduler)
+	logRecoveryReport()
+
+	go ReattachStepContainers(ctx)
+	StartImageDriftChecker(ctx)
+	StartImageGC(ctx)
 }
 
+// NewQuartzScheduler returns a scheduler for queue that is not started yet.
 func NewQuartzScheduler(queue *jobQueue) *quartz.StdScheduler {
 	config := quartz.StdSchedulerOptions{
-		OutdatedThreshold: time.Hour * 48, // considering file system I/O latency
+		OutdatedThreshold: misfireThreshold(),
 		WorkerLimit:       10,
 	}
-	scheduler := quartz.NewStdSchedulerWithOptions(config, queue, nil)
-	ctx := context.Background()
-	scheduler.Start(ctx)
-	return scheduler
+	return quartz.NewStdSchedulerWithOptions(config, queue, nil)
+}
+
+// startQueue recovers the persisted jobs of a queue and then starts its
+// scheduler, so jobs that are overdue get their misfire policy before the
+// scheduler can fire them.
+func startQueue(ctx context.Context, jq *jobQueue, s *quartz.StdScheduler) {
+	if err := jq.Recover(ctx, s); err != nil {
+		log.Printf("recover %s queue: %v", jq.Name, err)
+	}
+	s.Start(ctx)
 }
 
 const jobGroupTemplt = "[userid:%s][page:%s][uuid:%s][group]"
@@ -139,6 +170,19 @@ func LemcJobKey(recipe *JobRecipe, name string) string {
 	//return fmt.Sprintf(jobKeyAppIndividualTemplt, userid, pageid, uuid, name)
 }
 
+// RecipeJobKey is the LemcJobKey of the job's recipe, for the jobs a page
+// holds one of per recipe like recipe schedules.
+func RecipeJobKey(recipe *JobRecipe, name string) string {
+	return fmt.Sprintf("%s[recipe:%s]", LemcJobKey(recipe, name), recipe.Recipe.Name)
+}
+
+// StepJobKey is the key of an in or every step of the job's recipe. Every
+// step gets its own key so the deferred steps of a recipe are queued side by
+// side.
+func StepJobKey(recipe *JobRecipe, queue string, step int) string {
+	return fmt.Sprintf("%s[step:%d]", RecipeJobKey(recipe, queue), step)
+}
+
 func BuildTags(env []string, do string) []string {
 	tags := []string{}
 	for _, envVar := range env {
@@ -0,0 +1,65 @@
+package yeschef
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Clients subscribe to the topics of the views they show, and are only sent
+// the messages of those topics. A client without topics is sent everything.
+const (
+	appTopicTemplt = "app:%s:page:%s:scope:%s"
+	runTopicTemplt = "run:%s"
+	maxTopics      = 64
+)
+
+var topicRgx = regexp.MustCompile(`^(app|run):[A-Za-z0-9:._-]+$`)
+
+// AppTopic is the topic of the messages of a recipe shown on a page of a
+// cookbook or app, uuid being the cookbook or app UUID.
+func AppTopic(uuid, pageID, scope string) string {
+	return fmt.Sprintf(appTopicTemplt, uuid, pageID, scope)
+}
+
+// RunTopic is the topic of the messages of one run of a recipe.
+func RunTopic(runID string) string {
+	return fmt.Sprintf(runTopicTemplt, runID)
+}
+
+// jobTopics returns the topics a message of the job is published on.
+func jobTopics(job *JobRecipe) []string {
+	topics := []string{AppTopic(job.UUID, job.PageID, job.Scope)}
+	if job.RunID != "" {
+		topics = append(topics, RunTopic(job.RunID))
+	}
+	return topics
+}
+
+// ParseTopics reads a comma separated list of topics, dropping the ones
+// that are not well formed.
+func ParseTopics(raw string) []string {
+	var topics []string
+	for _, t := range strings.Split(raw, ",") {
+		t = strings.TrimSpace(t)
+		if topicRgx.MatchString(t) && len(topics) < maxTopics {
+			topics = append(topics, t)
+		}
+	}
+	return topics
+}
+
+// wants reports whether the client subscribed to one of the topics of f.
+func (x *Client) wants(f frame) bool {
+	if len(x.Topics) == 0 || len(f.topics) == 0 {
+		return true
+	}
+	for _, want := range x.Topics {
+		for _, t := range f.topics {
+			if t == want {
+				return true
+			}
+		}
+	}
+	return false
+}
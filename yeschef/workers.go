@@ -0,0 +1,296 @@
+package yeschef
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jaredfolkins/letemcook/models"
+	"github.com/jaredfolkins/letemcook/worker"
+)
+
+const (
+	// workerHelloTimeout is how long a worker has to introduce itself.
+	workerHelloTimeout = 10 * time.Second
+	// workerPingInterval is how often connected workers are pinged, a worker
+	// that misses two pings is dropped.
+	workerPingInterval = 30 * time.Second
+)
+
+// ErrWorkerDisconnected is returned for a step whose worker went away.
+var ErrWorkerDisconnected = errors.New("worker disconnected")
+
+// RemoteWorker is a lemc-worker connected to this server.
+type RemoteWorker struct {
+	Name      string
+	Address   string
+	Labels    []string
+	Connected time.Time
+
+	conn  *websocket.Conn
+	wmu   sync.Mutex // serializes writes to conn
+	mu    sync.Mutex
+	steps map[string]chan worker.Message
+}
+
+var workers = struct {
+	mu   sync.Mutex
+	list map[*RemoteWorker]struct{}
+}{list: make(map[*RemoteWorker]struct{})}
+
+var assignmentSeq atomic.Uint64
+
+// WorkerToken reads LEMC_WORKER_TOKEN. Workers cannot connect while it is
+// empty.
+func WorkerToken() string {
+	return strings.TrimSpace(os.Getenv("LEMC_WORKER_TOKEN"))
+}
+
+// WorkerAuthorized reports whether the Authorization header of a worker
+// carries the worker token.
+func WorkerAuthorized(header string) bool {
+	token := WorkerToken()
+	if token == "" {
+		return false
+	}
+	got := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// ServeWorker registers the worker on conn once it said hello, and routes
+// its messages to the steps it runs until the connection is lost. Steps
+// still running then fail with ErrWorkerDisconnected.
+func ServeWorker(conn *websocket.Conn, address string) error {
+	defer conn.Close()
+
+	var hello worker.Message
+	conn.SetReadDeadline(time.Now().Add(workerHelloTimeout))
+	if err := conn.ReadJSON(&hello); err != nil {
+		return err
+	}
+	if hello.Type != worker.TypeHello || hello.Hello == nil || hello.Hello.Name == "" {
+		return fmt.Errorf("worker at %s did not say hello", address)
+	}
+	for _, l := range hello.Hello.Labels {
+		if err := worker.ValidLabel(l); err != nil {
+			return err
+		}
+	}
+
+	w := &RemoteWorker{
+		Name:      hello.Hello.Name,
+		Address:   address,
+		Labels:    hello.Hello.Labels,
+		Connected: time.Now(),
+		conn:      conn,
+		steps:     make(map[string]chan worker.Message),
+	}
+	workers.mu.Lock()
+	workers.list[w] = struct{}{}
+	workers.mu.Unlock()
+	log.Printf("worker %s connected from %s with labels %s", w.Name, address, strings.Join(w.Labels, ", "))
+	defer w.drop()
+
+	conn.SetReadDeadline(time.Now().Add(2 * workerPingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * workerPingInterval))
+	})
+	stopPing := make(chan struct{})
+	defer close(stopPing)
+	go w.ping(stopPing)
+
+	for {
+		var m worker.Message
+		if err := conn.ReadJSON(&m); err != nil {
+			return err
+		}
+		w.mu.Lock()
+		ch, ok := w.steps[m.ID]
+		if ok && m.Type == worker.TypeDone {
+			delete(w.steps, m.ID)
+		}
+		w.mu.Unlock()
+		if !ok {
+			continue
+		}
+		ch <- m
+		if m.Type == worker.TypeDone {
+			close(ch)
+		}
+	}
+}
+
+// drop unregisters the worker and fails the steps it was running.
+func (w *RemoteWorker) drop() {
+	workers.mu.Lock()
+	delete(workers.list, w)
+	workers.mu.Unlock()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, ch := range w.steps {
+		close(ch)
+		delete(w.steps, id)
+	}
+	log.Printf("worker %s disconnected", w.Name)
+}
+
+func (w *RemoteWorker) ping(stop chan struct{}) {
+	ticker := time.NewTicker(workerPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.wmu.Lock()
+			err := w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(workerHelloTimeout))
+			w.wmu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (w *RemoteWorker) send(m worker.Message) error {
+	w.wmu.Lock()
+	defer w.wmu.Unlock()
+	return w.conn.WriteJSON(m)
+}
+
+func (w *RemoteWorker) running() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.steps)
+}
+
+// Run hands a step to the worker and passes each line of its output to line
+// until the worker reports the step done. When line returns an error or ctx
+// is done the step is cancelled on the worker and that error returned once
+// the worker stopped it. started is called with the image the container
+// runs, the exit code of the container is stored for the step of ctx.
+func (w *RemoteWorker) Run(ctx context.Context, as worker.Assignment, started func(imageID string), line func(string) error) error {
+	as.ID = strconv.FormatUint(assignmentSeq.Add(1), 10)
+	ch := make(chan worker.Message, 256)
+	w.mu.Lock()
+	w.steps[as.ID] = ch
+	w.mu.Unlock()
+
+	if err := w.send(worker.Message{Type: worker.TypeAssign, Assign: &as}); err != nil {
+		w.mu.Lock()
+		delete(w.steps, as.ID)
+		w.mu.Unlock()
+		return err
+	}
+
+	var stepErr error
+	cancel := func(err error) {
+		stepErr = err
+		if err := w.send(worker.Message{Type: worker.TypeCancel, ID: as.ID}); err != nil {
+			log.Printf("cancel step on worker %s: %v", w.Name, err)
+		}
+	}
+	done := ctx.Done()
+	for {
+		var m worker.Message
+		var ok bool
+		select {
+		case <-done:
+			// The worker still reports the step done, or goes away.
+			done = nil
+			if stepErr == nil {
+				cancel(ctx.Err())
+			}
+			continue
+		case m, ok = <-ch:
+		}
+		if !ok {
+			break
+		}
+		switch m.Type {
+		case worker.TypeStarted:
+			started(m.ImageID)
+		case worker.TypeOutput:
+			if stepErr != nil {
+				continue
+			}
+			if err := line(m.Line); err != nil {
+				cancel(err)
+			}
+		case worker.TypeDone:
+			if m.ExitCode != nil {
+				setStepExit(ctx, *m.ExitCode)
+			}
+			if stepErr != nil {
+				return stepErr
+			}
+			if m.Error != "" {
+				return fmt.Errorf("worker %s: %s", w.Name, m.Error)
+			}
+			return nil
+		}
+	}
+	if stepErr != nil {
+		return stepErr
+	}
+	return fmt.Errorf("%w: %s", ErrWorkerDisconnected, w.Name)
+}
+
+// pickWorker returns the connected worker with all the labels that runs
+// the fewest steps.
+func pickWorker(labels []string) (*RemoteWorker, error) {
+	workers.mu.Lock()
+	defer workers.mu.Unlock()
+	var best *RemoteWorker
+	for w := range workers.list {
+		if !worker.MatchLabels(w.Labels, labels) {
+			continue
+		}
+		if best == nil || w.running() < best.running() {
+			best = w
+		}
+	}
+	if best == nil {
+		return nil, NewUserVisibleError("NO_WORKER", fmt.Sprintf("No worker labeled %s is connected.", strings.Join(labels, ", ")), nil)
+	}
+	return best, nil
+}
+
+// Workers lists the connected workers by name.
+func Workers() []models.WorkerInfo {
+	workers.mu.Lock()
+	defer workers.mu.Unlock()
+	list := make([]models.WorkerInfo, 0, len(workers.list))
+	for w := range workers.list {
+		list = append(list, models.WorkerInfo{
+			Name:      w.Name,
+			Address:   w.Address,
+			Labels:    w.Labels,
+			Connected: w.Connected,
+			Running:   w.running(),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// ValidateRunsOn checks the worker labels a step targets.
+func ValidateRunsOn(st models.Step) error {
+	for _, l := range st.RunsOn {
+		if err := worker.ValidLabel(l); err != nil {
+			return fmt.Errorf("runs_on: %w", err)
+		}
+	}
+	return nil
+}
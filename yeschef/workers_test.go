@@ -0,0 +1,147 @@
+package yeschef
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jaredfolkins/letemcook/worker"
+)
+
+// connectTestWorker serves a worker endpoint and connects a fake worker with
+// the labels to it. The fake worker is returned once it is registered.
+func connectTestWorker(t *testing.T, labels ...string) (*websocket.Conn, *RemoteWorker) {
+	t.Helper()
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		ServeWorker(conn, "127.0.0.1")
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := conn.WriteJSON(worker.Message{Type: worker.TypeHello, Hello: &worker.Hello{Name: "edge", Labels: labels}}); err != nil {
+		t.Fatalf("hello: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if w, err := pickWorker(labels); err == nil {
+			return conn, w
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("worker did not register")
+	return nil, nil
+}
+
+func TestWorkerRunsAssignedStep(t *testing.T) {
+	conn, w := connectTestWorker(t, "arch:amd64", "dmz")
+	if _, err := pickWorker([]string{"dmz", "gpu"}); GetUserVisibleError(err) == nil {
+		t.Fatalf("expected no worker with the gpu label, got %v", err)
+	}
+	if got := Workers(); len(got) != 1 || got[0].Name != "edge" {
+		t.Fatalf("unexpected workers %+v", got)
+	}
+
+	go func() {
+		var m worker.Message
+		if err := conn.ReadJSON(&m); err != nil || m.Type != worker.TypeAssign {
+			return
+		}
+		id := m.Assign.ID
+		conn.WriteJSON(worker.Message{Type: worker.TypeStarted, ID: id, ImageID: "sha256:0123456789ab"})
+		conn.WriteJSON(worker.Message{Type: worker.TypeOutput, ID: id, Line: "hello"})
+		conn.WriteJSON(worker.Message{Type: worker.TypeOutput, ID: id, Line: "bad"})
+		// The step is cancelled after the bad line.
+		if err := conn.ReadJSON(&m); err != nil || m.Type != worker.TypeCancel || m.ID != id {
+			return
+		}
+		code := 3
+		conn.WriteJSON(worker.Message{Type: worker.TypeDone, ID: id, ExitCode: &code})
+	}()
+
+	res := &stepResult{}
+	ctx := withStepResult(context.Background(), res)
+	var image string
+	var lines []string
+	stepErr := errors.New("step failed")
+	err := w.Run(ctx, worker.Assignment{Image: "alpine"}, func(id string) { image = id }, func(s string) error {
+		lines = append(lines, s)
+		if s == "bad" {
+			return stepErr
+		}
+		return nil
+	})
+	if !errors.Is(err, stepErr) {
+		t.Fatalf("expected the step error, got %v", err)
+	}
+	if image != "sha256:0123456789ab" || len(lines) != 2 || lines[0] != "hello" {
+		t.Fatalf("unexpected image %q and lines %v", image, lines)
+	}
+	if res.exitCode == nil || *res.exitCode != 3 {
+		t.Fatalf("exit code %v, want 3", res.exitCode)
+	}
+	if w.running() != 0 {
+		t.Fatal("step still assigned after it was done")
+	}
+}
+
+func TestWorkerDisconnectFailsStep(t *testing.T) {
+	conn, w := connectTestWorker(t, "edge-zone")
+	go func() {
+		var m worker.Message
+		conn.ReadJSON(&m)
+		conn.Close()
+	}()
+
+	err := w.Run(context.Background(), worker.Assignment{Image: "alpine"}, func(string) {}, func(string) error { return nil })
+	if !errors.Is(err, ErrWorkerDisconnected) {
+		t.Fatalf("expected the step to fail with the worker, got %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(Workers()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(Workers()) != 0 {
+		t.Fatal("worker still listed after it disconnected")
+	}
+}
+
+func TestWorkerCancelsStepWhenContextEnds(t *testing.T) {
+	conn, w := connectTestWorker(t, "edge-cancel")
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		var m worker.Message
+		if err := conn.ReadJSON(&m); err != nil || m.Type != worker.TypeAssign {
+			return
+		}
+		id := m.Assign.ID
+		conn.WriteJSON(worker.Message{Type: worker.TypeStarted, ID: id, ImageID: "sha256:0123456789ab"})
+		cancel()
+		if err := conn.ReadJSON(&m); err != nil || m.Type != worker.TypeCancel || m.ID != id {
+			return
+		}
+		conn.WriteJSON(worker.Message{Type: worker.TypeDone, ID: id})
+	}()
+
+	err := w.Run(ctx, worker.Assignment{Image: "alpine"}, func(string) {}, func(string) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the step to be cancelled, got %v", err)
+	}
+	if w.running() != 0 {
+		t.Fatal("step still assigned after it was cancelled")
+	}
+}